@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"os"
 
 	config "github.com/fanchunke/chatgpt-lark/conf"
 	"github.com/fanchunke/chatgpt-lark/internal/app"
@@ -9,31 +10,83 @@ import (
 	"github.com/rs/zerolog/log"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 func main() {
 	conf := flag.String("conf", "conf/online.conf", "配置文件")
-	initEnt := flag.Bool("init-ent", false, "是否初始化数据库")
+	initEnt := flag.Bool("init-ent", false, "是否初始化数据库，等同于 migrate up")
+	purge := flag.Bool("purge", false, "按数据保留策略清理过期数据")
+	verifyAudit := flag.String("verify-audit", "", "校验审计日志文件的哈希链")
+	mockLLM := flag.Bool("mock-llm", false, "使用模拟的 OpenAI 服务，不需要 API Key，等同于 gpt.mock=true，需要使用 -tags mockllm 编译")
 	flag.Parse()
+
+	// 校验审计日志，不需要读取配置
+	if *verifyAudit != "" {
+		n, err := app.VerifyAudit(*verifyAudit)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("audit log verification failed after %d records", n)
+		}
+		log.Info().Msgf("audit log verified, %d records", n)
+		return
+	}
+
+	// 数据库迁移：app [flags] migrate status|up [n]|down [n]|diff，只需要 [database] 配置
+	args := flag.Args()
+	if *initEnt {
+		args = []string{"migrate", "up"}
+	}
+	if len(args) > 0 && args[0] == "migrate" {
+		cfg, err := config.Load(*conf)
+		if err == nil {
+			err = cfg.Database.Validate()
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("Config Load Failed")
+		}
+		configureLogger(cfg)
+		if err := app.Migrate(cfg, args[1:], os.Stdout); err != nil {
+			log.Fatal().Err(err).Msg("failed migrating database")
+		}
+		return
+	}
+
+	if *mockLLM {
+		os.Setenv("GPT_MOCK", "true")
+	}
 	cfg, err := config.New(*conf)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Config Load Failed")
 	}
-	logger.Configure(logger.Config{
-		ConsoleLoggingEnabled: true,
-		FileLoggingEnabled:    true,
-		Level:                 cfg.Logger.Level,
-		Filename:              cfg.Logger.Filename,
-	})
+	configureLogger(cfg)
 
-	// 数据库迁移
-	if *initEnt {
-		if err := app.Migrate(cfg); err != nil {
-			log.Fatal().Err(err).Msg("failed creating schema resources")
+	// 知识库：app [flags] kb ingest [-prune] <name> <path>...|list|delete <name> [source]|search <name> <query>|sync
+	if len(args) > 0 && args[0] == "kb" {
+		if err := app.KB(cfg, args[1:], os.Stdout); err != nil {
+			log.Fatal().Err(err).Msg("failed running kb command")
+		}
+		return
+	}
+
+	// 清理过期数据
+	if *purge {
+		result, err := app.Purge(cfg)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed purging expired data")
 		}
+		log.Info().Msgf("purge finished, %s", result)
 		return
 	}
 
 	app.Run(cfg)
 }
+
+func configureLogger(cfg *config.Config) {
+	logger.Configure(logger.Config{
+		ConsoleLoggingEnabled: true,
+		FileLoggingEnabled:    true,
+		Level:                 cfg.Logger.Level,
+		Filename:              cfg.Logger.Filename,
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/api"
+	"github.com/fanchunke/chatgpt-lark/internal/app"
+	"github.com/fanchunke/chatgpt-lark/pkg/logger"
+	"github.com/rs/zerolog/log"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	conf := flag.String("conf", "conf/online.conf", "配置文件")
+	userId := flag.String("user", "local-user", "用户的 open_id")
+	appName := flag.String("app", "", "使用的飞书应用名称，默认为 [lark] 中的默认应用")
+	appId := flag.String("app-id", "", "应用的 appId，默认使用应用配置中的 appId")
+	version := flag.String("version", "", "回调版本 v1 或 v2，默认使用应用配置中的版本")
+	mockLLM := flag.Bool("mock-llm", false, "使用模拟的 OpenAI 服务，不需要 API Key，等同于 gpt.mock=true，需要使用 -tags mockllm 编译")
+	verbose := flag.Bool("v", false, "在标准错误中输出日志")
+	flag.Parse()
+
+	if *mockLLM {
+		os.Setenv("GPT_MOCK", "true")
+	}
+	cfg, err := config.New(*conf)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Config Load Failed")
+	}
+	logger.Configure(logger.Config{
+		ConsoleLoggingEnabled: *verbose,
+		Level:                 cfg.Logger.Level,
+	})
+
+	err = app.Chat(cfg, api.ChatOptions{
+		AppName: *appName,
+		AppId:   *appId,
+		UserId:  *userId,
+		Version: *version,
+	}, os.Stdin, os.Stdout)
+	if err != nil {
+		// 未开启 -v 时日志不会输出，直接打印错误
+		fmt.Fprintf(os.Stderr, "chat failed: %v\n", err)
+		os.Exit(1)
+	}
+}
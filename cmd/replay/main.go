@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/app"
+	"github.com/fanchunke/chatgpt-lark/pkg/logger"
+	"github.com/rs/zerolog/log"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	conf := flag.String("conf", "conf/online.conf", "配置文件")
+	realLLM := flag.Bool("real-llm", false, "使用配置中的 OpenAI 接口，默认使用模拟服务返回记录中的 GPT 响应")
+	realLark := flag.Bool("real-lark", false, "使用配置中的飞书开放平台，回复会发送给真实用户")
+	ignore := flag.String("ignore", "user,uuid", "对比时忽略的 JSON 字段，以逗号分隔")
+	timeout := flag.Duration("timeout", 30*time.Second, "每个事件的处理超时时间")
+	verbose := flag.Bool("v", false, "在标准错误中输出日志")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <record file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.New(*conf)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Config Load Failed")
+	}
+	logger.Configure(logger.Config{
+		ConsoleLoggingEnabled: *verbose,
+		Level:                 cfg.Logger.Level,
+	})
+
+	fields := make([]string, 0)
+	for _, f := range strings.Split(*ignore, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	differs, err := app.Replay(cfg, flag.Arg(0), app.ReplayOptions{
+		RealLLM:  *realLLM,
+		RealLark: *realLark,
+		Ignore:   fields,
+		Timeout:  *timeout,
+	}, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay failed: %v\n", err)
+		os.Exit(1)
+	}
+	if differs > 0 {
+		fmt.Fprintf(os.Stderr, "%d events differ\n", differs)
+		os.Exit(1)
+	}
+}
@@ -2,18 +2,35 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
+// Config 的配置项可以通过 Reloader 在运行时重新加载，标记了 reload:"restart" 的配置项需要重启才能生效
 type Config struct {
-	App          `mapstructure:"app"`
-	HTTP         `mapstructure:"http"`
-	Logger       `mapstructure:"logger"`
-	Lark         `mapstructure:"lark"`
+	App          `mapstructure:"app" reload:"restart"`
+	HTTP         `mapstructure:"http" reload:"restart"`
+	Logger       `mapstructure:"logger" reload:"restart"`
+	Lark         `mapstructure:"lark" reload:"restart"`
 	GPT          `mapstructure:"gpt"`
-	Database     `mapstructure:"database"`
+	Database     `mapstructure:"database" reload:"restart"`
 	Conversation `mapstructure:"conversation"`
+	Admin        `mapstructure:"admin" reload:"restart"`
+	Retention    `mapstructure:"retention" reload:"restart"`
+	Tracing      `mapstructure:"tracing" reload:"restart"`
+	Health       `mapstructure:"health" reload:"restart"`
+	Audit        `mapstructure:"audit" reload:"restart"`
+	Moderation   `mapstructure:"moderation"`
+	PII          `mapstructure:"pii"`
+	Record       `mapstructure:"record" reload:"restart"`
+	RAG          `mapstructure:"rag"`
+	Tools        `mapstructure:"tools"`
+	// 在同一个进程中提供服务的其他飞书应用
+	Apps []LarkApp `mapstructure:"apps" reload:"restart"`
 }
 
 type App struct {
@@ -23,6 +40,12 @@ type App struct {
 
 type HTTP struct {
 	Port string `mapstructure:"port"`
+	// 请求内容的最大字节数，超出时返回 413，为 0 时不限制
+	MaxBodySize int64 `mapstructure:"maxBodySize"`
+	// 监控指标的单独监听端口，为空时 /metrics 与回调地址共用端口，并需要管理接口的认证
+	MetricsPort string `mapstructure:"metricsPort"`
+	// 信任的反向代理 IP 或 CIDR，只有来自这些地址的请求才使用 X-Forwarded-For 等请求头获取客户端 IP，为空时不信任任何代理
+	TrustedProxies []string `mapstructure:"trustedProxies"`
 }
 
 type Logger struct {
@@ -30,23 +53,346 @@ type Logger struct {
 	FileLoggingEnabled    bool   `mapstructure:"file_enabled"`
 	ConsoleLoggingEnabled bool   `mapstructure:"console_enabled"`
 	Filename              string `mapstructure:"filename"`
+	// debug 访问日志中请求和响应内容的最大长度，超出部分被截断，为 0 时不截断
+	AccessMaxBody int `mapstructure:"access_max_body"`
+	// debug 访问日志中需要隐藏的 JSON 字段名
+	AccessRedactFields []string `mapstructure:"access_redact_fields"`
+	// 记录 debug 访问日志的请求比例，取值 (0, 1]，为 0 时全部记录
+	AccessSampleRate float64 `mapstructure:"access_sample_rate"`
 }
 
 type Lark struct {
-	VerificationToken string `mapstructure:"verificationToken"`
-	EventEncryptKey   string `mapstructure:"eventEncryptKey"`
+	VerificationToken string `mapstructure:"verificationToken" secret:"true"`
+	EventEncryptKey   string `mapstructure:"eventEncryptKey" secret:"true"`
 	AppId             string `mapstructure:"appId"`
-	AppSecret         string `mapstructure:"appSecret"`
+	AppSecret         string `mapstructure:"appSecret" secret:"true"`
 	BaseUrl           string `mapstructure:"baseUrl"`
+	// 默认应用的人设、模型和每日额度，与 [[apps]] 中的同名配置相同
+	SystemPrompt  string `mapstructure:"systemPrompt"`
+	Model         string `mapstructure:"model"`
+	DailyMessages int    `mapstructure:"dailyMessages"`
+	DailyTokens   int    `mapstructure:"dailyTokens"`
+}
+
+type Audit struct {
+	// 是否记录审计日志
+	Enabled bool `mapstructure:"enabled"`
+	// 审计日志文件，与程序日志分开保存
+	Filename string `mapstructure:"filename"`
+	// 是否保存完整的提问和回答，关闭时只保存哈希
+	StoreContent bool `mapstructure:"storeContent"`
+	// 单个文件的最大 MB 数，超出后轮转
+	MaxSize int `mapstructure:"maxSize"`
+	// 保留的轮转文件数和天数，为 0 时全部保留
+	MaxBackups int `mapstructure:"maxBackups"`
+	MaxAge     int `mapstructure:"maxAge"`
+}
+
+type Record struct {
+	// 是否记录飞书事件以及处理过程中的 GPT 和飞书接口调用，用于通过 cmd/replay 重放
+	Enabled bool `mapstructure:"enabled"`
+	// 记录文件，JSON lines 格式
+	Filename string `mapstructure:"filename"`
+	// JSON 内容中需要隐藏的字段名
+	RedactFields []string `mapstructure:"redactFields"`
+	// 是否将手机号、邮箱等敏感信息替换为占位符，占位符使用每次启动随机生成的 key 计算
+	RedactPII bool `mapstructure:"redactPII"`
+	// 是否记录用户消息中下载的图片和文件内容，重放时由模拟服务返回。文件内容不会脱敏
+	Resources bool `mapstructure:"resources"`
+	// 单个文件的最大 MB 数，超出后轮转
+	MaxSize int `mapstructure:"maxSize"`
+	// 保留的轮转文件数和天数，为 0 时全部保留
+	MaxBackups int `mapstructure:"maxBackups"`
+	MaxAge     int `mapstructure:"maxAge"`
+}
+
+type RAG struct {
+	// 知识库的存储方式：database 保存在 database 配置的数据库中，file 保存在 indexDir 下的 <知识库>.json 文件中
+	Storage string `mapstructure:"storage" reload:"restart"`
+	// storage 为 file 时索引文件所在的目录
+	IndexDir string `mapstructure:"indexDir" reload:"restart"`
+	// 生成向量使用的 OpenAI embeddings 模型，修改后需要重新导入知识库
+	EmbeddingModel string `mapstructure:"embeddingModel" reload:"restart"`
+	// 导入时片段的最大字符数和相邻片段重叠的字符数
+	ChunkSize    int `mapstructure:"chunkSize"`
+	ChunkOverlap int `mapstructure:"chunkOverlap"`
+	// 每次提问检索的片段数
+	TopK int `mapstructure:"topK"`
+	// 片段与问题的最低相似度，低于该值的片段不会被引用
+	MinScore float64 `mapstructure:"minScore"`
+	// 默认应用使用的知识库，为空时不开启知识库问答。v1 应用和默认应用的 /lark/receive 回调不使用知识库
+	KnowledgeBase string `mapstructure:"knowledgeBase"`
+	// 检索到片段时附加在请求中的系统提示词，片段以 [n] 编号列在提示词之后
+	Prompt string `mapstructure:"prompt"`
+	// 按群聊或单聊设置知识库，优先于应用的配置
+	Chats []RAGChat `mapstructure:"chats"`
+	// 同步到知识库的飞书知识空间和云文档
+	Lark []RAGLark `mapstructure:"lark" reload:"restart"`
+	// 定时同步飞书文档的间隔，为 0 时只能通过 `app kb sync` 手动同步
+	SyncInterval time.Duration `mapstructure:"syncInterval" reload:"restart"`
+}
+
+type RAGLark struct {
+	// 同步到的知识库
+	KnowledgeBase string `mapstructure:"knowledgeBase"`
+	// 使用哪个飞书应用的凭证读取文档，为空时使用默认应用
+	App string `mapstructure:"app"`
+	// 企业的飞书域名，如 https://example.feishu.cn，用于在回答中链接到原文档，必填
+	Domain string `mapstructure:"domain"`
+	// 知识空间 Id
+	SpaceIds []string `mapstructure:"spaceIds"`
+	// 云空间文件夹 token
+	Folders []string `mapstructure:"folders"`
+	// 文档 token
+	Documents []string `mapstructure:"documents"`
+}
+
+type RAGChat struct {
+	// 群聊或单聊的 chat_id
+	ChatId string `mapstructure:"chatId"`
+	// 使用的知识库，为空时该会话不使用知识库
+	KnowledgeBase string `mapstructure:"knowledgeBase"`
+}
+
+// KnowledgeBaseFor 返回会话使用的知识库，优先级为 rag.chats、应用的 knowledgeBase、rag.knowledgeBase，为空时不使用知识库
+func (r RAG) KnowledgeBaseFor(app LarkApp, chatId string) string {
+	for _, chat := range r.Chats {
+		if chatId != "" && chat.ChatId == chatId {
+			return chat.KnowledgeBase
+		}
+	}
+	if app.KnowledgeBase != "" {
+		return app.KnowledgeBase
+	}
+	return r.KnowledgeBase
+}
+
+type Tools struct {
+	// 是否开启工具调用，开启后 v2 应用在回答前由 GPT 决定是否调用工具，每条消息会多一次 GPT 请求
+	Enabled bool `mapstructure:"enabled"`
+	// 可以调用的工具，为空时可以调用全部内置工具
+	Names []string `mapstructure:"names"`
+	// 每条消息最多调用工具的轮数，为 0 时使用 3
+	MaxRounds int `mapstructure:"maxRounds"`
+	// 单次工具调用的超时时间，为 0 时使用 10s
+	Timeout time.Duration `mapstructure:"timeout"`
+	// 写操作确认卡片的有效期，为 0 时使用 10m
+	ConfirmTimeout time.Duration `mapstructure:"confirmTimeout"`
+	// 按工具限制可以调用的用户
+	Permissions []ToolPermission `mapstructure:"permissions"`
+}
+
+type ToolPermission struct {
+	// 工具名称
+	Tool string `mapstructure:"tool"`
+	// 可以调用该工具的用户 open_id，* 表示所有用户，为空时所有用户都不能调用
+	Users []string `mapstructure:"users"`
+}
+
+// AllUsers 在 tools.permissions.users 中表示所有用户
+const AllUsers = "*"
+
+// restrictedTools 以应用身份读取同事日程或写入数据的工具，不受同事的日历共享设置和用户自身的权限限制，
+// 没有配置 permissions 时所有用户都不能调用
+var restrictedTools = []string{"check_freebusy", "create_task"}
+
+// Allowed 工具是否开启，以及用户是否可以调用该工具。
+// 没有配置 permissions 的工具所有用户都可以调用，restrictedTools 中的工具所有用户都不能调用。
+func (t Tools) Allowed(name, openId string) bool {
+	if len(t.Names) > 0 && !contains(t.Names, name) {
+		return false
+	}
+	configured := false
+	for _, p := range t.Permissions {
+		if p.Tool != name {
+			continue
+		}
+		configured = true
+		if !contains(p.Users, openId) && !contains(p.Users, AllUsers) {
+			return false
+		}
+	}
+	return configured || !contains(restrictedTools, name)
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+type Moderation struct {
+	// 是否调用 OpenAI moderation 接口审核内容
+	OpenAI bool `mapstructure:"openai"`
+	// 本地关键词，不区分大小写
+	Keywords []string `mapstructure:"keywords"`
+	// 本地正则表达式
+	Patterns []string `mapstructure:"patterns"`
+	// 用户消息和 GPT 回答未通过审核时的处理方式：block、warn、redact、log，默认 block。
+	// redact 只能隐藏命中关键词和正则的内容，OpenAI 接口判定违规时按 block 处理
+	InputAction  string `mapstructure:"inputAction"`
+	OutputAction string `mapstructure:"outputAction"`
+	// 拦截用户消息和 GPT 回答时的回复
+	BlockedInputReply  string `mapstructure:"blockedInputReply"`
+	BlockedOutputReply string `mapstructure:"blockedOutputReply"`
+	// warn 时附加在回复前的提醒
+	WarnReply string `mapstructure:"warnReply"`
+}
+
+type PII struct {
+	// 启用的内置检测器：idcard、mobile、email、accesskey、ip，为空且未配置 rules 时不脱敏
+	Detectors []string `mapstructure:"detectors"`
+	// 自定义的检测规则，如内部域名
+	Rules []PIIRule `mapstructure:"rules"`
+	// 生成占位符和加密原始值的密钥，启用脱敏时必须配置。多个实例需要使用相同的密钥，修改后之前的占位符不再还原
+	Key string `mapstructure:"key" secret:"true" reload:"restart"`
+	// 占位符和加密后的原始值在数据库中的保存时间，超过后 GPT 回答中的占位符不再还原
+	TTL time.Duration `mapstructure:"ttl"`
+}
+
+type PIIRule struct {
+	// 规则名称，用于生成占位符，如 host 生成 [HOST_xxxxxxxx]
+	Name string `mapstructure:"name"`
+	// 正则表达式
+	Pattern string `mapstructure:"pattern"`
+}
+
+// LarkApp 一个飞书应用的配置。[lark] 为默认应用，[[apps]] 中的应用通过
+// /lark/apps/<name>/receive 和 /lark/apps/<name>/card 接收回调。
+type LarkApp struct {
+	// 应用名称，用于回调地址，只能包含小写字母、数字、- 和 _
+	Name              string `mapstructure:"name"`
+	AppId             string `mapstructure:"appId"`
+	AppSecret         string `mapstructure:"appSecret" secret:"true"`
+	VerificationToken string `mapstructure:"verificationToken" secret:"true"`
+	EventEncryptKey   string `mapstructure:"eventEncryptKey" secret:"true"`
+	// 为空时使用 lark.baseUrl
+	BaseUrl string `mapstructure:"baseUrl"`
+	// v1 使用 completion 接口，v2 使用 chat 接口，默认 v2
+	Version string `mapstructure:"version"`
+	// 应用的人设，作为会话的默认系统提示词，优先于 conversation.systemPrompt
+	SystemPrompt string `mapstructure:"systemPrompt"`
+	// 应用默认使用的模型
+	Model string `mapstructure:"model"`
+	// 每个用户每天可以发送的消息数，为 0 时不限制
+	DailyMessages int `mapstructure:"dailyMessages"`
+	// 每个用户每天可以消耗的 token 数，为 0 时不限制
+	DailyTokens int `mapstructure:"dailyTokens"`
+	// 应用使用的知识库，为空时使用 rag.knowledgeBase，只能用于 v2 应用
+	KnowledgeBase string `mapstructure:"knowledgeBase"`
+}
+
+// DefaultAppName 默认应用 [lark] 的名称
+const DefaultAppName = ""
+
+var (
+	appNameRegexp     = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	piiRuleNameRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+)
+
+// LarkApps 返回全部飞书应用，未配置 lark.appId 时不包含默认应用
+func (c *Config) LarkApps() []LarkApp {
+	apps := make([]LarkApp, 0, len(c.Apps)+1)
+	if c.Lark.AppId != "" {
+		apps = append(apps, LarkApp{
+			Name:              DefaultAppName,
+			AppId:             c.Lark.AppId,
+			AppSecret:         c.Lark.AppSecret,
+			VerificationToken: c.Lark.VerificationToken,
+			EventEncryptKey:   c.Lark.EventEncryptKey,
+			BaseUrl:           c.Lark.BaseUrl,
+			SystemPrompt:      c.Lark.SystemPrompt,
+			Model:             c.Lark.Model,
+			DailyMessages:     c.Lark.DailyMessages,
+			DailyTokens:       c.Lark.DailyTokens,
+		})
+	}
+	for _, app := range c.Apps {
+		if app.BaseUrl == "" {
+			app.BaseUrl = c.Lark.BaseUrl
+		}
+		if app.Version == "" {
+			app.Version = "v2"
+		}
+		apps = append(apps, app)
+	}
+	return apps
+}
+
+// LarkApp 按名称获取飞书应用
+func (c *Config) LarkApp(name string) (LarkApp, bool) {
+	for _, app := range c.LarkApps() {
+		if app.Name == name {
+			return app, true
+		}
+	}
+	return LarkApp{}, false
+}
+
+func (a LarkApp) validate() []string {
+	problems := make([]string, 0)
+	if !appNameRegexp.MatchString(a.Name) {
+		problems = append(problems, fmt.Sprintf("invalid apps.name %q, only lowercase letters, digits, - and _ are allowed", a.Name))
+	}
+	if a.AppId == "" {
+		problems = append(problems, fmt.Sprintf("apps[%s].appId is required", a.Name))
+	}
+	if a.AppSecret == "" {
+		problems = append(problems, fmt.Sprintf("apps[%s].appSecret is required", a.Name))
+	}
+	switch a.Version {
+	case "", "v1", "v2":
+	default:
+		problems = append(problems, fmt.Sprintf("unsupported apps[%s].version %q, supported versions: v1, v2", a.Name, a.Version))
+	}
+	// 知识库只对 v2 应用生效
+	if a.Version == "v1" && a.KnowledgeBase != "" {
+		problems = append(problems, fmt.Sprintf("apps[%s].knowledgeBase requires version v2", a.Name))
+	}
+	if a.DailyMessages < 0 || a.DailyTokens < 0 {
+		problems = append(problems, fmt.Sprintf("apps[%s] quotas must not be negative", a.Name))
+	}
+	return problems
 }
 
 type GPT struct {
-	ApiKey string `mapstructure:"api_key"`
+	ApiKey string `mapstructure:"api_key" secret:"true" reload:"restart"`
+	// OpenAI 兼容接口的地址，为空时使用 https://api.openai.com/v1
+	BaseUrl string `mapstructure:"baseUrl" reload:"restart"`
+	// 使用进程内的模拟 OpenAI 服务，回显用户消息，不需要 api_key，仅用于本地开发，需要使用 -tags mockllm 编译
+	Mock bool `mapstructure:"mock" reload:"restart"`
+	// 用户可以通过 /model 切换的模型，为空时不做限制
+	Models []string `mapstructure:"models"`
+	// 同时请求 GPT 的最大并发数，超出的消息排队等待，为 0 时不限制
+	MaxConcurrency int `mapstructure:"maxConcurrency" reload:"restart"`
 }
 
 type Database struct {
 	Driver     string `mapstructure:"driver"`
-	DataSource string `mapstructure:"dataSource"`
+	DataSource string `mapstructure:"dataSource" secret:"true"`
+	// 启动时自动执行未执行的数据库迁移
+	AutoMigrate bool `mapstructure:"autoMigrate"`
+}
+
+// SupportedDrivers 支持的数据库驱动
+var SupportedDrivers = []string{"mysql", "sqlite3", "postgres"}
+
+// Validate 检查数据库配置，数据库迁移命令只校验这一部分
+func (d Database) Validate() error {
+	problems := make([]string, 0)
+	if d.DataSource == "" {
+		problems = append(problems, "database.dataSource is required")
+	}
+	if !contains(SupportedDrivers, d.Driver) {
+		problems = append(problems, fmt.Sprintf("unsupported database driver %q, supported drivers: %s", d.Driver, strings.Join(SupportedDrivers, ", ")))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
 }
 
 type Conversation struct {
@@ -55,16 +401,295 @@ type Conversation struct {
 	CloseSessionReply  string `mapstructure:"closeSessionReply"`
 	EnableEnterEvent   bool   `mapstructure:"enableEnterEvent"`
 	EnterEventReply    string `mapstructure:"enterEventReply"`
+	// 新会话默认的系统提示词，仅对 chat 接口生效
+	SystemPrompt string `mapstructure:"systemPrompt"`
+	// 会话超过该时长未活跃时自动关闭，为 0 时不关闭
+	IdleTimeout time.Duration `mapstructure:"idleTimeout" reload:"restart"`
+	// 自动关闭会话后给用户的提示，%s 为距离上次消息的时长，为空时不提示
+	IdleTimeoutReply string `mapstructure:"idleTimeoutReply"`
+	// 用户超出应用每日额度时的回复
+	QuotaExceededReply string `mapstructure:"quotaExceededReply"`
+	// 用户不在应用白名单中时的回复，白名单通过管理接口设置
+	NotAllowedReply string `mapstructure:"notAllowedReply"`
+	// 后台批量关闭不活跃会话的间隔，为 0 时不启动。被关闭的会话在用户下次发送消息时同样按 idleTimeoutReply 提示
+	IdleSweepInterval time.Duration `mapstructure:"idleSweepInterval" reload:"restart"`
+	// 消息去重记录的保留时长，飞书在该时长内重试推送的同一条消息只处理一次，为 0 时不去重
+	DedupeTTL time.Duration `mapstructure:"dedupeTTL" reload:"restart"`
+}
+
+type Admin struct {
+	// 管理接口的访问 token，与 hmacSecret 均为空时不开启管理接口
+	Token string `mapstructure:"token" secret:"true"`
+	// 管理接口的 HMAC 签名密钥，请求使用 token 或签名之一即可，签名请求的 nonce 只能使用一次
+	HMACSecret string `mapstructure:"hmacSecret" secret:"true"`
+	// 签名时间戳与服务器时间允许的最大误差
+	HMACMaxSkew time.Duration `mapstructure:"hmacMaxSkew"`
+	// 管理后台 /admin/ui 登录 cookie 的签名密钥，与 token 均不为空时开启管理后台，不能与 token 相同
+	SessionSecret string `mapstructure:"sessionSecret" secret:"true"`
+	// 管理后台 /admin/ui 登录的有效期
+	SessionTTL time.Duration `mapstructure:"sessionTTL"`
+	// 登录 cookie 是否始终设置 Secure，关闭时只在 HTTPS 请求中设置。部署在终止 TLS 的反向代理之后时需要开启
+	SecureCookie bool `mapstructure:"secureCookie"`
+	// 同一个客户端 IP 在 loginLockout 内登录失败 loginMaxFailures 次后，在 loginLockout 结束前拒绝登录。
+	// 客户端 IP 只在请求来自 http.trustedProxies 时从 X-Forwarded-For 获取
+	LoginMaxFailures int           `mapstructure:"loginMaxFailures"`
+	LoginLockout     time.Duration `mapstructure:"loginLockout"`
+}
+
+// Enabled 是否开启管理接口
+func (a Admin) Enabled() bool {
+	return a.Token != "" || a.HMACSecret != ""
+}
+
+// DashboardEnabled 是否开启管理后台
+func (a Admin) DashboardEnabled() bool {
+	return a.Token != "" && a.SessionSecret != ""
+}
+
+type Retention struct {
+	// 对话数据的保留天数，为 0 时永久保留
+	Days int `mapstructure:"days"`
+	// 过期数据的处理方式：delete、metadata、anonymize
+	Mode string `mapstructure:"mode"`
+	// 匿名化用户 Id 时使用的盐，mode 为 anonymize 时必须设置
+	AnonymizeSalt string `mapstructure:"anonymizeSalt" secret:"true"`
+	// 定时清理的间隔，为 0 时不启动
+	PurgeInterval time.Duration `mapstructure:"purgeInterval"`
+}
+
+// MaxAge 对话数据的保留时长
+func (r Retention) MaxAge() time.Duration {
+	return time.Duration(r.Days) * 24 * time.Hour
+}
+
+type Tracing struct {
+	// trace 导出方式：otlp、stdout，为空时不开启
+	Exporter string `mapstructure:"exporter"`
+	// OTLP HTTP 接收地址，如 localhost:4318
+	Endpoint string `mapstructure:"endpoint"`
+	// 使用 HTTP 而不是 HTTPS 连接 OTLP 接收端
+	Insecure bool `mapstructure:"insecure"`
+	// 采样比例，取值 (0, 1]，为 0 时全部采样
+	SampleRatio float64 `mapstructure:"sampleRatio"`
+}
+
+type Health struct {
+	// 就绪检查结果的缓存时长，避免探针频繁请求上游服务
+	CacheTTL time.Duration `mapstructure:"cacheTTL"`
+	// 单次就绪检查的超时时间，为 0 时不限制
+	Timeout time.Duration `mapstructure:"timeout"`
+	// 就绪检查是否检查 OpenAI 的可达性，失败时不影响就绪状态
+	CheckOpenAI bool `mapstructure:"checkOpenAI"`
+}
+
+// Validate 检查必填的配置项和取值范围，返回全部不合法的配置项
+func (c *Config) Validate() error {
+	problems := make([]string, 0)
+	required := map[string]string{
+		"http.port": c.HTTP.Port,
+	}
+	if !c.GPT.Mock {
+		required["gpt.api_key"] = c.GPT.ApiKey
+	}
+	// 配置了 [[apps]] 时可以不配置默认应用
+	if len(c.Apps) == 0 || c.Lark.AppId != "" {
+		required["lark.appId"] = c.Lark.AppId
+		required["lark.appSecret"] = c.Lark.AppSecret
+	}
+	for _, f := range fields() {
+		if value, ok := required[f.key]; ok && value == "" {
+			problems = append(problems, fmt.Sprintf("%s is required", f.key))
+		}
+	}
+	if c.Lark.DailyMessages < 0 || c.Lark.DailyTokens < 0 {
+		problems = append(problems, "lark quotas must not be negative")
+	}
+	names, appIds := make(map[string]bool), map[string]bool{c.Lark.AppId: c.Lark.AppId != ""}
+	for _, app := range c.Apps {
+		problems = append(problems, app.validate()...)
+		if names[app.Name] {
+			problems = append(problems, fmt.Sprintf("duplicate apps.name %q", app.Name))
+		}
+		if appIds[app.AppId] {
+			problems = append(problems, fmt.Sprintf("duplicate appId %q of apps[%s]", app.AppId, app.Name))
+		}
+		names[app.Name], appIds[app.AppId] = true, true
+	}
+	if err := c.Database.Validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, action := range []string{c.Moderation.InputAction, c.Moderation.OutputAction} {
+		switch action {
+		case "", "block", "warn", "redact", "log":
+		default:
+			problems = append(problems, fmt.Sprintf("unsupported moderation action %q, supported actions: block, warn, redact, log", action))
+		}
+	}
+	for _, p := range c.Moderation.Patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid moderation pattern %q: %s", p, err))
+		}
+	}
+	for _, d := range c.PII.Detectors {
+		switch d {
+		case "idcard", "mobile", "email", "accesskey", "ip":
+		default:
+			problems = append(problems, fmt.Sprintf("unsupported pii detector %q, supported detectors: idcard, mobile, email, accesskey, ip", d))
+		}
+	}
+	for _, rule := range c.PII.Rules {
+		if !piiRuleNameRegexp.MatchString(rule.Name) {
+			problems = append(problems, fmt.Sprintf("invalid pii.rules.name %q, only letters, digits and _ are allowed", rule.Name))
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid pii rule %s pattern %q: %s", rule.Name, rule.Pattern, err))
+		}
+	}
+	if (len(c.PII.Detectors) > 0 || len(c.PII.Rules) > 0) && c.PII.Key == "" {
+		problems = append(problems, "pii.key is required when pii detectors or rules are configured")
+	}
+	if c.Admin.SessionSecret != "" && c.Admin.Token == "" {
+		problems = append(problems, "admin.token is required when admin.sessionSecret is set")
+	}
+	if c.Admin.SessionSecret != "" && c.Admin.SessionSecret == c.Admin.Token {
+		problems = append(problems, "admin.sessionSecret must differ from admin.token")
+	}
+	if c.Admin.LoginMaxFailures < 0 {
+		problems = append(problems, "admin.loginMaxFailures must not be negative")
+	}
+	if c.Record.Enabled && c.Record.Filename == "" {
+		problems = append(problems, "record.filename is required when record is enabled")
+	}
+	if c.Audit.Enabled && c.Audit.Filename == "" {
+		problems = append(problems, "audit.filename is required when audit is enabled")
+	}
+	if c.HTTP.MaxBodySize < 0 {
+		problems = append(problems, "http.maxBodySize must not be negative")
+	}
+	for _, proxy := range c.HTTP.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			problems = append(problems, fmt.Sprintf("invalid http.trustedProxies %q, must be an IP or CIDR", proxy))
+		}
+	}
+	if c.Logger.AccessSampleRate < 0 || c.Logger.AccessSampleRate > 1 {
+		problems = append(problems, "logger.access_sample_rate must be between 0 and 1")
+	}
+	if c.GPT.MaxConcurrency < 0 {
+		problems = append(problems, "gpt.maxConcurrency must not be negative")
+	}
+	if c.Retention.Days < 0 {
+		problems = append(problems, "retention.days must not be negative")
+	}
+	if c.Retention.Days > 0 {
+		switch c.Retention.Mode {
+		case "delete", "metadata", "anonymize":
+		default:
+			problems = append(problems, fmt.Sprintf("unsupported retention.mode %q, supported modes: delete, metadata, anonymize", c.Retention.Mode))
+		}
+		if c.Retention.Mode == "anonymize" && c.Retention.AnonymizeSalt == "" {
+			problems = append(problems, "retention.anonymizeSalt is required when retention.mode is anonymize")
+		}
+	}
+	switch c.Tracing.Exporter {
+	case "", "otlp", "stdout":
+	default:
+		problems = append(problems, fmt.Sprintf("unsupported tracing.exporter %q, supported exporters: otlp, stdout", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		problems = append(problems, "tracing.sampleRatio must be between 0 and 1")
+	}
+	switch c.RAG.Storage {
+	case "", "database":
+	case "file":
+		if c.RAG.IndexDir == "" {
+			problems = append(problems, "rag.indexDir is required when rag.storage is file")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unsupported rag.storage %q, supported storages: database, file", c.RAG.Storage))
+	}
+	if c.RAG.ChunkSize < 0 || c.RAG.ChunkOverlap < 0 || c.RAG.TopK < 0 {
+		problems = append(problems, "rag.chunkSize, rag.chunkOverlap and rag.topK must not be negative")
+	}
+	kbs := []string{c.RAG.KnowledgeBase}
+	for _, app := range c.Apps {
+		kbs = append(kbs, app.KnowledgeBase)
+	}
+	for _, chat := range c.RAG.Chats {
+		if chat.ChatId == "" {
+			problems = append(problems, "rag.chats.chatId is required")
+		}
+		kbs = append(kbs, chat.KnowledgeBase)
+	}
+	larkApps := make(map[string]string)
+	for _, l := range c.RAG.Lark {
+		if app, ok := larkApps[l.KnowledgeBase]; ok && app != l.App {
+			problems = append(problems, fmt.Sprintf("rag.lark of knowledge base %q must use the same app", l.KnowledgeBase))
+		}
+		larkApps[l.KnowledgeBase] = l.App
+		if l.KnowledgeBase == "" {
+			problems = append(problems, "rag.lark.knowledgeBase is required")
+		}
+		if _, ok := c.LarkApp(l.App); !ok {
+			problems = append(problems, fmt.Sprintf("lark app %q of rag.lark is not configured", l.App))
+		}
+		if l.Domain == "" {
+			problems = append(problems, fmt.Sprintf("rag.lark[%s].domain is required", l.KnowledgeBase))
+		}
+		if len(l.SpaceIds)+len(l.Folders)+len(l.Documents) == 0 {
+			problems = append(problems, fmt.Sprintf("rag.lark[%s] requires spaceIds, folders or documents", l.KnowledgeBase))
+		}
+		kbs = append(kbs, l.KnowledgeBase)
+	}
+	if c.RAG.SyncInterval < 0 {
+		problems = append(problems, "rag.syncInterval must not be negative")
+	}
+	for _, kb := range kbs {
+		if kb != "" && !appNameRegexp.MatchString(kb) {
+			problems = append(problems, fmt.Sprintf("invalid knowledge base name %q, only lowercase letters, digits, - and _ are allowed", kb))
+		}
+	}
+	if c.Tools.MaxRounds < 0 || c.Tools.Timeout < 0 || c.Tools.ConfirmTimeout < 0 {
+		problems = append(problems, "tools.maxRounds, tools.timeout and tools.confirmTimeout must not be negative")
+	}
+	for _, p := range c.Tools.Permissions {
+		if p.Tool == "" {
+			problems = append(problems, "tools.permissions.tool is required")
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
 }
 
+// New 读取并校验配置文件，环境变量优先于配置文件，如 LARK_APPSECRET 覆盖 lark.appSecret。
+// 敏感配置可以通过 <key>_file 从文件中读取，如 LARK_APPSECRET_FILE=/run/secrets/lark-app-secret。
 func New(path string) (*Config, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid configuration: %s", err)
+	}
+	return cfg, nil
+}
+
+// Load 读取配置文件但不校验，用于只需要部分配置的命令，如数据库迁移只需要 [database]
+func Load(path string) (*Config, error) {
 	viper.SetConfigFile(path)
 	viper.SetConfigType("toml")
-	viper.AutomaticEnv()
+	if err := bindEnvs(viper.GetViper()); err != nil {
+		return nil, fmt.Errorf("Failed to bind environment variables: %s", err)
+	}
 
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, fmt.Errorf("Failed to Read configuration: %s", err)
 	}
+	if err := loadSecretFiles(viper.GetViper()); err != nil {
+		return nil, fmt.Errorf("Failed to Read secret files: %s", err)
+	}
 
 	cfg := &Config{}
 	if err := viper.Unmarshal(cfg); err != nil {
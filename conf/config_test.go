@@ -0,0 +1,32 @@
+package config
+
+import "testing"
+
+func TestToolsAllowed(t *testing.T) {
+	cases := []struct {
+		name   string
+		tools  Tools
+		tool   string
+		openId string
+		want   bool
+	}{
+		{name: "not configured", tool: "lookup_user", openId: "ou_1", want: true},
+		{name: "not in names", tools: Tools{Names: []string{"get_current_time"}}, tool: "lookup_user", openId: "ou_1"},
+		{name: "in names", tools: Tools{Names: []string{"lookup_user"}}, tool: "lookup_user", openId: "ou_1", want: true},
+		{name: "permitted user", tools: Tools{Permissions: []ToolPermission{{Tool: "lookup_user", Users: []string{"ou_1"}}}}, tool: "lookup_user", openId: "ou_1", want: true},
+		{name: "other user", tools: Tools{Permissions: []ToolPermission{{Tool: "lookup_user", Users: []string{"ou_1"}}}}, tool: "lookup_user", openId: "ou_2"},
+		{name: "no users", tools: Tools{Permissions: []ToolPermission{{Tool: "lookup_user"}}}, tool: "lookup_user", openId: "ou_1"},
+		// 读取同事日程和写入数据的工具没有配置 permissions 时所有用户都不能调用
+		{name: "restricted", tool: "check_freebusy", openId: "ou_1"},
+		{name: "restricted permitted", tools: Tools{Permissions: []ToolPermission{{Tool: "create_task", Users: []string{"ou_1"}}}}, tool: "create_task", openId: "ou_1", want: true},
+		{name: "restricted all users", tools: Tools{Permissions: []ToolPermission{{Tool: "check_freebusy", Users: []string{AllUsers}}}}, tool: "check_freebusy", openId: "ou_2", want: true},
+		{name: "restricted not in names", tools: Tools{Names: []string{"lookup_user"}, Permissions: []ToolPermission{{Tool: "create_task", Users: []string{AllUsers}}}}, tool: "create_task", openId: "ou_1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.tools.Allowed(tc.tool, tc.openId); got != tc.want {
+				t.Errorf("Allowed(%s, %s) = %v, want %v", tc.tool, tc.openId, got, tc.want)
+			}
+		})
+	}
+}
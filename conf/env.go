@@ -0,0 +1,177 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// secretFileSuffix 敏感配置可以通过 <key>_file 指定文件路径，从文件中读取配置值，如 Kubernetes secret
+	secretFileSuffix = "_file"
+	redactedValue    = "******"
+)
+
+// field 配置项的 key 和在 Config 中的位置。elem 不为空时配置项为 [[table]] 数组，elem 为数组元素的类型。
+type field struct {
+	key     string
+	index   []int
+	secret  bool
+	restart bool
+	elem    reflect.Type
+}
+
+// fields 遍历 Config 的全部配置项，key 为 mapstructure tag 以 . 拼接的路径
+func fields() []field {
+	result := make([]field, 0)
+	var walk func(t reflect.Type, prefix string, index []int, restart bool)
+	walk = func(t reflect.Type, prefix string, index []int, restart bool) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := f.Tag.Get("mapstructure")
+			if name == "" || name == "-" {
+				continue
+			}
+			key := name
+			if prefix != "" {
+				key = prefix + "." + name
+			}
+			idx := append(append([]int{}, index...), i)
+			restart := restart || f.Tag.Get("reload") == "restart"
+			if f.Type.Kind() == reflect.Struct {
+				walk(f.Type, key, idx, restart)
+				continue
+			}
+			fd := field{key: key, index: idx, secret: f.Tag.Get("secret") == "true", restart: restart}
+			if f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct {
+				fd.elem = f.Type.Elem()
+			}
+			result = append(result, fd)
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "", nil, false)
+	return result
+}
+
+// bindEnvs 为全部配置项绑定环境变量，如 lark.appSecret 对应 LARK_APPSECRET，
+// 敏感配置额外绑定 LARK_APPSECRET_FILE。配置文件中没有的配置项也可以通过环境变量设置。
+func bindEnvs(v *viper.Viper) error {
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	for _, f := range fields() {
+		if f.elem != nil {
+			continue
+		}
+		if err := v.BindEnv(f.key); err != nil {
+			return err
+		}
+		if f.secret {
+			if err := v.BindEnv(f.key + secretFileSuffix); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadSecretFiles 读取 <key>_file 指定的文件作为敏感配置的值，文件末尾的换行会被去掉。
+// [[table]] 数组中的敏感配置同样支持，如 [[apps]] 中的 appSecret_file。
+func loadSecretFiles(v *viper.Viper) error {
+	for _, f := range fields() {
+		if f.elem != nil {
+			if err := loadTableSecretFiles(v, f); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.secret {
+			continue
+		}
+		path := v.GetString(f.key + secretFileSuffix)
+		if path == "" {
+			continue
+		}
+		content, err := readSecretFile(path)
+		if err != nil {
+			return fmt.Errorf("read %s%s failed: %w", f.key, secretFileSuffix, err)
+		}
+		v.Set(f.key, content)
+	}
+	return nil
+}
+
+func loadTableSecretFiles(v *viper.Viper, f field) error {
+	items, ok := v.Get(f.key).([]interface{})
+	if !ok {
+		return nil
+	}
+	for i, item := range items {
+		table, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for j := 0; j < f.elem.NumField(); j++ {
+			sf := f.elem.Field(j)
+			name := sf.Tag.Get("mapstructure")
+			if sf.Tag.Get("secret") != "true" || name == "" {
+				continue
+			}
+			for k, value := range table {
+				path, ok := value.(string)
+				if !ok || path == "" || !strings.EqualFold(k, name+secretFileSuffix) {
+					continue
+				}
+				content, err := readSecretFile(path)
+				if err != nil {
+					return fmt.Errorf("read %s[%d].%s%s failed: %w", f.key, i, name, secretFileSuffix, err)
+				}
+				table[name] = content
+			}
+		}
+	}
+	v.Set(f.key, items)
+	return nil
+}
+
+func readSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// Redacted 返回隐藏了敏感配置的副本，用于打印配置
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redact(reflect.ValueOf(&redacted).Elem())
+	return &redacted
+}
+
+// redact 隐藏 v 中标记了 secret:"true" 的字段，v 中的数组会被复制，不影响原配置
+func redact(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		fv := v.Field(i)
+		switch {
+		case fv.Kind() == reflect.Struct:
+			redact(fv)
+		case fv.Kind() == reflect.Slice && t.Field(i).Type.Elem().Kind() == reflect.Struct:
+			items := reflect.MakeSlice(fv.Type(), fv.Len(), fv.Len())
+			reflect.Copy(items, fv)
+			for j := 0; j < items.Len(); j++ {
+				redact(items.Index(j))
+			}
+			fv.Set(items)
+		case fv.Kind() == reflect.String && t.Field(i).Tag.Get("secret") == "true" && fv.String() != "":
+			fv.SetString(redactedValue)
+		}
+	}
+}
+
+func (c *Config) String() string {
+	return fmt.Sprintf("%+v", *c.Redacted())
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// ReloadResult 重新加载配置的结果
+type ReloadResult struct {
+	// 已生效的配置项
+	Changed []string `json:"changed"`
+	// 已修改但需要重启才能生效的配置项
+	RestartRequired []string `json:"restartRequired"`
+}
+
+// Reloader 持有当前生效的配置快照，重新加载时校验新的配置并原子地替换快照
+type Reloader struct {
+	mu      sync.Mutex
+	current atomic.Pointer[Config]
+}
+
+func NewReloader(cfg *Config) *Reloader {
+	r := &Reloader{}
+	r.current.Store(cfg)
+	return r
+}
+
+// Load 返回当前生效的配置快照，快照不会被修改
+func (r *Reloader) Load() *Config {
+	return r.current.Load()
+}
+
+// Reload 重新读取配置文件。配置不合法时保留当前配置；需要重启才能生效的配置项保持原值。
+func (r *Reloader) Reload() (*ReloadResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := viper.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("Failed to Read configuration: %s", err)
+	}
+	if err := loadSecretFiles(viper.GetViper()); err != nil {
+		return nil, fmt.Errorf("Failed to Read secret files: %s", err)
+	}
+	next := &Config{}
+	if err := viper.Unmarshal(next); err != nil {
+		return nil, fmt.Errorf("Failed to Unmarshal configuration: %s", err)
+	}
+	if err := next.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid configuration: %s", err)
+	}
+
+	result := &ReloadResult{Changed: make([]string, 0), RestartRequired: make([]string, 0)}
+	prev := r.current.Load()
+	pv, nv := reflect.ValueOf(prev).Elem(), reflect.ValueOf(next).Elem()
+	for _, f := range fields() {
+		pf, nf := pv.FieldByIndex(f.index), nv.FieldByIndex(f.index)
+		if reflect.DeepEqual(pf.Interface(), nf.Interface()) {
+			continue
+		}
+		if f.restart {
+			result.RestartRequired = append(result.RestartRequired, f.key)
+			nf.Set(pf)
+			continue
+		}
+		result.Changed = append(result.Changed, f.key)
+	}
+	r.current.Store(next)
+	return result, nil
+}
+
+// Watch 监听配置文件的变化并重新加载，每次重新加载后调用 fn。
+// 不使用 viper.WatchConfig：它在回调之前自行读取配置文件，与管理接口触发的 Reload 并发修改 viper 的状态。
+// 这里只监听文件事件，读取配置统一由 Reload 在锁内完成。
+func (r *Reloader) Watch(fn func(result *ReloadResult, err error)) error {
+	file := filepath.Clean(viper.ConfigFileUsed())
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("create config watcher failed: %w", err)
+	}
+	// 监听所在目录，编辑器保存和 k8s ConfigMap 更新时会替换文件本身
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("watch config dir failed: %w", err)
+	}
+
+	realFile, _ := filepath.EvalSymlinks(file)
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				current, _ := filepath.EvalSymlinks(file)
+				written := filepath.Clean(e.Name) == file && e.Op&(fsnotify.Write|fsnotify.Create) != 0
+				relinked := current != "" && current != realFile
+				if !written && !relinked {
+					continue
+				}
+				realFile = current
+				fn(r.Reload())
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fn(nil, fmt.Errorf("watch config file failed: %w", err))
+			}
+		}
+	}()
+	return nil
+}
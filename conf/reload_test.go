@@ -0,0 +1,65 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestReloaderWatch 修改配置文件后自动重新加载，同时通过 Reload 手动触发，两者在同一把锁内读取配置
+func TestReloaderWatch(t *testing.T) {
+	data, err := os.ReadFile("online.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = []byte(strings.NewReplacer(`appId=""`, `appId="cli_test"`, `appSecret=""`, `appSecret="secret"`, `api_key = ""`, `api_key = "sk-test"`).Replace(string(data)))
+	path := filepath.Join(t.TempDir(), "app.conf")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReloader(cfg)
+	results := make(chan *ReloadResult, 16)
+	err = r.Watch(func(result *ReloadResult, err error) {
+		if err != nil {
+			t.Errorf("reload: %v", err)
+			return
+		}
+		results <- result
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := strings.Replace(string(data), `closeSessionReply="会话已重启。"`, `closeSessionReply="新的会话已开启。"`, 1)
+	if err := os.WriteFile(path, []byte(changed), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.Reload(); err != nil {
+				t.Errorf("reload: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-results:
+	case <-time.After(5 * time.Second):
+		t.Fatal("config change not watched")
+	}
+	if got := r.Load().Conversation.CloseSessionReply; got != "新的会话已开启。" {
+		t.Errorf("close session reply = %q", got)
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// activityWindow 统计最近多少分钟的消息数
+	activityWindow = 60
+	// maxRecentErrors 保留的最近错误数
+	maxRecentErrors = 50
+)
+
+// trafficPoint 一分钟内处理的消息数和失败数
+type trafficPoint struct {
+	Time     time.Time `json:"time"`
+	Messages int       `json:"messages"`
+	Errors   int       `json:"errors"`
+}
+
+// recentError 处理消息时的错误
+type recentError struct {
+	Time      time.Time `json:"time"`
+	App       string    `json:"app"`
+	UserID    string    `json:"userId"`
+	MessageID string    `json:"messageId"`
+	Error     string    `json:"error"`
+}
+
+// activity 统计应用最近的消息数和错误，用于管理后台展示。数据保存在内存中，只包含本实例处理的消息。
+type activity struct {
+	mu     sync.Mutex
+	points [activityWindow]trafficPoint
+	errors []recentError
+}
+
+func newActivity() *activity {
+	return &activity{}
+}
+
+// point 返回 t 所在分钟的统计，跨分钟时清空旧的统计
+func (a *activity) point(t time.Time) *trafficPoint {
+	minute := t.Truncate(time.Minute)
+	p := &a.points[minute.Unix()/60%activityWindow]
+	if !p.Time.Equal(minute) {
+		*p = trafficPoint{Time: minute}
+	}
+	return p
+}
+
+// observe 记录一条消息的处理结果
+func (a *activity) observe(app string, msg *message, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	p := a.point(now)
+	p.Messages++
+	if err == nil {
+		return
+	}
+	p.Errors++
+	a.errors = append(a.errors, recentError{Time: now, App: app, UserID: msg.openId, MessageID: msg.messageId, Error: err.Error()})
+	if len(a.errors) > maxRecentErrors {
+		a.errors = a.errors[len(a.errors)-maxRecentErrors:]
+	}
+}
+
+// traffic 返回最近 activityWindow 分钟每分钟的统计，按时间正序
+func (a *activity) traffic() []trafficPoint {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now().Truncate(time.Minute)
+	result := make([]trafficPoint, 0, activityWindow)
+	for i := activityWindow - 1; i >= 0; i-- {
+		t := now.Add(-time.Duration(i) * time.Minute)
+		p := a.points[t.Unix()/60%activityWindow]
+		if !p.Time.Equal(t) {
+			p = trafficPoint{Time: t}
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// recentErrors 返回最近的错误，按时间倒序
+func (a *activity) recentErrors() []recentError {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	result := make([]recentError, 0, len(a.errors))
+	for i := len(a.errors) - 1; i >= 0; i-- {
+		result = append(result, a.errors[i])
+	}
+	return result
+}
@@ -0,0 +1,573 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/export"
+	"github.com/fanchunke/chatgpt-lark/internal/metrics"
+	"github.com/fanchunke/chatgpt-lark/internal/middleware"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// ExportConversation 导出 xgpt3 对话，format 支持 md、json、html
+func (r *router) ExportConversation(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid conversation id"})
+		return
+	}
+	format, err := export.ParseFormat(c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+
+	conv, err := export.Load(c.Request.Context(), r.store, id)
+	if err == store.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "conversation not found"})
+		return
+	}
+	if err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msgf("Load conversation error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "load conversation failed"})
+		return
+	}
+
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(export.FileName(conv, format)))
+	c.Status(http.StatusOK)
+	if err := export.Render(c.Writer, conv, format); err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msgf("Render conversation error: %v", err)
+	}
+}
+
+// ForgetUser 删除用户的全部对话数据
+func (r *router) ForgetUser(c *gin.Context) {
+	userId := c.Param("userId")
+	result, err := r.store.ForgetUser(c.Request.Context(), userId)
+	if err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msgf("Forget user error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "forget user failed"})
+		return
+	}
+	log.Ctx(c.Request.Context()).Info().Msgf("[UserId: %s] Forget user, %s", userId, result)
+	c.JSON(http.StatusOK, gin.H{
+		"messages":      result.Messages,
+		"conversations": result.Conversations,
+		"usages":        result.Usages,
+		"sessions":      result.Sessions,
+		"feedbacks":     result.Feedbacks,
+		"piiValues":     result.PIIValues,
+	})
+}
+
+// ReloadConfig 重新加载配置文件，返回已生效和需要重启才能生效的配置项
+func (r *router) ReloadConfig(c *gin.Context) {
+	result, err := r.reloader.Reload()
+	if err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msgf("Reload config error: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+	log.Ctx(c.Request.Context()).Info().Msgf("Config reloaded, changed: %v, restart required: %v", result.Changed, result.RestartRequired)
+	c.JSON(http.StatusOK, result)
+}
+
+// defaultAppParam 管理接口中默认应用 [lark] 的名称，应用名称不能以 _ 开头，不会与其他应用冲突
+const defaultAppParam = "_default"
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type conversationView struct {
+	ID              int       `json:"id"`
+	ConversationKey string    `json:"conversationKey"`
+	UserID          string    `json:"userId"`
+	Session         string    `json:"session"`
+	Open            bool      `json:"open"`
+	Messages        int       `json:"messages"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+type messageView struct {
+	ID         int       `json:"id"`
+	FromUserID string    `json:"fromUserId"`
+	ToUserID   string    `json:"toUserId"`
+	Content    string    `json:"content"`
+	ReplyTo    int       `json:"replyTo,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// ListConversations 查询 xgpt3 对话，支持按用户 Id、消息内容和开启状态过滤
+func (r *router) ListConversations(c *gin.Context) {
+	filter := store.ConversationFilter{UserID: c.Query("userId"), Query: c.Query("q")}
+	if status := c.Query("status"); status != "" {
+		open := status == "open"
+		if !open && status != "closed" {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "status must be open or closed"})
+			return
+		}
+		filter.Open = &open
+	}
+	var err error
+	if filter.Limit, filter.Offset, err = pagination(c); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+
+	convs, total, err := r.store.ListConversations(c.Request.Context(), filter)
+	if err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msgf("List conversations error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "list conversations failed"})
+		return
+	}
+	result := make([]conversationView, 0, len(convs))
+	for _, conv := range convs {
+		result = append(result, conversationView{
+			ID:              conv.ID,
+			ConversationKey: conv.ConversationKey,
+			UserID:          conv.UserID,
+			Session:         conv.SessionName,
+			Open:            conv.Open,
+			Messages:        conv.Messages,
+			CreatedAt:       conv.CreatedAt,
+			UpdatedAt:       conv.UpdatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"total": total, "conversations": result})
+}
+
+// ListConversationMessages 获取 xgpt3 对话的全部消息
+func (r *router) ListConversationMessages(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid conversation id"})
+		return
+	}
+	conv, msgs, err := r.store.GetConversation(c.Request.Context(), id)
+	if err == store.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "conversation not found"})
+		return
+	}
+	if err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msgf("Get conversation error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "get conversation failed"})
+		return
+	}
+	result := make([]messageView, 0, len(msgs))
+	for _, msg := range msgs {
+		result = append(result, messageView{
+			ID:         msg.ID,
+			FromUserID: msg.FromUserID,
+			ToUserID:   msg.ToUserID,
+			Content:    msg.Content,
+			ReplyTo:    msg.SpouseID,
+			CreatedAt:  msg.CreatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":              conv.ID,
+		"conversationKey": conv.UserID,
+		"open":            conv.Status,
+		"messages":        result,
+	})
+}
+
+// CloseUserConversation 强制关闭用户会话当前的 xgpt3 对话，用户下一条消息将开启新的对话。
+// app 为会话所属的应用，为空或 _default 时为默认应用；session 为空时关闭用户的当前会话。
+func (r *router) CloseUserConversation(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId, app, name := c.Param("userId"), c.Query("app"), c.Query("session")
+	if app == defaultAppParam {
+		app = config.DefaultAppName
+	}
+	sessions, err := r.store.ListSessions(ctx, app, userId)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("List sessions error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "list sessions failed"})
+		return
+	}
+	var sess *store.Session
+	for _, s := range sessions {
+		if (name == "" && s.Active) || (name != "" && s.Name == name) {
+			sess = s
+			break
+		}
+	}
+	if sess == nil {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "session not found"})
+		return
+	}
+
+	if err := r.xgpt3Client.CloseConversation(ctx, sess.ConversationKey); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("Close conversation error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "close conversation failed"})
+		return
+	}
+	log.Ctx(ctx).Info().Msgf("[UserId: %s] Conversation of session %s closed by admin", userId, sess.Name)
+	c.JSON(http.StatusOK, gin.H{"userId": userId, "session": sess.Name, "conversationKey": sess.ConversationKey})
+}
+
+// ListApps 获取全部飞书应用的生效配置
+func (r *router) ListApps(c *gin.Context) {
+	result := make([]gin.H, 0, len(r.apps))
+	for _, app := range r.apps {
+		conf, settings, err := r.appSettings(c.Request.Context(), app)
+		if err != nil {
+			log.Ctx(c.Request.Context()).Error().Err(err).Msgf("Load app settings error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "load app settings failed"})
+			return
+		}
+		result = append(result, appView(app, conf, settings))
+	}
+	c.JSON(http.StatusOK, gin.H{"apps": result})
+}
+
+// GetAppSettings 获取应用的生效配置和管理接口修改的设置
+func (r *router) GetAppSettings(c *gin.Context) {
+	app, ok := r.appByParam(c)
+	if !ok {
+		return
+	}
+	conf, settings, err := r.appSettings(c.Request.Context(), app)
+	if err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msgf("Load app settings error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "load app settings failed"})
+		return
+	}
+	c.JSON(http.StatusOK, appView(app, conf, settings))
+}
+
+// UpdateAppSettings 修改应用的人设、每日额度和白名单，请求中未包含的设置保持不变
+func (r *router) UpdateAppSettings(c *gin.Context) {
+	app, ok := r.appByParam(c)
+	if !ok {
+		return
+	}
+	update := &appSettings{}
+	if err := c.ShouldBindJSON(update); err != nil {
+		if middleware.IsBodyTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"msg": "request body too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+	if err := update.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+	values, err := update.encode()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+	if err := r.store.SetAppSettings(c.Request.Context(), app.name, values); err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msgf("Update app settings error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "update app settings failed"})
+		return
+	}
+	log.Ctx(c.Request.Context()).Info().Msgf("[App: %s] App settings updated: %v", app.name, keys(values))
+	r.GetAppSettings(c)
+}
+
+// ResetAppSettings 删除管理接口修改的设置，恢复使用配置文件中的值。name 为空时删除全部设置。
+func (r *router) ResetAppSettings(c *gin.Context) {
+	app, ok := r.appByParam(c)
+	if !ok {
+		return
+	}
+	names := c.QueryArray("name")
+	for _, name := range names {
+		if !contains(settingNames, name) {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": fmt.Sprintf("unsupported setting %q, supported settings: %s", name, strings.Join(settingNames, ", "))})
+			return
+		}
+	}
+	if len(names) == 0 {
+		names = settingNames
+	}
+	if err := r.store.DeleteAppSettings(c.Request.Context(), app.name, names...); err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msgf("Reset app settings error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "reset app settings failed"})
+		return
+	}
+	log.Ctx(c.Request.Context()).Info().Msgf("[App: %s] App settings reset: %v", app.name, names)
+	r.GetAppSettings(c)
+}
+
+// GetAppQuota 获取应用当天每个用户已使用的额度。额度计数保存在数据库中，多个实例之间共享。
+func (r *router) GetAppQuota(c *gin.Context) {
+	app, ok := r.appByParam(c)
+	if !ok {
+		return
+	}
+	conf, _, err := r.appSettings(c.Request.Context(), app)
+	if err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msgf("Load app settings error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "load app settings failed"})
+		return
+	}
+	day, users, err := app.quota.usages(c.Request.Context())
+	if err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msgf("List quota usages error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "list quota usages failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"day":           day,
+		"dailyMessages": conf.DailyMessages,
+		"dailyTokens":   conf.DailyTokens,
+		"users":         users,
+	})
+}
+
+// ResetUserQuota 清空用户当天已使用的额度
+func (r *router) ResetUserQuota(c *gin.Context) {
+	app, ok := r.appByParam(c)
+	if !ok {
+		return
+	}
+	userId := c.Param("userId")
+	if err := app.quota.resetUser(c.Request.Context(), userId); err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msgf("Reset user quota error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "reset user quota failed"})
+		return
+	}
+	log.Ctx(c.Request.Context()).Info().Msgf("[App: %s] [UserId: %s] Quota reset by admin", app.name, userId)
+	c.JSON(http.StatusOK, gin.H{"userId": userId})
+}
+
+// UsageStats 统计 GPT 用量，from、to 为 2006-01-02 格式的日期（包含 to 当天），默认统计最近 7 天。
+// groupBy 支持 model、user、day。
+func (r *router) UsageStats(c *gin.Context) {
+	group, err := store.ParseUsageGroup(c.Query("groupBy"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	from, err := parseDate(c.Query("from"), today.AddDate(0, 0, -6))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid from: " + err.Error()})
+		return
+	}
+	to, err := parseDate(c.Query("to"), today)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid to: " + err.Error()})
+		return
+	}
+
+	stats, err := r.store.UsageStats(c.Request.Context(), from, to.AddDate(0, 0, 1), group)
+	if err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msgf("Query usage stats error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "query usage stats failed"})
+		return
+	}
+	total := &store.UsageStat{}
+	result := make([]gin.H, 0, len(stats))
+	for _, stat := range stats {
+		total.Requests += stat.Requests
+		total.PromptTokens += stat.PromptTokens
+		total.CompletionTokens += stat.CompletionTokens
+		total.TotalTokens += stat.TotalTokens
+		if group == store.UsageGroupApp && stat.Key == config.DefaultAppName {
+			stat.Key = defaultAppParam
+		}
+		result = append(result, usageView(stat))
+	}
+	totalView := usageView(total)
+	delete(totalView, "key")
+	c.JSON(http.StatusOK, gin.H{
+		"from":    from.Format("2006-01-02"),
+		"to":      to.Format("2006-01-02"),
+		"groupBy": group,
+		"total":   totalView,
+		"stats":   result,
+	})
+}
+
+// Traffic 获取本实例最近 60 分钟每分钟处理的消息数和失败数，以及正在处理和排队的消息数
+func (r *router) Traffic(c *gin.Context) {
+	var points []trafficPoint
+	for _, app := range r.apps {
+		for i, p := range app.activity.traffic() {
+			if points == nil {
+				points = make([]trafficPoint, activityWindow)
+			}
+			points[i].Time = p.Time
+			points[i].Messages += p.Messages
+			points[i].Errors += p.Errors
+		}
+	}
+	if points == nil {
+		points = make([]trafficPoint, 0)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"inFlight": metrics.GaugeValue(metrics.InFlight),
+		"queued":   metrics.GaugeValue(metrics.QueueDepth),
+		"points":   points,
+	})
+}
+
+// RecentErrors 获取本实例处理消息时最近的错误
+func (r *router) RecentErrors(c *gin.Context) {
+	result := make([]recentError, 0)
+	for _, app := range r.apps {
+		result = append(result, app.activity.recentErrors()...)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Time.After(result[j].Time) })
+	if len(result) > maxRecentErrors {
+		result = result[:maxRecentErrors]
+	}
+	c.JSON(http.StatusOK, gin.H{"errors": result})
+}
+
+// ListFeedbacks 按时间倒序获取用户的评价，rating 支持 good、bad，为空时返回全部评价
+func (r *router) ListFeedbacks(c *gin.Context) {
+	ratings := map[string]int{"": 0, "good": store.RatingGood, "bad": store.RatingBad}
+	rating, ok := ratings[c.Query("rating")]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "rating must be good or bad"})
+		return
+	}
+	limit, _, err := pagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+	feedbacks, err := r.store.ListFeedbacks(c.Request.Context(), rating, limit)
+	if err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msgf("List feedbacks error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "list feedbacks failed"})
+		return
+	}
+	result := make([]gin.H, 0, len(feedbacks))
+	for _, f := range feedbacks {
+		result = append(result, gin.H{
+			"id":              f.ID,
+			"userId":          f.UserID,
+			"conversationKey": f.ConversationKey,
+			"messageId":       f.MessageID,
+			"conversationId":  f.ConversationID,
+			"rating":          f.Rating,
+			"comment":         f.Comment,
+			"createdAt":       f.CreatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"feedbacks": result})
+}
+
+// appByParam 根据路径参数 app 查找应用，未找到时返回 404
+func (r *router) appByParam(c *gin.Context) (*larkApp, bool) {
+	name := c.Param("app")
+	if name == defaultAppParam {
+		name = config.DefaultAppName
+	}
+	for _, app := range r.apps {
+		if app.name == name {
+			return app, true
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"msg": "app not found"})
+	return nil, false
+}
+
+// appSettings 从数据库加载应用设置，返回覆盖后的应用配置，同时刷新本实例的缓存
+func (r *router) appSettings(ctx context.Context, app *larkApp) (config.LarkApp, *appSettings, error) {
+	settings, err := loadAppSettings(ctx, r.store, app.name)
+	if err != nil {
+		return config.LarkApp{}, nil, err
+	}
+	app.settings.set(settings)
+	conf, _ := r.reloader.Load().LarkApp(app.name)
+	return settings.apply(conf), settings, nil
+}
+
+func appView(app *larkApp, conf config.LarkApp, settings *appSettings) gin.H {
+	name := app.name
+	if name == config.DefaultAppName {
+		name = defaultAppParam
+	}
+	allowList := make([]string, 0)
+	if settings.AllowList != nil {
+		allowList = *settings.AllowList
+	}
+	overrides, _ := settings.encode()
+	return gin.H{
+		"name":          name,
+		"appId":         conf.AppId,
+		"version":       conf.Version,
+		"model":         conf.Model,
+		"systemPrompt":  conf.SystemPrompt,
+		"dailyMessages": conf.DailyMessages,
+		"dailyTokens":   conf.DailyTokens,
+		"allowList":     allowList,
+		"overrides":     keys(overrides),
+	}
+}
+
+func usageView(stat *store.UsageStat) gin.H {
+	return gin.H{
+		"key":              stat.Key,
+		"requests":         stat.Requests,
+		"promptTokens":     stat.PromptTokens,
+		"completionTokens": stat.CompletionTokens,
+		"totalTokens":      stat.TotalTokens,
+	}
+}
+
+// pagination 解析分页参数 limit、offset
+func pagination(c *gin.Context) (int, int, error) {
+	limit, offset := defaultPageSize, 0
+	var err error
+	if s := c.Query("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 || limit > maxPageSize {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+	}
+	if s := c.Query("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must not be negative")
+		}
+	}
+	return limit, offset, nil
+}
+
+func parseDate(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+func keys(m map[string]string) []string {
+	result := make([]string, 0, len(m))
+	for k := range m {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
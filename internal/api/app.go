@@ -0,0 +1,32 @@
+package api
+
+import (
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/pii"
+	"github.com/fanchunke/chatgpt-lark/internal/tools"
+	lark "github.com/larksuite/oapi-sdk-go/v3"
+)
+
+// larkApp 一个飞书应用的运行时状态，应用的配置在每个事件中从配置快照读取
+type larkApp struct {
+	name   string
+	client *lark.Client
+	// 回复消息的发送渠道
+	channel Channel
+	quota   *quota
+	// 敏感信息的占位符和加密后的原始值，未配置 pii.key 时为 nil
+	vault *pii.Vault
+	// 通过管理接口修改的应用设置
+	settings *settingsCache
+	// 最近的消息数和错误，用于管理后台展示
+	activity *activity
+	// GPT 可以调用的工具，以及等待用户确认的写操作
+	tools   *tools.Registry
+	pending *pendingCalls
+}
+
+// appConfig 获取当前配置快照中应用的配置，并使用管理接口修改的设置覆盖
+func (h *callbackHandler) appConfig() config.LarkApp {
+	app, _ := h.cfg.LarkApp(h.app.name)
+	return h.app.settings.get().apply(app)
+}
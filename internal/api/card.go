@@ -0,0 +1,207 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/fanchunke/chatgpt-lark/internal/audit"
+	"github.com/fanchunke/chatgpt-lark/internal/metrics"
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	cardActionSwitchSession = "session.switch"
+	cardActionDeleteSession = "session.delete"
+	cardActionConfirmTool   = "tool.confirm"
+	cardActionCancelTool    = "tool.cancel"
+)
+
+// sessionCard 构造会话列表卡片，每个会话提供切换和删除按钮
+func (h *callbackHandler) sessionCard(ctx context.Context, openId string) (map[string]interface{}, error) {
+	active, err := h.store.ActiveSession(ctx, h.app.name, openId)
+	if err != nil {
+		return nil, fmt.Errorf("get active session failed: %w", err)
+	}
+	sessions, err := h.store.ListSessions(ctx, h.app.name, openId)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions failed: %w", err)
+	}
+
+	elements := make([]interface{}, 0)
+	for i, sess := range sessions {
+		if i > 0 {
+			elements = append(elements, map[string]interface{}{"tag": "hr"})
+		}
+		title := fmt.Sprintf("**%s**", sess.Name)
+		if sess.ID == active.ID {
+			title += "（当前）"
+		}
+		desc := fmt.Sprintf("%s\n模型：%s", title, h.sessionModel(sess))
+		if sess.SystemPrompt != "" {
+			desc += fmt.Sprintf("\n系统提示词：%s", h.restorePII(ctx, openId, sess.SystemPrompt))
+		}
+		elements = append(elements, map[string]interface{}{
+			"tag":  "div",
+			"text": map[string]interface{}{"tag": "lark_md", "content": desc},
+		})
+
+		actions := make([]interface{}, 0)
+		if sess.ID != active.ID {
+			actions = append(actions, cardButton("切换", "primary", cardActionSwitchSession, sess.Name, nil))
+		}
+		actions = append(actions, cardButton("删除", "danger", cardActionDeleteSession, sess.Name, map[string]interface{}{
+			"title": map[string]interface{}{"tag": "plain_text", "content": "删除会话"},
+			"text":  map[string]interface{}{"tag": "plain_text", "content": fmt.Sprintf("确认删除会话「%s」吗？", sess.Name)},
+		}))
+		elements = append(elements, map[string]interface{}{"tag": "action", "actions": actions})
+	}
+	elements = append(elements, map[string]interface{}{
+		"tag": "note",
+		"elements": []interface{}{
+			map[string]interface{}{"tag": "plain_text", "content": "发送 /session new <名称> 创建新会话"},
+		},
+	})
+
+	return map[string]interface{}{
+		"config": map[string]interface{}{"wide_screen_mode": true, "update_multi": true},
+		"header": map[string]interface{}{
+			"title": map[string]interface{}{"tag": "plain_text", "content": "会话列表"},
+		},
+		"elements": elements,
+	}, nil
+}
+
+func cardButton(text, buttonType, action, name string, confirm map[string]interface{}) map[string]interface{} {
+	button := map[string]interface{}{
+		"tag":   "button",
+		"text":  map[string]interface{}{"tag": "plain_text", "content": text},
+		"type":  buttonType,
+		"value": map[string]interface{}{"action": action, "name": name},
+	}
+	if confirm != nil {
+		button["confirm"] = confirm
+	}
+	return button
+}
+
+// toolConfirmCard 构造写操作的确认卡片，id 为等待确认的操作 Id
+func (h *callbackHandler) toolConfirmCard(id, description string) map[string]interface{} {
+	card := toolCard("请确认操作", "orange", description)
+	elements := card["elements"].([]interface{})
+	elements = append(elements,
+		map[string]interface{}{"tag": "action", "actions": []interface{}{
+			cardButton("确认", "primary", cardActionConfirmTool, id, nil),
+			cardButton("取消", "default", cardActionCancelTool, id, nil),
+		}},
+		map[string]interface{}{"tag": "note", "elements": []interface{}{
+			map[string]interface{}{"tag": "plain_text", "content": fmt.Sprintf("仅发起人可以确认，%s 内有效", humanizeDuration(h.confirmTimeout()))},
+		}},
+	)
+	card["elements"] = elements
+	return card
+}
+
+// toolCard 构造工具调用结果的卡片，template 为标题栏的颜色
+func toolCard(title, template, content string) map[string]interface{} {
+	elements := []interface{}{
+		map[string]interface{}{
+			"tag":  "div",
+			"text": map[string]interface{}{"tag": "plain_text", "content": content},
+		},
+	}
+	return map[string]interface{}{
+		"config": map[string]interface{}{"wide_screen_mode": true, "update_multi": true},
+		"header": map[string]interface{}{
+			"title":    map[string]interface{}{"tag": "plain_text", "content": title},
+			"template": template,
+		},
+		"elements": elements,
+	}
+}
+
+func (h *callbackHandler) sendSessionCard(ctx context.Context, appId, openId string) error {
+	card, err := h.sessionCard(ctx, openId)
+	if err != nil {
+		return err
+	}
+	return h.sendCard(ctx, appId, openId, card)
+}
+
+func (h *callbackHandler) sendCard(ctx context.Context, appId, openId string, card map[string]interface{}) error {
+	content, err := json.Marshal(card)
+	if err != nil {
+		return fmt.Errorf("marshal card failed: %w", err)
+	}
+	return h.sendMessage(ctx, appId, openId, larkim.MsgTypeInteractive, string(content))
+}
+
+// cardChatId 获取卡片所在的会话 Id。SDK 的 CardAction 没有 open_chat_id 字段，从回调内容中解析，解析失败时返回空
+func cardChatId(action *larkcard.CardAction) string {
+	if action.EventReq == nil {
+		return ""
+	}
+	var body struct {
+		OpenChatID string `json:"open_chat_id"`
+	}
+	if err := json.Unmarshal(action.EventReq.Body, &body); err != nil {
+		return ""
+	}
+	return body.OpenChatID
+}
+
+// OnCardAction: 用户点击消息卡片按钮后触发此回调，返回更新后的卡片。
+func (h *callbackHandler) OnCardAction(ctx context.Context, action *larkcard.CardAction) (interface{}, error) {
+	h = h.withConfig()
+	if action.Action == nil {
+		return nil, nil
+	}
+	name, _ := action.Action.Value["name"].(string)
+	kind, _ := action.Action.Value["action"].(string)
+
+	var (
+		reply string
+		err   error
+	)
+	switch kind {
+	case cardActionSwitchSession, cardActionDeleteSession, cardActionConfirmTool, cardActionCancelTool:
+		metrics.Commands.WithLabelValues(kind).Inc()
+	default:
+		// 卡片操作来自回调内容，未知的操作统一记为 unknown
+		metrics.Commands.WithLabelValues("unknown").Inc()
+		log.Ctx(ctx).Warn().Msgf("UnSupported card action: %v", kind)
+		return nil, nil
+	}
+
+	// 检查白名单，移出白名单的用户不能继续操作之前收到的卡片
+	h.refreshSettings(ctx)
+	if !h.app.settings.get().allowed(action.OpenID) {
+		log.Ctx(ctx).Info().Msgf("[UserId: %s] User not allowed, card action %s ignored", action.OpenID, kind)
+		return nil, nil
+	}
+
+	switch kind {
+	case cardActionSwitchSession:
+		reply, err = h.switchSession(ctx, action.OpenID, name)
+	case cardActionDeleteSession:
+		reply, err = h.deleteSession(ctx, action.OpenID, name)
+	case cardActionConfirmTool, cardActionCancelTool:
+		return h.toolCardAction(ctx, action.OpenID, action.OpenMessageID, kind, name)
+	}
+	h.audit(ctx, &audit.Record{
+		Action:    audit.ActionCardAction,
+		AppId:     h.appConfig().AppId,
+		UserId:    action.OpenID,
+		ChatId:    cardChatId(action),
+		MessageId: action.OpenMessageID,
+	}, kind+" "+name, reply, err)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("Handle card action error: %v", err)
+		return nil, err
+	}
+	log.Ctx(ctx).Info().Msgf("[UserId: %s] Card action %s: %s", action.OpenID, kind, reply)
+
+	return h.sessionCard(ctx, action.OpenID)
+}
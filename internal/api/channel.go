@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	lark "github.com/larksuite/oapi-sdk-go/v3"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+// Channel 回复消息的发送渠道，飞书应用使用飞书开放平台，cmd/chat 使用终端
+type Channel interface {
+	// SendMessage 发送消息给用户，msgType 和 content 与飞书消息的格式一致
+	SendMessage(ctx context.Context, userId, msgType, content string) error
+	// UploadFile 上传文件，返回文件消息中使用的 file_key
+	UploadFile(ctx context.Context, fileName string, file io.Reader) (string, error)
+}
+
+// larkChannel 通过飞书开放平台发送消息
+type larkChannel struct {
+	client *lark.Client
+}
+
+func (c *larkChannel) SendMessage(ctx context.Context, userId, msgType, content string) error {
+	resp, err := c.client.Im.Message.Create(ctx, larkim.NewCreateMessageReqBuilder().
+		ReceiveIdType(larkim.ReceiveIdTypeOpenId).
+		Body(larkim.NewCreateMessageReqBodyBuilder().
+			MsgType(msgType).
+			ReceiveId(userId).
+			Content(content).
+			Build()).
+		Build())
+	if err != nil {
+		return err
+	}
+	if !resp.Success() {
+		return fmt.Errorf("%d %s", resp.Code, resp.Msg)
+	}
+	return nil
+}
+
+func (c *larkChannel) UploadFile(ctx context.Context, fileName string, file io.Reader) (string, error) {
+	resp, err := c.client.Im.File.Create(ctx, larkim.NewCreateFileReqBuilder().
+		Body(larkim.NewCreateFileReqBodyBuilder().
+			FileType(larkim.FileTypeStream).
+			FileName(fileName).
+			File(file).
+			Build()).
+		Build())
+	if err != nil {
+		return "", err
+	}
+	if !resp.Success() {
+		return "", fmt.Errorf("%d %s", resp.Code, resp.Msg)
+	}
+	return *resp.Data.FileKey, nil
+}
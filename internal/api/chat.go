@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/audit"
+	"github.com/fanchunke/chatgpt-lark/internal/kb"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/fanchunke/chatgpt-lark/internal/tools"
+	"github.com/fanchunke/xgpt3"
+)
+
+// ChatOptions 本地会话的用户和应用
+type ChatOptions struct {
+	// AppName 使用的飞书应用配置，默认为 [lark] 中的默认应用
+	AppName string
+	// AppId 为空时使用应用配置中的 appId
+	AppId string
+	// UserId 用户的 open_id
+	UserId string
+	// Version 回调版本 v1 或 v2，为空时使用应用配置中的版本
+	Version string
+}
+
+// Chat 在终端等本地渠道中处理用户消息，与飞书回调使用相同的处理流程，包括命令、会话历史、系统提示词和内容审核，
+// 回复通过 channel 发送。本地渠道没有飞书 client，只能调用不依赖飞书接口的工具。
+type Chat struct {
+	h      *callbackHandler
+	appId  string
+	userId string
+	seq    int
+}
+
+func NewChat(reloader *config.Reloader, xgpt3Client *xgpt3.Client, store *store.Store, auditor *audit.Logger, retriever *kb.Retriever, toolClient *tools.Client, channel Channel, opts ChatOptions) (*Chat, error) {
+	cfg := reloader.Load()
+	appConf, ok := cfg.LarkApp(opts.AppName)
+	if !ok {
+		return nil, fmt.Errorf("lark app %q not found", opts.AppName)
+	}
+	// 默认应用同时提供 v1 和 v2 回调，未指定时使用 v2
+	version := versionType(opts.Version)
+	if version == "" {
+		version = versionType(appConf.Version)
+	}
+	if version == "" {
+		version = callbackVersionV2
+	}
+	if version != callbackVersionV1 && version != callbackVersionV2 {
+		return nil, fmt.Errorf("unsupported version %q, supported versions: v1, v2", version)
+	}
+	if opts.UserId == "" {
+		return nil, fmt.Errorf("user id is required")
+	}
+	appId := opts.AppId
+	if appId == "" {
+		appId = appConf.AppId
+	}
+
+	vault, err := newVault(appConf.Name, cfg, store)
+	if err != nil {
+		return nil, fmt.Errorf("init pii vault failed: %w", err)
+	}
+	app := &larkApp{
+		name:     appConf.Name,
+		channel:  channel,
+		quota:    newQuota(store, appConf.Name),
+		vault:    vault,
+		settings: newSettingsCache(),
+		activity: newActivity(),
+		tools:    tools.NewRegistry(tools.Builtin()...),
+		pending:  newPendingCalls(),
+	}
+	return &Chat{
+		h:      NewCallbackHandler(reloader, xgpt3Client, app, store, auditor, nil, retriever, toolClient, newLimiter(cfg.GPT.MaxConcurrency), version),
+		appId:  appId,
+		userId: opts.UserId,
+	}, nil
+}
+
+// Send 处理用户发送的一条消息，回复发送完成后返回
+func (c *Chat) Send(ctx context.Context, content string) error {
+	c.seq++
+	return c.h.withConfig().processMessage(ctx, &message{
+		appId:     c.appId,
+		openId:    c.userId,
+		chatId:    "local",
+		chatType:  "p2p",
+		messageId: fmt.Sprintf("local_%d", c.seq),
+		content:   content,
+	})
+}
@@ -0,0 +1,315 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fanchunke/chatgpt-lark/internal/export"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+const maxSessionNameLength = 32
+
+type commandRequest struct {
+	appId  string
+	openId string
+	args   []string
+}
+
+type command struct {
+	name  string
+	usage string
+	// noArgs 命令不接受参数，只有消息与命令完全一致时才作为命令处理
+	noArgs  bool
+	handler func(ctx context.Context, req *commandRequest) (string, error)
+}
+
+func (h *callbackHandler) commands() []*command {
+	return []*command{
+		{name: h.cfg.Conversation.CloseSessionFlag, usage: "重启当前会话", noArgs: true, handler: h.restartCommand},
+		{name: "/session", usage: "管理会话：/session [list|new <名称>|switch <名称>|rename <旧名称> <新名称>|delete <名称>]", handler: h.sessionCommand},
+		{name: "/system", usage: "查看或设置当前会话的系统提示词：/system [提示词|clear]", handler: h.systemCommand},
+		{name: "/model", usage: "查看或设置当前会话的模型：/model [模型]", handler: h.modelCommand},
+		{name: "/export", usage: "导出当前会话：/export [md|json|html]", handler: h.exportCommand},
+		{name: "/feedback", usage: "评价上一条回答：/feedback <good|bad> [说明]", handler: h.feedbackCommand},
+		{name: "/help", usage: "查看帮助", noArgs: true, handler: h.helpCommand},
+	}
+}
+
+// parseCommand 解析用户消息中的命令。非命令消息返回 false。
+func (h *callbackHandler) parseCommand(content string) (*command, []string, bool) {
+	fields := strings.Fields(content)
+	if len(fields) == 0 {
+		return nil, nil, false
+	}
+	for _, cmd := range h.commands() {
+		if cmd.name == "" || cmd.name != fields[0] {
+			continue
+		}
+		if cmd.noArgs && len(fields) > 1 {
+			return nil, nil, false
+		}
+		return cmd, fields[1:], true
+	}
+	return nil, nil, false
+}
+
+func (h *callbackHandler) helpCommand(ctx context.Context, req *commandRequest) (string, error) {
+	var b strings.Builder
+	b.WriteString("支持以下命令：")
+	for _, cmd := range h.commands() {
+		if cmd.name == "" {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("\n%s  %s", cmd.name, cmd.usage))
+	}
+	return b.String(), nil
+}
+
+func (h *callbackHandler) restartCommand(ctx context.Context, req *commandRequest) (string, error) {
+	sess, err := h.store.ActiveSession(ctx, h.app.name, req.openId)
+	if err != nil {
+		return "", fmt.Errorf("get active session failed: %w", err)
+	}
+	if err := h.xgpt3Client.CloseConversation(ctx, sess.ConversationKey); err != nil {
+		return "", fmt.Errorf("Close Conversation error: %w", err)
+	}
+	// 用户主动重启会话后不再提示会话已过期
+	if _, _, err := h.store.TakeIdleExpired(ctx, sess.ID); err != nil {
+		return "", err
+	}
+	return h.cfg.Conversation.CloseSessionReply, nil
+}
+
+func (h *callbackHandler) sessionCommand(ctx context.Context, req *commandRequest) (string, error) {
+	if len(req.args) == 0 || req.args[0] == "list" {
+		if err := h.sendSessionCard(ctx, req.appId, req.openId); err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+
+	args := req.args[1:]
+	switch req.args[0] {
+	case "new":
+		if len(args) != 1 {
+			return "用法：/session new <名称>", nil
+		}
+		if err := validateSessionName(args[0]); err != nil {
+			return err.Error(), nil
+		}
+		if _, err := h.store.CreateSession(ctx, h.app.name, req.openId, args[0]); errors.Is(err, store.ErrSessionExists) {
+			return fmt.Sprintf("会话「%s」已存在。", args[0]), nil
+		} else if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("[UserId: %s] Create session error: %v", req.openId, err)
+			return "创建会话失败，请稍后重试。", nil
+		}
+		return fmt.Sprintf("已创建并切换到会话「%s」。", args[0]), nil
+	case "switch":
+		if len(args) != 1 {
+			return "用法：/session switch <名称>", nil
+		}
+		return h.switchSession(ctx, req.openId, args[0])
+	case "rename":
+		if len(args) != 2 {
+			return "用法：/session rename <旧名称> <新名称>", nil
+		}
+		if err := validateSessionName(args[1]); err != nil {
+			return err.Error(), nil
+		}
+		if err := h.store.RenameSession(ctx, h.app.name, req.openId, args[0], args[1]); err == store.ErrNotFound {
+			return fmt.Sprintf("会话「%s」不存在。", args[0]), nil
+		} else if errors.Is(err, store.ErrSessionExists) {
+			return fmt.Sprintf("会话「%s」已存在。", args[1]), nil
+		} else if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("[UserId: %s] Rename session error: %v", req.openId, err)
+			return "重命名会话失败，请稍后重试。", nil
+		}
+		return fmt.Sprintf("会话「%s」已重命名为「%s」。", args[0], args[1]), nil
+	case "delete":
+		if len(args) != 1 {
+			return "用法：/session delete <名称>", nil
+		}
+		return h.deleteSession(ctx, req.openId, args[0])
+	}
+	return h.helpCommand(ctx, req)
+}
+
+func (h *callbackHandler) switchSession(ctx context.Context, openId, name string) (string, error) {
+	if _, err := h.store.SwitchSession(ctx, h.app.name, openId, name); err == store.ErrNotFound {
+		return fmt.Sprintf("会话「%s」不存在。", name), nil
+	} else if err != nil {
+		return "", fmt.Errorf("switch session failed: %w", err)
+	}
+	return fmt.Sprintf("已切换到会话「%s」。", name), nil
+}
+
+func (h *callbackHandler) deleteSession(ctx context.Context, openId, name string) (string, error) {
+	sess, err := h.store.DeleteSession(ctx, h.app.name, openId, name)
+	if err == store.ErrNotFound {
+		return fmt.Sprintf("会话「%s」不存在。", name), nil
+	} else if err != nil {
+		return "", fmt.Errorf("delete session failed: %w", err)
+	}
+	if err := h.xgpt3Client.CloseConversation(ctx, sess.ConversationKey); err != nil {
+		return "", fmt.Errorf("Close Conversation error: %w", err)
+	}
+	return fmt.Sprintf("会话「%s」已删除。", name), nil
+}
+
+func (h *callbackHandler) systemCommand(ctx context.Context, req *commandRequest) (string, error) {
+	sess, err := h.store.ActiveSession(ctx, h.app.name, req.openId)
+	if err != nil {
+		return "", fmt.Errorf("get active session failed: %w", err)
+	}
+	if len(req.args) == 0 {
+		if sess.SystemPrompt == "" {
+			return fmt.Sprintf("会话「%s」未设置系统提示词。", sess.Name), nil
+		}
+		return fmt.Sprintf("会话「%s」的系统提示词：%s", sess.Name, h.restorePII(ctx, req.openId, sess.SystemPrompt)), nil
+	}
+
+	if len(req.args) == 1 && req.args[0] == "clear" {
+		sess.SystemPrompt = ""
+	} else {
+		// 系统提示词会发送给 GPT，与用户消息一样保存脱敏后的内容
+		prompt, err := h.redactPII(ctx, req.openId, strings.Join(req.args, " "))
+		if err != nil {
+			return "", fmt.Errorf("redact pii failed: %w", err)
+		}
+		sess.SystemPrompt = prompt
+	}
+	if err := h.store.UpdateSession(ctx, sess); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("会话「%s」的系统提示词已更新。", sess.Name), nil
+}
+
+func (h *callbackHandler) modelCommand(ctx context.Context, req *commandRequest) (string, error) {
+	sess, err := h.store.ActiveSession(ctx, h.app.name, req.openId)
+	if err != nil {
+		return "", fmt.Errorf("get active session failed: %w", err)
+	}
+	if len(req.args) == 0 {
+		return fmt.Sprintf("会话「%s」当前使用的模型：%s", sess.Name, h.sessionModel(sess)), nil
+	}
+
+	model := req.args[0]
+	if !h.modelAllowed(model) {
+		return fmt.Sprintf("不支持的模型：%s。可选模型：%s", model, strings.Join(h.cfg.GPT.Models, ", ")), nil
+	}
+	if !h.modelSupported(model) {
+		return fmt.Sprintf("模型 %s 不能用于 %s 接口，%s", model, h.version, h.modelHint()), nil
+	}
+	sess.Model = model
+	if err := h.store.UpdateSession(ctx, sess); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("会话「%s」已切换为模型 %s。", sess.Name, model), nil
+}
+
+func (h *callbackHandler) exportCommand(ctx context.Context, req *commandRequest) (string, error) {
+	format := export.FormatMarkdown
+	if len(req.args) > 0 {
+		f, err := export.ParseFormat(req.args[0])
+		if err != nil {
+			return "用法：/export [md|json|html]", nil
+		}
+		format = f
+	}
+
+	sess, err := h.store.ActiveSession(ctx, h.app.name, req.openId)
+	if err != nil {
+		return "", fmt.Errorf("get active session failed: %w", err)
+	}
+	latest, err := h.store.LatestConversation(ctx, sess.ConversationKey)
+	if err == store.ErrNotFound {
+		return fmt.Sprintf("会话「%s」还没有对话记录。", sess.Name), nil
+	} else if err != nil {
+		return "", err
+	}
+	conv, err := export.Load(ctx, h.store, latest.ID)
+	if err != nil {
+		return "", fmt.Errorf("load conversation failed: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := export.Render(&buf, conv, format); err != nil {
+		return "", fmt.Errorf("render conversation failed: %w", err)
+	}
+	if err := h.sendFileMessage(ctx, req.appId, req.openId, export.FileName(conv, format), &buf); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+func (h *callbackHandler) feedbackCommand(ctx context.Context, req *commandRequest) (string, error) {
+	ratings := map[string]int{"good": store.RatingGood, "bad": store.RatingBad}
+	if len(req.args) == 0 || ratings[req.args[0]] == 0 {
+		return "用法：/feedback <good|bad> [说明]", nil
+	}
+
+	sess, err := h.store.ActiveSession(ctx, h.app.name, req.openId)
+	if err != nil {
+		return "", fmt.Errorf("get active session failed: %w", err)
+	}
+	f := &store.Feedback{
+		UserID:          req.openId,
+		ConversationKey: sess.ConversationKey,
+		Rating:          ratings[req.args[0]],
+		Comment:         strings.Join(req.args[1:], " "),
+	}
+	if h.cfg.Conversation.EnableConversation {
+		id, err := h.store.LatestReplyID(ctx, sess.ConversationKey)
+		if err == store.ErrNotFound {
+			return "还没有可以评价的回答。", nil
+		} else if err != nil {
+			return "", err
+		}
+		f.MessageID = id
+	}
+	if err := h.store.CreateFeedback(ctx, f); err != nil {
+		return "", err
+	}
+	return "感谢您的反馈。", nil
+}
+
+func (h *callbackHandler) modelAllowed(model string) bool {
+	if len(h.cfg.GPT.Models) == 0 {
+		return true
+	}
+	for _, m := range h.cfg.GPT.Models {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// modelSupported 模型是否可以用于当前的接口版本：v1 使用 completion 接口，v2 使用 chat 接口
+func (h *callbackHandler) modelSupported(model string) bool {
+	return isChatModel(model) == (h.version == callbackVersionV2)
+}
+
+func (h *callbackHandler) modelHint() string {
+	if h.version == callbackVersionV1 {
+		return "请使用 text-davinci-003 等 completion 模型。"
+	}
+	return "请使用 gpt-3.5-turbo、gpt-4 等 chat 模型。"
+}
+
+// isChatModel 是否为 chat 接口的模型
+func isChatModel(model string) bool {
+	return strings.HasPrefix(model, "gpt-3.5-turbo") || strings.HasPrefix(model, "gpt-4")
+}
+
+func validateSessionName(name string) error {
+	if len([]rune(name)) > maxSessionNameLength {
+		return fmt.Errorf("会话名称不能超过 %d 个字符。", maxSessionNameLength)
+	}
+	return nil
+}
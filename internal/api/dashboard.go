@@ -0,0 +1,180 @@
+package api
+
+import (
+	"crypto/subtle"
+	"embed"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fanchunke/chatgpt-lark/internal/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultSessionTTL 未配置 admin.sessionTTL 时登录的有效期
+	defaultSessionTTL = 12 * time.Hour
+	// defaultLoginMaxFailures、defaultLoginLockout 未配置 admin.loginMaxFailures、admin.loginLockout 时的登录限制
+	defaultLoginMaxFailures = 5
+	defaultLoginLockout     = 15 * time.Minute
+	// loginMaxClients 登录限制最多记录的客户端数
+	loginMaxClients = 10000
+)
+
+// uiFS 管理后台的静态文件，不依赖外部 CDN，可以在内网部署
+//
+//go:embed ui
+var uiFS embed.FS
+
+// registerDashboard 注册管理后台 /admin/ui，通过 admin.token 登录，登录后使用 admin.sessionSecret 签名的 cookie 访问管理接口
+func (r *router) registerDashboard() {
+	r.logins = newLoginLimiter(r.cfg.Admin.LoginMaxFailures, r.cfg.Admin.LoginLockout)
+	static, err := fs.Sub(uiFS, "ui")
+	if err != nil {
+		panic(err)
+	}
+	fileServer := http.StripPrefix("/admin/ui", http.FileServer(http.FS(static)))
+
+	r.GET("/admin/ui", func(c *gin.Context) {
+		c.Redirect(http.StatusMovedPermanently, "/admin/ui/")
+	})
+	r.GET("/admin/ui/*filepath", func(c *gin.Context) {
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Content-Security-Policy", "default-src 'self'; style-src 'self'; img-src 'self' data:; frame-ancestors 'none'")
+		c.Header("X-Content-Type-Options", "nosniff")
+		fileServer.ServeHTTP(c.Writer, c.Request)
+	})
+	r.POST("/admin/ui/login", r.DashboardLogin)
+	r.POST("/admin/ui/logout", r.DashboardLogout)
+}
+
+// DashboardLogin 校验 admin.token，成功后设置登录 cookie。同一个客户端 IP 登录失败次数过多时暂时拒绝登录，
+// 客户端 IP 只在请求来自 http.trustedProxies 时从 X-Forwarded-For 获取
+func (r *router) DashboardLogin(c *gin.Context) {
+	ip := c.ClientIP()
+	if !r.logins.allowed(ip) {
+		log.Ctx(c.Request.Context()).Warn().Msgf("Dashboard login from %s rejected, too many failures", ip)
+		c.JSON(http.StatusTooManyRequests, gin.H{"msg": "too many failed logins, try again later"})
+		return
+	}
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		if middleware.IsBodyTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"msg": "request body too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"msg": err.Error()})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Token), []byte(r.cfg.Admin.Token)) != 1 {
+		r.logins.fail(ip)
+		log.Ctx(c.Request.Context()).Warn().Msgf("Dashboard login failed from %s", ip)
+		c.JSON(http.StatusUnauthorized, gin.H{"msg": "unauthorized"})
+		return
+	}
+	r.logins.reset(ip)
+
+	ttl := r.cfg.Admin.SessionTTL
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(middleware.SessionCookie, middleware.NewSession(r.cfg.Admin.SessionSecret, ttl), int(ttl.Seconds()), "/admin", "", r.secureCookie(c), true)
+	log.Ctx(c.Request.Context()).Info().Msgf("Dashboard login from %s", ip)
+	c.JSON(http.StatusOK, gin.H{"expiresIn": int(ttl.Seconds())})
+}
+
+// DashboardLogout 清除登录 cookie
+func (r *router) DashboardLogout(c *gin.Context) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(middleware.SessionCookie, "", -1, "/admin", "", r.secureCookie(c), true)
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// secureCookie 登录 cookie 是否设置 Secure，开启 admin.secureCookie 时始终设置，否则只在 HTTPS 请求中设置
+func (r *router) secureCookie(c *gin.Context) bool {
+	return r.cfg.Admin.SecureCookie || c.Request.TLS != nil
+}
+
+// loginLimiter 按客户端 IP 统计管理后台登录失败的次数，lockout 内失败 max 次后拒绝登录，直到 lockout 结束。
+// 计数保存在每个实例的内存中，最多记录 loginMaxClients 个客户端，已满时在最早的记录过期前拒绝新客户端登录。
+type loginLimiter struct {
+	mu       sync.Mutex
+	max      int
+	lockout  time.Duration
+	failures map[string]*loginFailures
+	// queue 按第一次失败的时间排列的计数，用于按顺序过期
+	queue []*loginFailures
+}
+
+// loginFailures 客户端第一次失败的时间和失败次数
+type loginFailures struct {
+	ip    string
+	since time.Time
+	count int
+}
+
+func newLoginLimiter(max int, lockout time.Duration) *loginLimiter {
+	if max <= 0 {
+		max = defaultLoginMaxFailures
+	}
+	if lockout <= 0 {
+		lockout = defaultLoginLockout
+	}
+	return &loginLimiter{max: max, lockout: lockout, failures: make(map[string]*loginFailures)}
+}
+
+// allowed 客户端是否可以尝试登录
+func (l *loginLimiter) allowed(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire(time.Now())
+	f, ok := l.failures[ip]
+	if !ok {
+		return len(l.failures) < loginMaxClients
+	}
+	return f.count < l.max
+}
+
+// fail 记录一次登录失败
+func (l *loginLimiter) fail(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.expire(now)
+	f, ok := l.failures[ip]
+	if !ok {
+		if len(l.failures) >= loginMaxClients {
+			return
+		}
+		f = &loginFailures{ip: ip, since: now}
+		l.failures[ip] = f
+		l.queue = append(l.queue, f)
+	}
+	f.count++
+}
+
+// reset 登录成功后清空失败次数，队列中的记录在过期时移除
+func (l *loginLimiter) reset(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, ip)
+}
+
+// expire 从队列头部删除 lockout 之前开始的计数
+func (l *loginLimiter) expire(now time.Time) {
+	n := 0
+	for ; n < len(l.queue) && now.Sub(l.queue[n].since) >= l.lockout; n++ {
+		f := l.queue[n]
+		if l.failures[f.ip] == f {
+			delete(l.failures, f.ip)
+		}
+		l.queue[n] = nil
+	}
+	l.queue = l.queue[n:]
+}
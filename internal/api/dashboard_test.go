@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/middleware"
+)
+
+func TestDashboardLogin(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.Admin = config.Admin{Token: "admin-token", SessionSecret: "session-secret", SecureCookie: true, LoginMaxFailures: 2}
+	})
+	forwardedFor := 0
+	login := func(token string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, env.url+"/admin/ui/login", strings.NewReader(`{"token":"`+token+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		// 未配置 http.trustedProxies 时伪造的 X-Forwarded-For 不影响计数
+		forwardedFor++
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("10.0.0.%d", forwardedFor))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	get := func(cookie string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, env.url+"/admin/apps", nil)
+		req.AddCookie(&http.Cookie{Name: middleware.SessionCookie, Value: cookie})
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	resp := login("admin-token")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login status = %d, want 200", resp.StatusCode)
+	}
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || !cookies[0].Secure || !cookies[0].HttpOnly {
+		t.Fatalf("cookies = %+v, want one secure http only cookie", cookies)
+	}
+	if status := get(cookies[0].Value); status != http.StatusOK {
+		t.Errorf("session status = %d, want 200", status)
+	}
+	// cookie 使用 sessionSecret 签名，使用 token 签名的 cookie 无效
+	if status := get(middleware.NewSession("admin-token", time.Hour)); status != http.StatusUnauthorized {
+		t.Errorf("token signed session status = %d, want 401", status)
+	}
+
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if resp := login("wrong"); resp.StatusCode != want {
+			t.Errorf("failed login %d status = %d, want %d", i, resp.StatusCode, want)
+		}
+	}
+	// 锁定期间正确的 token 同样被拒绝
+	if resp := login("admin-token"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("locked login status = %d, want 429", resp.StatusCode)
+	}
+}
+
+func TestLoginLimiter(t *testing.T) {
+	l := newLoginLimiter(1, time.Minute)
+	for i := 0; i < loginMaxClients; i++ {
+		l.fail(fmt.Sprintf("ip-%d", i))
+	}
+	if l.allowed("ip-0") {
+		t.Error("ip-0 allowed, want locked")
+	}
+	// 记录已满时拒绝新客户端
+	if l.allowed("new") {
+		t.Error("new client allowed, want rejected when full")
+	}
+	l.fail("new")
+	if len(l.failures) != loginMaxClients {
+		t.Errorf("tracked %d clients, want %d", len(l.failures), loginMaxClients)
+	}
+
+	// 过期的记录按顺序移除
+	l.reset("ip-1")
+	l.expire(time.Now().Add(time.Minute))
+	if len(l.failures) != 0 || len(l.queue) != 0 {
+		t.Errorf("after expire: %d clients, %d queued, want none", len(l.failures), len(l.queue))
+	}
+	if !l.allowed("new") {
+		t.Error("new client rejected after expire")
+	}
+}
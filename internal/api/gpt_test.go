@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/openaitest"
+	"github.com/fanchunke/chatgpt-lark/internal/tools"
+	"github.com/fanchunke/xgpt3"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// newTestHandler 创建使用模拟 OpenAI 服务和内存数据库的 callbackHandler
+func newTestHandler(t *testing.T, version versionType, configure func(cfg *config.Config)) (*callbackHandler, *openaitest.Server) {
+	t.Helper()
+	gptSrv := openaitest.NewServer()
+	t.Cleanup(gptSrv.Close)
+	st := newTestStore(t)
+
+	cfg := &config.Config{
+		GPT:          config.GPT{ApiKey: "test"},
+		Conversation: config.Conversation{EnableConversation: true},
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+	app := &larkApp{
+		name:     config.DefaultAppName,
+		quota:    newQuota(st, config.DefaultAppName),
+		settings: newSettingsCache(),
+		activity: newActivity(),
+		tools:    tools.NewRegistry(),
+		pending:  newPendingCalls(),
+	}
+	xgpt3Client := xgpt3.NewClient(openai.NewClientWithConfig(gptSrv.ClientConfig()), st.ConversationHandler())
+	h := NewCallbackHandler(config.NewReloader(cfg), xgpt3Client, app, st, nil, nil, nil, nil, newLimiter(0), version)
+	return h, gptSrv
+}
+
+// ask 按接口版本请求 GPT
+func ask(ctx context.Context, h *callbackHandler, openId, content string) (*completion, error) {
+	sess, err := h.store.ActiveSession(ctx, h.app.name, openId)
+	if err != nil {
+		return nil, err
+	}
+	if h.version == callbackVersionV1 {
+		return h.getOpenAICompletion(ctx, testLarkApp.AppID, sess, content)
+	}
+	comp, _, err := h.getOpenAIChatCompletion(ctx, testLarkApp.AppID, sess, content, nil, nil)
+	return comp, err
+}
+
+// statusCode 获取 OpenAI 接口返回的状态码
+func statusCode(err error) int {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.StatusCode
+	}
+	return 0
+}
+
+func TestGetOpenAICompletion(t *testing.T) {
+	usage := &openai.Usage{PromptTokens: 7, CompletionTokens: 3, TotalTokens: 10}
+	cases := []struct {
+		name     string
+		response openaitest.Response
+		// disableConversation 不开启多轮对话时 xgpt3 不保存消息
+		disableConversation bool
+		timeout             time.Duration
+		reply               string
+		// status 不为 0 时期望返回错误
+		status int
+		// timedOut 期望请求超时
+		timedOut bool
+	}{
+		{name: "reply", response: openaitest.Response{Content: "  hi\n", Usage: usage}, reply: "hi"},
+		{name: "without conversation", response: openaitest.Response{Content: "hi", Usage: usage}, disableConversation: true, reply: "hi"},
+		{name: "rate limited", response: openaitest.Response{Status: http.StatusTooManyRequests}, status: http.StatusTooManyRequests},
+		{name: "server error", response: openaitest.Response{Status: http.StatusInternalServerError}, status: http.StatusInternalServerError},
+		{name: "latency", response: openaitest.Response{Content: "late", Delay: time.Second}, timeout: 50 * time.Millisecond, timedOut: true},
+	}
+	for _, version := range []versionType{callbackVersionV1, callbackVersionV2} {
+		for _, tc := range cases {
+			t.Run(string(version)+"/"+tc.name, func(t *testing.T) {
+				h, gpt := newTestHandler(t, version, func(cfg *config.Config) {
+					cfg.Conversation.EnableConversation = !tc.disableConversation
+				})
+				gpt.Enqueue(tc.response)
+				ctx := context.Background()
+				if tc.timeout > 0 {
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+					defer cancel()
+				}
+
+				comp, err := ask(ctx, h, "ou_user", "hello")
+				switch {
+				case tc.status != 0:
+					if got := statusCode(err); got != tc.status {
+						t.Fatalf("status = %d, want %d (err: %v)", got, tc.status, err)
+					}
+					return
+				case tc.timedOut:
+					if !errors.Is(err, context.DeadlineExceeded) {
+						t.Fatalf("err = %v, want deadline exceeded", err)
+					}
+					return
+				case err != nil:
+					t.Fatal(err)
+				}
+
+				if comp.reply != tc.reply {
+					t.Errorf("reply = %q, want %q", comp.reply, tc.reply)
+				}
+				if comp.usage != *usage {
+					t.Errorf("usage = %+v, want %+v", comp.usage, *usage)
+				}
+				// 开启多轮对话时在 xgpt3 保存回复前审核，否则由 processMessage 在返回后审核
+				if (comp.output == nil) != tc.disableConversation {
+					t.Errorf("output moderation = %+v, want moderated before persisting: %v", comp.output, !tc.disableConversation)
+				}
+				if tc.disableConversation {
+					if comp.replyId != 0 {
+						t.Errorf("reply id = %d, want 0", comp.replyId)
+					}
+					return
+				}
+				// 用量关联到 xgpt3 保存的回复消息
+				usages, err := h.store.ListUsagesByMessage(context.Background(), comp.replyId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if u := usages[comp.replyId]; u == nil || u.TotalTokens != usage.TotalTokens {
+					t.Errorf("usage of reply %d = %+v, want %d tokens", comp.replyId, u, usage.TotalTokens)
+				}
+			})
+		}
+	}
+}
+
+func TestConversationHistory(t *testing.T) {
+	cases := []struct {
+		name    string
+		version versionType
+		// moderation 开启输出审核，第一轮回答被拦截
+		moderation bool
+		// history 第二轮请求中应包含的历史回答
+		history string
+	}{
+		{name: "completion", version: callbackVersionV1, history: "the secret"},
+		{name: "chat completion", version: callbackVersionV2, history: "the secret"},
+		{name: "completion blocked", version: callbackVersionV1, moderation: true, history: "回答已拦截"},
+		{name: "chat completion blocked", version: callbackVersionV2, moderation: true, history: "回答已拦截"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, gpt := newTestHandler(t, tc.version, func(cfg *config.Config) {
+				if tc.moderation {
+					cfg.Moderation = config.Moderation{Keywords: []string{"secret"}, OutputAction: "block", BlockedOutputReply: "回答已拦截"}
+				}
+			})
+			gpt.Enqueue(openaitest.Response{Content: "the secret"}, openaitest.Response{Content: "second"})
+			ctx := context.Background()
+
+			first, err := ask(ctx, h, "ou_user", "hello")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first.output.blocked != tc.moderation {
+				t.Errorf("blocked = %v, want %v", first.output.blocked, tc.moderation)
+			}
+			if _, err := ask(ctx, h, "ou_user", "again"); err != nil {
+				t.Fatal(err)
+			}
+
+			requests := gpt.Requests()
+			if len(requests) != 2 {
+				t.Fatalf("got %d requests, want 2", len(requests))
+			}
+			second := requests[1]
+			if tc.version == callbackVersionV1 {
+				if !strings.Contains(second.Prompt, "hello") || !strings.Contains(second.Prompt, tc.history) || !strings.Contains(second.Prompt, "again") {
+					t.Errorf("prompt = %q, want history of hello, %q and again", second.Prompt, tc.history)
+				}
+				if tc.moderation && strings.Contains(second.Prompt, "the secret") {
+					t.Errorf("prompt = %q, blocked reply is replayed", second.Prompt)
+				}
+				return
+			}
+			want := []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleUser, Content: "hello"},
+				{Role: openai.ChatMessageRoleAssistant, Content: tc.history},
+				{Role: openai.ChatMessageRoleUser, Content: "again"},
+			}
+			if len(second.Messages) != len(want) {
+				t.Fatalf("messages = %+v, want %+v", second.Messages, want)
+			}
+			for i, m := range second.Messages {
+				if m.Role != want[i].Role || m.Content != want[i].Content {
+					t.Errorf("messages[%d] = %s %q, want %s %q", i, m.Role, m.Content, want[i].Role, want[i].Content)
+				}
+			}
+		})
+	}
+}
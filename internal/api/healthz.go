@@ -1,11 +1,70 @@
 package api
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/health"
 	"github.com/gin-gonic/gin"
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
 )
 
+// Healthz 存活探针，只要进程能处理请求就返回 200
 func (r *router) Healthz(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"msg": "healthz"})
 }
+
+// Readyz 就绪探针，检查数据库、飞书和 OpenAI 等依赖，任一必需的检查失败时返回 503
+func (r *router) Readyz(c *gin.Context) {
+	report := r.readiness.Check(c.Request.Context())
+	status := http.StatusOK
+	if !report.OK() {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
+
+func (r *router) readinessChecks() []health.Check {
+	checks := []health.Check{
+		{Name: "database", Fn: r.store.Ping},
+	}
+	for _, app := range r.apps {
+		name := "lark"
+		if app.name != config.DefaultAppName {
+			name = "lark." + app.name
+		}
+		checks = append(checks, health.Check{Name: name, Fn: r.checkLark(app)})
+	}
+	if r.cfg.Health.CheckOpenAI {
+		checks = append(checks, health.Check{Name: "openai", Optional: true, Fn: r.checkOpenAI})
+	}
+	return checks
+}
+
+// checkLark 获取 tenant access token，检查飞书是否可达以及应用凭证是否有效
+func (r *router) checkLark(app *larkApp) func(ctx context.Context) error {
+	appConf, _ := r.cfg.LarkApp(app.name)
+	return func(ctx context.Context) error {
+		resp, err := app.client.GetTenantAccessTokenBySelfBuiltApp(ctx, &larkcore.SelfBuiltTenantAccessTokenReq{
+			AppID:     appConf.AppId,
+			AppSecret: appConf.AppSecret,
+		})
+		if err != nil {
+			return fmt.Errorf("get tenant access token failed: %w", err)
+		}
+		if !resp.Success() {
+			return fmt.Errorf("get tenant access token failed: %d %s", resp.Code, resp.Msg)
+		}
+		return nil
+	}
+}
+
+// checkOpenAI 请求模型列表，检查 OpenAI 是否可达
+func (r *router) checkOpenAI(ctx context.Context) error {
+	if _, err := r.xgpt3Client.Client.ListModels(ctx); err != nil {
+		return fmt.Errorf("list models failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/metrics"
+	"github.com/fanchunke/chatgpt-lark/internal/middleware"
+	"github.com/gin-gonic/gin"
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	larkevent "github.com/larksuite/oapi-sdk-go/v3/event"
+	"github.com/larksuite/oapi-sdk-go/v3/event/dispatcher"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	cardActionEventType = "card.action"
+	// challengeEventType 飞书配置回调地址时发送的校验请求
+	challengeEventType = "url_verification"
+	// unhandledEventType 没有注册处理器的事件
+	unhandledEventType = "unhandled"
+)
+
+// errInvalidToken 事件的 verification token 与配置不一致。SDK 只校验 challenge 请求的 token，其他事件在处理器中校验。
+var errInvalidToken = errors.New("invalid verification token")
+
+// eventResult 记录 SDK 分发事件的结果，由注册到 dispatcher 的处理器写入
+type eventResult struct {
+	eventType string
+	handled   bool
+}
+
+type eventResultKey struct{}
+
+// newEventDispatcher 创建飞书事件分发器。处理器先校验 verification token 并记录事件类型，再调用 h。
+func newEventDispatcher(appConf config.LarkApp, h func(context.Context, *larkim.P2MessageReceiveV1) error) *dispatcher.EventDispatcher {
+	return dispatcher.NewEventDispatcher(appConf.VerificationToken, appConf.EventEncryptKey).OnP2MessageReceiveV1(func(ctx context.Context, event *larkim.P2MessageReceiveV1) error {
+		res, ok := ctx.Value(eventResultKey{}).(*eventResult)
+		if !ok {
+			res = &eventResult{}
+		}
+		var token string
+		if event.EventV2Base != nil && event.EventV2Base.Header != nil {
+			token, res.eventType = event.EventV2Base.Header.Token, event.EventV2Base.Header.EventType
+		}
+		if appConf.VerificationToken != "" && token != appConf.VerificationToken {
+			return errInvalidToken
+		}
+		res.handled = true
+		return h(ctx, event)
+	})
+}
+
+// larkEventHandlerFunc 将飞书事件处理器适配为 gin handler。
+// 与 sdkginext 不同，事件处理器使用请求的 context，并根据 SDK 的处理结果统计收到和未通过校验的事件。
+func larkEventHandlerFunc(route string, d *dispatcher.EventDispatcher) gin.HandlerFunc {
+	d.InitConfig()
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		req, err := translateEventReq(c.Request)
+		if err != nil {
+			writeReadError(c, err)
+			return
+		}
+
+		res := &eventResult{}
+		resp := d.Handle(context.WithValue(ctx, eventResultKey{}, res), req)
+		eventType, reason := classifyEventResp(res, resp)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("lark.event_type", eventType))
+		if reason == "" {
+			metrics.EventsReceived.WithLabelValues(route, eventType).Inc()
+			writeEventResp(ctx, c, resp)
+			return
+		}
+
+		metrics.EventsRejected.WithLabelValues(route, reason).Inc()
+		log.Ctx(ctx).Warn().Msgf("Lark event rejected: %s", reason)
+		if reason == "token" {
+			c.JSON(http.StatusUnauthorized, gin.H{"msg": errInvalidToken.Error()})
+			return
+		}
+		writeEventResp(ctx, c, resp)
+	}
+}
+
+// classifyEventResp 根据 SDK 的响应判断事件类型，事件未通过校验时返回原因
+func classifyEventResp(res *eventResult, resp *larkevent.EventResp) (string, string) {
+	if resp.StatusCode == http.StatusOK {
+		switch {
+		case res.eventType != "":
+			return res.eventType, ""
+		case strings.Contains(string(resp.Body), `"challenge"`):
+			return challengeEventType, ""
+		default:
+			return unhandledEventType, ""
+		}
+	}
+	if res.handled {
+		// 通过校验，处理器返回了错误
+		return res.eventType, ""
+	}
+
+	var body struct {
+		Msg string `json:"msg"`
+	}
+	_ = json.Unmarshal(resp.Body, &body)
+	switch msg := body.Msg; {
+	case msg == errInvalidToken.Error(), strings.Contains(msg, "auth by challenge"):
+		return res.eventType, "token"
+	case strings.Contains(msg, "decryption"):
+		return res.eventType, "decrypt"
+	case strings.Contains(msg, "signature"):
+		return res.eventType, "signature"
+	default:
+		return res.eventType, "parse"
+	}
+}
+
+// larkCardHandlerFunc 将飞书消息卡片回调处理器适配为 gin handler
+func larkCardHandlerFunc(route string, h *larkcard.CardActionHandler) gin.HandlerFunc {
+	h.InitConfig()
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		req, err := translateEventReq(c.Request)
+		if err != nil {
+			writeReadError(c, err)
+			return
+		}
+		metrics.EventsReceived.WithLabelValues(route, cardActionEventType).Inc()
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("lark.event_type", cardActionEventType))
+		writeEventResp(ctx, c, h.Handle(ctx, req))
+	}
+}
+
+// writeReadError 读取请求内容失败时返回错误，超出大小限制时返回 413
+func writeReadError(c *gin.Context, err error) {
+	if middleware.IsBodyTooLarge(err) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"msg": "request body too large"})
+		return
+	}
+	c.String(http.StatusInternalServerError, err.Error())
+}
+
+func translateEventReq(r *http.Request) (*larkevent.EventReq, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read request body failed: %w", err)
+	}
+	return &larkevent.EventReq{
+		Header:     r.Header,
+		Body:       body,
+		RequestURI: r.RequestURI,
+	}, nil
+}
+
+func writeEventResp(ctx context.Context, c *gin.Context, resp *larkevent.EventResp) {
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			c.Writer.Header().Add(k, v)
+		}
+	}
+	c.Status(resp.StatusCode)
+	if len(resp.Body) > 0 {
+		if _, err := c.Writer.Write(resp.Body); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("write lark response error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/larktest"
+	"github.com/fanchunke/chatgpt-lark/internal/metrics"
+	"github.com/gin-gonic/gin"
+	larkevent "github.com/larksuite/oapi-sdk-go/v3/event"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLarkEventHandlerFunc(t *testing.T) {
+	appConf := config.LarkApp{VerificationToken: "token", EventEncryptKey: "encrypt-key"}
+	valid := larktest.App{AppID: "cli_test", VerificationToken: "token", EventEncryptKey: "encrypt-key"}
+
+	cases := []struct {
+		name string
+		app  larktest.App
+		// body 构造请求内容，为空时发送文本消息事件
+		body func(s *larktest.Server) ([]byte, error)
+		// tamper 在发送前修改请求
+		tamper    func(req *http.Request)
+		status    int
+		eventType string
+		reason    string
+		handled   bool
+	}{
+		{name: "message", app: valid, status: http.StatusOK, eventType: "im.message.receive_v1", handled: true},
+		{
+			name:      "challenge",
+			app:       valid,
+			body:      func(s *larktest.Server) ([]byte, error) { return s.ChallengeBody("challenge-code") },
+			status:    http.StatusOK,
+			eventType: challengeEventType,
+		},
+		{
+			name:   "invalid token",
+			app:    larktest.App{AppID: "cli_test", VerificationToken: "other", EventEncryptKey: "encrypt-key"},
+			status: http.StatusUnauthorized,
+			reason: "token",
+		},
+		{
+			name:   "invalid challenge token",
+			app:    larktest.App{AppID: "cli_test", VerificationToken: "other", EventEncryptKey: "encrypt-key"},
+			body:   func(s *larktest.Server) ([]byte, error) { return s.ChallengeBody("challenge-code") },
+			status: http.StatusUnauthorized,
+			reason: "token",
+		},
+		{
+			name:   "decrypt",
+			app:    valid,
+			body:   func(s *larktest.Server) ([]byte, error) { return []byte(`{"encrypt":"not base64"}`), nil },
+			status: http.StatusInternalServerError,
+			reason: "decrypt",
+		},
+		{
+			// 密钥不一致时能解密出内容，但内容无法解析
+			name:   "invalid encrypt key",
+			app:    larktest.App{AppID: "cli_test", VerificationToken: "token", EventEncryptKey: "other-key"},
+			status: http.StatusInternalServerError,
+			reason: "parse",
+		},
+		{
+			name:   "signature",
+			app:    valid,
+			tamper: func(req *http.Request) { req.Header.Set(larkevent.EventSignature, "invalid") },
+			status: http.StatusInternalServerError,
+			reason: "signature",
+		},
+		{
+			name:   "parse",
+			app:    valid,
+			body:   func(s *larktest.Server) ([]byte, error) { return []byte("{}"), nil },
+			status: http.StatusInternalServerError,
+			reason: "parse",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			route := "test." + strings.ReplaceAll(tc.name, " ", "_")
+			handled := false
+			d := newEventDispatcher(appConf, func(ctx context.Context, event *larkim.P2MessageReceiveV1) error {
+				handled = true
+				return nil
+			})
+			gin.SetMode(gin.ReleaseMode)
+			e := gin.New()
+			e.POST("/lark/receive", larkEventHandlerFunc(route, d))
+			srv := httptest.NewServer(e)
+			defer srv.Close()
+
+			lark := larktest.NewServer(tc.app)
+			defer lark.Close()
+			var (
+				body []byte
+				err  error
+			)
+			if tc.body != nil {
+				body, err = tc.body(lark)
+			} else {
+				body, err = lark.EventBody(larktest.TextEvent("ou_user", "hello"))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := lark.NewEventRequest(srv.URL+"/lark/receive", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.tamper != nil {
+				tc.tamper(req)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tc.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tc.status)
+			}
+			if handled != tc.handled {
+				t.Errorf("handled = %v, want %v", handled, tc.handled)
+			}
+			if tc.reason != "" {
+				if got := testutil.ToFloat64(metrics.EventsRejected.WithLabelValues(route, tc.reason)); got != 1 {
+					t.Errorf("events rejected by %s = %v, want 1", tc.reason, got)
+				}
+				return
+			}
+			if got := testutil.ToFloat64(metrics.EventsReceived.WithLabelValues(route, tc.eventType)); got != 1 {
+				t.Errorf("events received of %s = %v, want 1", tc.eventType, got)
+			}
+		})
+	}
+}
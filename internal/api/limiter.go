@@ -0,0 +1,24 @@
+package api
+
+import "github.com/fanchunke/chatgpt-lark/internal/metrics"
+
+// limiter 限制同时请求 GPT 的并发数，为 nil 时不限制
+type limiter chan struct{}
+
+func newLimiter(n int) limiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(limiter, n)
+}
+
+// acquire 获取并发额度，返回释放额度的函数。等待期间计入排队的消息数。
+func (l limiter) acquire() func() {
+	if l == nil {
+		return func() {}
+	}
+	metrics.QueueDepth.Inc()
+	l <- struct{}{}
+	metrics.QueueDepth.Dec()
+	return func() { <-l }
+}
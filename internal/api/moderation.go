@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/metrics"
+	"github.com/fanchunke/chatgpt-lark/internal/moderation"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/fanchunke/chatgpt-lark/internal/tracing"
+	"github.com/rs/zerolog/log"
+	openai "github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	moderationStageInput  = "input"
+	moderationStageOutput = "output"
+	// moderationStageTool 工具调用的结果，与用户消息一样按 inputAction 处理
+	moderationStageTool = "tool"
+)
+
+// moderationResult 一次审核的结果
+type moderationResult struct {
+	// 审核后的内容，redact 时为隐藏后的内容
+	text    string
+	verdict string
+	blocked bool
+	warned  bool
+}
+
+// moderatorCache 缓存根据配置快照创建的审核器，配置重新加载后才重新编译关键词和正则
+type moderatorCache struct {
+	mu  sync.Mutex
+	cfg *config.Config
+	m   *moderation.Moderator
+	err error
+}
+
+// moderator 返回当前配置快照的审核器
+func (h *callbackHandler) moderator() (*moderation.Moderator, error) {
+	c := h.moderators
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cfg == h.cfg {
+		return c.m, c.err
+	}
+
+	mc := h.cfg.Moderation
+	var client *openai.Client
+	if mc.OpenAI {
+		client = h.xgpt3Client.Client
+	}
+	c.cfg = h.cfg
+	c.m, c.err = moderation.New(client, mc.Keywords, mc.Patterns)
+	return c.m, c.err
+}
+
+// outputHook 返回在 xgpt3 保存回复前审核 GPT 回答的回调，审核结果记录在 comp.output 中。
+// 被拦截的回答以 blockedOutputReply 保存，redact 时保存隐藏后的内容，违规内容不会进入会话历史。
+func (h *callbackHandler) outputHook(comp *completion) *store.ReplyHook {
+	return &store.ReplyHook{
+		Rewrite: func(ctx context.Context, content string) (string, error) {
+			output, err := h.moderate(ctx, moderationStageOutput, strings.TrimSpace(content))
+			if err != nil {
+				return "", err
+			}
+			comp.output = output
+			if output.blocked {
+				return h.cfg.Moderation.BlockedOutputReply, nil
+			}
+			return output.text, nil
+		},
+	}
+}
+
+// moderateOutput 审核未经过 outputHook 的 GPT 回答，如未开启多轮对话时 xgpt3 不会保存回复
+func (h *callbackHandler) moderateOutput(ctx context.Context, comp *completion) error {
+	if comp.output != nil {
+		return nil
+	}
+	output, err := h.moderate(ctx, moderationStageOutput, comp.reply)
+	if err != nil {
+		return err
+	}
+	comp.output = output
+	return nil
+}
+
+// moderate 审核用户消息或 GPT 回答，并按配置的处理方式返回结果。未配置审核时直接放行。
+func (h *callbackHandler) moderate(ctx context.Context, stage, text string) (*moderationResult, error) {
+	result := &moderationResult{text: text, verdict: "pass"}
+	m, err := h.moderator()
+	if err != nil {
+		return nil, err
+	}
+	if !m.Enabled() {
+		return result, nil
+	}
+	actionConf := h.cfg.Moderation.InputAction
+	if stage == moderationStageOutput {
+		actionConf = h.cfg.Moderation.OutputAction
+	}
+	action, err := moderation.ParseAction(actionConf)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := tracing.Start(ctx, "moderation", attribute.String("moderation.stage", stage))
+	verdict, err := m.Check(ctx, text)
+	tracing.End(span, err)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msgf("Moderation %s error, let it pass: %v", stage, err)
+	}
+	result.verdict = verdict.String()
+	if !verdict.Flagged {
+		return result, nil
+	}
+
+	// OpenAI 接口判定的违规内容无法隐藏，按 block 处理
+	if action == moderation.ActionRedact && !verdict.Redactable() {
+		action = moderation.ActionBlock
+	}
+	result.verdict = fmt.Sprintf("%s:%s", verdict, action)
+	metrics.ModerationFlagged.WithLabelValues(stage, string(action)).Inc()
+	log.Ctx(ctx).Info().Msgf("Moderation %s %s", stage, result.verdict)
+
+	switch action {
+	case moderation.ActionBlock:
+		result.blocked = true
+	case moderation.ActionWarn:
+		result.warned = true
+	case moderation.ActionRedact:
+		result.text = m.Redact(text)
+	}
+	return result, nil
+}
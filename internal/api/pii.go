@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/pii"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultPIITTL 未配置 pii.ttl 时占位符的保存时间
+const defaultPIITTL = 24 * time.Hour
+
+// errVaultNotConfigured 启用了脱敏但启动时未配置 pii.key
+var errVaultNotConfigured = errors.New("pii vault is not configured, set pii.key and restart")
+
+// newVault 创建应用的占位符存储，未配置 pii.key 时返回 nil
+func newVault(app string, cfg *config.Config, st *store.Store) (*pii.Vault, error) {
+	if cfg.PII.Key == "" {
+		return nil, nil
+	}
+	return pii.NewVault(app, cfg.PII.Key, st)
+}
+
+// redactorCache 缓存根据配置快照创建的脱敏器，配置重新加载后才重新编译规则
+type redactorCache struct {
+	mu    sync.Mutex
+	cfg   *config.Config
+	vault *pii.Vault
+	r     *pii.Redactor
+	err   error
+}
+
+// redactor 返回当前配置快照的脱敏器
+func (h *callbackHandler) redactor() (*pii.Redactor, error) {
+	c := h.redactors
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cfg == h.cfg && c.vault == h.app.vault {
+		return c.r, c.err
+	}
+
+	conf := h.cfg.PII
+	rules := make([]pii.Rule, 0, len(conf.Rules))
+	for _, r := range conf.Rules {
+		rules = append(rules, pii.Rule{Name: r.Name, Pattern: r.Pattern})
+	}
+	c.cfg, c.vault = h.cfg, h.app.vault
+	c.r, c.err = pii.New(h.app.vault.Key(), conf.Detectors, rules)
+	return c.r, c.err
+}
+
+// redactPII 将用户消息中的敏感信息替换为占位符，并保存占位符和原始值用于还原回答
+func (h *callbackHandler) redactPII(ctx context.Context, openId, text string) (string, error) {
+	conf := h.cfg.PII
+	if len(conf.Detectors) == 0 && len(conf.Rules) == 0 {
+		return text, nil
+	}
+	// 无法保存原始值时不发送消息，避免敏感信息发送给 GPT
+	if h.app.vault == nil {
+		return "", errVaultNotConfigured
+	}
+	r, err := h.redactor()
+	if err != nil {
+		return "", err
+	}
+
+	redacted, values := r.Redact(text)
+	if len(values) == 0 {
+		return text, nil
+	}
+	ttl := conf.TTL
+	if ttl <= 0 {
+		ttl = defaultPIITTL
+	}
+	if err := h.app.vault.Put(ctx, openId, values, ttl); err != nil {
+		return "", err
+	}
+	log.Ctx(ctx).Info().Msgf("[UserId: %s] Redacted %d sensitive values", openId, len(values))
+	return redacted, nil
+}
+
+// restorePII 将 GPT 回答或会话设置中的占位符还原为原始值，还原失败时保留占位符
+func (h *callbackHandler) restorePII(ctx context.Context, openId, text string) string {
+	if h.app.vault == nil {
+		return text
+	}
+	restored, err := h.app.vault.Restore(ctx, openId, text)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msgf("[UserId: %s] Restore PII error: %v", openId, err)
+	}
+	return restored
+}
+
+// restoreArguments 将 GPT 生成的工具参数中的占位符还原为原始值，工具以原始值执行。
+// 只还原 JSON 字符串中的占位符，还原后重新编码，原始值中的引号等字符不会破坏参数；参数不是合法的 JSON 时原样返回，由工具报告参数错误
+func (h *callbackHandler) restoreArguments(ctx context.Context, openId, arguments string) json.RawMessage {
+	if h.app.vault == nil || arguments == "" {
+		return json.RawMessage(arguments)
+	}
+	var v interface{}
+	d := json.NewDecoder(strings.NewReader(arguments))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return json.RawMessage(arguments)
+	}
+	restored, err := json.Marshal(h.restoreValue(ctx, openId, v))
+	if err != nil {
+		return json.RawMessage(arguments)
+	}
+	return restored
+}
+
+func (h *callbackHandler) restoreValue(ctx context.Context, openId string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return h.restorePII(ctx, openId, v)
+	case []interface{}:
+		for i := range v {
+			v[i] = h.restoreValue(ctx, openId, v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = h.restoreValue(ctx, openId, v[k])
+		}
+	}
+	return v
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+)
+
+// quota 统计每个用户当天的消息数和 token 数，用于限制飞书应用的每日额度。
+// 计数保存在数据库中，重启后保留，多个实例之间共享。
+type quota struct {
+	store *store.Store
+	app   string
+}
+
+func newQuota(store *store.Store, app string) *quota {
+	return &quota{store: store, app: app}
+}
+
+// today 额度按本地时区的自然日计算
+func today() string {
+	return time.Now().Format("2006-01-02")
+}
+
+// allow 检查用户是否超出当天的额度，未超出时计入一条消息。maxMessages、maxTokens 为 0 时不限制。
+func (q *quota) allow(ctx context.Context, userId string, maxMessages, maxTokens int) (bool, error) {
+	return q.store.TakeQuota(ctx, q.app, userId, today(), maxMessages, maxTokens)
+}
+
+// addTokens 计入用户消耗的 token 数
+func (q *quota) addTokens(ctx context.Context, userId string, tokens int) error {
+	return q.store.AddQuotaTokens(ctx, q.app, userId, today(), tokens)
+}
+
+// usages 返回当天全部用户已使用的额度，按用户 Id 排序
+func (q *quota) usages(ctx context.Context) (string, []store.QuotaUsage, error) {
+	day := today()
+	users, err := q.store.ListQuotaUsages(ctx, q.app, day)
+	return day, users, err
+}
+
+// resetUser 清空用户当天的计数
+func (q *quota) resetUser(ctx context.Context, userId string) error {
+	return q.store.ResetQuota(ctx, q.app, userId, today())
+}
+
+// allowQuota 检查用户是否超出应用当天的额度，查询失败时不限制
+func (h *callbackHandler) allowQuota(ctx context.Context, openId string) bool {
+	app := h.appConfig()
+	ok, err := h.app.quota.allow(ctx, openId, app.DailyMessages, app.DailyTokens)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("Check quota error: %v", err)
+		return true
+	}
+	return ok
+}
+
+// addQuotaTokens 计入用户消耗的 token 数，失败时只记录日志
+func (h *callbackHandler) addQuotaTokens(ctx context.Context, userId string, tokens int) {
+	if err := h.app.quota.addTokens(ctx, userId, tokens); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("Add quota tokens error: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fanchunke/chatgpt-lark/internal/kb"
+	"github.com/fanchunke/chatgpt-lark/internal/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	defaultTopK = 3
+	// gptMaxContextLength 与 xgpt3 的默认上下文长度一致，xgpt3 按字节数截断请求中的消息，
+	// 参考资料超出剩余长度时会挤掉用户的消息，因此按该长度限制参考资料
+	gptMaxContextLength = 4097
+	// minKnowledgeLength 剩余长度不足时不附加参考资料
+	minKnowledgeLength = 200
+)
+
+var citationRegexp = regexp.MustCompile(`\[(\d+)\]`)
+
+// retrieve 从会话使用的知识库中检索与问题相关的片段，未开启知识库或检索失败时返回 nil，不影响正常回答
+func (h *callbackHandler) retrieve(ctx context.Context, msg *message, query string) []*kb.Result {
+	if h.retriever == nil || h.version != callbackVersionV2 {
+		return nil
+	}
+	name := h.cfg.RAG.KnowledgeBaseFor(h.appConfig(), msg.chatId)
+	if name == "" {
+		return nil
+	}
+	topK := h.cfg.RAG.TopK
+	if topK <= 0 {
+		topK = defaultTopK
+	}
+
+	ctx, span := tracing.Start(ctx, "kb.search", attribute.String("kb.name", name))
+	results, err := h.retriever.Search(ctx, name, query, topK, h.cfg.RAG.MinScore)
+	span.SetAttributes(attribute.Int("kb.results", len(results)))
+	tracing.End(span, err)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msgf("[AppId: %s] [UserId: %s] Search knowledge base %s error: %v", msg.appId, msg.openId, name, err)
+		return nil
+	}
+	log.Ctx(ctx).Debug().Msgf("[AppId: %s] [UserId: %s] Found %d chunks in knowledge base %s", msg.appId, msg.openId, len(results), name)
+	return results
+}
+
+// knowledgeMessage 生成附加在请求中的参考资料，片段按相似度编号为 [1]、[2]…。
+// 参考资料不超过 budget 字节，超出时截断最后一个片段并丢弃之后的片段，返回实际使用的片段。
+func knowledgeMessage(prompt string, refs []*kb.Result, budget int) (string, []*kb.Result) {
+	var b strings.Builder
+	b.WriteString(prompt)
+	used := make([]*kb.Result, 0, len(refs))
+	for i, ref := range refs {
+		header := fmt.Sprintf("\n\n[%d] %s\n", i+1, refLabel(ref))
+		remain := budget - b.Len() - len(header)
+		if remain < minKnowledgeLength/2 {
+			break
+		}
+		b.WriteString(header)
+		b.WriteString(truncateBytes(ref.Content, remain))
+		used = append(used, ref)
+	}
+	return b.String(), used
+}
+
+// truncateBytes 按字节数截断字符串，不截断多字节字符
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	n -= len("…")
+	for n > 0 && n < len(s) && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n] + "…"
+}
+
+// citations 生成回答末尾的参考资料列表，只列出回答中引用的编号，回答中没有引用时列出全部参考资料
+func citations(reply string, refs []*kb.Result) string {
+	if len(refs) == 0 {
+		return ""
+	}
+	cited := make(map[int]bool)
+	for _, m := range citationRegexp.FindAllStringSubmatch(reply, -1) {
+		if n, err := strconv.Atoi(m[1]); err == nil && n >= 1 && n <= len(refs) {
+			cited[n] = true
+		}
+	}
+	lines := []string{"参考资料："}
+	for i, ref := range refs {
+		if len(cited) > 0 && !cited[i+1] {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("[%d] %s", i+1, refLabel(ref)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// refLabel 片段的标题和出处，飞书文档的出处为链接，与标题之间以空格分隔，便于在飞书中识别为链接
+func refLabel(ref *kb.Result) string {
+	if strings.HasPrefix(ref.Source, "https://") || strings.HasPrefix(ref.Source, "http://") {
+		return ref.Title + " " + ref.Source
+	}
+	return fmt.Sprintf("%s（%s）", ref.Title, ref.Source)
+}
@@ -4,17 +4,27 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"strings"
+	"time"
 
 	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/audit"
+	"github.com/fanchunke/chatgpt-lark/internal/kb"
+	"github.com/fanchunke/chatgpt-lark/internal/metrics"
+	"github.com/fanchunke/chatgpt-lark/internal/replay"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/fanchunke/chatgpt-lark/internal/tools"
+	"github.com/fanchunke/chatgpt-lark/internal/tracing"
 	"github.com/fanchunke/xgpt3"
 
-	lark "github.com/larksuite/oapi-sdk-go/v3"
 	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
 	larkcontact "github.com/larksuite/oapi-sdk-go/v3/service/contact/v3"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 	"github.com/rs/zerolog/log"
 	openai "github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type versionType string
@@ -25,81 +35,319 @@ const (
 )
 
 type callbackHandler struct {
+	// cfg 为处理当前事件时的配置快照，由 withConfig 从 reloader 中获取
 	cfg         *config.Config
+	reloader    *config.Reloader
 	xgpt3Client *xgpt3.Client
-	larkClient  *lark.Client
-	version     versionType
+	app         *larkApp
+	store       *store.Store
+	auditor     *audit.Logger
+	recorder    *replay.Recorder
+	// retriever 为 nil 时不使用知识库
+	retriever *kb.Retriever
+	// toolClient 为 nil 时不调用工具
+	toolClient *tools.Client
+	// moderators 在各个事件的 handler 副本间共享
+	moderators *moderatorCache
+	redactors  *redactorCache
+	limiter    limiter
+	version    versionType
 }
 
-func NewCallbackHandler(cfg *config.Config, xgpt3Client *xgpt3.Client, larkClient *lark.Client, version versionType) *callbackHandler {
+func NewCallbackHandler(reloader *config.Reloader, xgpt3Client *xgpt3.Client, app *larkApp, store *store.Store, auditor *audit.Logger, recorder *replay.Recorder, retriever *kb.Retriever, toolClient *tools.Client, limiter limiter, version versionType) *callbackHandler {
 	return &callbackHandler{
-		cfg:         cfg,
-		larkClient:  larkClient,
+		cfg:         reloader.Load(),
+		reloader:    reloader,
+		app:         app,
 		xgpt3Client: xgpt3Client,
+		store:       store,
+		auditor:     auditor,
+		recorder:    recorder,
+		retriever:   retriever,
+		toolClient:  toolClient,
+		moderators:  &moderatorCache{},
+		redactors:   &redactorCache{},
+		limiter:     limiter,
 		version:     version,
 	}
 }
 
+// withConfig 返回使用当前配置快照的 handler，同一个事件的处理过程中配置保持不变
+func (h *callbackHandler) withConfig() *callbackHandler {
+	hh := *h
+	hh.cfg = h.reloader.Load()
+	return &hh
+}
+
 // OnP2MessageReceiveV1: 机器人接收到用户发送的消息后触发此事件。
 func (h *callbackHandler) OnP2MessageReceiveV1(ctx context.Context, event *larkim.P2MessageReceiveV1) error {
+	h = h.withConfig()
 	log.Debug().Msgf("收到飞书消息: %+v", larkcore.Prettify(event))
+	ctx = h.recordEvent(ctx, event)
+
+	// 飞书未及时收到响应时会重试推送，同一条消息只处理一次
+	messageId := larkcore.StringValue(event.Event.Message.MessageId)
+	if !h.firstReceived(ctx, messageId) {
+		log.Ctx(ctx).Info().Msgf("Duplicate message %s ignored", messageId)
+		h.recordDone(ctx, nil)
+		return nil
+	}
+
 	content, err := h.convertMessage(ctx, event)
 	if err != nil {
 		log.Ctx(ctx).Error().Err(err).Msgf("Convert lark msg error: %v", err)
+		h.recordDone(ctx, err)
 		return err
 	}
 
-	appId := event.EventV2Base.Header.AppID
-	openId := *event.Event.Sender.SenderId.OpenId
+	msg := &message{
+		appId:     event.EventV2Base.Header.AppID,
+		openId:    *event.Event.Sender.SenderId.OpenId,
+		chatId:    larkcore.StringValue(event.Event.Message.ChatId),
+		chatType:  larkcore.StringValue(event.Event.Message.ChatType),
+		messageId: messageId,
+		content:   content,
+	}
 
+	// 异步处理消息，沿用 webhook 请求中的 span、日志和请求 Id
+	msgCtx := tracing.Detach(ctx)
+	metrics.InFlight.Inc()
 	go func() {
+		defer metrics.InFlight.Dec()
 		defer func() {
 			if err := recover(); err != nil {
-				log.Error().Msgf("recovery from: %v", err)
+				log.Ctx(msgCtx).Error().Msgf("recovery from: %v", err)
 			}
 		}()
 
-		var reply string
+		ctx, span := tracing.Start(msgCtx, "lark.message.process",
+			attribute.String("lark.app_id", msg.appId),
+			attribute.String("lark.app_name", h.app.name),
+			attribute.String("lark.message_id", msg.messageId),
+			attribute.String("callback.version", string(h.version)),
+		)
+		err := h.processMessage(ctx, msg)
+		tracing.End(span, err)
+		h.app.activity.observe(h.app.name, msg, err)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("Process message error: %v", err)
+		}
+		h.recordDone(ctx, err)
+	}()
 
-		// 判断是否需要重启会话
-		closeSession := h.cfg.Conversation.CloseSessionFlag == content
+	return nil
+}
 
-		// 获取回复
-		if !closeSession {
-			var handler func(ctx context.Context, appId, userId string, content string) (string, error)
-			if h.version == callbackVersionV1 {
-				handler = h.getOpenAICompletion
-			} else {
-				handler = h.getOpenAIChatCompletion
-			}
+// firstReceived 检查是否第一次收到该消息，未开启去重或查询失败时按第一次收到处理
+func (h *callbackHandler) firstReceived(ctx context.Context, messageId string) bool {
+	if h.cfg.Conversation.DedupeTTL <= 0 || messageId == "" {
+		return true
+	}
+	ctx, span := tracing.Start(ctx, "lark.message.dedupe", attribute.String("lark.message_id", messageId))
+	first, err := h.store.MarkReceived(ctx, h.app.name, messageId)
+	span.SetAttributes(attribute.Bool("lark.message.duplicate", err == nil && !first))
+	tracing.End(span, err)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("Dedupe message error: %v", err)
+		return true
+	}
+	return first
+}
 
-			reply, err = handler(context.Background(), appId, openId, content)
-			if err != nil {
-				log.Error().Err(err).Msgf("Get GPT Response error: %v", err)
-				return
+// message 用户发送给机器人的消息
+type message struct {
+	appId  string
+	openId string
+	chatId string
+	// chatType 为 p2p 或 group
+	chatType  string
+	messageId string
+	content   string
+}
+
+// completion GPT 的回复和用量
+type completion struct {
+	reply string
+	model string
+	usage openai.Usage
+	// replyId xgpt3 保存的回复消息 Id，未开启会话功能时为 0
+	replyId int
+	// output GPT 回答的审核结果
+	output *moderationResult
+}
+
+// processMessage 执行命令或获取 GPT 回复，并将回复发送给用户
+func (h *callbackHandler) processMessage(ctx context.Context, msg *message) error {
+	var (
+		reply string
+		err   error
+	)
+	appId, openId, content := msg.appId, msg.openId, msg.content
+
+	// 检查白名单
+	h.refreshSettings(ctx)
+	if !h.app.settings.get().allowed(openId) {
+		log.Ctx(ctx).Info().Msgf("[AppId: %s] [UserId: %s] User not allowed", appId, openId)
+		if h.cfg.Conversation.NotAllowedReply == "" {
+			return nil
+		}
+		return h.sendTextMessage(ctx, appId, openId, h.cfg.Conversation.NotAllowedReply)
+	}
+
+	// 处理命令
+	if cmd, args, ok := h.parseCommand(content); ok {
+		metrics.Commands.WithLabelValues(cmd.name).Inc()
+		cmdCtx, span := tracing.Start(ctx, "command", attribute.String("command.name", cmd.name))
+		reply, err = cmd.handler(cmdCtx, &commandRequest{appId: appId, openId: openId, args: args})
+		tracing.End(span, err)
+		h.auditCommand(ctx, msg, reply, err)
+		if err != nil {
+			return fmt.Errorf("Handle command %s error: %w", cmd.name, err)
+		}
+	} else {
+		if !h.allowQuota(ctx, openId) {
+			log.Ctx(ctx).Info().Msgf("[AppId: %s] [UserId: %s] Daily quota exceeded", appId, openId)
+			reply = h.cfg.Conversation.QuotaExceededReply
+			if reply == "" {
+				return nil
 			}
+			return h.sendTextMessage(ctx, appId, openId, reply)
+		}
+
+		// 替换敏感信息，GPT 只能看到占位符
+		prompt, err := h.redactPII(ctx, openId, content)
+		if err != nil {
+			return fmt.Errorf("Redact PII error: %w", err)
+		}
+
+		// 审核用户消息
+		input, err := h.moderate(ctx, moderationStageInput, prompt)
+		if err != nil {
+			return fmt.Errorf("Moderate input error: %w", err)
+		}
+		if input.blocked {
+			h.auditCompletion(ctx, msg, &completion{}, fmt.Sprintf("input=%s", input.verdict), nil)
+			return h.sendTextMessage(ctx, appId, openId, h.cfg.Moderation.BlockedInputReply)
+		}
+
+		// 获取回复，v2 应用开启知识库时附加检索到的参考资料，开启工具调用时附加工具调用的结果
+		var (
+			handler func(ctx context.Context, appId string, sess *store.Session, content string) (*completion, error)
+			refs    []*kb.Result
+		)
+		if h.version == callbackVersionV1 {
+			handler = h.getOpenAICompletion
 		} else {
-			if err := h.xgpt3Client.CloseConversation(context.Background(), openId); err != nil {
-				log.Error().Err(err).Msgf("Close Conversation error: %v", err)
-				return
+			refs = h.retrieve(ctx, msg, input.text)
+			handler = func(ctx context.Context, appId string, sess *store.Session, content string) (*completion, error) {
+				results := h.callTools(ctx, msg, sess, content)
+				comp, used, err := h.getOpenAIChatCompletion(ctx, appId, sess, content, refs, results)
+				refs = used
+				return comp, err
 			}
-			reply = h.cfg.Conversation.CloseSessionReply
 		}
 
-		// 发送回复
-		if reply == "" {
-			log.Debug().Msg("Reply is empty")
-			return
+		sess, err := h.store.ActiveSession(ctx, h.app.name, openId)
+		if err != nil {
+			return fmt.Errorf("Get active session error: %w", err)
 		}
-		if err := h.sendTextMessage(context.Background(), appId, openId, reply); err != nil {
-			log.Error().Err(err).Msgf("Send Lark Response error: %v", err)
-			return
+		if err := h.expireIdleSession(ctx, appId, openId, sess); err != nil {
+			return fmt.Errorf("Expire idle session error: %w", err)
 		}
-	}()
+		_, span := tracing.Start(ctx, "gpt.queue")
+		release := h.limiter.acquire()
+		span.End()
+		comp, err := handler(ctx, appId, sess, input.text)
+		release()
+		if err != nil {
+			h.auditCompletion(ctx, msg, &completion{model: h.sessionModel(sess)}, fmt.Sprintf("input=%s", input.verdict), err)
+			return fmt.Errorf("Get GPT Response error: %w", err)
+		}
+
+		// 审核 GPT 回答，开启多轮对话时已在保存回复前审核
+		if err := h.moderateOutput(ctx, comp); err != nil {
+			return fmt.Errorf("Moderate output error: %w", err)
+		}
+		output := comp.output
+		h.auditCompletion(ctx, msg, comp, fmt.Sprintf("input=%s;output=%s", input.verdict, output.verdict), nil)
+		switch {
+		case output.blocked:
+			reply = h.cfg.Moderation.BlockedOutputReply
+		case (input.warned || output.warned) && h.cfg.Moderation.WarnReply != "":
+			reply = h.cfg.Moderation.WarnReply + "\n\n" + h.restorePII(ctx, openId, output.text)
+		default:
+			reply = h.restorePII(ctx, openId, output.text)
+		}
+		if footer := citations(comp.reply, refs); footer != "" && !output.blocked {
+			reply += "\n\n" + footer
+		}
+	}
+
+	// 发送回复
+	if reply == "" {
+		log.Ctx(ctx).Debug().Msg("Reply is empty")
+		return nil
+	}
+	if err := h.sendTextMessage(ctx, appId, openId, reply); err != nil {
+		return fmt.Errorf("Send Lark Response error: %w", err)
+	}
+	return nil
+}
+
+// expireIdleSession 会话超过 idleTimeout 未活跃时关闭会话，并提示用户已开启新的会话。
+// 会话已被后台任务关闭时，根据任务记录的过期时间提示用户。
+func (h *callbackHandler) expireIdleSession(ctx context.Context, appId, openId string, sess *store.Session) error {
+	timeout := h.cfg.Conversation.IdleTimeout
+	if timeout <= 0 {
+		return nil
+	}
 
+	last, ok, err := h.store.LastActivity(ctx, sess.ConversationKey)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		last, ok, err = h.store.TakeIdleExpired(ctx, sess.ID)
+		if err != nil || !ok {
+			return err
+		}
+		log.Ctx(ctx).Info().Msgf("[AppId: %s] [UserId: %s] Session %s was closed by idle sweeper", appId, openId, sess.Name)
+		h.sendIdleTimeoutReply(ctx, appId, openId, time.Since(last))
+		return nil
+	}
+	elapsed := time.Since(last)
+	if elapsed <= timeout {
+		return nil
+	}
+
+	log.Ctx(ctx).Info().Msgf("[AppId: %s] [UserId: %s] Session %s idle for %s, close conversation", appId, openId, sess.Name, elapsed)
+	if err := h.xgpt3Client.CloseConversation(ctx, sess.ConversationKey); err != nil {
+		return fmt.Errorf("Close Conversation error: %w", err)
+	}
+	h.sendIdleTimeoutReply(ctx, appId, openId, elapsed)
 	return nil
 }
 
+func (h *callbackHandler) sendIdleTimeoutReply(ctx context.Context, appId, openId string, elapsed time.Duration) {
+	if reply := h.cfg.Conversation.IdleTimeoutReply; reply != "" {
+		if err := h.sendTextMessage(ctx, appId, openId, fmt.Sprintf(reply, humanizeDuration(elapsed))); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("Send Lark Response error: %v", err)
+		}
+	}
+}
+
+// humanizeDuration 将时长转换为“3 天”“5 小时”这样的描述
+func humanizeDuration(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%d 天", int(d/(24*time.Hour)))
+	case d >= time.Hour:
+		return fmt.Sprintf("%d 小时", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%d 分钟", int(d/time.Minute))
+	}
+}
+
 func (h *callbackHandler) OnP2MessageReadV1(ctx context.Context, event *larkim.P2MessageReadV1) error {
 	fmt.Println(larkcore.Prettify(event))
 	fmt.Println(event.RequestId())
@@ -139,89 +387,262 @@ func (h *callbackHandler) sendTextMessage(ctx context.Context, appId, userId, co
 	sendContent, _ := json.Marshal(map[string]string{
 		"text": content,
 	})
-	log.Info().Msgf("[AppId: %d] [UserId: %s] Start Send Lark Response: %s", appId, userId, string(sendContent))
-	_, err := h.larkClient.Im.Message.Create(ctx, larkim.NewCreateMessageReqBuilder().
-		ReceiveIdType(larkim.ReceiveIdTypeOpenId).
-		Body(larkim.NewCreateMessageReqBodyBuilder().
-			MsgType(larkim.MsgTypeText).
-			ReceiveId(userId).
-			Content(string(sendContent)).
-			Build()).
-		Build())
+	return h.sendMessage(ctx, appId, userId, larkim.MsgTypeText, string(sendContent))
+}
 
-	if err != nil {
+func (h *callbackHandler) sendMessage(ctx context.Context, appId, userId, msgType, content string) (err error) {
+	ctx, span := tracing.Start(ctx, "lark.send", attribute.String("lark.msg_type", msgType))
+	defer func() { tracing.End(span, err) }()
+
+	log.Ctx(ctx).Info().Msgf("[AppId: %s] [UserId: %s] Start Send Lark Response: %s", appId, userId, content)
+	if err := h.app.channel.SendMessage(ctx, userId, msgType, content); err != nil {
+		metrics.LarkSendFailures.WithLabelValues(msgType).Inc()
 		return fmt.Errorf("Send Lark Message failed: %w", err)
 	}
-
 	return nil
 }
 
-func (h *callbackHandler) getOpenAICompletion(ctx context.Context, appId, userId, content string) (string, error) {
+// sendFileMessage 上传文件并以文件消息发送给用户
+func (h *callbackHandler) sendFileMessage(ctx context.Context, appId, userId, fileName string, file io.Reader) error {
+	uploadCtx, span := tracing.Start(ctx, "lark.upload_file")
+	fileKey, err := h.app.channel.UploadFile(uploadCtx, fileName, file)
+	tracing.End(span, err)
+	if err != nil {
+		metrics.LarkSendFailures.WithLabelValues(larkim.MsgTypeFile).Inc()
+		return fmt.Errorf("Upload Lark File failed: %w", err)
+	}
+
+	content, _ := json.Marshal(map[string]string{
+		"file_key": fileKey,
+	})
+	return h.sendMessage(ctx, appId, userId, larkim.MsgTypeFile, string(content))
+}
+
+// observeGPT 记录 GPT 调用的耗时和 token 用量指标，并结束 span
+func (h *callbackHandler) observeGPT(span trace.Span, model string, start time.Time, usage openai.Usage, err error) {
+	span.SetAttributes(
+		attribute.Int("gpt.prompt_tokens", usage.PromptTokens),
+		attribute.Int("gpt.completion_tokens", usage.CompletionTokens),
+	)
+	tracing.End(span, err)
+
+	route, model := string(h.version), h.metricModel(model)
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	metrics.GPTDuration.WithLabelValues(model, route, result).Observe(time.Since(start).Seconds())
+	metrics.GPTTokens.WithLabelValues(model, route, "prompt").Add(float64(usage.PromptTokens))
+	metrics.GPTTokens.WithLabelValues(model, route, "completion").Add(float64(usage.CompletionTokens))
+}
+
+// metricModel 指标中的模型名称。未配置 gpt.models 时用户可以通过 /model 设置任意模型，
+// 不在配置中的模型统一记为 other，避免标签数量随用户输入增长
+func (h *callbackHandler) metricModel(model string) string {
+	known := append([]string{openai.GPT3TextDavinci003, openai.GPT3Dot5Turbo, h.appConfig().Model}, h.cfg.GPT.Models...)
+	for _, m := range known {
+		if m != "" && m == model {
+			return model
+		}
+	}
+	return "other"
+}
+
+// auditCompletion 将用户的提问、GPT 的回答和内容审核结果写入审计日志，callErr 为 GPT 请求失败的原因
+func (h *callbackHandler) auditCompletion(ctx context.Context, msg *message, comp *completion, moderation string, callErr error) {
+	h.audit(ctx, &audit.Record{
+		Action:           audit.ActionCompletion,
+		AppId:            msg.appId,
+		UserId:           msg.openId,
+		ChatId:           msg.chatId,
+		MessageId:        msg.messageId,
+		Model:            comp.model,
+		PromptTokens:     comp.usage.PromptTokens,
+		CompletionTokens: comp.usage.CompletionTokens,
+		TotalTokens:      comp.usage.TotalTokens,
+		Moderation:       moderation,
+	}, msg.content, comp.reply, callErr)
+}
+
+// auditCommand 将用户发送的命令和回复写入审计日志，cmdErr 为命令执行失败的原因
+func (h *callbackHandler) auditCommand(ctx context.Context, msg *message, reply string, cmdErr error) {
+	h.audit(ctx, &audit.Record{
+		Action:    audit.ActionCommand,
+		AppId:     msg.appId,
+		UserId:    msg.openId,
+		ChatId:    msg.chatId,
+		MessageId: msg.messageId,
+	}, msg.content, reply, cmdErr)
+}
+
+// audit 写入审计记录，opErr 不为 nil 时记录失败原因
+func (h *callbackHandler) audit(ctx context.Context, r *audit.Record, prompt, answer string, opErr error) {
+	r.AppName = h.app.name
+	if opErr != nil {
+		r.Error = opErr.Error()
+	}
+	if err := h.auditor.Log(r, prompt, answer); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("Write audit record error: %v", err)
+	}
+}
+
+// recordUsage 记录 GPT 调用的模型和用量。replyId 为 xgpt3 保存的回复消息，用于导出对话，未开启会话功能时为 0。
+func (h *callbackHandler) recordUsage(ctx context.Context, sess *store.Session, model string, usage openai.Usage, replyId int) {
+	h.addQuotaTokens(ctx, sess.UserID, usage.TotalTokens)
+	u := &store.Usage{
+		App:              sess.App,
+		ConversationKey:  sess.ConversationKey,
+		MessageID:        replyId,
+		Model:            model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+	}
+	if err := h.store.CreateUsage(ctx, u); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("Record usage error: %v", err)
+	}
+}
+
+// sessionModel 获取会话使用的模型，会话未设置时依次使用应用的模型和接口版本的默认模型。
+// v1 和 v2 共用会话，会话或应用的模型不能用于当前接口版本时使用默认模型。
+func (h *callbackHandler) sessionModel(sess *store.Session) string {
+	if sess.Model != "" && h.modelSupported(sess.Model) {
+		return sess.Model
+	}
+	if model := h.appConfig().Model; model != "" && h.modelSupported(model) {
+		return model
+	}
+	if h.version == callbackVersionV1 {
+		return openai.GPT3TextDavinci003
+	}
+	return openai.GPT3Dot5Turbo
+}
+
+func (h *callbackHandler) getOpenAICompletion(ctx context.Context, appId string, sess *store.Session, content string) (*completion, error) {
 	// 获取 GPT 回复
 	req := openai.CompletionRequest{
-		Model:           openai.GPT3TextDavinci003,
+		Model:           h.sessionModel(sess),
 		MaxTokens:       1500,
 		Prompt:          content,
 		TopP:            1,
 		Temperature:     0.9,
 		PresencePenalty: 0.6,
-		User:            userId,
+		User:            sess.ConversationKey,
 	}
 
 	var resp openai.CompletionResponse
 	var err error
+	start := time.Now()
+	comp := &completion{}
+	hook := h.outputHook(comp)
+	gptCtx, span := tracing.Start(store.WithReplyHook(ctx, hook), "gpt.completion", attribute.String("gpt.model", req.Model))
 	if h.cfg.Conversation.EnableConversation {
-		resp, err = h.xgpt3Client.CreateConversationCompletionWithChannel(ctx, req, appId)
+		resp, err = h.xgpt3Client.CreateConversationCompletionWithChannel(gptCtx, req, appId)
 	} else {
-		resp, err = h.xgpt3Client.Client.CreateCompletion(ctx, req)
+		resp, err = h.xgpt3Client.Client.CreateCompletion(gptCtx, req)
 	}
+	h.observeGPT(span, req.Model, start, resp.Usage, err)
 
 	if err != nil {
-		return "", fmt.Errorf("CreateCompletion failed: %w", err)
+		return nil, fmt.Errorf("CreateCompletion failed: %w", err)
 	}
 
 	if len(resp.Choices) == 0 {
-		return "", fmt.Errorf("Empty GPT Choices")
+		return nil, fmt.Errorf("Empty GPT Choices")
 	}
+	// 使用 xgpt3 保存回复时记录的消息 Id，并发的消息不会关联到其他轮次的回复
+	h.recordUsage(ctx, sess, resp.Model, resp.Usage, hook.ReplyID)
 
 	// 发送回复给用户
-	reply := strings.TrimSpace(resp.Choices[0].Text)
-	return reply, nil
+	comp.reply = strings.TrimSpace(resp.Choices[0].Text)
+	comp.model, comp.usage, comp.replyId = resp.Model, resp.Usage, hook.ReplyID
+	return comp, nil
+}
+
+// sessionSystemPrompt 获取会话的系统提示词，会话未设置时依次使用应用的人设和配置中的默认值
+func (h *callbackHandler) sessionSystemPrompt(sess *store.Session) string {
+	if sess.SystemPrompt != "" {
+		return sess.SystemPrompt
+	}
+	if prompt := h.appConfig().SystemPrompt; prompt != "" {
+		return prompt
+	}
+	return h.cfg.Conversation.SystemPrompt
 }
 
-func (h *callbackHandler) getOpenAIChatCompletion(ctx context.Context, appId, userId, content string) (string, error) {
+// getOpenAIChatCompletion 获取 GPT 回复，refs 为知识库中检索到的片段，results 为工具调用的结果，返回实际附加在请求中的片段
+func (h *callbackHandler) getOpenAIChatCompletion(ctx context.Context, appId string, sess *store.Session, content string, refs []*kb.Result, results []*toolResult) (*completion, []*kb.Result, error) {
 	// 获取 GPT 回复
+	const maxTokens = 1500
+	messages := make([]openai.ChatCompletionMessage, 0, 3)
+	if systemPrompt := h.sessionSystemPrompt(sess); systemPrompt != "" {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: systemPrompt,
+		})
+	}
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: content,
+	})
+	// 工具调用的结果和参考资料放在用户消息之后：xgpt3 只保存最后一条用户消息，它们不会进入会话历史；
+	// 没有历史消息时 xgpt3 会丢弃开头的非用户消息，放在之后不会被丢弃
+	budget := gptMaxContextLength - maxTokens
+	for _, m := range messages {
+		budget -= len(m.Content)
+	}
+	if len(results) > 0 && budget >= minKnowledgeLength {
+		output := toolMessage(results, budget)
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: output,
+		})
+		budget -= len(output)
+	}
+	if len(refs) > 0 {
+		var knowledge string
+		knowledge, refs = knowledgeMessage(h.cfg.RAG.Prompt, refs, budget)
+		if len(refs) > 0 {
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: knowledge,
+			})
+		}
+	}
 	req := openai.ChatCompletionRequest{
-		Model:     openai.GPT3Dot5Turbo,
-		MaxTokens: 1500,
-		Messages: []openai.ChatCompletionMessage{
-			{
-				Role:    openai.ChatMessageRoleUser,
-				Content: content,
-			},
-		},
+		Model:           h.sessionModel(sess),
+		MaxTokens:       maxTokens,
+		Messages:        messages,
 		TopP:            1,
 		Temperature:     0.9,
 		PresencePenalty: 0.6,
-		User:            userId,
+		User:            sess.ConversationKey,
 	}
 	var resp openai.ChatCompletionResponse
 	var err error
+	start := time.Now()
+	comp := &completion{}
+	hook := h.outputHook(comp)
+	gptCtx, span := tracing.Start(store.WithReplyHook(ctx, hook), "gpt.chat_completion", attribute.String("gpt.model", req.Model))
 	if h.cfg.Conversation.EnableConversation {
-		resp, err = h.xgpt3Client.CreateChatCompletionWithChannel(ctx, req, appId)
+		resp, err = h.xgpt3Client.CreateChatCompletionWithChannel(gptCtx, req, appId)
 	} else {
-		resp, err = h.xgpt3Client.Client.CreateChatCompletion(ctx, req)
+		resp, err = h.xgpt3Client.Client.CreateChatCompletion(gptCtx, req)
 	}
+	h.observeGPT(span, req.Model, start, resp.Usage, err)
 
 	if err != nil {
-		return "", fmt.Errorf("CreateCompletion failed: %w", err)
+		return nil, refs, fmt.Errorf("CreateCompletion failed: %w", err)
 	}
 
 	if len(resp.Choices) == 0 {
-		return "", fmt.Errorf("Empty GPT Choices")
+		return nil, refs, fmt.Errorf("Empty GPT Choices")
 	}
+	// 使用 xgpt3 保存回复时记录的消息 Id，并发的消息不会关联到其他轮次的回复
+	h.recordUsage(ctx, sess, resp.Model, resp.Usage, hook.ReplyID)
 
 	// 发送回复给用户
-	reply := strings.TrimSpace(resp.Choices[0].Message.Content)
-	return reply, nil
+	comp.reply = strings.TrimSpace(resp.Choices[0].Message.Content)
+	comp.model, comp.usage, comp.replyId = resp.Model, resp.Usage, hook.ReplyID
+	return comp, refs, nil
 }
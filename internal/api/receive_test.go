@@ -0,0 +1,261 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	entsql "entgo.io/ent/dialect/sql"
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/audit"
+	"github.com/fanchunke/chatgpt-lark/internal/larktest"
+	"github.com/fanchunke/chatgpt-lark/internal/migrate"
+	"github.com/fanchunke/chatgpt-lark/internal/openaitest"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/fanchunke/xgpt3"
+	lark "github.com/larksuite/oapi-sdk-go/v3"
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	larkevent "github.com/larksuite/oapi-sdk-go/v3/event"
+	_ "github.com/mattn/go-sqlite3"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+var testLarkApp = larktest.App{AppID: "cli_test", AppSecret: "secret", VerificationToken: "token", EventEncryptKey: "encrypt-key"}
+
+// testEnv 使用模拟的飞书和 OpenAI 服务以及内存数据库启动的完整路由
+type testEnv struct {
+	url  string
+	lark *larktest.Server
+	gpt  *openaitest.Server
+}
+
+// newTestEnv 启动测试环境，configure 用于修改默认配置
+func newTestEnv(t *testing.T, configure func(cfg *config.Config)) *testEnv {
+	t.Helper()
+	larkSrv := larktest.NewServer(testLarkApp)
+	t.Cleanup(larkSrv.Close)
+	gptSrv := openaitest.NewServer()
+	t.Cleanup(gptSrv.Close)
+	st := newTestStore(t)
+
+	cfg := &config.Config{
+		HTTP: config.HTTP{Port: "8080"},
+		Lark: config.Lark{
+			AppId:             testLarkApp.AppID,
+			AppSecret:         testLarkApp.AppSecret,
+			VerificationToken: testLarkApp.VerificationToken,
+			EventEncryptKey:   testLarkApp.EventEncryptKey,
+			BaseUrl:           larkSrv.URL,
+		},
+		GPT:          config.GPT{ApiKey: "test"},
+		Conversation: config.Conversation{EnableConversation: true, DedupeTTL: time.Hour},
+		Health:       config.Health{Timeout: 5 * time.Second},
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	larkClient := lark.NewClient(cfg.Lark.AppId, cfg.Lark.AppSecret, lark.WithOpenBaseUrl(cfg.Lark.BaseUrl))
+	xgpt3Client := xgpt3.NewClient(openai.NewClientWithConfig(gptSrv.ClientConfig()), st.ConversationHandler())
+	handler, err := NewRouter(config.NewReloader(cfg), xgpt3Client, map[string]*lark.Client{config.DefaultAppName: larkClient}, st, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return &testEnv{url: srv.URL, lark: larkSrv, gpt: gptSrv}
+}
+
+// newTestStore 创建执行了全部迁移的内存数据库
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	drv, err := entsql.Open("sqlite3", fmt.Sprintf("file:api-%d?mode=memory&cache=shared&_fk=1", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { drv.Close() })
+	m, err := migrate.New(drv.DB(), drv.Dialect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background(), 0); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	return store.New(drv)
+}
+
+// waitReplies 等待应用发送 n 条消息，并确认之后没有多余的消息
+func (e *testEnv) waitReplies(t *testing.T, n int) []larktest.Message {
+	t.Helper()
+	messages, err := e.lark.WaitMessages(n, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extra, err := e.lark.WaitMessages(n+1, 200*time.Millisecond); err == nil {
+		t.Fatalf("got %d messages, want %d: %+v", len(extra), n, extra)
+	}
+	return messages
+}
+
+func TestReceiveMessage(t *testing.T) {
+	cases := []struct {
+		name  string
+		path  string
+		text  string
+		reply string
+		// model 请求 GPT 的模型，为空时不请求 GPT
+		model string
+	}{
+		{name: "completion", path: "/lark/receive", text: "hello", reply: "hi", model: openai.GPT3TextDavinci003},
+		{name: "chat completion", path: "/lark/receive/v2", text: "hello", reply: "hi", model: openai.GPT3Dot5Turbo},
+		{name: "command", path: "/lark/receive/v2", text: "/model", reply: "会话「default」当前使用的模型：gpt-3.5-turbo"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.gpt.Enqueue(openaitest.Response{Content: "hi"})
+			resp, err := env.lark.SendEvent(env.url+tc.path, larktest.TextEvent("ou_user", tc.text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want 200", resp.StatusCode)
+			}
+
+			messages := env.waitReplies(t, 1)
+			want := larktest.Message{Op: larktest.OpCreate, ReceiveIDType: "open_id", ReceiveID: "ou_user", MsgType: "text"}
+			got := messages[0]
+			if got.Op != want.Op || got.ReceiveIDType != want.ReceiveIDType || got.ReceiveID != want.ReceiveID || got.MsgType != want.MsgType {
+				t.Errorf("message = %+v, want %+v", got, want)
+			}
+			if !strings.HasPrefix(got.Text(), tc.reply) {
+				t.Errorf("reply = %q, want prefix %q", got.Text(), tc.reply)
+			}
+
+			requests := env.gpt.Requests()
+			if tc.model == "" {
+				if len(requests) != 0 {
+					t.Errorf("got %d GPT requests, want 0", len(requests))
+				}
+				return
+			}
+			// completion 接口的 prompt 由 xgpt3 拼接，包含用户消息
+			if len(requests) != 1 || requests[0].Model != tc.model || !strings.Contains(requests[0].LastUserMessage(), tc.text) {
+				t.Errorf("GPT requests = %+v, want one %s request for %q", requests, tc.model, tc.text)
+			}
+		})
+	}
+}
+
+func TestReceiveRejected(t *testing.T) {
+	cases := []struct {
+		name   string
+		tamper func(req *http.Request)
+		status int
+	}{
+		{name: "invalid signature", tamper: func(req *http.Request) { req.Header.Set(larkevent.EventSignature, "invalid") }, status: http.StatusInternalServerError},
+		{name: "missing signature", tamper: func(req *http.Request) { req.Header.Del(larkevent.EventSignature) }, status: http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			body, err := env.lark.EventBody(larktest.TextEvent("ou_user", "hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := env.lark.NewEventRequest(env.url+"/lark/receive/v2", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.tamper(req)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tc.status)
+			}
+			env.waitReplies(t, 0)
+		})
+	}
+}
+
+func TestReceiveDuplicate(t *testing.T) {
+	env := newTestEnv(t, nil)
+	// 飞书重试推送时事件 Id 不同，消息 Id 相同
+	for _, eventID := range []string{"ev_1", "ev_2"} {
+		ev := larktest.TextEvent("ou_user", "hello")
+		ev.EventID, ev.MessageID = eventID, "om_retry"
+		resp, err := env.lark.SendEvent(env.url+"/lark/receive/v2", ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	env.waitReplies(t, 1)
+	if got := len(env.gpt.Requests()); got != 1 {
+		t.Errorf("got %d GPT requests, want 1", got)
+	}
+}
+
+func TestReadyzTenantToken(t *testing.T) {
+	cases := []struct {
+		name   string
+		secret string
+		status int
+	}{
+		{name: "valid secret", secret: testLarkApp.AppSecret, status: http.StatusOK},
+		{name: "invalid secret", secret: "other", status: http.StatusServiceUnavailable},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t, func(cfg *config.Config) { cfg.Lark.AppSecret = tc.secret })
+			resp, err := http.Get(env.url + "/readyz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tc.status)
+			}
+		})
+	}
+}
+
+func TestCardActionAudit(t *testing.T) {
+	h, _ := newTestHandler(t, callbackVersionV2, func(cfg *config.Config) {
+		cfg.Lark.AppId = testLarkApp.AppID
+	})
+	sink := &memoryAuditSink{}
+	auditor, err := audit.New(sink, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.auditor = auditor
+
+	// 与 SDK 相同，从回调内容中解析卡片操作
+	body := []byte(`{"open_id":"ou_1","open_message_id":"om_card","open_chat_id":"oc_1","action":{"value":{"action":"session.switch","name":"工作"}}}`)
+	action := &larkcard.CardAction{}
+	if err := json.Unmarshal(body, action); err != nil {
+		t.Fatal(err)
+	}
+	action.EventReq = &larkevent.EventReq{Body: body}
+	if _, err := h.OnCardAction(context.Background(), action); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.records) != 1 {
+		t.Fatalf("got %d audit records, want 1", len(sink.records))
+	}
+	r := sink.records[0]
+	if r.Action != audit.ActionCardAction || r.AppId != testLarkApp.AppID || r.UserId != "ou_1" || r.ChatId != "oc_1" || r.MessageId != "om_card" {
+		t.Errorf("record = %+v", r)
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"github.com/rs/zerolog/log"
+
+	"github.com/fanchunke/chatgpt-lark/internal/replay"
+)
+
+// recordEvent 记录解密后的飞书事件，返回的 context 用于记录处理过程中的接口调用。未开启记录时返回原 context。
+func (h *callbackHandler) recordEvent(ctx context.Context, event *larkim.P2MessageReceiveV1) context.Context {
+	if h.recorder == nil {
+		return ctx
+	}
+	ctx = replay.WithEvent(ctx, event.EventV2Base.Header.EventID, larkcore.StringValue(event.Event.Message.MessageId))
+	err := h.recorder.RecordEvent(ctx, h.app.name, string(h.version), map[string]interface{}{
+		"schema": event.EventV2Base.Schema,
+		"header": event.EventV2Base.Header,
+		"event":  event.Event,
+	})
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("Record event error: %v", err)
+	}
+	return ctx
+}
+
+// recordDone 记录事件处理完成
+func (h *callbackHandler) recordDone(ctx context.Context, processErr error) {
+	if err := h.recorder.RecordDone(ctx, processErr); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("Record event error: %v", err)
+	}
+}
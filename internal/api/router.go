@@ -1,49 +1,169 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/fanchunke/xgpt3"
 
+	"github.com/fanchunke/chatgpt-lark/internal/audit"
+	"github.com/fanchunke/chatgpt-lark/internal/health"
+	"github.com/fanchunke/chatgpt-lark/internal/kb"
 	"github.com/fanchunke/chatgpt-lark/internal/middleware"
+	"github.com/fanchunke/chatgpt-lark/internal/replay"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/fanchunke/chatgpt-lark/internal/tools"
 
 	config "github.com/fanchunke/chatgpt-lark/conf"
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
-	sdkginext "github.com/larksuite/oapi-sdk-gin"
 	lark "github.com/larksuite/oapi-sdk-go/v3"
-	"github.com/larksuite/oapi-sdk-go/v3/event/dispatcher"
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type router struct {
 	*gin.Engine
 	cfg         *config.Config
 	xgpt3Client *xgpt3.Client
-	larkClient  *lark.Client
+	apps        []*larkApp
+	store       *store.Store
+	auditor     *audit.Logger
+	recorder    *replay.Recorder
+	retriever   *kb.Retriever
+	toolClient  *tools.Client
+	readiness   *health.Checker
+	reloader    *config.Reloader
+	// logins 管理后台登录失败的计数
+	logins *loginLimiter
 }
 
-func NewRouter(cfg *config.Config, xgpt3Client *xgpt3.Client, larkClient *lark.Client) (http.Handler, error) {
+// NewRouter 创建路由。路由和中间件使用启动时的配置，消息回调在每个事件中读取 reloader 的最新配置。
+// larkClients 为每个飞书应用的 client，key 为应用名称。auditor 为 nil 时不记录审计日志，recorder 为 nil 时不记录事件，
+// retriever 为 nil 时不使用知识库，toolClient 为 nil 时不调用工具。
+func NewRouter(reloader *config.Reloader, xgpt3Client *xgpt3.Client, larkClients map[string]*lark.Client, store *store.Store, auditor *audit.Logger, recorder *replay.Recorder, retriever *kb.Retriever, toolClient *tools.Client) (http.Handler, error) {
 	gin.SetMode(gin.ReleaseMode)
 	e := gin.Default()
 	pprof.Register(e, "debug/pprof")
 
-	r := &router{Engine: e, cfg: cfg, xgpt3Client: xgpt3Client, larkClient: larkClient}
+	cfg := reloader.Load()
+	if err := e.SetTrustedProxies(cfg.HTTP.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("set trusted proxies failed: %w", err)
+	}
+	r := &router{Engine: e, cfg: cfg, xgpt3Client: xgpt3Client, store: store, auditor: auditor, recorder: recorder, retriever: retriever, toolClient: toolClient, reloader: reloader}
+	for _, app := range cfg.LarkApps() {
+		client, ok := larkClients[app.Name]
+		if !ok {
+			return nil, fmt.Errorf("lark client of app %q not found", app.Name)
+		}
+		vault, err := newVault(app.Name, cfg, store)
+		if err != nil {
+			return nil, fmt.Errorf("init pii vault of app %q failed: %w", app.Name, err)
+		}
+		r.apps = append(r.apps, &larkApp{
+			name:     app.Name,
+			client:   client,
+			channel:  &larkChannel{client: client},
+			quota:    newQuota(store, app.Name),
+			vault:    vault,
+			settings: newSettingsCache(),
+			activity: newActivity(),
+			tools:    tools.NewRegistry(append(tools.Builtin(), tools.Lark(client)...)...),
+			pending:  newPendingCalls(),
+		})
+	}
+
+	r.Use(middleware.TracingHandler(cfg.App.Name))
 	r.Use(middleware.Logger())
 	r.Use(middleware.URLHandler("url"))
 	r.Use(middleware.MethodHandler("method"))
 	r.Use(middleware.RequestIDHandler("requestId", "X-Request-Id"))
-	r.Use(middleware.AccessHandler())
+	r.Use(middleware.TraceIDHandler("traceId"))
+	r.Use(middleware.MetricsHandler())
+	r.Use(middleware.BodyLimitHandler(cfg.HTTP.MaxBodySize))
+	r.Use(middleware.AccessHandler(middleware.AccessLogOptions{
+		MaxBodyLength: cfg.Logger.AccessMaxBody,
+		RedactFields:  cfg.Logger.AccessRedactFields,
+		SampleRate:    cfg.Logger.AccessSampleRate,
+	}))
+	r.readiness = health.NewChecker(cfg.Health.CacheTTL, cfg.Health.Timeout, r.readinessChecks()...)
 	r.GET("/healthz", r.Healthz)
+	r.GET("/readyz", r.Readyz)
+	// 未配置单独的监控端口时，指标需要管理接口的认证
+	if cfg.HTTP.MetricsPort == "" && cfg.Admin.Enabled() {
+		r.GET("/metrics", r.adminAuth(), gin.WrapH(promhttp.Handler()))
+	}
+
+	// GPT 并发限制，所有应用共用
+	limiter := newLimiter(cfg.GPT.MaxConcurrency)
+
+	for _, app := range r.apps {
+		appConf, _ := cfg.LarkApp(app.name)
+		if app.name == config.DefaultAppName {
+			r.registerDefaultApp(appConf, app, limiter)
+			continue
+		}
 
+		version := versionType(appConf.Version)
+		callback := NewCallbackHandler(reloader, r.xgpt3Client, app, r.store, r.auditor, r.recorder, r.retriever, r.toolClient, limiter, version)
+		handler := newEventDispatcher(appConf, callback.OnP2MessageReceiveV1)
+		cardHandler := larkcard.NewCardActionHandler(appConf.VerificationToken, appConf.EventEncryptKey, callback.OnCardAction)
+		r.POST("/lark/apps/"+app.name+"/receive", larkEventHandlerFunc(app.name+"."+string(version), handler))
+		r.POST("/lark/apps/"+app.name+"/card", larkCardHandlerFunc(app.name+".card", cardHandler))
+	}
+
+	// 管理接口，未配置 token 和签名密钥时不开启
+	if r.cfg.Admin.Enabled() {
+		admin := r.Group("/admin", r.adminAuth())
+		admin.GET("/conversations", r.ListConversations)
+		admin.GET("/conversations/:id/messages", r.ListConversationMessages)
+		admin.GET("/conversations/:id/export", r.ExportConversation)
+		admin.POST("/users/:userId/close", r.CloseUserConversation)
+		admin.DELETE("/users/:userId", r.ForgetUser)
+		admin.GET("/apps", r.ListApps)
+		admin.GET("/apps/:app/settings", r.GetAppSettings)
+		admin.PUT("/apps/:app/settings", r.UpdateAppSettings)
+		admin.DELETE("/apps/:app/settings", r.ResetAppSettings)
+		admin.GET("/apps/:app/quota", r.GetAppQuota)
+		admin.DELETE("/apps/:app/quota/:userId", r.ResetUserQuota)
+		admin.GET("/usage", r.UsageStats)
+		admin.GET("/traffic", r.Traffic)
+		admin.GET("/errors", r.RecentErrors)
+		admin.GET("/feedback", r.ListFeedbacks)
+		admin.POST("/config/reload", r.ReloadConfig)
+	}
+	// 管理后台通过 token 登录，未配置 token 或 sessionSecret 时不开启
+	if r.cfg.Admin.DashboardEnabled() {
+		r.registerDashboard()
+	}
+	return r, nil
+}
+
+// adminAuth 管理接口的认证中间件
+func (r *router) adminAuth() gin.HandlerFunc {
+	return middleware.AuthHandler(middleware.AuthOptions{
+		Token:         r.cfg.Admin.Token,
+		HMACSecret:    r.cfg.Admin.HMACSecret,
+		MaxSkew:       r.cfg.Admin.HMACMaxSkew,
+		Nonces:        r.store,
+		SessionSecret: r.cfg.Admin.SessionSecret,
+	})
+}
+
+// registerDefaultApp 注册默认应用 [lark] 的回调地址
+func (r *router) registerDefaultApp(appConf config.LarkApp, app *larkApp, limiter limiter) {
 	// gpt3
-	callbackV1 := NewCallbackHandler(cfg, r.xgpt3Client, r.larkClient, callbackVersionV1)
-	handlerV1 := dispatcher.NewEventDispatcher(r.cfg.Lark.VerificationToken, r.cfg.Lark.EventEncryptKey).OnP2MessageReceiveV1(callbackV1.OnP2MessageReceiveV1)
+	callbackV1 := NewCallbackHandler(r.reloader, r.xgpt3Client, app, r.store, r.auditor, r.recorder, r.retriever, r.toolClient, limiter, callbackVersionV1)
+	handlerV1 := newEventDispatcher(appConf, callbackV1.OnP2MessageReceiveV1)
 
 	// gpt 3.5 turbo
-	callbackV2 := NewCallbackHandler(cfg, r.xgpt3Client, r.larkClient, callbackVersionV2)
-	handlerV2 := dispatcher.NewEventDispatcher(r.cfg.Lark.VerificationToken, r.cfg.Lark.EventEncryptKey).OnP2MessageReceiveV1(callbackV2.OnP2MessageReceiveV1)
+	callbackV2 := NewCallbackHandler(r.reloader, r.xgpt3Client, app, r.store, r.auditor, r.recorder, r.retriever, r.toolClient, limiter, callbackVersionV2)
+	handlerV2 := newEventDispatcher(appConf, callbackV2.OnP2MessageReceiveV1)
 
-	r.POST("/lark/receive", sdkginext.NewEventHandlerFunc(handlerV1))
-	r.POST("/lark/receive/v2", sdkginext.NewEventHandlerFunc(handlerV2))
-	return r, nil
+	// 消息卡片
+	cardHandler := larkcard.NewCardActionHandler(appConf.VerificationToken, appConf.EventEncryptKey, callbackV2.OnCardAction)
+
+	r.POST("/lark/receive", larkEventHandlerFunc(string(callbackVersionV1), handlerV1))
+	r.POST("/lark/receive/v2", larkEventHandlerFunc(string(callbackVersionV2), handlerV2))
+	r.POST("/lark/card", larkCardHandlerFunc("card", cardHandler))
 }
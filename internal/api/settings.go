@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+// settingsRefreshInterval 应用设置的缓存时间，多个实例部署时通过管理接口修改的设置最多延迟该时长生效
+const settingsRefreshInterval = 30 * time.Second
+
+// appSettings 通过管理接口修改的应用设置，字段为 nil 时使用配置文件中的值。
+// 每个字段以 JSON 格式保存在 app_settings 表中，名称为字段的 json tag。
+type appSettings struct {
+	// 应用的人设
+	SystemPrompt *string `json:"systemPrompt,omitempty"`
+	// 每个用户每天可以发送的消息数，为 0 时不限制
+	DailyMessages *int `json:"dailyMessages,omitempty"`
+	// 每个用户每天可以消耗的 token 数，为 0 时不限制
+	DailyTokens *int `json:"dailyTokens,omitempty"`
+	// 允许使用应用的用户 Id，为空时不限制
+	AllowList *[]string `json:"allowList,omitempty"`
+}
+
+// settingNames 支持的设置名称
+var settingNames = []string{"systemPrompt", "dailyMessages", "dailyTokens", "allowList"}
+
+func (s *appSettings) validate() error {
+	if s.DailyMessages != nil && *s.DailyMessages < 0 {
+		return fmt.Errorf("dailyMessages must not be negative")
+	}
+	if s.DailyTokens != nil && *s.DailyTokens < 0 {
+		return fmt.Errorf("dailyTokens must not be negative")
+	}
+	return nil
+}
+
+// apply 使用设置覆盖应用的配置
+func (s *appSettings) apply(app config.LarkApp) config.LarkApp {
+	if s.SystemPrompt != nil {
+		app.SystemPrompt = *s.SystemPrompt
+	}
+	if s.DailyMessages != nil {
+		app.DailyMessages = *s.DailyMessages
+	}
+	if s.DailyTokens != nil {
+		app.DailyTokens = *s.DailyTokens
+	}
+	return app
+}
+
+// allowed 用户是否在白名单中，未设置白名单时允许全部用户
+func (s *appSettings) allowed(userId string) bool {
+	if s.AllowList == nil || len(*s.AllowList) == 0 {
+		return true
+	}
+	for _, id := range *s.AllowList {
+		if id == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// encode 返回以设置名称为 key 的 JSON 值，nil 字段不包含在内
+func (s *appSettings) encode() (map[string]string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("marshal app settings failed: %w", err)
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("unmarshal app settings failed: %w", err)
+	}
+	result := make(map[string]string, len(fields))
+	for name, value := range fields {
+		result[name] = string(value)
+	}
+	return result, nil
+}
+
+func decodeSettings(values map[string]string) (*appSettings, error) {
+	fields := make(map[string]json.RawMessage, len(values))
+	for name, value := range values {
+		fields[name] = json.RawMessage(value)
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("marshal app settings failed: %w", err)
+	}
+	s := &appSettings{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("unmarshal app settings failed: %w", err)
+	}
+	return s, nil
+}
+
+// settingsCache 缓存应用设置，过期后在处理消息时从数据库重新加载
+type settingsCache struct {
+	mu       sync.Mutex
+	settings *appSettings
+	loadedAt time.Time
+}
+
+func newSettingsCache() *settingsCache {
+	return &settingsCache{settings: &appSettings{}}
+}
+
+func (c *settingsCache) get() *appSettings {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.settings
+}
+
+func (c *settingsCache) set(s *appSettings) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.settings, c.loadedAt = s, time.Now()
+}
+
+func (c *settingsCache) stale() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Since(c.loadedAt) > settingsRefreshInterval
+}
+
+// loadAppSettings 从数据库加载应用设置
+func loadAppSettings(ctx context.Context, s *store.Store, app string) (*appSettings, error) {
+	values, err := s.ListAppSettings(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSettings(values)
+}
+
+// refreshSettings 缓存过期时重新加载应用设置，加载失败时继续使用缓存的设置
+func (h *callbackHandler) refreshSettings(ctx context.Context) {
+	if !h.app.settings.stale() {
+		return
+	}
+	s, err := loadAppSettings(ctx, h.store, h.app.name)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msgf("Load app settings error: %v", err)
+		return
+	}
+	h.app.settings.set(s)
+}
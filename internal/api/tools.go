@@ -0,0 +1,414 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fanchunke/chatgpt-lark/internal/audit"
+	"github.com/fanchunke/chatgpt-lark/internal/metrics"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/fanchunke/chatgpt-lark/internal/tools"
+	"github.com/fanchunke/chatgpt-lark/internal/tracing"
+	"github.com/rs/zerolog/log"
+	openai "github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	defaultToolRounds     = 3
+	defaultToolTimeout    = 10 * time.Second
+	defaultConfirmTimeout = 10 * time.Minute
+	// maxToolOutputLength 单次工具调用结果的最大字节数，与参考资料一样受 xgpt3 上下文长度的限制
+	maxToolOutputLength = 1000
+	// toolHistoryMessages、maxToolHistoryLength 判断是否调用工具时附加的最近会话消息数和最大字节数，
+	// 用于理解「他」「明天那个会」等指代之前消息的问题
+	toolHistoryMessages  = 6
+	maxToolHistoryLength = 2000
+
+	toolSystemPrompt = "你可以调用工具查询同事、日程和聊天记录，或者创建任务。只有需要实时信息或执行操作时才调用工具，否则直接回答。" +
+		"写操作会先请用户在卡片中确认，确认前不要告诉用户操作已经完成。"
+	toolResultPrompt = "以下是为回答用户的问题调用工具得到的结果，请根据这些结果回答，不要编造结果中没有的信息。"
+	// toolBlockedOutput 工具结果未通过内容审核时提供给 GPT 的结果
+	toolBlockedOutput = "调用结果未通过内容审核，请告诉用户无法提供该结果。"
+)
+
+var (
+	errPendingCallNotFound = errors.New("pending call not found or expired")
+	errNotRequester        = errors.New("only the requesting user can confirm")
+)
+
+// toolResult 一次工具调用的参数和结果
+type toolResult struct {
+	name      string
+	arguments string
+	output    string
+}
+
+// toolsEnabled 当前应用和回调版本是否开启工具调用
+func (h *callbackHandler) toolsEnabled() bool {
+	return h.toolClient != nil && h.app.tools != nil && h.cfg.Tools.Enabled && h.version == callbackVersionV2
+}
+
+// toolDefinitions 用户可以调用的工具
+func (h *callbackHandler) toolDefinitions(openId string) []tools.Definition {
+	defs := make([]tools.Definition, 0)
+	for _, name := range h.app.tools.Names() {
+		if !h.cfg.Tools.Allowed(name, openId) {
+			continue
+		}
+		t, _ := h.app.tools.Get(name)
+		defs = append(defs, t.Definition())
+	}
+	return defs
+}
+
+// callTools 获取回答前由 GPT 决定是否调用工具，返回全部工具调用的结果。
+// 未开启工具调用、GPT 没有调用工具或请求失败时返回 nil，不影响正常回答。
+func (h *callbackHandler) callTools(ctx context.Context, msg *message, sess *store.Session, content string) []*toolResult {
+	if !h.toolsEnabled() {
+		return nil
+	}
+	defs := h.toolDefinitions(msg.openId)
+	if len(defs) == 0 {
+		return nil
+	}
+	rounds := h.cfg.Tools.MaxRounds
+	if rounds <= 0 {
+		rounds = defaultToolRounds
+	}
+
+	messages := []tools.Message{{Role: openai.ChatMessageRoleSystem, Content: toolSystemPrompt}}
+	messages = append(messages, h.toolHistory(ctx, sess)...)
+	messages = append(messages, tools.Message{Role: openai.ChatMessageRoleUser, Content: content})
+	results := make([]*toolResult, 0)
+	for i := 0; i < rounds; i++ {
+		req := &tools.Request{
+			Model:      h.sessionModel(sess),
+			Messages:   messages,
+			Tools:      defs,
+			ToolChoice: "auto",
+			User:       sess.ConversationKey,
+		}
+		start := time.Now()
+		gptCtx, span := tracing.Start(ctx, "gpt.tools", attribute.String("gpt.model", req.Model))
+		resp, err := h.toolClient.CreateChatCompletion(gptCtx, req)
+		var usage openai.Usage
+		if resp != nil {
+			usage = resp.Usage
+		}
+		h.observeGPT(span, req.Model, start, usage, err)
+		if err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msgf("[AppId: %s] [UserId: %s] Call tools error: %v", msg.appId, msg.openId, err)
+			break
+		}
+		h.recordToolUsage(ctx, sess, resp.Model, resp.Usage)
+
+		reply := resp.Choices[0].Message
+		if len(reply.ToolCalls) == 0 {
+			break
+		}
+		messages = append(messages, reply)
+		for _, call := range reply.ToolCalls {
+			output := h.invokeTool(ctx, msg, call)
+			results = append(results, &toolResult{name: call.Function.Name, arguments: call.Function.Arguments, output: output})
+			messages = append(messages, tools.Message{Role: tools.RoleTool, Content: output, ToolCallId: call.Id})
+		}
+	}
+	return results
+}
+
+// toolHistory 返回会话最近的消息，从最近的消息开始保留不超过 maxToolHistoryLength 字节。
+// 会话历史中的消息已经过脱敏和审核，未开启多轮对话时没有历史消息
+func (h *callbackHandler) toolHistory(ctx context.Context, sess *store.Session) []tools.Message {
+	if !h.cfg.Conversation.EnableConversation {
+		return nil
+	}
+	msgs, err := h.store.RecentMessages(ctx, sess.ConversationKey, toolHistoryMessages)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msgf("[UserId: %s] Get recent messages error: %v", sess.UserID, err)
+		return nil
+	}
+	length := 0
+	start := len(msgs)
+	for start > 0 && length+len(msgs[start-1].Content) <= maxToolHistoryLength {
+		start--
+		length += len(msgs[start].Content)
+	}
+	history := make([]tools.Message, 0, len(msgs)-start)
+	for _, m := range msgs[start:] {
+		role := openai.ChatMessageRoleAssistant
+		if m.FromUserID == sess.ConversationKey {
+			role = openai.ChatMessageRoleUser
+		}
+		history = append(history, tools.Message{Role: role, Content: m.Content})
+	}
+	return history
+}
+
+// recordToolUsage 记录工具调用请求的用量，工具调用请求没有对应的回复消息
+func (h *callbackHandler) recordToolUsage(ctx context.Context, sess *store.Session, model string, usage openai.Usage) {
+	h.addQuotaTokens(ctx, sess.UserID, usage.TotalTokens)
+	err := h.store.CreateUsage(ctx, &store.Usage{
+		App:              sess.App,
+		ConversationKey:  sess.ConversationKey,
+		Model:            model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+	})
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("Record usage error: %v", err)
+	}
+}
+
+// invokeTool 执行 GPT 请求的工具调用，返回提供给 GPT 的结果。调用失败时结果为失败原因。
+// 结果和失败原因中可能包含同事的邮箱等信息，与用户消息一样脱敏和审核后才提供给 GPT。
+func (h *callbackHandler) invokeTool(ctx context.Context, msg *message, call tools.Call) string {
+	name := call.Function.Name
+	ctx, span := tracing.Start(ctx, "tool.call", attribute.String("tool.name", name))
+	output, result, err := h.runTool(ctx, msg, call)
+	tracing.End(span, err)
+	label := name
+	if _, ok := h.app.tools.Get(name); !ok {
+		// 工具名称来自 GPT 的回复，未注册的工具统一记为 unknown
+		label = "unknown"
+	}
+	metrics.ToolCalls.WithLabelValues(label, result).Inc()
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msgf("[AppId: %s] [UserId: %s] Tool %s %s: %v", msg.appId, msg.openId, name, result, err)
+		output = "调用失败：" + err.Error()
+	} else {
+		log.Ctx(ctx).Info().Msgf("[AppId: %s] [UserId: %s] Tool %s %s", msg.appId, msg.openId, name, result)
+	}
+
+	output, err = h.sanitizeToolOutput(ctx, msg.openId, output)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("[AppId: %s] [UserId: %s] Sanitize output of tool %s error: %v", msg.appId, msg.openId, name, err)
+		return "调用失败：无法处理调用结果"
+	}
+	// 先脱敏再截断，截断不会留下无法识别的半个敏感信息
+	return truncateBytes(output, maxToolOutputLength)
+}
+
+// sanitizeToolOutput 替换工具结果中的敏感信息并按 inputAction 审核，未通过审核时只告诉 GPT 结果被拦截
+func (h *callbackHandler) sanitizeToolOutput(ctx context.Context, openId, output string) (string, error) {
+	redacted, err := h.redactPII(ctx, openId, output)
+	if err != nil {
+		return "", fmt.Errorf("redact tool output failed: %w", err)
+	}
+	result, err := h.moderate(ctx, moderationStageTool, redacted)
+	if err != nil {
+		return "", fmt.Errorf("moderate tool output failed: %w", err)
+	}
+	if result.blocked {
+		return toolBlockedOutput, nil
+	}
+	return result.text, nil
+}
+
+// runTool 检查权限后执行工具，写操作发送确认卡片，用户确认后才执行。返回结果和用于统计的调用结果。
+func (h *callbackHandler) runTool(ctx context.Context, msg *message, call tools.Call) (string, string, error) {
+	t, ok := h.app.tools.Get(call.Function.Name)
+	if !ok {
+		return "", "unknown", fmt.Errorf("unknown tool %q", call.Function.Name)
+	}
+	if !h.cfg.Tools.Allowed(t.Name, msg.openId) {
+		return "", "forbidden", tools.Forbidden("tool is not enabled for the user")
+	}
+	// GPT 只能看到占位符，参数中的占位符在执行前还原为原始值
+	inv := &tools.Invocation{
+		AppId:     msg.appId,
+		UserId:    msg.openId,
+		ChatId:    msg.chatId,
+		ChatType:  msg.chatType,
+		Arguments: h.restoreArguments(ctx, msg.openId, call.Function.Arguments),
+	}
+	if err := h.authorizeTool(ctx, t, inv); err != nil {
+		return "", toolErrorResult(err), err
+	}
+
+	if t.Write {
+		desc, err := t.Describe(ctx, inv)
+		if err != nil {
+			return "", "error", err
+		}
+		id, err := h.app.pending.add(&pendingCall{tool: t, inv: inv, description: desc, expires: time.Now().Add(h.confirmTimeout())})
+		if err != nil {
+			return "", "error", err
+		}
+		if err := h.sendCard(ctx, msg.appId, msg.openId, h.toolConfirmCard(id, desc)); err != nil {
+			return "", "error", err
+		}
+		return fmt.Sprintf("已向用户发送确认卡片，用户确认后才会执行：\n%s\n请提示用户在卡片中确认，不要说操作已经完成。", desc), "pending", nil
+	}
+
+	output, err := h.executeTool(ctx, t, inv)
+	if err != nil {
+		return "", "error", err
+	}
+	return output, "success", nil
+}
+
+// authorizeTool 以发起请求的用户身份检查工具调用的权限
+func (h *callbackHandler) authorizeTool(ctx context.Context, t *tools.Tool, inv *tools.Invocation) error {
+	if t.Authorize == nil {
+		return nil
+	}
+	return t.Authorize(ctx, inv)
+}
+
+func (h *callbackHandler) executeTool(ctx context.Context, t *tools.Tool, inv *tools.Invocation) (string, error) {
+	timeout := h.cfg.Tools.Timeout
+	if timeout <= 0 {
+		timeout = defaultToolTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return t.Run(ctx, inv)
+}
+
+func (h *callbackHandler) confirmTimeout() time.Duration {
+	if h.cfg.Tools.ConfirmTimeout > 0 {
+		return h.cfg.Tools.ConfirmTimeout
+	}
+	return defaultConfirmTimeout
+}
+
+func toolErrorResult(err error) string {
+	if errors.Is(err, tools.ErrForbidden) {
+		return "forbidden"
+	}
+	return "error"
+}
+
+// toolMessage 生成附加在请求中的工具调用结果，不超过 budget 字节
+func toolMessage(results []*toolResult, budget int) string {
+	var b strings.Builder
+	b.WriteString(toolResultPrompt)
+	for _, r := range results {
+		header := fmt.Sprintf("\n\n[%s] %s\n", r.name, r.arguments)
+		remain := budget - b.Len() - len(header)
+		if remain < minKnowledgeLength/2 {
+			break
+		}
+		b.WriteString(header)
+		b.WriteString(truncateBytes(r.output, remain))
+	}
+	return b.String()
+}
+
+// pendingCall 等待用户确认的写操作
+type pendingCall struct {
+	tool        *tools.Tool
+	inv         *tools.Invocation
+	description string
+	expires     time.Time
+}
+
+// pendingCalls 应用中等待用户确认的写操作，保存在内存中，重启后需要重新发起
+type pendingCalls struct {
+	mu    sync.Mutex
+	calls map[string]*pendingCall
+}
+
+func newPendingCalls() *pendingCalls {
+	return &pendingCalls{calls: make(map[string]*pendingCall)}
+}
+
+// add 保存写操作，返回确认卡片中使用的 Id，同时清理已过期的操作
+func (p *pendingCalls) add(call *pendingCall) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate pending call id failed: %w", err)
+	}
+	id := hex.EncodeToString(buf)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for k, c := range p.calls {
+		if now.After(c.expires) {
+			delete(p.calls, k)
+		}
+	}
+	p.calls[id] = call
+	return id, nil
+}
+
+// take 取出发起人为 openId 的写操作，每个操作只能确认或取消一次
+func (p *pendingCalls) take(id, openId string) (*pendingCall, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	call, ok := p.calls[id]
+	if !ok || time.Now().After(call.expires) {
+		delete(p.calls, id)
+		return nil, errPendingCallNotFound
+	}
+	if call.inv.UserId != openId {
+		return nil, errNotRequester
+	}
+	delete(p.calls, id)
+	return call, nil
+}
+
+// toolCardAction 处理确认卡片的确认和取消，只有发起请求的用户可以操作。执行前重新检查权限，返回更新后的卡片。
+// 确认和取消都写入审计日志，messageId 为确认卡片的消息 Id。
+func (h *callbackHandler) toolCardAction(ctx context.Context, openId, messageId, kind, id string) (interface{}, error) {
+	call, err := h.app.pending.take(id, openId)
+	if errors.Is(err, errNotRequester) {
+		log.Ctx(ctx).Warn().Msgf("[UserId: %s] Card action %s of pending call %s rejected: %v", openId, kind, id, err)
+		return nil, nil
+	}
+	if err != nil {
+		return toolCard("操作已失效", "grey", "确认已过期或已处理，请重新发起。"), nil
+	}
+
+	name := call.tool.Name
+	if kind == cardActionCancelTool {
+		h.auditTool(ctx, call, messageId, kind, "", nil)
+		metrics.ToolCalls.WithLabelValues(name, "cancelled").Inc()
+		log.Ctx(ctx).Info().Msgf("[UserId: %s] Tool %s cancelled", openId, name)
+		return toolCard("已取消", "grey", call.description), nil
+	}
+
+	ctx, span := tracing.Start(ctx, "tool.call", attribute.String("tool.name", name))
+	output, err := h.confirmTool(ctx, call)
+	tracing.End(span, err)
+	h.auditTool(ctx, call, messageId, kind, output, err)
+	if err != nil {
+		metrics.ToolCalls.WithLabelValues(name, toolErrorResult(err)).Inc()
+		log.Ctx(ctx).Warn().Err(err).Msgf("[UserId: %s] Tool %s failed: %v", openId, name, err)
+		return toolCard("执行失败", "red", fmt.Sprintf("%s\n\n失败原因：%v", call.description, err)), nil
+	}
+	metrics.ToolCalls.WithLabelValues(name, "success").Inc()
+	log.Ctx(ctx).Info().Msgf("[UserId: %s] Tool %s confirmed: %s", openId, name, output)
+	return toolCard("已执行", "green", fmt.Sprintf("%s\n\n%s", call.description, output)), nil
+}
+
+// auditTool 将用户确认或取消的写操作写入审计日志，提问为操作、工具名称和参数，回答为执行结果
+func (h *callbackHandler) auditTool(ctx context.Context, call *pendingCall, messageId, kind, output string, toolErr error) {
+	h.audit(ctx, &audit.Record{
+		Action:    audit.ActionTool,
+		AppId:     call.inv.AppId,
+		UserId:    call.inv.UserId,
+		ChatId:    call.inv.ChatId,
+		MessageId: messageId,
+	}, fmt.Sprintf("%s %s %s", kind, call.tool.Name, call.inv.Arguments), output, toolErr)
+}
+
+// confirmTool 执行用户确认的写操作，配置和权限可能在确认前发生变化，因此重新检查
+func (h *callbackHandler) confirmTool(ctx context.Context, call *pendingCall) (string, error) {
+	if !h.toolsEnabled() || !h.cfg.Tools.Allowed(call.tool.Name, call.inv.UserId) {
+		return "", tools.Forbidden("tool is not enabled for the user")
+	}
+	if err := h.authorizeTool(ctx, call.tool, call.inv); err != nil {
+		return "", err
+	}
+	return h.executeTool(ctx, call.tool, call.inv)
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/audit"
+	"github.com/fanchunke/chatgpt-lark/internal/tools"
+)
+
+// memoryAuditSink 保存在内存中的审计记录
+type memoryAuditSink struct {
+	mu      sync.Mutex
+	records []*audit.Record
+}
+
+func (s *memoryAuditSink) Write(record []byte) error {
+	var r audit.Record
+	if err := json.Unmarshal(record, &r); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, &r)
+	return nil
+}
+
+func (s *memoryAuditSink) Close() error { return nil }
+
+func TestPendingCallsTake(t *testing.T) {
+	p := newPendingCalls()
+	add := func(expires time.Time) string {
+		t.Helper()
+		id, err := p.add(&pendingCall{inv: &tools.Invocation{UserId: "ou_1"}, expires: expires})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	id := add(time.Now().Add(time.Minute))
+	expired := add(time.Now().Add(-time.Second))
+
+	cases := []struct {
+		name   string
+		id     string
+		openId string
+		err    error
+	}{
+		// 其他用户不能操作，操作仍然保留
+		{name: "other user", id: id, openId: "ou_2", err: errNotRequester},
+		{name: "requester", id: id, openId: "ou_1"},
+		{name: "taken", id: id, openId: "ou_1", err: errPendingCallNotFound},
+		{name: "expired", id: expired, openId: "ou_1", err: errPendingCallNotFound},
+		{name: "unknown", id: "unknown", openId: "ou_1", err: errPendingCallNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			call, err := p.take(tc.id, tc.openId)
+			if err != tc.err {
+				t.Fatalf("err = %v, want %v", err, tc.err)
+			}
+			if (call != nil) != (tc.err == nil) {
+				t.Errorf("call = %+v, want call only without error", call)
+			}
+		})
+	}
+}
+
+func TestToolCardAction(t *testing.T) {
+	h, gptSrv := newTestHandler(t, callbackVersionV2, func(cfg *config.Config) {
+		cfg.Tools = config.Tools{Enabled: true}
+	})
+	sink := &memoryAuditSink{}
+	auditor, err := audit.New(sink, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.auditor = auditor
+	h.toolClient = tools.NewClient(gptSrv.ClientConfig().BaseURL, "test", nil)
+	runs := 0
+	tool := &tools.Tool{
+		Name:  "write",
+		Write: true,
+		Run: func(ctx context.Context, inv *tools.Invocation) (string, error) {
+			runs++
+			return "done", nil
+		},
+	}
+	h.app.tools.Register(tool)
+	add := func(expires time.Time) string {
+		t.Helper()
+		id, err := h.app.pending.add(&pendingCall{
+			tool:        tool,
+			inv:         &tools.Invocation{UserId: "ou_1", Arguments: json.RawMessage(`{"a":1}`)},
+			description: "写入",
+			expires:     expires,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	title := func(card interface{}) string {
+		if card == nil {
+			return ""
+		}
+		header := card.(map[string]interface{})["header"].(map[string]interface{})
+		return header["title"].(map[string]interface{})["content"].(string)
+	}
+
+	cancelled, confirmed, expired := add(time.Now().Add(time.Minute)), add(time.Now().Add(time.Minute)), add(time.Now().Add(-time.Second))
+	cases := []struct {
+		name   string
+		openId string
+		kind   string
+		id     string
+		title  string
+		runs   int
+	}{
+		{name: "cancel", openId: "ou_1", kind: cardActionCancelTool, id: cancelled, title: "已取消"},
+		{name: "other user", openId: "ou_2", kind: cardActionConfirmTool, id: confirmed},
+		{name: "confirm", openId: "ou_1", kind: cardActionConfirmTool, id: confirmed, title: "已执行", runs: 1},
+		{name: "confirm twice", openId: "ou_1", kind: cardActionConfirmTool, id: confirmed, title: "操作已失效", runs: 1},
+		{name: "expired", openId: "ou_1", kind: cardActionConfirmTool, id: expired, title: "操作已失效", runs: 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			card, err := h.toolCardAction(context.Background(), tc.openId, "om_card", tc.kind, tc.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := title(card); got != tc.title {
+				t.Errorf("card title = %q, want %q", got, tc.title)
+			}
+			if runs != tc.runs {
+				t.Errorf("runs = %d, want %d", runs, tc.runs)
+			}
+		})
+	}
+
+	// 取消和确认各写入一条审计记录
+	if len(sink.records) != 2 {
+		t.Fatalf("got %d audit records, want 2", len(sink.records))
+	}
+	for i, want := range []struct{ prompt, answer string }{
+		{prompt: cardActionCancelTool + ` write {"a":1}`},
+		{prompt: cardActionConfirmTool + ` write {"a":1}`, answer: "done"},
+	} {
+		r := sink.records[i]
+		if r.Action != audit.ActionTool || r.UserId != "ou_1" || r.MessageId != "om_card" || r.Prompt != want.prompt || r.Answer != want.answer {
+			t.Errorf("records[%d] = %+v, want prompt %q answer %q", i, r, want.prompt, want.answer)
+		}
+	}
+}
+
+func TestCallToolsHistory(t *testing.T) {
+	h, gptSrv := newTestHandler(t, callbackVersionV2, func(cfg *config.Config) {
+		cfg.Tools = config.Tools{Enabled: true}
+	})
+	h.toolClient = tools.NewClient(gptSrv.ClientConfig().BaseURL, "test", nil)
+	h.app.tools = tools.NewRegistry(tools.Builtin()...)
+	ctx := context.Background()
+	if _, err := ask(ctx, h, "ou_1", "我下周要见张三"); err != nil {
+		t.Fatal(err)
+	}
+	sess, err := h.store.ActiveSession(ctx, h.app.name, "ou_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gptSrv.Reset()
+
+	// 判断是否调用工具的请求包含会话历史，GPT 能够理解「他」指代的同事
+	if results := h.callTools(ctx, &message{openId: "ou_1"}, sess, "他明天有空吗"); len(results) != 0 {
+		t.Errorf("results = %+v, want no tool calls", results)
+	}
+	requests := gptSrv.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	roles := make([]string, 0)
+	for _, m := range requests[0].Messages {
+		roles = append(roles, m.Role)
+	}
+	want := "system,user,assistant,user"
+	if got := strings.Join(roles, ","); got != want {
+		t.Fatalf("roles = %s, want %s", got, want)
+	}
+	if m := requests[0].Messages; m[1].Content != "我下周要见张三" || m[3].Content != "他明天有空吗" {
+		t.Errorf("messages = %+v", m)
+	}
+}
+
+func TestToolPII(t *testing.T) {
+	h, _ := newTestHandler(t, callbackVersionV2, func(cfg *config.Config) {
+		cfg.PII = config.PII{Detectors: []string{"email"}, Key: "pii-key"}
+		cfg.Moderation = config.Moderation{Keywords: []string{"机密"}}
+	})
+	vault, err := newVault(h.app.name, h.cfg, h.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.app.vault = vault
+	ctx := context.Background()
+
+	// 工具结果中的邮箱替换为占位符，命中审核的结果不提供给 GPT
+	output, err := h.sanitizeToolOutput(ctx, "ou_1", "邮箱：alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output, "alice@example.com") || !strings.HasPrefix(output, "邮箱：[EMAIL_") {
+		t.Fatalf("output = %q, want email replaced by placeholder", output)
+	}
+	if blocked, err := h.sanitizeToolOutput(ctx, "ou_1", "机密文件"); err != nil || blocked != toolBlockedOutput {
+		t.Errorf("blocked output = %q, %v, want %q", blocked, err, toolBlockedOutput)
+	}
+
+	// GPT 在参数中使用占位符，执行前还原为原始值，其他用户的占位符不还原
+	placeholder := strings.TrimPrefix(output, "邮箱：")
+	args, _ := json.Marshal(map[string]interface{}{"emails": []string{placeholder}, "count": 12345678901234567})
+	cases := []struct {
+		openId string
+		want   string
+	}{
+		{openId: "ou_1", want: `{"count":12345678901234567,"emails":["alice@example.com"]}`},
+		{openId: "ou_2", want: string(args)},
+	}
+	for _, tc := range cases {
+		if got := string(h.restoreArguments(ctx, tc.openId, string(args))); got != tc.want {
+			t.Errorf("arguments of %s = %s, want %s", tc.openId, got, tc.want)
+		}
+	}
+}
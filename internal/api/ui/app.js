@@ -0,0 +1,262 @@
+'use strict';
+
+// 管理接口的地址相对于 /admin/ui/，部署在路径前缀下时同样可用
+const base = new URL('../', location.href);
+const pageSize = 20;
+const refreshInterval = 10000;
+
+let offset = 0;
+let timer = null;
+
+async function api(path, options = {}) {
+  const headers = Object.assign({ 'X-Admin-UI': '1' }, options.headers);
+  const resp = await fetch(new URL(path, base), Object.assign({ credentials: 'same-origin' }, options, { headers }));
+  if (resp.status === 401) {
+    showLogin();
+    throw new Error('unauthorized');
+  }
+  const body = await resp.json();
+  if (!resp.ok) {
+    throw new Error(body.msg || resp.statusText);
+  }
+  return body;
+}
+
+// el 创建元素，文本内容一律通过 textContent 写入，避免渲染用户消息中的 HTML
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [key, value] of Object.entries(attrs || {})) {
+    if (key.startsWith('on')) {
+      node.addEventListener(key.slice(2), value);
+    } else {
+      node.setAttribute(key, value);
+    }
+  }
+  for (const child of children) {
+    node.append(child instanceof Node ? child : document.createTextNode(child == null ? '' : String(child)));
+  }
+  return node;
+}
+
+function svg(tag, attrs) {
+  const node = document.createElementNS('http://www.w3.org/2000/svg', tag);
+  for (const [key, value] of Object.entries(attrs)) {
+    node.setAttribute(key, value);
+  }
+  return node;
+}
+
+function formatTime(s) {
+  const d = new Date(s);
+  const pad = (n) => String(n).padStart(2, '0');
+  return `${d.getMonth() + 1}-${pad(d.getDate())} ${pad(d.getHours())}:${pad(d.getMinutes())}:${pad(d.getSeconds())}`;
+}
+
+function formatNumber(n) {
+  return Number(n).toLocaleString('zh-CN');
+}
+
+function fillTable(id, rows, empty) {
+  const tbody = document.querySelector(`#${id} tbody`);
+  tbody.replaceChildren(...rows);
+  if (rows.length === 0) {
+    const cols = document.querySelectorAll(`#${id} thead th`).length;
+    tbody.append(el('tr', {}, el('td', { colspan: cols, class: 'muted' }, empty)));
+  }
+}
+
+// barChart 绘制柱状图，series 为 [{key, className}]，同一位置的多个系列叠加绘制
+function barChart(id, points, series, title) {
+  const chart = document.getElementById(id);
+  const width = 600;
+  const height = 120;
+  const max = Math.max(1, ...points.map((p) => p[series[0].key]));
+  const barWidth = width / Math.max(points.length, 1);
+  const bars = [];
+  points.forEach((p, i) => {
+    for (const s of series) {
+      const h = (p[s.key] / max) * (height - 4);
+      const rect = svg('rect', {
+        x: i * barWidth + 1,
+        y: height - h,
+        width: Math.max(barWidth - 2, 1),
+        height: h,
+        class: s.className,
+      });
+      rect.append(svg('title', {}));
+      rect.firstChild.textContent = title(p);
+      bars.push(rect);
+    }
+  });
+  chart.replaceChildren(...bars);
+}
+
+async function loadTraffic() {
+  const data = await api('traffic');
+  document.getElementById('in-flight').textContent = data.inFlight;
+  document.getElementById('queued').textContent = data.queued;
+  document.getElementById('messages-hour').textContent = formatNumber(data.points.reduce((n, p) => n + p.messages, 0));
+  document.getElementById('errors-hour').textContent = formatNumber(data.points.reduce((n, p) => n + p.errors, 0));
+  barChart('traffic-chart', data.points, [
+    { key: 'messages', className: 'messages' },
+    { key: 'errors', className: 'errors' },
+  ], (p) => `${formatTime(p.time)} 消息 ${p.messages}，失败 ${p.errors}`);
+}
+
+async function loadTokens() {
+  const from = new Date(Date.now() - 29 * 24 * 3600 * 1000);
+  const day = (d) => `${d.getFullYear()}-${String(d.getMonth() + 1).padStart(2, '0')}-${String(d.getDate()).padStart(2, '0')}`;
+  const data = await api(`usage?groupBy=day&from=${day(from)}`);
+  // 补齐没有用量的日期
+  const stats = new Map(data.stats.map((s) => [s.key, s]));
+  const points = [];
+  for (let i = 0; i < 30; i++) {
+    const key = day(new Date(from.getTime() + i * 24 * 3600 * 1000));
+    points.push(stats.get(key) || { key, totalTokens: 0, requests: 0 });
+  }
+  barChart('tokens-chart', points, [{ key: 'totalTokens', className: 'tokens' }],
+    (p) => `${p.key} ${formatNumber(p.totalTokens)} tokens，${p.requests} 次请求`);
+  document.getElementById('tokens-total').textContent =
+    `合计 ${formatNumber(data.total.totalTokens)} tokens，${formatNumber(data.total.requests)} 次请求`;
+}
+
+async function loadTopUsers() {
+  const data = await api('usage?groupBy=user');
+  fillTable('top-users', data.stats.slice(0, 10).map((s) => el('tr', { class: 'clickable', onclick: () => searchUser(s.key) },
+    el('td', {}, s.key),
+    el('td', {}, formatNumber(s.requests)),
+    el('td', {}, formatNumber(s.totalTokens)),
+  )), '暂无用量');
+}
+
+async function loadFeedbacks() {
+  const data = await api('feedback?rating=bad&limit=20');
+  fillTable('feedbacks', data.feedbacks.map((f) => el('tr', {},
+    el('td', {}, formatTime(f.createdAt)),
+    el('td', {}, f.userId),
+    el('td', {}, f.comment),
+    el('td', {}, f.conversationId ? el('button', { class: 'link', onclick: () => showConversation(f.conversationId) }, '查看') : ''),
+  )), '暂无差评');
+}
+
+async function loadErrors() {
+  const data = await api('errors');
+  fillTable('errors', data.errors.map((e) => el('tr', {},
+    el('td', {}, formatTime(e.time)),
+    el('td', {}, e.app || '默认应用'),
+    el('td', {}, e.userId),
+    el('td', {}, e.error),
+  )), '暂无错误');
+}
+
+async function loadConversations() {
+  const params = new URLSearchParams({ limit: pageSize, offset });
+  for (const [name, id] of [['userId', 'search-user'], ['q', 'search-q'], ['status', 'search-status']]) {
+    const value = document.getElementById(id).value.trim();
+    if (value) {
+      params.set(name, value);
+    }
+  }
+  const data = await api(`conversations?${params}`);
+  fillTable('conversations', data.conversations.map((c) => el('tr', { class: 'clickable', 'data-id': c.id, onclick: () => showConversation(c.id) },
+    el('td', {}, c.id + (c.open ? '' : '（已关闭）')),
+    el('td', {}, c.userId || c.conversationKey),
+    el('td', {}, c.session),
+    el('td', {}, c.messages),
+    el('td', {}, formatTime(c.createdAt)),
+  )), '没有满足条件的对话');
+  const pages = Math.max(1, Math.ceil(data.total / pageSize));
+  document.getElementById('page').textContent = `${offset / pageSize + 1} / ${pages}，共 ${data.total} 个对话`;
+  document.getElementById('prev').disabled = offset === 0;
+  document.getElementById('next').disabled = offset + pageSize >= data.total;
+}
+
+async function showConversation(id) {
+  const container = document.getElementById('messages');
+  for (const row of document.querySelectorAll('#conversations tbody tr')) {
+    row.classList.toggle('selected', row.dataset.id === String(id));
+  }
+  try {
+    const data = await api(`conversations/${id}/messages`);
+    const items = data.messages.map((m) => {
+      const reply = m.fromUserId !== data.conversationKey;
+      return el('div', { class: reply ? 'message reply' : 'message' },
+        el('span', { class: 'meta' }, `${reply ? '机器人' : m.fromUserId} · ${formatTime(m.createdAt)}`),
+        m.content);
+    });
+    container.replaceChildren(el('p', { class: 'muted' }, `对话 ${id}${data.open ? '' : '（已关闭）'}`), ...items);
+  } catch (err) {
+    container.replaceChildren(el('p', { class: 'error' }, err.message));
+  }
+}
+
+function searchUser(userId) {
+  document.getElementById('search-user').value = userId;
+  document.getElementById('search-q').value = '';
+  offset = 0;
+  loadConversations().catch(console.error);
+  document.getElementById('search-form').scrollIntoView({ behavior: 'smooth' });
+}
+
+function refresh() {
+  return Promise.all([loadTraffic(), loadErrors()]).catch(console.error);
+}
+
+function showLogin() {
+  clearInterval(timer);
+  timer = null;
+  document.getElementById('dashboard').hidden = true;
+  document.getElementById('logout').hidden = true;
+  document.getElementById('login').hidden = false;
+}
+
+async function showDashboard() {
+  document.getElementById('login').hidden = true;
+  document.getElementById('dashboard').hidden = false;
+  document.getElementById('logout').hidden = false;
+  await Promise.all([refresh(), loadTokens(), loadTopUsers(), loadFeedbacks(), loadConversations()]);
+  if (!timer) {
+    timer = setInterval(refresh, refreshInterval);
+  }
+}
+
+document.getElementById('login-form').addEventListener('submit', async (event) => {
+  event.preventDefault();
+  const error = document.getElementById('login-error');
+  error.textContent = '';
+  const resp = await fetch(new URL('ui/login', base), {
+    method: 'POST',
+    credentials: 'same-origin',
+    headers: { 'Content-Type': 'application/json', 'X-Admin-UI': '1' },
+    body: JSON.stringify({ token: document.getElementById('token').value }),
+  });
+  if (!resp.ok) {
+    error.textContent = resp.status === 401 ? 'token 错误' : `登录失败：${resp.statusText}`;
+    return;
+  }
+  document.getElementById('token').value = '';
+  showDashboard().catch(console.error);
+});
+
+document.getElementById('logout').addEventListener('click', async () => {
+  await fetch(new URL('ui/logout', base), { method: 'POST', credentials: 'same-origin', headers: { 'X-Admin-UI': '1' } });
+  showLogin();
+});
+
+document.getElementById('search-form').addEventListener('submit', (event) => {
+  event.preventDefault();
+  offset = 0;
+  loadConversations().catch(console.error);
+});
+
+document.getElementById('prev').addEventListener('click', () => {
+  offset = Math.max(0, offset - pageSize);
+  loadConversations().catch(console.error);
+});
+
+document.getElementById('next').addEventListener('click', () => {
+  offset += pageSize;
+  loadConversations().catch(console.error);
+});
+
+showDashboard().catch(console.error);
@@ -1,53 +1,149 @@
 package app
 
 import (
+	"context"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/fanchunke/xgpt3"
-	"github.com/fanchunke/xgpt3/conversation/ent"
-	"github.com/fanchunke/xgpt3/conversation/ent/chatent"
 
+	entsql "entgo.io/ent/dialect/sql"
 	config "github.com/fanchunke/chatgpt-lark/conf"
 	"github.com/fanchunke/chatgpt-lark/internal/api"
+	"github.com/fanchunke/chatgpt-lark/internal/audit"
+	"github.com/fanchunke/chatgpt-lark/internal/job"
+	"github.com/fanchunke/chatgpt-lark/internal/kb"
+	"github.com/fanchunke/chatgpt-lark/internal/replay"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/fanchunke/chatgpt-lark/internal/tools"
+	"github.com/fanchunke/chatgpt-lark/internal/tracing"
 	"github.com/fanchunke/chatgpt-lark/pkg/httpserver"
 
 	lark "github.com/larksuite/oapi-sdk-go/v3"
 	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 	openai "github.com/sashabaranov/go-openai"
 )
 
 func Run(cfg *config.Config) {
-	log.Info().Msgf("Config: %v", cfg)
+	log.Info().Msgf("Config: %s", cfg)
+
+	// 初始化 tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName:    cfg.App.Name,
+		ServiceVersion: cfg.App.Version,
+		Exporter:       cfg.Tracing.Exporter,
+		Endpoint:       cfg.Tracing.Endpoint,
+		Insecure:       cfg.Tracing.Insecure,
+		SampleRatio:    cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("tracing - setup failed")
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Error().Err(err).Msg("tracing - shutdown failed")
+		}
+	}()
+
+	// 初始化事件记录
+	var recorder *replay.Recorder
+	if rc := cfg.Record; rc.Enabled {
+		recorder, err = replay.NewFile(rc.Filename, rc.MaxSize, rc.MaxBackups, rc.MaxAge, replay.Options{RedactFields: rc.RedactFields, RedactPII: rc.RedactPII, Resources: rc.Resources})
+		if err != nil {
+			log.Fatal().Err(err).Msg("record - init failed")
+		}
+		defer recorder.Close()
+	}
 
 	// 初始化 gpt client
-	gptClient := openai.NewClient(cfg.GPT.ApiKey)
+	gptClient, toolClient, closeGPT, err := newGPTClient(cfg, recorder.Transport(replay.ServiceGPT, nil))
+	if err != nil {
+		log.Fatal().Err(err).Msg("gpt - init client failed")
+	}
+	defer closeGPT()
 
-	// 初始化 lark client
-	larkClient := lark.NewClient(
-		cfg.Lark.AppId,
-		cfg.Lark.AppSecret,
-		lark.WithOpenBaseUrl(cfg.Lark.BaseUrl),
-		lark.WithLogLevel(larkcore.LogLevelDebug),
-	)
+	// 初始化 lark client，每个飞书应用一个
+	larkClients := newLarkClients(cfg, recorder.Transport(replay.ServiceLark, nil))
 
 	// 初始化数据库 client
 	dbConf := cfg.Database
-	chatentClient, err := chatent.Open(dbConf.Driver, dbConf.DataSource)
+	drv, err := entsql.Open(dbConf.Driver, dbConf.DataSource)
 	if err != nil {
 		log.Fatal().Err(err).Msg("ent - open database failed")
 	}
-	if err := Migrate(cfg); err != nil {
-		log.Fatal().Err(err).Msg("ent - database migrate failed")
+	st := store.New(tracing.Driver(drv))
+	if err := checkSchema(context.Background(), cfg, drv); err != nil {
+		log.Fatal().Err(err).Msg("ent - database schema check failed, run `app migrate up` to apply migrations")
 	}
-	log.Info().Msg("数据库迁移成功")
+	log.Info().Msg("数据库版本检查成功")
 
 	// 初始化 xgpt3 client
-	xgpt3Client := xgpt3.NewClient(gptClient, ent.New(chatentClient))
+	xgpt3Client := xgpt3.NewClient(gptClient, st.ConversationHandler())
+
+	// 初始化知识库检索
+	embedder, err := kb.NewEmbedder(gptClient, cfg.RAG.EmbeddingModel)
+	if err != nil {
+		log.Fatal().Err(err).Msg("kb - init embedder failed")
+	}
+	kbStorage := newKBStorage(cfg, st)
+	retriever := kb.NewRetriever(kbStorage, embedder, 0)
+
+	// 启动后台任务
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	if conv := cfg.Conversation; conv.IdleTimeout > 0 && conv.IdleSweepInterval > 0 {
+		go job.NewIdleSweeper(st, xgpt3Client, conv.IdleTimeout, conv.IdleSweepInterval).Run(jobCtx)
+	}
+	if ttl := cfg.Conversation.DedupeTTL; ttl > 0 {
+		go job.NewReceivedCleaner(st, ttl).Run(jobCtx)
+	}
+	if cfg.PII.Key != "" {
+		go job.NewPIICleaner(st, time.Hour).Run(jobCtx)
+	}
+	if cfg.Admin.HMACSecret != "" {
+		go job.NewNonceCleaner(st, time.Hour).Run(jobCtx)
+	}
+	go job.NewQuotaCleaner(st, 24*time.Hour).Run(jobCtx)
+	if rc := cfg.Retention; rc.Days > 0 && rc.PurgeInterval > 0 {
+		go job.NewPurger(st, rc.MaxAge(), store.RetentionMode(rc.Mode), rc.AnonymizeSalt, rc.PurgeInterval).Run(jobCtx)
+	}
+	if len(cfg.RAG.Lark) > 0 && cfg.RAG.SyncInterval > 0 {
+		syncs, err := newKBSyncs(cfg, larkClients, kbStorage, embedder)
+		if err != nil {
+			log.Fatal().Err(err).Msg("kb - init lark sync failed")
+		}
+		go job.NewKBSyncer(syncs, cfg.RAG.SyncInterval).Run(jobCtx)
+	}
 
-	handler, err := api.NewRouter(cfg, xgpt3Client, larkClient)
+	// 监听配置文件，重新加载不需要重启的配置
+	reloader := config.NewReloader(cfg)
+	err = reloader.Watch(func(result *config.ReloadResult, err error) {
+		if err != nil {
+			log.Error().Err(err).Msg("config - reload failed, keep current config")
+			return
+		}
+		log.Info().Msgf("config - reloaded, changed: %v, restart required: %v", result.Changed, result.RestartRequired)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("config - watch config file failed, reload through the admin api instead")
+	}
+
+	// 初始化审计日志
+	var auditor *audit.Logger
+	if ac := cfg.Audit; ac.Enabled {
+		auditor, err = audit.New(audit.NewFileSink(ac.Filename, ac.MaxSize, ac.MaxBackups, ac.MaxAge), ac.StoreContent)
+		if err != nil {
+			log.Fatal().Err(err).Msg("audit - init failed")
+		}
+		defer auditor.Close()
+	}
+
+	handler, err := api.NewRouter(reloader, xgpt3Client, larkClients, st, auditor, recorder, retriever, toolClient)
 	if err != nil {
 		log.Fatal().Err(err).Msg("api - Router - api.Router failed")
 	}
@@ -55,6 +151,13 @@ func Run(cfg *config.Config) {
 	httpServer.Start()
 	log.Info().Msg("Server Started")
 
+	// 监控指标使用单独的端口
+	metricsServer := httpserver.New(promhttp.Handler(), httpserver.Port(cfg.HTTP.MetricsPort))
+	if cfg.HTTP.MetricsPort != "" {
+		metricsServer.Start()
+		log.Info().Msgf("Metrics server started on port %s", cfg.HTTP.MetricsPort)
+	}
+
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
 
@@ -63,6 +166,14 @@ func Run(cfg *config.Config) {
 		log.Info().Msgf("app - Run - signal: %s", s.String())
 	case err := <-httpServer.Notify():
 		log.Error().Err(err).Msg("app - Run - httpServer.Notify")
+	case err := <-metricsServer.Notify():
+		log.Error().Err(err).Msg("app - Run - metricsServer.Notify")
+	}
+
+	if cfg.HTTP.MetricsPort != "" {
+		if err := metricsServer.Shutdown(); err != nil {
+			log.Error().Err(err).Msg("app - Run - metricsServer.Shutdown")
+		}
 	}
 
 	err = httpServer.Shutdown()
@@ -71,3 +182,38 @@ func Run(cfg *config.Config) {
 	}
 
 }
+
+// newGPTClient 创建 OpenAI client 和调用工具使用的 client，开启 gpt.mock 时使用进程内的模拟服务，返回的函数用于关闭模拟服务
+func newGPTClient(cfg *config.Config, transport http.RoundTripper) (*openai.Client, *tools.Client, func(), error) {
+	httpClient := &http.Client{Transport: transport}
+	gptConfig := openai.DefaultConfig(cfg.GPT.ApiKey)
+	gptConfig.HTTPClient = httpClient
+	if cfg.GPT.BaseUrl != "" {
+		gptConfig.BaseURL = cfg.GPT.BaseUrl
+	}
+	closeGPT := func() {}
+	if cfg.GPT.Mock {
+		baseURL, closeMock, err := startMockGPT()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		log.Warn().Msgf("gpt - using mock OpenAI server at %s", baseURL)
+		gptConfig.BaseURL, closeGPT = baseURL, closeMock
+	}
+	return openai.NewClientWithConfig(gptConfig), tools.NewClient(gptConfig.BaseURL, cfg.GPT.ApiKey, httpClient), closeGPT, nil
+}
+
+// newLarkClients 为每个飞书应用创建 lark client，key 为应用名称
+func newLarkClients(cfg *config.Config, transport http.RoundTripper) map[string]*lark.Client {
+	clients := make(map[string]*lark.Client)
+	for _, app := range cfg.LarkApps() {
+		clients[app.Name] = lark.NewClient(
+			app.AppId,
+			app.AppSecret,
+			lark.WithOpenBaseUrl(app.BaseUrl),
+			lark.WithLogLevel(larkcore.LogLevelDebug),
+			lark.WithHttpClient(&http.Client{Transport: transport}),
+		)
+	}
+	return clients
+}
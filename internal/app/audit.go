@@ -0,0 +1,18 @@
+package app
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fanchunke/chatgpt-lark/internal/audit"
+)
+
+// VerifyAudit 校验审计日志文件的哈希链，返回校验通过的记录数
+func VerifyAudit(filename string) (int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, fmt.Errorf("open audit log failed: %w", err)
+	}
+	defer f.Close()
+	return audit.Verify(f)
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	entsql "entgo.io/ent/dialect/sql"
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/api"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/fanchunke/xgpt3"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"github.com/rs/zerolog/log"
+)
+
+// Chat 在终端中与机器人对话，使用与飞书回调相同的处理流程。每行输入为一条消息，输入 EOF 时退出。
+func Chat(cfg *config.Config, opts api.ChatOptions, in io.Reader, out io.Writer) error {
+	gptClient, toolClient, closeGPT, err := newGPTClient(cfg, nil)
+	if err != nil {
+		return err
+	}
+	defer closeGPT()
+
+	dbConf := cfg.Database
+	drv, err := entsql.Open(dbConf.Driver, dbConf.DataSource)
+	if err != nil {
+		return fmt.Errorf("open database failed: %w", err)
+	}
+	defer drv.Close()
+	ctx := log.Logger.WithContext(context.Background())
+	if err := checkSchema(ctx, cfg, drv); err != nil {
+		return fmt.Errorf("database schema check failed, run `app migrate up` to apply migrations: %w", err)
+	}
+	st := store.New(drv)
+	xgpt3Client := xgpt3.NewClient(gptClient, st.ConversationHandler())
+
+	// 监听配置文件，调试时修改系统提示词等配置后立即生效
+	reloader := config.NewReloader(cfg)
+	reloader.Watch(func(result *config.ReloadResult, err error) {
+		if err != nil {
+			log.Error().Err(err).Msg("config - reload failed, keep current config")
+			return
+		}
+		log.Info().Msgf("config - reloaded, changed: %v, restart required: %v", result.Changed, result.RestartRequired)
+	})
+
+	retriever, err := newRetriever(cfg, gptClient, st)
+	if err != nil {
+		return err
+	}
+
+	// 本地调试不写审计日志
+	chat, err := api.NewChat(reloader, xgpt3Client, st, nil, retriever, toolClient, &terminalChannel{w: out, dir: filepath.Join(os.TempDir(), "chatgpt-lark-chat")}, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "用户 %s 已连接，输入 /help 查看命令，Ctrl-D 退出\n", opts.UserId)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := chat.Send(ctx, line); err != nil {
+			fmt.Fprintf(out, "[错误] %v\n", err)
+		}
+	}
+	fmt.Fprintln(out)
+	return scanner.Err()
+}
+
+// terminalChannel 将回复输出到终端，文件保存到本地目录
+type terminalChannel struct {
+	w   io.Writer
+	dir string
+}
+
+func (c *terminalChannel) SendMessage(ctx context.Context, userId, msgType, content string) error {
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &body); err != nil {
+		return fmt.Errorf("unmarshal message content failed: %w", err)
+	}
+	switch msgType {
+	case larkim.MsgTypeText:
+		fmt.Fprintln(c.w, body["text"])
+	case larkim.MsgTypeFile:
+		fmt.Fprintf(c.w, "[文件] %s\n", body["file_key"])
+	case larkim.MsgTypeInteractive:
+		fmt.Fprint(c.w, renderCard(body))
+	default:
+		fmt.Fprintf(c.w, "[%s] %s\n", msgType, content)
+	}
+	return nil
+}
+
+// UploadFile 将文件保存到本地目录，返回文件路径作为 file_key
+func (c *terminalChannel) UploadFile(ctx context.Context, fileName string, file io.Reader) (string, error) {
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return "", fmt.Errorf("create directory failed: %w", err)
+	}
+	path := filepath.Join(c.dir, filepath.Base(fileName))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("create file failed: %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, file); err != nil {
+		return "", fmt.Errorf("write file failed: %w", err)
+	}
+	return path, nil
+}
+
+// renderCard 将消息卡片渲染为文本，按钮显示为 [按钮名称]
+func renderCard(card map[string]interface{}) string {
+	var b strings.Builder
+	if header, ok := card["header"].(map[string]interface{}); ok {
+		fmt.Fprintf(&b, "== %s ==\n", cardText(header["title"]))
+	}
+	elements, _ := card["elements"].([]interface{})
+	for _, e := range elements {
+		el, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch el["tag"] {
+		case "div":
+			fmt.Fprintln(&b, cardText(el["text"]))
+		case "hr":
+			fmt.Fprintln(&b, "----")
+		case "action", "note":
+			items, _ := el["actions"].([]interface{})
+			if el["tag"] == "note" {
+				items, _ = el["elements"].([]interface{})
+			}
+			texts := make([]string, 0, len(items))
+			for _, item := range items {
+				if m, ok := item.(map[string]interface{}); ok {
+					if el["tag"] == "action" {
+						texts = append(texts, "["+cardText(m["text"])+"]")
+					} else {
+						texts = append(texts, cardText(m))
+					}
+				}
+			}
+			fmt.Fprintln(&b, strings.Join(texts, " "))
+		}
+	}
+	return b.String()
+}
+
+func cardText(v interface{}) string {
+	if m, ok := v.(map[string]interface{}); ok {
+		if s, ok := m["content"].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,191 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	entsql "entgo.io/ent/dialect/sql"
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/job"
+	"github.com/fanchunke/chatgpt-lark/internal/kb"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	lark "github.com/larksuite/oapi-sdk-go/v3"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// newKBStorage 按 rag.storage 创建知识库的存储
+func newKBStorage(cfg *config.Config, st *store.Store) kb.Storage {
+	if cfg.RAG.Storage == "file" {
+		return kb.NewFileStorage(cfg.RAG.IndexDir)
+	}
+	return kb.NewDBStorage(st)
+}
+
+// newRetriever 创建知识库检索，是否使用知识库由每个会话的配置决定
+func newRetriever(cfg *config.Config, gptClient *openai.Client, st *store.Store) (*kb.Retriever, error) {
+	embedder, err := kb.NewEmbedder(gptClient, cfg.RAG.EmbeddingModel)
+	if err != nil {
+		return nil, err
+	}
+	return kb.NewRetriever(newKBStorage(cfg, st), embedder, 0), nil
+}
+
+// newKBSyncs 按知识库合并 [[rag.lark]] 的配置，使用对应飞书应用的 lark client 读取文档
+func newKBSyncs(cfg *config.Config, larkClients map[string]*lark.Client, storage kb.Storage, embedder *kb.Embedder) ([]job.KBSync, error) {
+	split := kb.SplitOptions{Size: cfg.RAG.ChunkSize, Overlap: cfg.RAG.ChunkOverlap}
+	syncs := make([]job.KBSync, 0)
+	index := make(map[string]int)
+	for _, l := range cfg.RAG.Lark {
+		source := kb.LarkSource{SpaceIds: l.SpaceIds, Folders: l.Folders, Documents: l.Documents, Domain: l.Domain}
+		if i, ok := index[l.KnowledgeBase]; ok {
+			syncs[i].Sources = append(syncs[i].Sources, source)
+			continue
+		}
+		client, ok := larkClients[l.App]
+		if !ok {
+			return nil, fmt.Errorf("lark client of app %q not found", l.App)
+		}
+		index[l.KnowledgeBase] = len(syncs)
+		syncs = append(syncs, job.KBSync{
+			KB:      l.KnowledgeBase,
+			Syncer:  kb.NewLarkSyncer(client, storage, embedder, split),
+			Sources: []kb.LarkSource{source},
+		})
+	}
+	return syncs, nil
+}
+
+// KB 执行知识库命令。
+//
+//	ingest [-prune] <name> <path>...   导入文件或目录中的 markdown、HTML 和文本文件，-prune 删除本次没有导入的文档
+//	list                               查看全部知识库的文档数和片段数
+//	delete <name> [source]             删除知识库或知识库中的一个文档
+//	search <name> <query>              检索知识库，用于调试 topK 和 minScore
+//	sync                               同步 [[rag.lark]] 配置的飞书文档
+func KB(cfg *config.Config, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing kb command, supported commands: ingest, list, delete, search, sync")
+	}
+
+	dbConf := cfg.Database
+	drv, err := entsql.Open(dbConf.Driver, dbConf.DataSource)
+	if err != nil {
+		return fmt.Errorf("open database failed: %w", err)
+	}
+	defer drv.Close()
+	ctx := context.Background()
+	st := store.New(drv)
+	if cfg.RAG.Storage != "file" {
+		if err := checkSchema(ctx, cfg, drv); err != nil {
+			return fmt.Errorf("database schema check failed, run `app migrate up` to apply migrations: %w", err)
+		}
+	}
+	storage := newKBStorage(cfg, st)
+
+	switch args[0] {
+	case "ingest":
+		fs := flag.NewFlagSet("kb ingest", flag.ContinueOnError)
+		fs.SetOutput(w)
+		prune := fs.Bool("prune", false, "删除知识库中本次没有导入的文档")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() < 2 {
+			return fmt.Errorf("usage: kb ingest [-prune] <name> <path>...")
+		}
+		gptClient, _, closeGPT, err := newGPTClient(cfg, nil)
+		if err != nil {
+			return err
+		}
+		defer closeGPT()
+		embedder, err := kb.NewEmbedder(gptClient, cfg.RAG.EmbeddingModel)
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		result, err := kb.Ingest(ctx, storage, embedder, fs.Arg(0), fs.Args()[1:], kb.IngestOptions{
+			Split: kb.SplitOptions{Size: cfg.RAG.ChunkSize, Overlap: cfg.RAG.ChunkOverlap},
+			Prune: *prune,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "ingested %d files (%d chunks), skipped %d unchanged files, removed %d files in %s\n",
+			result.Files, result.Chunks, result.Skipped, result.Removed, time.Since(start).Round(time.Millisecond))
+		return nil
+	case "list":
+		stats, err := storage.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range stats {
+			fmt.Fprintf(w, "%-32s %6d sources %8d chunks\n", s.KB, s.Sources, s.Chunks)
+		}
+		return nil
+	case "delete":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("usage: kb delete <name> [source]")
+		}
+		if len(args) == 3 {
+			return storage.DeleteSource(ctx, args[1], args[2])
+		}
+		return storage.Delete(ctx, args[1])
+	case "search":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: kb search <name> <query>")
+		}
+		gptClient, _, closeGPT, err := newGPTClient(cfg, nil)
+		if err != nil {
+			return err
+		}
+		defer closeGPT()
+		retriever, err := newRetriever(cfg, gptClient, st)
+		if err != nil {
+			return err
+		}
+		topK := cfg.RAG.TopK
+		if topK <= 0 {
+			topK = 3
+		}
+		results, err := retriever.Search(ctx, args[1], strings.Join(args[2:], " "), topK, cfg.RAG.MinScore)
+		if err != nil {
+			return err
+		}
+		for i, r := range results {
+			fmt.Fprintf(w, "[%d] %.4f %s#%d %s\n%s\n\n", i+1, r.Score, r.Source, r.Index, r.Title, r.Content)
+		}
+		return nil
+	case "sync":
+		if len(cfg.RAG.Lark) == 0 {
+			return fmt.Errorf("rag.lark is not configured")
+		}
+		gptClient, _, closeGPT, err := newGPTClient(cfg, nil)
+		if err != nil {
+			return err
+		}
+		defer closeGPT()
+		embedder, err := kb.NewEmbedder(gptClient, cfg.RAG.EmbeddingModel)
+		if err != nil {
+			return err
+		}
+		syncs, err := newKBSyncs(cfg, newLarkClients(cfg, nil), storage, embedder)
+		if err != nil {
+			return err
+		}
+		for _, s := range syncs {
+			result, err := s.Syncer.Sync(ctx, s.KB, s.Sources)
+			if err != nil {
+				return fmt.Errorf("sync %s failed: %w", s.KB, err)
+			}
+			fmt.Fprintf(w, "%s: updated %d documents (%d chunks), skipped %d unchanged, removed %d, failed %d\n",
+				s.KB, result.Files, result.Chunks, result.Skipped, result.Removed, result.Failed)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported kb command %q, supported commands: ingest, list, delete, search, sync", args[0])
+	}
+}
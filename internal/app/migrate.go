@@ -2,21 +2,110 @@ package app
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"strconv"
 
+	"entgo.io/ent/dialect"
+	entsql "entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/schema"
 	config "github.com/fanchunke/chatgpt-lark/conf"
-	"github.com/fanchunke/xgpt3/conversation/ent/chatent"
+	"github.com/fanchunke/chatgpt-lark/internal/migrate"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	chatentmigrate "github.com/fanchunke/xgpt3/conversation/ent/chatent/migrate"
 )
 
-func Migrate(cfg *config.Config) error {
+// Migrate 执行数据库迁移命令。
+//
+//	status      查看迁移的执行状态
+//	up [n]      执行未执行的迁移，默认全部执行
+//	down [n]    回滚已执行的迁移，默认回滚一个版本
+//	diff        输出 ent schema 与当前数据库的差异 SQL，用于编写新的迁移文件
+func Migrate(cfg *config.Config, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing migrate command, supported commands: status, up, down, diff")
+	}
+	steps := 0
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid migrate steps: %s", args[1])
+		}
+		steps = n
+	}
+
 	dbConf := cfg.Database
-	client, err := chatent.Open(dbConf.Driver, dbConf.DataSource)
+	drv, err := entsql.Open(dbConf.Driver, dbConf.DataSource)
 	if err != nil {
 		return err
 	}
-	defer client.Close()
+	defer drv.Close()
+
+	ctx := context.Background()
+	if args[0] == "diff" {
+		return migrateDiff(ctx, drv, w)
+	}
 
-	if err := client.Schema.Create(context.Background()); err != nil {
+	m, err := migrate.New(drv.DB(), drv.Dialect())
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		version, err := m.Version(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "database version: %d, latest version: %d\n", version, m.Latest())
+		for _, s := range status {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied at " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%06d  %-40s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	case "up":
+		done, err := m.Up(ctx, steps)
+		for _, mg := range done {
+			fmt.Fprintf(w, "applied %06d_%s\n", mg.Version, mg.Name)
+		}
 		return err
+	case "down":
+		done, err := m.Down(ctx, steps)
+		for _, mg := range done {
+			fmt.Fprintf(w, "reverted %06d_%s\n", mg.Version, mg.Name)
+		}
+		return err
+	}
+	return fmt.Errorf("unsupported migrate command: %s", args[0])
+}
+
+// migrateDiff 输出 ent schema 与当前数据库的差异，只输出 SQL，不修改数据库
+func migrateDiff(ctx context.Context, drv dialect.Driver, w io.Writer) error {
+	tables := append([]*schema.Table{}, chatentmigrate.Tables...)
+	tables = append(tables, store.Tables...)
+	m, err := schema.NewMigrate(&schema.WriteDriver{Writer: w, Driver: drv})
+	if err != nil {
+		return fmt.Errorf("ent - NewMigrate failed: %w", err)
+	}
+	return m.Create(ctx, tables...)
+}
+
+// checkSchema 检查数据库版本。开启 autoMigrate 时先执行未执行的迁移；数据库版本高于程序支持的版本时拒绝启动。
+func checkSchema(ctx context.Context, cfg *config.Config, drv *entsql.Driver) error {
+	m, err := migrate.New(drv.DB(), drv.Dialect())
+	if err != nil {
+		return err
+	}
+	if cfg.Database.AutoMigrate {
+		if _, err := m.Up(ctx, 0); err != nil {
+			return err
+		}
 	}
-	return nil
+	return m.Check(ctx)
 }
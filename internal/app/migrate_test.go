@@ -0,0 +1,80 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	entsql "entgo.io/ent/dialect/sql"
+	"github.com/fanchunke/chatgpt-lark/internal/migrate"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TestMigrations 在空数据库上执行全部迁移，检查 ent schema 与迁移后的表结构一致，再回滚全部迁移。
+// sqlite3 使用内存数据库；postgres 和 mysql 通过 TEST_POSTGRES_DSN、TEST_MYSQL_DSN 指定空数据库，未设置时跳过。
+func TestMigrations(t *testing.T) {
+	cases := []struct {
+		driver string
+		dsn    string
+	}{
+		{driver: "sqlite3", dsn: fmt.Sprintf("file:migrate-%d?mode=memory&cache=shared&_fk=1", time.Now().UnixNano())},
+		{driver: "postgres", dsn: os.Getenv("TEST_POSTGRES_DSN")},
+		{driver: "mysql", dsn: os.Getenv("TEST_MYSQL_DSN")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.driver, func(t *testing.T) {
+			if tc.dsn == "" {
+				t.Skipf("TEST_%s_DSN is not set", strings.ToUpper(tc.driver))
+			}
+			testMigrations(t, tc.driver, tc.dsn)
+		})
+	}
+}
+
+func testMigrations(t *testing.T, driver, dsn string) {
+	ctx := context.Background()
+	drv, err := entsql.Open(driver, dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer drv.Close()
+	m, err := migrate.New(drv.DB(), drv.Dialect())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := m.Up(ctx, 0)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if len(done) != m.Latest() {
+		t.Fatalf("applied %d migrations, want %d; the database is not empty", len(done), m.Latest())
+	}
+	if err := m.Check(ctx); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+
+	var diff bytes.Buffer
+	if err := migrateDiff(ctx, drv, &diff); err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	for _, line := range strings.Split(diff.String(), "\n") {
+		switch upper := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(upper, "CREATE"), strings.HasPrefix(upper, "ALTER"), strings.HasPrefix(upper, "DROP"):
+			t.Errorf("ent schema differs from migrations: %s", line)
+		}
+	}
+
+	if _, err := m.Down(ctx, m.Latest()); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	if version, err := m.Version(ctx); err != nil || version != 0 {
+		t.Fatalf("version after down = %d, %v", version, err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build mockllm
+
+package app
+
+import "github.com/fanchunke/chatgpt-lark/internal/openaitest"
+
+// startMockGPT 启动进程内的模拟 OpenAI 服务，返回接口地址和关闭函数
+func startMockGPT() (string, func(), error) {
+	mock := openaitest.NewServer()
+	return mock.ClientConfig().BaseURL, mock.Close, nil
+}
@@ -0,0 +1,10 @@
+//go:build !mockllm
+
+package app
+
+import "errors"
+
+// startMockGPT 未使用 -tags mockllm 编译时不提供模拟服务，生产环境无法通过配置或环境变量切换到模拟服务
+func startMockGPT() (string, func(), error) {
+	return "", nil, errors.New("gpt.mock is only available in builds with -tags mockllm")
+}
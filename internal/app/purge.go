@@ -0,0 +1,29 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	entsql "entgo.io/ent/dialect/sql"
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/job"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+)
+
+// Purge 按数据保留策略清理一次过期数据
+func Purge(cfg *config.Config) (store.PurgeResult, error) {
+	rc := cfg.Retention
+	if rc.Days <= 0 {
+		return store.PurgeResult{}, fmt.Errorf("retention.days is not configured")
+	}
+
+	dbConf := cfg.Database
+	drv, err := entsql.Open(dbConf.Driver, dbConf.DataSource)
+	if err != nil {
+		return store.PurgeResult{}, err
+	}
+	defer drv.Close()
+
+	purger := job.NewPurger(store.New(drv), rc.MaxAge(), store.RetentionMode(rc.Mode), rc.AnonymizeSalt, rc.PurgeInterval)
+	return purger.Purge(context.Background())
+}
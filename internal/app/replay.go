@@ -0,0 +1,290 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"time"
+
+	entsql "entgo.io/ent/dialect/sql"
+	config "github.com/fanchunke/chatgpt-lark/conf"
+	"github.com/fanchunke/chatgpt-lark/internal/api"
+	"github.com/fanchunke/chatgpt-lark/internal/larktest"
+	"github.com/fanchunke/chatgpt-lark/internal/migrate"
+	"github.com/fanchunke/chatgpt-lark/internal/openaitest"
+	"github.com/fanchunke/chatgpt-lark/internal/replay"
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/fanchunke/xgpt3"
+	lark "github.com/larksuite/oapi-sdk-go/v3"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ReplayOptions 重放使用的服务和对比方式
+type ReplayOptions struct {
+	// RealLLM 使用配置中的 OpenAI 接口，默认使用模拟服务并按顺序返回记录中的 GPT 响应
+	RealLLM bool
+	// RealLark 使用配置中的飞书开放平台，回复会发送给真实用户，默认使用模拟服务
+	RealLark bool
+	// Ignore 对比时忽略的 JSON 字段
+	Ignore []string
+	// Timeout 每个事件的处理超时时间
+	Timeout time.Duration
+}
+
+// Replay 在当前代码上按顺序重放记录文件中的飞书事件，对比处理过程中发出的接口请求，返回存在差异的事件数。
+// 重放使用内存中的 sqlite 数据库，对话历史只包含记录文件中的事件。
+func Replay(cfg *config.Config, filename string, opts ReplayOptions, out io.Writer) (int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, fmt.Errorf("open record file failed: %w", err)
+	}
+	entries, err := replay.Read(f)
+	f.Close()
+	if err != nil {
+		return 0, fmt.Errorf("read record file failed: %w", err)
+	}
+	exchanges := replay.Exchanges(entries)
+	if len(exchanges) == 0 {
+		return 0, fmt.Errorf("no events found in %s", filename)
+	}
+
+	// 记录重放过程中的接口调用，使用与线上相同的脱敏方式
+	collector := &collector{}
+	recorder, err := replay.New(collector, replay.Options{RedactFields: cfg.Record.RedactFields, RedactPII: cfg.Record.RedactPII})
+	if err != nil {
+		return 0, err
+	}
+
+	// 模拟的飞书开放平台，同时用于发送签名和加密的事件
+	fakes := make(map[string]*larktest.Server)
+	for _, app := range cfg.LarkApps() {
+		fake := larktest.NewServer(larktest.App{
+			AppID:             app.AppId,
+			AppSecret:         app.AppSecret,
+			VerificationToken: app.VerificationToken,
+			EventEncryptKey:   app.EventEncryptKey,
+		})
+		defer fake.Close()
+		fakes[app.Name] = fake
+	}
+	larkTransport := recorder.Transport(replay.ServiceLark, nil)
+	larkClients := newLarkClients(cfg, larkTransport)
+	if !opts.RealLark {
+		for _, app := range cfg.LarkApps() {
+			larkClients[app.Name] = lark.NewClient(app.AppId, app.AppSecret,
+				lark.WithOpenBaseUrl(fakes[app.Name].URL),
+				lark.WithHttpClient(&http.Client{Transport: larkTransport}),
+			)
+		}
+	}
+
+	gptTransport := recorder.Transport(replay.ServiceGPT, nil)
+	var (
+		gptClient *openai.Client
+		fakeGPT   *openaitest.Server
+	)
+	if opts.RealLLM {
+		var closeGPT func()
+		gptClient, _, closeGPT, err = newGPTClient(cfg, gptTransport)
+		if err != nil {
+			return 0, err
+		}
+		defer closeGPT()
+	} else {
+		fakeGPT = openaitest.NewServer()
+		defer fakeGPT.Close()
+		fakeGPT.SetResponder(func(req *openaitest.Request) openaitest.Response {
+			return openaitest.Response{Status: http.StatusInternalServerError, Error: "no recorded response"}
+		})
+		gptConfig := fakeGPT.ClientConfig()
+		gptConfig.HTTPClient = &http.Client{Transport: gptTransport}
+		gptClient = openai.NewClientWithConfig(gptConfig)
+	}
+
+	// 内存数据库
+	drv, err := entsql.Open("sqlite3", fmt.Sprintf("file:replay-%d?mode=memory&cache=shared&_fk=1", time.Now().UnixNano()))
+	if err != nil {
+		return 0, fmt.Errorf("open database failed: %w", err)
+	}
+	defer drv.Close()
+	m, err := migrate.New(drv.DB(), drv.Dialect())
+	if err != nil {
+		return 0, err
+	}
+	if _, err := m.Up(context.Background(), 0); err != nil {
+		return 0, fmt.Errorf("migrate database failed: %w", err)
+	}
+	st := store.New(drv)
+
+	// 内存数据库中没有知识库，重放时不检索知识库；工具可能执行写操作，重放时不调用工具
+	handler, err := api.NewRouter(config.NewReloader(cfg), xgpt3.NewClient(gptClient, st.ConversationHandler()), larkClients, st, nil, recorder, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	differs := 0
+	for i, old := range exchanges {
+		ev := old.Event
+		fmt.Fprintf(out, "[%d/%d] message %s (app %q, %s): ", i+1, len(exchanges), ev.MessageId, ev.App, ev.Version)
+		if fakeGPT != nil {
+			fakeGPT.Reset()
+			fakeGPT.Enqueue(recordedResponses(old)...)
+		}
+		if fake := fakes[ev.App]; fake != nil {
+			addRecordedResources(fake, old)
+		}
+		got, err := replayEvent(srv.URL, fakes, collector, cfg, old, opts.Timeout)
+		if err != nil {
+			differs++
+			fmt.Fprintf(out, "failed: %v\n", err)
+			continue
+		}
+		diff := replay.Diff(old, got, opts.Ignore)
+		if len(diff) == 0 {
+			fmt.Fprintln(out, "ok")
+			continue
+		}
+		differs++
+		fmt.Fprintln(out, "differs")
+		for _, line := range diff {
+			fmt.Fprintln(out, "  "+line)
+		}
+	}
+	return differs, nil
+}
+
+// replayEvent 将记录的事件发送到回调地址，等待处理完成后返回重放中的接口调用
+func replayEvent(baseUrl string, fakes map[string]*larktest.Server, c *collector, cfg *config.Config, old *replay.Exchange, timeout time.Duration) (*replay.Exchange, error) {
+	ev := old.Event
+	app, ok := cfg.LarkApp(ev.App)
+	fake := fakes[ev.App]
+	if !ok || fake == nil {
+		return nil, fmt.Errorf("lark app %q not found in config", ev.App)
+	}
+	path := "/lark/apps/" + ev.App + "/receive"
+	if ev.App == config.DefaultAppName {
+		path = "/lark/receive"
+		if ev.Version == "v2" {
+			path = "/lark/receive/v2"
+		}
+	}
+
+	// 记录中的 token 已脱敏，使用配置中的 token
+	var body map[string]interface{}
+	if err := json.Unmarshal(ev.Request, &body); err != nil {
+		return nil, fmt.Errorf("unmarshal event failed: %w", err)
+	}
+	if header, ok := body["header"].(map[string]interface{}); ok {
+		header["token"] = app.VerificationToken
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal event failed: %w", err)
+	}
+	resp, err := fake.SendRawEvent(baseUrl+path, raw)
+	if err != nil {
+		return nil, fmt.Errorf("send event failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("callback returned %s", resp.Status)
+	}
+	return c.wait(ev.EventId, ev.MessageId, timeout)
+}
+
+// addRecordedResources 将记录中下载的消息资源添加到模拟的飞书开放平台
+func addRecordedResources(fake *larktest.Server, ex *replay.Exchange) {
+	for _, call := range ex.Calls {
+		messageId, fileKey, ok := call.Resource()
+		if !ok || call.Data == nil {
+			continue
+		}
+		fake.AddResource(messageId, fileKey, call.FileName, call.Data)
+	}
+}
+
+// recordedResponses 将记录中的 GPT 响应转换为模拟服务的预设响应
+func recordedResponses(ex *replay.Exchange) []openaitest.Response {
+	responses := make([]openaitest.Response, 0)
+	for _, call := range ex.Calls {
+		if call.Service != replay.ServiceGPT {
+			continue
+		}
+		var body struct {
+			Choices []struct {
+				Text    string `json:"text"`
+				Message struct {
+					Content string `json:"content"`
+				} `json:"message"`
+			} `json:"choices"`
+			Usage *openai.Usage `json:"usage"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(call.Response, &body)
+		resp := openaitest.Response{Usage: body.Usage}
+		if call.Status >= http.StatusBadRequest || call.Error != "" {
+			resp.Status = call.Status
+			if resp.Status == 0 {
+				resp.Status = http.StatusBadGateway
+			}
+			resp.Error = call.Error
+			if body.Error != nil {
+				resp.Error = body.Error.Message
+			}
+		} else if len(body.Choices) > 0 {
+			resp.Content = body.Choices[0].Text + body.Choices[0].Message.Content
+		}
+		responses = append(responses, resp)
+	}
+	return responses
+}
+
+// collector 收集重放过程中 Recorder 写入的记录
+type collector struct {
+	mu      sync.Mutex
+	entries []*replay.Entry
+}
+
+func (c *collector) Write(p []byte) (int, error) {
+	e := &replay.Entry{}
+	if err := json.Unmarshal(p, e); err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = append(c.entries, e)
+	return len(p), nil
+}
+
+// wait 等待事件处理完成，返回事件的接口调用。没有事件 Id 的旧记录按消息 Id 查找
+func (c *collector) wait(eventId, messageId string, timeout time.Duration) (*replay.Exchange, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		c.mu.Lock()
+		for _, ex := range replay.Exchanges(c.entries) {
+			matched := ex.Event.EventId == eventId
+			if eventId == "" {
+				matched = ex.Event.MessageId == messageId
+			}
+			if matched && ex.Done != nil {
+				c.entries = nil
+				c.mu.Unlock()
+				return ex, nil
+			}
+		}
+		c.mu.Unlock()
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("wait for message %s timeout", messageId)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,188 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ModerationUnchecked 未经过内容审核
+const ModerationUnchecked = "unchecked"
+
+// 审计记录对应的操作
+const (
+	// ActionCompletion 用户提问和模型回答，未记录操作的旧记录也是提问
+	ActionCompletion = "completion"
+	// ActionCommand 用户发送的命令
+	ActionCommand = "command"
+	// ActionCardAction 用户在消息卡片中的操作，如切换、删除会话
+	ActionCardAction = "card"
+	// ActionTool 用户在确认卡片中确认或取消的工具写操作
+	ActionTool = "tool"
+)
+
+// Record 一条审计记录，对应用户的一次提问和模型的回答，或者一次命令、卡片操作和工具写操作。
+// Hash 为不含 Hash 字段的记录 JSON 与 PrevHash 一起计算的 sha256，前后记录通过 PrevHash 组成哈希链，
+// 修改或删除任意一条记录都会使之后的记录校验失败。
+type Record struct {
+	Seq              int64  `json:"seq"`
+	Time             string `json:"time"`
+	Action           string `json:"action,omitempty"`
+	AppId            string `json:"appId"`
+	AppName          string `json:"appName,omitempty"`
+	UserId           string `json:"userId"`
+	ChatId           string `json:"chatId"`
+	MessageId        string `json:"messageId"`
+	Model            string `json:"model"`
+	PromptTokens     int    `json:"promptTokens"`
+	CompletionTokens int    `json:"completionTokens"`
+	TotalTokens      int    `json:"totalTokens"`
+	Moderation       string `json:"moderation"`
+	// 操作失败时的原因，成功时为空
+	Error      string `json:"error,omitempty"`
+	PromptHash string `json:"promptHash"`
+	AnswerHash string `json:"answerHash"`
+	// 开启 storeContent 时保存完整的提问和回答
+	Prompt   string `json:"prompt,omitempty"`
+	Answer   string `json:"answer,omitempty"`
+	PrevHash string `json:"prevHash"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// Sink 审计记录的写入目标，每次写入一条 JSON
+type Sink interface {
+	Write(record []byte) error
+	Close() error
+}
+
+// lastRecorder 可以读取最后一条记录的 Sink，用于重启后延续哈希链
+type lastRecorder interface {
+	LastRecord() ([]byte, error)
+}
+
+// Logger 写入审计记录并维护哈希链
+type Logger struct {
+	mu           sync.Mutex
+	sink         Sink
+	storeContent bool
+	seq          int64
+	prevHash     string
+}
+
+// New 创建审计日志。sink 实现了 LastRecord 时从最后一条记录延续哈希链。
+func New(sink Sink, storeContent bool) (*Logger, error) {
+	l := &Logger{sink: sink, storeContent: storeContent}
+	if lr, ok := sink.(lastRecorder); ok {
+		last, err := lr.LastRecord()
+		if err != nil {
+			return nil, fmt.Errorf("audit - read last record failed: %w", err)
+		}
+		if len(last) > 0 {
+			var r Record
+			if err := json.Unmarshal(last, &r); err != nil {
+				return nil, fmt.Errorf("audit - unmarshal last record failed: %w", err)
+			}
+			l.seq, l.prevHash = r.Seq, r.Hash
+		}
+	}
+	return l, nil
+}
+
+// Log 计算提问和回答的哈希并写入审计记录。l 为 nil 时不记录。
+func (l *Logger) Log(r *Record, prompt, answer string) error {
+	if l == nil {
+		return nil
+	}
+	r.PromptHash = sum(prompt)
+	r.AnswerHash = sum(answer)
+	if l.storeContent {
+		r.Prompt, r.Answer = prompt, answer
+	}
+	if r.Moderation == "" {
+		r.Moderation = ModerationUnchecked
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r.Seq = l.seq + 1
+	r.Time = time.Now().Format(time.RFC3339Nano)
+	r.PrevHash = l.prevHash
+	hash, err := r.computeHash()
+	if err != nil {
+		return err
+	}
+	r.Hash = hash
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("audit - marshal record failed: %w", err)
+	}
+	if err := l.sink.Write(b); err != nil {
+		return fmt.Errorf("audit - write record failed: %w", err)
+	}
+	l.seq, l.prevHash = r.Seq, r.Hash
+	return nil
+}
+
+// Close 关闭 sink
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.sink.Close()
+}
+
+func (r Record) computeHash() (string, error) {
+	r.Hash = ""
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("audit - marshal record failed: %w", err)
+	}
+	return sum(string(b)), nil
+}
+
+func sum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// Verify 校验 JSON lines 格式的审计记录的哈希链和序号，返回校验通过的记录数。
+// 第一条记录的 PrevHash 和序号不做校验，以便校验轮转后的单个文件；之后每条记录的序号必须比前一条大 1。
+func Verify(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	n := 0
+	prev := ""
+	var prevSeq int64
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return n, fmt.Errorf("audit - record %d is not valid json: %w", n+1, err)
+		}
+		if n > 0 && rec.Seq != prevSeq+1 {
+			return n, fmt.Errorf("audit - record seq %d: expected seq %d after seq %d", rec.Seq, prevSeq+1, prevSeq)
+		}
+		if n > 0 && rec.PrevHash != prev {
+			return n, fmt.Errorf("audit - record seq %d: prevHash does not match previous record", rec.Seq)
+		}
+		hash, err := rec.computeHash()
+		if err != nil {
+			return n, err
+		}
+		if hash != rec.Hash {
+			return n, fmt.Errorf("audit - record seq %d: hash mismatch", rec.Seq)
+		}
+		prev, prevSeq = rec.Hash, rec.Seq
+		n++
+	}
+	return n, scanner.Err()
+}
@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type memorySink struct {
+	lines [][]byte
+}
+
+func (s *memorySink) Write(record []byte) error {
+	s.lines = append(s.lines, record)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+func (s *memorySink) join() *bytes.Buffer {
+	return bytes.NewBuffer(bytes.Join(s.lines, []byte("\n")))
+}
+
+// rechain 重新计算 records 的哈希链，模拟篡改记录后重新计算哈希
+func rechain(t *testing.T, records []*Record) *bytes.Buffer {
+	var buf bytes.Buffer
+	prev := ""
+	for _, r := range records {
+		r.PrevHash = prev
+		hash, err := r.computeHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Hash, prev = hash, hash
+		b, _ := json.Marshal(r)
+		buf.Write(append(b, '\n'))
+	}
+	return &buf
+}
+
+func TestVerify(t *testing.T) {
+	sink := &memorySink{}
+	l, err := New(sink, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range []string{ActionCompletion, ActionCommand, ActionCardAction} {
+		if err := l.Log(&Record{Action: action, UserId: "ou_user"}, "prompt", "answer"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := Verify(sink.join()); err != nil || n != 3 {
+		t.Fatalf("Verify() = %d, %v, want 3 records", n, err)
+	}
+
+	parse := func() []*Record {
+		records := make([]*Record, 0, len(sink.lines))
+		for _, line := range sink.lines {
+			r := &Record{}
+			if err := json.Unmarshal(line, r); err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, r)
+		}
+		return records
+	}
+
+	cases := []struct {
+		name   string
+		tamper func() *bytes.Buffer
+		want   string
+	}{
+		{
+			name: "modified",
+			tamper: func() *bytes.Buffer {
+				records := parse()
+				records[1].UserId = "ou_other"
+				var buf bytes.Buffer
+				for _, r := range records {
+					b, _ := json.Marshal(r)
+					buf.Write(append(b, '\n'))
+				}
+				return &buf
+			},
+			want: "hash mismatch",
+		},
+		{
+			name: "deleted and rechained",
+			tamper: func() *bytes.Buffer {
+				records := parse()
+				return rechain(t, append(records[:1], records[2:]...))
+			},
+			want: "expected seq 2",
+		},
+		{
+			name: "inserted and rechained",
+			tamper: func() *bytes.Buffer {
+				records := parse()
+				inserted := &Record{Seq: 2, Action: ActionCommand}
+				return rechain(t, append(records[:2], append([]*Record{inserted}, records[2:]...)...))
+			},
+			want: "expected seq 3",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Verify(tc.tamper())
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Verify() error = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
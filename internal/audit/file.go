@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// FileSink 以 JSON lines 格式写入文件，按大小轮转
+type FileSink struct {
+	filename string
+	writer   *lumberjack.Logger
+}
+
+// NewFileSink maxSize 为单个文件的最大 MB 数，maxBackups、maxAge 为 0 时保留全部轮转的文件
+func NewFileSink(filename string, maxSize, maxBackups, maxAge int) *FileSink {
+	return &FileSink{
+		filename: filename,
+		writer: &lumberjack.Logger{
+			Filename:   filename,
+			LocalTime:  true,
+			MaxSize:    maxSize,
+			MaxBackups: maxBackups,
+			MaxAge:     maxAge,
+		},
+	}
+}
+
+func (s *FileSink) Write(record []byte) error {
+	_, err := s.writer.Write(append(record, '\n'))
+	return err
+}
+
+func (s *FileSink) Close() error {
+	return s.writer.Close()
+}
+
+// LastRecord 读取当前文件的最后一条记录，文件不存在时返回空
+func (s *FileSink) LastRecord() ([]byte, error) {
+	f, err := os.Open(s.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// 从文件末尾向前查找最后一个非空行
+	const chunk = 64 * 1024
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var buf []byte
+	for offset := info.Size(); offset > 0; {
+		size := int64(chunk)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		b := make([]byte, size)
+		if _, err := f.ReadAt(b, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		buf = append(b, buf...)
+		trimmed := bytes.TrimRight(buf, "\r\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+		if offset == 0 {
+			return trimmed, nil
+		}
+	}
+	return nil, nil
+}
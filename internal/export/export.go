@@ -0,0 +1,154 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/fanchunke/xgpt3/conversation/ent/chatent"
+)
+
+// Format 导出格式
+type Format string
+
+const (
+	FormatMarkdown Format = "md"
+	FormatJSON     Format = "json"
+	FormatHTML     Format = "html"
+)
+
+// ParseFormat 解析导出格式，为空时默认导出 Markdown
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "md", "markdown":
+		return FormatMarkdown, nil
+	case "json":
+		return FormatJSON, nil
+	case "html", "htm":
+		return FormatHTML, nil
+	}
+	return "", fmt.Errorf("unsupported export format: %s", s)
+}
+
+// ContentType 导出文件的 MIME 类型
+func (f Format) ContentType() string {
+	switch f {
+	case FormatJSON:
+		return "application/json; charset=utf-8"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "text/markdown; charset=utf-8"
+	}
+}
+
+// Turn 一轮问答
+type Turn struct {
+	Question         string    `json:"question"`
+	AskedAt          time.Time `json:"asked_at"`
+	Answer           string    `json:"answer"`
+	AnsweredAt       time.Time `json:"answered_at,omitempty"`
+	Model            string    `json:"model,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+}
+
+// Conversation 导出的对话
+type Conversation struct {
+	ID          int       `json:"id"`
+	UserID      string    `json:"user_id"`
+	Title       string    `json:"title"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	TotalTokens int       `json:"total_tokens"`
+	Turns       []*Turn   `json:"turns"`
+}
+
+// Load 从 xgpt3 会话表读取对话，并关联每轮回复的模型和用量
+func Load(ctx context.Context, st *store.Store, id int) (*Conversation, error) {
+	sess, msgs, err := st.GetConversation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	replyIds := make([]int, 0, len(msgs))
+	for _, m := range msgs {
+		if m.FromUserID != sess.UserID {
+			replyIds = append(replyIds, m.ID)
+		}
+	}
+	usages, err := st.ListUsagesByMessage(ctx, replyIds...)
+	if err != nil {
+		return nil, err
+	}
+
+	conv := newConversation(sess, msgs, usages)
+	conv.Title = sess.UserID
+	if s, err := st.SessionByConversationKey(ctx, sess.UserID); err == nil {
+		conv.UserID = s.UserID
+		conv.Title = s.Name
+	} else if err != store.ErrNotFound {
+		return nil, err
+	}
+	return conv, nil
+}
+
+func newConversation(sess *chatent.Session, msgs []*chatent.Message, usages map[int]*store.Usage) *Conversation {
+	conv := &Conversation{
+		ID:        sess.ID,
+		UserID:    sess.UserID,
+		Active:    sess.Status,
+		CreatedAt: sess.CreatedAt,
+		Turns:     make([]*Turn, 0),
+	}
+
+	// 回复消息通过 spouse_id 关联提问消息
+	replies := make(map[int]*chatent.Message)
+	for _, m := range msgs {
+		if m.FromUserID != sess.UserID && m.SpouseID != 0 {
+			replies[m.SpouseID] = m
+		}
+	}
+
+	for _, m := range msgs {
+		if m.FromUserID != sess.UserID {
+			continue
+		}
+		turn := &Turn{Question: m.Content, AskedAt: m.CreatedAt}
+		if reply, ok := replies[m.ID]; ok {
+			turn.Answer = reply.Content
+			turn.AnsweredAt = reply.CreatedAt
+			if u, ok := usages[reply.ID]; ok {
+				turn.Model = u.Model
+				turn.PromptTokens = u.PromptTokens
+				turn.CompletionTokens = u.CompletionTokens
+				turn.TotalTokens = u.TotalTokens
+				conv.TotalTokens += u.TotalTokens
+			}
+		}
+		conv.Turns = append(conv.Turns, turn)
+	}
+	return conv
+}
+
+// FileName 导出文件名
+func FileName(conv *Conversation, format Format) string {
+	return fmt.Sprintf("conversation-%d-%s.%s", conv.ID, conv.CreatedAt.Format("20060102"), format)
+}
+
+// Render 按指定格式输出对话
+func Render(w io.Writer, conv *Conversation, format Format) error {
+	switch format {
+	case FormatMarkdown:
+		return renderMarkdown(w, conv)
+	case FormatJSON:
+		return renderJSON(w, conv)
+	case FormatHTML:
+		return renderHTML(w, conv)
+	}
+	return fmt.Errorf("unsupported export format: %s", format)
+}
@@ -0,0 +1,104 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+const timeLayout = "2006-01-02 15:04:05"
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(timeLayout)
+}
+
+func status(conv *Conversation) string {
+	if conv.Active {
+		return "进行中"
+	}
+	return "已关闭"
+}
+
+func (t *Turn) meta() string {
+	parts := []string{formatTime(t.AnsweredAt)}
+	if t.Model != "" {
+		parts = append(parts, t.Model)
+	}
+	if t.TotalTokens > 0 {
+		parts = append(parts, fmt.Sprintf("tokens: %d + %d = %d", t.PromptTokens, t.CompletionTokens, t.TotalTokens))
+	}
+	return strings.Join(parts, "，")
+}
+
+func renderMarkdown(w io.Writer, conv *Conversation) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# 会话：%s\n\n", conv.Title)
+	fmt.Fprintf(&b, "- 用户：%s\n", conv.UserID)
+	fmt.Fprintf(&b, "- 创建时间：%s\n", formatTime(conv.CreatedAt))
+	fmt.Fprintf(&b, "- 状态：%s\n", status(conv))
+	fmt.Fprintf(&b, "- Token 总数：%d\n", conv.TotalTokens)
+	for i, t := range conv.Turns {
+		fmt.Fprintf(&b, "\n## %d. %s\n\n", i+1, formatTime(t.AskedAt))
+		fmt.Fprintf(&b, "**用户**：\n\n%s\n\n", t.Question)
+		if t.Answer == "" {
+			b.WriteString("**ChatGPT**：（无回复）\n")
+			continue
+		}
+		fmt.Fprintf(&b, "**ChatGPT**（%s）：\n\n%s\n", t.meta(), t.Answer)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func renderJSON(w io.Writer, conv *Conversation) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(conv)
+}
+
+var htmlTemplate = template.Must(template.New("conversation").Funcs(template.FuncMap{
+	"formatTime": formatTime,
+	"status":     status,
+	"meta":       func(t *Turn) string { return t.meta() },
+	"inc":        func(i int) int { return i + 1 },
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>会话：{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "PingFang SC", "Microsoft YaHei", sans-serif; max-width: 860px; margin: 24px auto; padding: 0 16px; color: #1f2329; }
+.meta { color: #646a73; font-size: 14px; }
+.turn { border-top: 1px solid #dee0e3; padding: 12px 0; }
+.role { font-weight: 600; margin: 8px 0 4px; }
+.content { white-space: pre-wrap; word-break: break-word; background: #f5f6f7; border-radius: 6px; padding: 8px 12px; }
+.answer .content { background: #e1eaff; }
+</style>
+</head>
+<body>
+<h1>会话：{{.Title}}</h1>
+<p class="meta">用户：{{.UserID}} · 创建时间：{{formatTime .CreatedAt}} · 状态：{{status .}} · Token 总数：{{.TotalTokens}}</p>
+{{range $i, $t := .Turns}}
+<div class="turn">
+  <div class="meta">#{{inc $i}} · {{formatTime $t.AskedAt}}</div>
+  <div class="role">用户</div>
+  <div class="content">{{$t.Question}}</div>
+  <div class="answer">
+    <div class="role">ChatGPT <span class="meta">{{meta $t}}</span></div>
+    <div class="content">{{if $t.Answer}}{{$t.Answer}}{{else}}（无回复）{{end}}</div>
+  </div>
+</div>
+{{end}}
+</body>
+</html>
+`))
+
+func renderHTML(w io.Writer, conv *Conversation) error {
+	return htmlTemplate.Execute(w, conv)
+}
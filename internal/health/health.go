@@ -0,0 +1,113 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// 检查失败的原因。探针接口不需要认证，响应中只返回原因，错误详情只记录在日志中
+const (
+	ReasonTimeout     = "timeout"
+	ReasonUnavailable = "unavailable"
+)
+
+// Check 一项依赖检查，Optional 的检查失败时不影响整体状态
+type Check struct {
+	Name     string
+	Optional bool
+	Fn       func(ctx context.Context) error
+}
+
+// Result 单项检查结果
+type Result struct {
+	Status   string `json:"status"`
+	Optional bool   `json:"optional,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report 全部检查的结果
+type Report struct {
+	Status    string             `json:"status"`
+	CheckedAt time.Time          `json:"checkedAt"`
+	Checks    map[string]*Result `json:"checks"`
+}
+
+// OK 必需的检查是否全部通过
+func (r *Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+// Checker 并发执行依赖检查，并在 ttl 内缓存结果，避免探针频繁请求上游服务
+type Checker struct {
+	checks  []Check
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu     sync.Mutex
+	report *Report
+}
+
+func NewChecker(ttl, timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, ttl: ttl, timeout: timeout}
+}
+
+// Check 返回检查结果，缓存未过期时直接返回缓存
+func (c *Checker) Check(ctx context.Context) *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.report != nil && time.Since(c.report.CheckedAt) < c.ttl {
+		return c.report
+	}
+	c.report = c.run(ctx)
+	return c.report
+}
+
+func (c *Checker) run(ctx context.Context) *Report {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	results := make([]*Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			start := time.Now()
+			r := &Result{Status: StatusOK, Optional: check.Optional}
+			if err := check.Fn(ctx); err != nil {
+				r.Status = StatusFail
+				r.Reason = ReasonUnavailable
+				if errors.Is(err, context.DeadlineExceeded) {
+					r.Reason = ReasonTimeout
+				}
+				log.Ctx(ctx).Warn().Err(err).Msgf("health - check %s failed: %v", check.Name, err)
+			}
+			r.Duration = time.Since(start).String()
+			results[i] = r
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := &Report{Status: StatusOK, CheckedAt: time.Now(), Checks: make(map[string]*Result)}
+	for i, check := range c.checks {
+		r := results[i]
+		report.Checks[check.Name] = r
+		if r.Status != StatusOK && !check.Optional {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
@@ -0,0 +1,75 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/fanchunke/xgpt3"
+	"github.com/rs/zerolog/log"
+)
+
+const idleSweepBatchSize = 100
+
+// IdleSweeper 定期批量关闭长时间不活跃的会话
+type IdleSweeper struct {
+	store       *store.Store
+	xgpt3Client *xgpt3.Client
+	timeout     time.Duration
+	interval    time.Duration
+}
+
+func NewIdleSweeper(store *store.Store, xgpt3Client *xgpt3.Client, timeout, interval time.Duration) *IdleSweeper {
+	return &IdleSweeper{
+		store:       store,
+		xgpt3Client: xgpt3Client,
+		timeout:     timeout,
+		interval:    interval,
+	}
+}
+
+// Run 启动定时任务，直到 ctx 结束
+func (s *IdleSweeper) Run(ctx context.Context) {
+	runEvery(ctx, "idle-sweeper", s.interval, func(ctx context.Context) error {
+		n, err := s.Sweep(ctx)
+		if n > 0 {
+			log.Info().Msgf("job - idle-sweeper closed %d conversations", n)
+		}
+		return err
+	})
+}
+
+// Sweep 关闭所有超过 timeout 未活跃的会话，返回关闭的会话数量
+func (s *IdleSweeper) Sweep(ctx context.Context) (int, error) {
+	before := time.Now().Add(-s.timeout)
+	closed, after := 0, ""
+	for {
+		keys, err := s.store.ListIdleConversations(ctx, before, after, idleSweepBatchSize)
+		if err != nil {
+			return closed, err
+		}
+		for _, key := range keys {
+			// 查询之后用户可能发送了新消息，关闭前重新检查最近一次活跃的时间
+			last, ok, err := s.store.LastActivity(ctx, key)
+			if err != nil {
+				return closed, err
+			}
+			if !ok || !last.Before(before) {
+				continue
+			}
+			if err := s.xgpt3Client.CloseConversation(ctx, key); err != nil {
+				return closed, fmt.Errorf("Close Conversation error: %w", err)
+			}
+			// 记录过期，用户下次发送消息时仍然提示已开启新的会话
+			if err := s.store.MarkIdleExpired(ctx, key, last); err != nil {
+				return closed, err
+			}
+			closed++
+		}
+		if len(keys) < idleSweepBatchSize {
+			return closed, nil
+		}
+		after = keys[len(keys)-1]
+	}
+}
@@ -0,0 +1,38 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/fanchunke/chatgpt-lark/internal/tracing"
+	"github.com/rs/zerolog/log"
+)
+
+// runEvery 每隔 interval 执行一次 fn，直到 ctx 结束
+func runEvery(ctx context.Context, name string, interval time.Duration, fn func(ctx context.Context) error) {
+	log.Info().Msgf("job - %s started, interval: %s", name, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msgf("job - %s stopped", name)
+			return
+		case <-ticker.C:
+			func() {
+				defer func() {
+					if err := recover(); err != nil {
+						log.Error().Msgf("job - %s recovery from: %v", name, err)
+					}
+				}()
+				ctx, span := tracing.Start(ctx, "job."+name)
+				err := fn(ctx)
+				tracing.End(span, err)
+				if err != nil {
+					log.Error().Err(err).Msgf("job - %s failed: %v", name, err)
+				}
+			}()
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fanchunke/chatgpt-lark/internal/kb"
+	"github.com/rs/zerolog/log"
+)
+
+// KBSync 一个知识库的飞书文档同步配置
+type KBSync struct {
+	KB      string
+	Syncer  *kb.LarkSyncer
+	Sources []kb.LarkSource
+}
+
+// KBSyncer 定期将飞书知识空间和云文档增量同步到知识库
+type KBSyncer struct {
+	targets  []KBSync
+	interval time.Duration
+}
+
+func NewKBSyncer(targets []KBSync, interval time.Duration) *KBSyncer {
+	return &KBSyncer{targets: targets, interval: interval}
+}
+
+// Run 启动时先同步一次，之后定时同步，直到 ctx 结束
+func (s *KBSyncer) Run(ctx context.Context) {
+	if err := s.Sync(ctx); err != nil {
+		log.Error().Err(err).Msgf("job - kb-syncer failed: %v", err)
+	}
+	runEvery(ctx, "kb-syncer", s.interval, s.Sync)
+}
+
+// Sync 依次同步每个知识库，一个知识库同步失败不影响其他知识库
+func (s *KBSyncer) Sync(ctx context.Context) error {
+	failed := 0
+	for _, t := range s.targets {
+		result, err := t.Syncer.Sync(ctx, t.KB, t.Sources)
+		if err != nil {
+			failed++
+			log.Error().Err(err).Msgf("job - kb-syncer sync %s failed: %v", t.KB, err)
+			continue
+		}
+		log.Info().Msgf("job - kb-syncer synced %s, updated %d documents (%d chunks), skipped %d, removed %d, failed %d",
+			t.KB, result.Files, result.Chunks, result.Skipped, result.Removed, result.Failed)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d knowledge bases failed to sync", failed)
+	}
+	return nil
+}
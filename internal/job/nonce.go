@@ -0,0 +1,30 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+// NonceCleaner 定期删除管理接口签名请求中过期的 nonce
+type NonceCleaner struct {
+	store    *store.Store
+	interval time.Duration
+}
+
+func NewNonceCleaner(store *store.Store, interval time.Duration) *NonceCleaner {
+	return &NonceCleaner{store: store, interval: interval}
+}
+
+// Run 启动定时任务，每隔 interval 清理一次，直到 ctx 结束
+func (c *NonceCleaner) Run(ctx context.Context) {
+	runEvery(ctx, "nonce-cleaner", c.interval, func(ctx context.Context) error {
+		n, err := c.store.PurgeNonces(ctx, time.Now())
+		if n > 0 {
+			log.Info().Msgf("job - nonce-cleaner deleted %d admin nonces", n)
+		}
+		return err
+	})
+}
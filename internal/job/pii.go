@@ -0,0 +1,30 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+// PIICleaner 定期删除过期的占位符和原始值
+type PIICleaner struct {
+	store    *store.Store
+	interval time.Duration
+}
+
+func NewPIICleaner(store *store.Store, interval time.Duration) *PIICleaner {
+	return &PIICleaner{store: store, interval: interval}
+}
+
+// Run 启动定时任务，每隔 interval 清理一次，直到 ctx 结束
+func (c *PIICleaner) Run(ctx context.Context) {
+	runEvery(ctx, "pii-cleaner", c.interval, func(ctx context.Context) error {
+		n, err := c.store.PurgePIIValues(ctx, time.Now())
+		if n > 0 {
+			log.Info().Msgf("job - pii-cleaner deleted %d pii values", n)
+		}
+		return err
+	})
+}
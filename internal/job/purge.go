@@ -0,0 +1,47 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+// Purger 按数据保留策略定期清理过期的对话数据
+type Purger struct {
+	store    *store.Store
+	maxAge   time.Duration
+	mode     store.RetentionMode
+	salt     string
+	interval time.Duration
+}
+
+func NewPurger(store *store.Store, maxAge time.Duration, mode store.RetentionMode, salt string, interval time.Duration) *Purger {
+	return &Purger{
+		store:    store,
+		maxAge:   maxAge,
+		mode:     mode,
+		salt:     salt,
+		interval: interval,
+	}
+}
+
+// Run 启动定时任务，直到 ctx 结束
+func (p *Purger) Run(ctx context.Context) {
+	runEvery(ctx, "purger", p.interval, func(ctx context.Context) error {
+		_, err := p.Purge(ctx)
+		return err
+	})
+}
+
+// Purge 执行一次清理
+func (p *Purger) Purge(ctx context.Context) (store.PurgeResult, error) {
+	before := time.Now().Add(-p.maxAge)
+	result, err := p.store.Purge(ctx, before, p.mode, p.salt)
+	if err != nil {
+		return result, err
+	}
+	log.Info().Msgf("job - purger purged data before %s with mode %s, %s", before.Format(time.RFC3339), p.mode, result)
+	return result, nil
+}
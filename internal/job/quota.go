@@ -0,0 +1,31 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+// QuotaCleaner 定期删除前一天之前的每日额度计数
+type QuotaCleaner struct {
+	store    *store.Store
+	interval time.Duration
+}
+
+func NewQuotaCleaner(store *store.Store, interval time.Duration) *QuotaCleaner {
+	return &QuotaCleaner{store: store, interval: interval}
+}
+
+// Run 启动定时任务，每隔 interval 清理一次，直到 ctx 结束
+func (c *QuotaCleaner) Run(ctx context.Context) {
+	runEvery(ctx, "quota-cleaner", c.interval, func(ctx context.Context) error {
+		// 保留前一天的计数，跨天前收到的消息可能在跨天后才计入 token
+		n, err := c.store.PurgeQuotas(ctx, time.Now().AddDate(0, 0, -1).Format("2006-01-02"))
+		if n > 0 {
+			log.Info().Msgf("job - quota-cleaner deleted %d daily quotas", n)
+		}
+		return err
+	})
+}
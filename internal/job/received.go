@@ -0,0 +1,30 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+// ReceivedCleaner 定期删除过期的消息去重记录
+type ReceivedCleaner struct {
+	store *store.Store
+	ttl   time.Duration
+}
+
+func NewReceivedCleaner(store *store.Store, ttl time.Duration) *ReceivedCleaner {
+	return &ReceivedCleaner{store: store, ttl: ttl}
+}
+
+// Run 启动定时任务，每隔 ttl 清理一次，直到 ctx 结束
+func (c *ReceivedCleaner) Run(ctx context.Context) {
+	runEvery(ctx, "received-cleaner", c.ttl, func(ctx context.Context) error {
+		n, err := c.store.PurgeReceived(ctx, time.Now().Add(-c.ttl))
+		if n > 0 {
+			log.Info().Msgf("job - received-cleaner deleted %d received messages", n)
+		}
+		return err
+	})
+}
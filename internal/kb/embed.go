@@ -0,0 +1,65 @@
+package kb
+
+import (
+	"context"
+	"fmt"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// DefaultEmbeddingModel 未配置 rag.embeddingModel 时使用的模型
+const DefaultEmbeddingModel = "text-embedding-ada-002"
+
+// embedBatchSize 每次请求 embeddings 接口的最大文本数
+const embedBatchSize = 100
+
+// Embedder 使用 OpenAI embeddings 接口生成向量
+type Embedder struct {
+	client *openai.Client
+	model  openai.EmbeddingModel
+	name   string
+}
+
+// NewEmbedder model 为空时使用 text-embedding-ada-002，不支持的模型返回错误
+func NewEmbedder(client *openai.Client, model string) (*Embedder, error) {
+	if model == "" {
+		model = DefaultEmbeddingModel
+	}
+	var m openai.EmbeddingModel
+	if err := m.UnmarshalText([]byte(model)); err != nil || m == openai.Unknown {
+		return nil, fmt.Errorf("unsupported embedding model %q", model)
+	}
+	return &Embedder{client: client, model: m, name: model}, nil
+}
+
+// Model 生成向量使用的模型名称，不同模型生成的向量不能混用
+func (e *Embedder) Model() string {
+	return e.name
+}
+
+// Embed 生成 texts 的归一化向量，返回结果与 texts 一一对应
+func (e *Embedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	result := make([][]float32, len(texts))
+	for start := 0; start < len(texts); start += embedBatchSize {
+		end := start + embedBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		resp, err := e.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{Input: texts[start:end], Model: e.model})
+		if err != nil {
+			return nil, fmt.Errorf("openai embeddings failed: %w", err)
+		}
+		for _, d := range resp.Data {
+			if d.Index < 0 || start+d.Index >= end {
+				return nil, fmt.Errorf("openai embeddings returned invalid index %d", d.Index)
+			}
+			result[start+d.Index] = normalize(d.Embedding)
+		}
+	}
+	for i, v := range result {
+		if v == nil {
+			return nil, fmt.Errorf("openai embeddings returned no vector for input %d", i)
+		}
+	}
+	return result, nil
+}
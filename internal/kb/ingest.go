@@ -0,0 +1,165 @@
+package kb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// IngestOptions 导入参数
+type IngestOptions struct {
+	Split SplitOptions
+	// 删除知识库中本次没有导入的文档
+	Prune bool
+}
+
+// IngestResult 导入结果
+type IngestResult struct {
+	// 新增或更新的文档数
+	Files int
+	// 内容未变化而跳过的文档数
+	Skipped int
+	// 新增或更新的片段数
+	Chunks int
+	// 删除的文档数
+	Removed int
+	// 获取失败的文档数，失败的文档保留之前导入的内容
+	Failed int
+}
+
+type document struct {
+	path   string
+	source string
+}
+
+// Ingest 将 paths 中支持的文件切分、生成向量后导入知识库 kb。
+// paths 为目录时导入其中的全部 markdown、HTML 和文本文件，文档路径为文件相对于目录的路径；
+// paths 为文件时文档路径为文件名。内容和切分参数未变化的文档不重复生成向量。
+func Ingest(ctx context.Context, storage Storage, embedder *Embedder, kb string, paths []string, opts IngestOptions) (*IngestResult, error) {
+	if err := ValidName(kb); err != nil {
+		return nil, err
+	}
+	opts.Split = opts.Split.withDefaults()
+
+	docs, err := collect(paths)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := storage.Sources(ctx, kb)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &IngestResult{}
+	seen := make(map[string]bool)
+	for _, doc := range docs {
+		if seen[doc.source] {
+			return nil, fmt.Errorf("duplicate document %s (%s)", doc.source, doc.path)
+		}
+		seen[doc.source] = true
+
+		data, err := os.ReadFile(doc.path)
+		if err != nil {
+			return nil, fmt.Errorf("read %s failed: %w", doc.path, err)
+		}
+		hash := hashDocument(embedder.Model(), opts.Split, data)
+		if existing[doc.source] == hash {
+			result.Skipped++
+			continue
+		}
+
+		chunks := Split(doc.path, data, opts.Split)
+		texts := make([]string, 0, len(chunks))
+		for _, c := range chunks {
+			texts = append(texts, c.Title+"\n"+c.Content)
+		}
+		vectors, err := embedder.Embed(ctx, texts)
+		if err != nil {
+			return nil, fmt.Errorf("embed %s failed: %w", doc.path, err)
+		}
+		entries := make([]*Entry, 0, len(chunks))
+		for i, c := range chunks {
+			entries = append(entries, &Entry{
+				Source:  doc.source,
+				Hash:    hash,
+				Index:   c.Index,
+				Title:   c.Title,
+				Content: c.Content,
+				Model:   embedder.Model(),
+				Vector:  vectors[i],
+			})
+		}
+		if err := storage.Replace(ctx, kb, doc.source, entries); err != nil {
+			return nil, fmt.Errorf("save %s failed: %w", doc.path, err)
+		}
+		result.Files++
+		result.Chunks += len(entries)
+	}
+
+	if opts.Prune {
+		for source := range existing {
+			if seen[source] {
+				continue
+			}
+			if err := storage.DeleteSource(ctx, kb, source); err != nil {
+				return nil, err
+			}
+			result.Removed++
+		}
+	}
+	return result, nil
+}
+
+// collect 展开 paths 中的目录，目录中不支持的文件和隐藏文件会被忽略
+func collect(paths []string) ([]document, error) {
+	docs := make([]document, 0)
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("stat %s failed: %w", p, err)
+		}
+		if !info.IsDir() {
+			if !Supported(p) {
+				return nil, fmt.Errorf("unsupported file %s, only markdown, HTML and text files are supported", p)
+			}
+			docs = append(docs, document{path: p, source: filepath.Base(p)})
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != p && len(d.Name()) > 0 && d.Name()[0] == '.' {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() || !Supported(path) {
+				return nil
+			}
+			rel, err := filepath.Rel(p, path)
+			if err != nil {
+				return err
+			}
+			docs = append(docs, document{path: path, source: filepath.ToSlash(rel)})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("walk %s failed: %w", p, err)
+		}
+	}
+	return docs, nil
+}
+
+func hashDocument(model string, opts SplitOptions, data []byte) string {
+	h := sha256.New()
+	h.Write([]byte(model + "\n" + strconv.Itoa(opts.Size) + "\n" + strconv.Itoa(opts.Overlap) + "\n"))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
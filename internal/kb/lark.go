@@ -0,0 +1,293 @@
+package kb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	lark "github.com/larksuite/oapi-sdk-go/v3"
+	larkdocx "github.com/larksuite/oapi-sdk-go/v3/service/docx/v1"
+	larkdrive "github.com/larksuite/oapi-sdk-go/v3/service/drive/v1"
+	larkwiki "github.com/larksuite/oapi-sdk-go/v3/service/wiki/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// larkHashPrefix 飞书文档的哈希前缀，同步时只删除带有该前缀的文档，不影响通过文件导入的文档
+	larkHashPrefix = "lark:"
+	larkPageSize   = 50
+	// objTypeDocx 目前只支持同步新版文档，旧版文档、表格、多维表格等会被跳过
+	objTypeDocx = "docx"
+)
+
+// LarkSource 同步到知识库的飞书云文档
+type LarkSource struct {
+	// 知识空间 Id，同步空间中的全部文档，应用需要被添加为知识空间的成员
+	SpaceIds []string
+	// 云空间文件夹 token，同步文件夹中的文档，不包含子文件夹
+	Folders []string
+	// 文档 token
+	Documents []string
+	// 企业的飞书域名，如 https://example.feishu.cn，用于生成文档链接，为空时文档路径不是链接
+	Domain string
+}
+
+// larkDoc 待同步的飞书文档
+type larkDoc struct {
+	// token 新版文档的 document_id
+	token string
+	title string
+	// link 文档的链接，作为知识库中的文档路径
+	link string
+}
+
+// LarkSyncer 将飞书知识空间和云文档同步到知识库，按文档的 revision 增量更新
+type LarkSyncer struct {
+	client   *lark.Client
+	storage  Storage
+	embedder *Embedder
+	split    SplitOptions
+}
+
+func NewLarkSyncer(client *lark.Client, storage Storage, embedder *Embedder, split SplitOptions) *LarkSyncer {
+	return &LarkSyncer{client: client, storage: storage, embedder: embedder, split: split.withDefaults()}
+}
+
+// Sync 同步 sources 中的全部文档到知识库 kb，未变化的文档跳过，不再存在的飞书文档会被删除。
+// 列出文档失败时返回错误且不删除任何文档；单个文档获取失败时保留之前同步的内容，计入 Failed。
+func (s *LarkSyncer) Sync(ctx context.Context, kb string, sources []LarkSource) (*IngestResult, error) {
+	if err := ValidName(kb); err != nil {
+		return nil, err
+	}
+	docs, err := s.list(ctx, sources)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.storage.Sources(ctx, kb)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &IngestResult{}
+	seen := make(map[string]bool)
+	for _, doc := range docs {
+		if seen[doc.link] {
+			continue
+		}
+		seen[doc.link] = true
+
+		n, err := s.syncDoc(ctx, kb, doc, existing[doc.link])
+		switch {
+		case err != nil:
+			result.Failed++
+			log.Warn().Err(err).Msgf("kb - sync lark document %s failed: %v", doc.link, err)
+		case n < 0:
+			result.Skipped++
+		default:
+			result.Files++
+			result.Chunks += n
+		}
+	}
+
+	for source, hash := range existing {
+		if seen[source] || !strings.HasPrefix(hash, larkHashPrefix) {
+			continue
+		}
+		if err := s.storage.DeleteSource(ctx, kb, source); err != nil {
+			return result, err
+		}
+		result.Removed++
+	}
+	return result, nil
+}
+
+// syncDoc 同步一个文档，revision 未变化时返回 -1，否则返回片段数
+func (s *LarkSyncer) syncDoc(ctx context.Context, kb string, doc larkDoc, oldHash string) (int, error) {
+	getResp, err := s.client.Docx.Document.Get(ctx, larkdocx.NewGetDocumentReqBuilder().DocumentId(doc.token).Build())
+	if err != nil {
+		return 0, fmt.Errorf("get document failed: %w", err)
+	}
+	if !getResp.Success() {
+		return 0, fmt.Errorf("get document failed: %d %s", getResp.Code, getResp.Msg)
+	}
+	revision := 0
+	if d := getResp.Data.Document; d != nil {
+		if d.RevisionId != nil {
+			revision = *d.RevisionId
+		}
+		if doc.title == "" && d.Title != nil {
+			doc.title = *d.Title
+		}
+	}
+	hash := s.hash(doc.token, revision)
+	if hash == oldHash {
+		return -1, nil
+	}
+
+	contentResp, err := s.client.Docx.Document.RawContent(ctx, larkdocx.NewRawContentDocumentReqBuilder().DocumentId(doc.token).Build())
+	if err != nil {
+		return 0, fmt.Errorf("get document content failed: %w", err)
+	}
+	if !contentResp.Success() {
+		return 0, fmt.Errorf("get document content failed: %d %s", contentResp.Code, contentResp.Msg)
+	}
+	var content string
+	if contentResp.Data.Content != nil {
+		content = *contentResp.Data.Content
+	}
+
+	// 纯文本内容中每行是一个段落
+	chunks := SplitText(doc.title, strings.ReplaceAll(content, "\n", "\n\n"), s.split)
+	texts := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		texts = append(texts, c.Title+"\n"+c.Content)
+	}
+	vectors, err := s.embedder.Embed(ctx, texts)
+	if err != nil {
+		return 0, err
+	}
+	entries := make([]*Entry, 0, len(chunks))
+	for i, c := range chunks {
+		entries = append(entries, &Entry{
+			Source:  doc.link,
+			Hash:    hash,
+			Index:   c.Index,
+			Title:   c.Title,
+			Content: c.Content,
+			Model:   s.embedder.Model(),
+			Vector:  vectors[i],
+		})
+	}
+	if err := s.storage.Replace(ctx, kb, doc.link, entries); err != nil {
+		return 0, err
+	}
+	log.Info().Msgf("kb - synced lark document %s revision %d, %d chunks", doc.link, revision, len(entries))
+	return len(entries), nil
+}
+
+// hash 文档的 revision 和切分参数的哈希，长度不超过 kb_chunks.source_hash 字段
+func (s *LarkSyncer) hash(token string, revision int) string {
+	h := sha256.Sum256([]byte(strings.Join([]string{
+		s.embedder.Model(), strconv.Itoa(s.split.Size), strconv.Itoa(s.split.Overlap), token, strconv.Itoa(revision),
+	}, "\n")))
+	return larkHashPrefix + hex.EncodeToString(h[:16])
+}
+
+// list 列出 sources 中的全部新版文档
+func (s *LarkSyncer) list(ctx context.Context, sources []LarkSource) ([]larkDoc, error) {
+	docs := make([]larkDoc, 0)
+	for _, src := range sources {
+		for _, spaceId := range src.SpaceIds {
+			nodes, err := s.listWikiNodes(ctx, spaceId, "")
+			if err != nil {
+				return nil, fmt.Errorf("list wiki space %s failed: %w", spaceId, err)
+			}
+			for _, node := range nodes {
+				if str(node.ObjType) != objTypeDocx {
+					log.Debug().Msgf("kb - skip wiki node %s of type %s", str(node.NodeToken), str(node.ObjType))
+					continue
+				}
+				docs = append(docs, larkDoc{token: str(node.ObjToken), title: str(node.Title), link: larkLink(src.Domain, "wiki", str(node.NodeToken))})
+			}
+		}
+		for _, folder := range src.Folders {
+			files, err := s.listFolder(ctx, folder)
+			if err != nil {
+				return nil, fmt.Errorf("list folder %s failed: %w", folder, err)
+			}
+			for _, f := range files {
+				if str(f.Type) != objTypeDocx {
+					continue
+				}
+				link := larkLink(src.Domain, "docx", str(f.Token))
+				if src.Domain == "" && str(f.Url) != "" {
+					link = str(f.Url)
+				}
+				docs = append(docs, larkDoc{token: str(f.Token), title: str(f.Name), link: link})
+			}
+		}
+		for _, token := range src.Documents {
+			docs = append(docs, larkDoc{token: token, link: larkLink(src.Domain, "docx", token)})
+		}
+	}
+	return docs, nil
+}
+
+// listWikiNodes 递归列出知识空间中 parent 下的全部节点
+func (s *LarkSyncer) listWikiNodes(ctx context.Context, spaceId, parent string) ([]*larkwiki.Node, error) {
+	nodes := make([]*larkwiki.Node, 0)
+	pageToken := ""
+	for {
+		builder := larkwiki.NewListSpaceNodeReqBuilder().SpaceId(spaceId).PageSize(larkPageSize)
+		if parent != "" {
+			builder.ParentNodeToken(parent)
+		}
+		if pageToken != "" {
+			builder.PageToken(pageToken)
+		}
+		resp, err := s.client.Wiki.SpaceNode.List(ctx, builder.Build())
+		if err != nil {
+			return nil, err
+		}
+		if !resp.Success() {
+			return nil, fmt.Errorf("%d %s", resp.Code, resp.Msg)
+		}
+		for _, node := range resp.Data.Items {
+			nodes = append(nodes, node)
+			if node.HasChild != nil && *node.HasChild {
+				children, err := s.listWikiNodes(ctx, spaceId, str(node.NodeToken))
+				if err != nil {
+					return nil, err
+				}
+				nodes = append(nodes, children...)
+			}
+		}
+		if resp.Data.HasMore == nil || !*resp.Data.HasMore || str(resp.Data.PageToken) == "" {
+			return nodes, nil
+		}
+		pageToken = str(resp.Data.PageToken)
+	}
+}
+
+// listFolder 列出云空间文件夹中的文件
+func (s *LarkSyncer) listFolder(ctx context.Context, folder string) ([]*larkdrive.File, error) {
+	files := make([]*larkdrive.File, 0)
+	pageToken := ""
+	for {
+		builder := larkdrive.NewListFileReqBuilder().FolderToken(folder).PageSize(larkPageSize)
+		if pageToken != "" {
+			builder.PageToken(pageToken)
+		}
+		resp, err := s.client.Drive.File.List(ctx, builder.Build())
+		if err != nil {
+			return nil, err
+		}
+		if !resp.Success() {
+			return nil, fmt.Errorf("%d %s", resp.Code, resp.Msg)
+		}
+		files = append(files, resp.Data.Files...)
+		if resp.Data.HasMore == nil || !*resp.Data.HasMore || str(resp.Data.NextPageToken) == "" {
+			return files, nil
+		}
+		pageToken = str(resp.Data.NextPageToken)
+	}
+}
+
+// larkLink 生成文档链接，未配置域名时返回 wiki/<token> 或 docx/<token>
+func larkLink(domain, kind, token string) string {
+	path := kind + "/" + token
+	if domain == "" {
+		return path
+	}
+	return strings.TrimSuffix(domain, "/") + "/" + path
+}
+
+func str(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
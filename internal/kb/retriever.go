@@ -0,0 +1,121 @@
+package kb
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultCacheTTL 检索时缓存知识库片段的时间，导入新文档后最多经过该时间生效
+	defaultCacheTTL = time.Minute
+	// LargeSize 检索时逐个计算全部片段的相似度，片段数超过该值时内存占用和检索耗时明显增加，加载时记录警告日志
+	LargeSize = 50000
+)
+
+var nameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidName 知识库名称只能包含小写字母、数字、下划线和中划线，同时作为索引文件名使用
+func ValidName(name string) error {
+	if !nameRegexp.MatchString(name) || len(name) > 64 {
+		return fmt.Errorf("invalid knowledge base name %q, only lowercase letters, digits, '_' and '-' are allowed", name)
+	}
+	return nil
+}
+
+// Result 检索到的片段
+type Result struct {
+	*Entry
+	Score float64
+}
+
+type cached struct {
+	// mu 加载知识库时只阻塞检索同一个知识库的请求
+	mu       sync.Mutex
+	entries  []*Entry
+	loadedAt time.Time
+}
+
+// Retriever 从知识库中检索与问题最相关的片段，片段缓存在内存中按相似度排序。
+// 每次检索都会计算全部片段的相似度，适用于数万个片段以内的知识库：
+// 使用 1536 维的向量时每个片段约占 6KB 内存，5 万个片段约 300MB，单次检索约几十毫秒。
+type Retriever struct {
+	storage  Storage
+	embedder *Embedder
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	cache map[string]*cached
+}
+
+// NewRetriever ttl 为 0 时缓存 1 分钟
+func NewRetriever(storage Storage, embedder *Embedder, ttl time.Duration) *Retriever {
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	return &Retriever{storage: storage, embedder: embedder, ttl: ttl, cache: make(map[string]*cached)}
+}
+
+func (r *Retriever) load(ctx context.Context, kb string) ([]*Entry, error) {
+	r.mu.Lock()
+	c, ok := r.cache[kb]
+	if !ok {
+		c = &cached{}
+		r.cache[kb] = c
+	}
+	r.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loadedAt.IsZero() && time.Since(c.loadedAt) < r.ttl {
+		return c.entries, nil
+	}
+	entries, err := r.storage.Load(ctx, kb)
+	if err != nil {
+		return nil, fmt.Errorf("load knowledge base %s failed: %w", kb, err)
+	}
+	// 跳过其他模型生成的向量，切换模型后需要重新导入
+	current := make([]*Entry, 0, len(entries))
+	for _, e := range entries {
+		if e.Model == r.embedder.Model() {
+			current = append(current, e)
+		}
+	}
+	if len(current) > LargeSize {
+		log.Warn().Msgf("kb - knowledge base %s has %d chunks, more than %d chunks slows down every search", kb, len(current), LargeSize)
+	}
+	c.entries, c.loadedAt = current, time.Now()
+	return current, nil
+}
+
+// Search 返回知识库中与 query 相似度不低于 minScore 的前 topK 个片段，按相似度从高到低排序
+func (r *Retriever) Search(ctx context.Context, kb, query string, topK int, minScore float64) ([]*Result, error) {
+	entries, err := r.load(ctx, kb)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 || topK <= 0 {
+		return nil, nil
+	}
+	vectors, err := r.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*Result, 0, len(entries))
+	for _, e := range entries {
+		if score := similarity(vectors[0], e.Vector); score >= minScore {
+			results = append(results, &Result{Entry: e, Score: score})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > topK {
+		results = results[:topK]
+	}
+	return results, nil
+}
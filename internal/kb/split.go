@@ -0,0 +1,293 @@
+package kb
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	defaultChunkSize    = 500
+	defaultChunkOverlap = 50
+)
+
+// SplitOptions 文档切分参数，长度按字符计算
+type SplitOptions struct {
+	// 片段的最大长度，为 0 时使用 500
+	Size int
+	// 相邻片段重叠的长度，为 0 时使用 50，小于 0 时不重叠
+	Overlap int
+}
+
+func (o SplitOptions) withDefaults() SplitOptions {
+	if o.Size <= 0 {
+		o.Size = defaultChunkSize
+	}
+	if o.Overlap == 0 {
+		o.Overlap = defaultChunkOverlap
+	}
+	if o.Overlap < 0 || o.Overlap >= o.Size {
+		o.Overlap = 0
+	}
+	return o
+}
+
+// Chunk 文档切分后的片段
+type Chunk struct {
+	// 片段所在章节的标题，多级标题以 " > " 连接，没有标题时为文件名
+	Title   string
+	Index   int
+	Content string
+}
+
+// section 文档中一个标题下的内容
+type section struct {
+	headings []string
+	text     string
+}
+
+var (
+	headingRegexp   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	paragraphRegexp = regexp.MustCompile(`\n\s*\n`)
+)
+
+// Supported 是否支持导入该类型的文件：markdown、HTML 和纯文本
+func Supported(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown", ".html", ".htm", ".txt":
+		return true
+	}
+	return false
+}
+
+// Split 按文件类型解析文档，并按章节和段落切分为片段
+func Split(path string, data []byte, opts SplitOptions) []Chunk {
+	opts = opts.withDefaults()
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	var sections []section
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown":
+		sections = parseMarkdown(string(data))
+	case ".html", ".htm":
+		var title string
+		title, sections = parseHTML(data)
+		if title != "" {
+			name = title
+		}
+	default:
+		sections = []section{{text: string(data)}}
+	}
+
+	return splitSections(name, sections, opts)
+}
+
+// SplitText 切分没有章节结构的纯文本，如飞书文档的纯文本内容，片段的标题为 title
+func SplitText(title, text string, opts SplitOptions) []Chunk {
+	return splitSections(title, []section{{text: text}}, opts.withDefaults())
+}
+
+func splitSections(name string, sections []section, opts SplitOptions) []Chunk {
+	chunks := make([]Chunk, 0)
+	for _, s := range sections {
+		title := strings.Join(s.headings, " > ")
+		if title == "" {
+			title = name
+		}
+		for _, content := range pack(units(s.text, opts.Size), opts.Size, opts.Overlap) {
+			chunks = append(chunks, Chunk{Title: title, Index: len(chunks), Content: content})
+		}
+	}
+	return chunks
+}
+
+// parseMarkdown 按标题切分 markdown，代码块中的 # 不作为标题
+func parseMarkdown(text string) []section {
+	sections := make([]section, 0)
+	var (
+		headings []string
+		body     strings.Builder
+		fenced   bool
+	)
+	flush := func() {
+		if strings.TrimSpace(body.String()) != "" {
+			sections = append(sections, section{headings: append([]string(nil), headings...), text: body.String()})
+		}
+		body.Reset()
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			fenced = !fenced
+		}
+		if m := headingRegexp.FindStringSubmatch(line); m != nil && !fenced {
+			flush()
+			level := len(m[1])
+			if len(headings) >= level {
+				headings = headings[:level-1]
+			}
+			for len(headings) < level-1 {
+				headings = append(headings, "")
+			}
+			headings = append(headings, m[2])
+			continue
+		}
+		body.WriteString(line)
+		body.WriteString("\n")
+	}
+	flush()
+
+	// 去掉跳级标题留下的空标题
+	for i, s := range sections {
+		headings := make([]string, 0, len(s.headings))
+		for _, h := range s.headings {
+			if h != "" {
+				headings = append(headings, h)
+			}
+		}
+		sections[i].headings = headings
+	}
+	return sections
+}
+
+// parseHTML 提取 HTML 的标题和正文，按 h1-h6 切分章节，忽略脚本和样式
+func parseHTML(data []byte) (string, []section) {
+	var (
+		title    string
+		sections = make([]section, 0)
+		headings []string
+		body     strings.Builder
+		heading  strings.Builder
+		level    int
+		skip     int
+		inTitle  bool
+	)
+	flush := func() {
+		if strings.TrimSpace(body.String()) != "" {
+			sections = append(sections, section{headings: append([]string(nil), headings...), text: body.String()})
+		}
+		body.Reset()
+	}
+
+	z := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		name, _ := z.TagName()
+		tag := string(name)
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch tag {
+			case "script", "style", "noscript", "template":
+				if tt == html.StartTagToken {
+					skip++
+				}
+			case "title":
+				inTitle = true
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				flush()
+				level = int(tag[1] - '0')
+				heading.Reset()
+			case "br", "p", "div", "li", "tr", "pre", "blockquote", "section", "article", "table", "ul", "ol":
+				body.WriteString("\n")
+				if tag == "p" || tag == "pre" || tag == "table" {
+					body.WriteString("\n")
+				}
+			}
+		case html.EndTagToken:
+			switch tag {
+			case "script", "style", "noscript", "template":
+				if skip > 0 {
+					skip--
+				}
+			case "title":
+				inTitle = false
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				if level > 0 {
+					if len(headings) >= level {
+						headings = headings[:level-1]
+					}
+					headings = append(headings, strings.TrimSpace(heading.String()))
+					level = 0
+				}
+			case "p", "div", "li", "tr", "pre", "blockquote", "section", "article", "table":
+				body.WriteString("\n\n")
+			case "td", "th":
+				body.WriteString(" ")
+			}
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			text := string(z.Text())
+			switch {
+			case inTitle:
+				title += strings.TrimSpace(text)
+			case level > 0:
+				heading.WriteString(text)
+			default:
+				body.WriteString(collapseSpaces(text))
+			}
+		}
+	}
+	flush()
+	return title, sections
+}
+
+var spaceRegexp = regexp.MustCompile(`[ \t\r\n]+`)
+
+func collapseSpaces(s string) string {
+	return spaceRegexp.ReplaceAllString(s, " ")
+}
+
+// units 按段落切分文本，超过 size 的段落按 size 截断
+func units(text string, size int) []string {
+	result := make([]string, 0)
+	for _, p := range paragraphRegexp.Split(strings.ReplaceAll(text, "\r\n", "\n"), -1) {
+		p = strings.TrimSpace(p)
+		runes := []rune(p)
+		for len(runes) > size {
+			result = append(result, string(runes[:size]))
+			runes = runes[size:]
+		}
+		if len(runes) > 0 {
+			result = append(result, string(runes))
+		}
+	}
+	return result
+}
+
+// pack 将段落合并为不超过 size 的片段，每个片段以上一个片段末尾 overlap 个字符开头
+func pack(units []string, size, overlap int) []string {
+	chunks := make([]string, 0)
+	var (
+		buf []rune
+		// buf 中是否有上一个片段之外的内容
+		fresh bool
+	)
+	for _, u := range units {
+		runes := []rune(u)
+		if fresh && len(buf)+2+len(runes) > size {
+			chunks = append(chunks, string(buf))
+			if overlap > 0 && len(buf) > overlap {
+				buf = append([]rune(nil), buf[len(buf)-overlap:]...)
+			} else if overlap <= 0 {
+				buf = nil
+			}
+			fresh = false
+		}
+		if len(buf) > 0 {
+			buf = append(buf, '\n', '\n')
+		}
+		buf = append(buf, runes...)
+		fresh = true
+	}
+	if fresh {
+		chunks = append(chunks, string(buf))
+	}
+	return chunks
+}
@@ -0,0 +1,286 @@
+package kb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fanchunke/chatgpt-lark/internal/store"
+)
+
+// Entry 知识库中的一个片段
+type Entry struct {
+	// 文档的相对路径
+	Source string `json:"source"`
+	// 文档内容和切分参数的哈希
+	Hash    string `json:"hash"`
+	Index   int    `json:"index"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	// 生成向量使用的模型
+	Model  string    `json:"model"`
+	Vector []float32 `json:"-"`
+}
+
+// Stat 知识库的文档数和片段数
+type Stat struct {
+	KB      string `json:"kb"`
+	Sources int    `json:"sources"`
+	Chunks  int    `json:"chunks"`
+}
+
+// Storage 知识库片段的存储
+type Storage interface {
+	// Sources 返回以文档路径为 key、文档哈希为值的 map
+	Sources(ctx context.Context, kb string) (map[string]string, error)
+	// Replace 使用 entries 替换文档的全部片段
+	Replace(ctx context.Context, kb, source string, entries []*Entry) error
+	// DeleteSource 删除知识库中的文档
+	DeleteSource(ctx context.Context, kb, source string) error
+	// Delete 删除整个知识库
+	Delete(ctx context.Context, kb string) error
+	// Load 加载知识库的全部片段
+	Load(ctx context.Context, kb string) ([]*Entry, error)
+	// Stats 返回全部知识库的统计，按名称排序
+	Stats(ctx context.Context) ([]*Stat, error)
+}
+
+// DBStorage 将片段保存在 database 配置的数据库中
+type DBStorage struct {
+	store *store.Store
+}
+
+func NewDBStorage(st *store.Store) *DBStorage {
+	return &DBStorage{store: st}
+}
+
+func (s *DBStorage) Sources(ctx context.Context, kb string) (map[string]string, error) {
+	return s.store.ListKBSources(ctx, kb)
+}
+
+func (s *DBStorage) Replace(ctx context.Context, kb, source string, entries []*Entry) error {
+	chunks := make([]*store.KBChunk, 0, len(entries))
+	for _, e := range entries {
+		chunks = append(chunks, &store.KBChunk{
+			SourceHash: e.Hash,
+			Index:      e.Index,
+			Title:      e.Title,
+			Content:    e.Content,
+			Model:      e.Model,
+			Embedding:  EncodeVector(e.Vector),
+		})
+	}
+	return s.store.ReplaceKBSource(ctx, kb, source, chunks)
+}
+
+func (s *DBStorage) DeleteSource(ctx context.Context, kb, source string) error {
+	return s.store.DeleteKBSource(ctx, kb, source)
+}
+
+func (s *DBStorage) Delete(ctx context.Context, kb string) error {
+	return s.store.DeleteKB(ctx, kb)
+}
+
+func (s *DBStorage) Load(ctx context.Context, kb string) ([]*Entry, error) {
+	chunks, err := s.store.ListKBChunks(ctx, kb)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*Entry, 0, len(chunks))
+	for _, c := range chunks {
+		vector, err := DecodeVector(c.Embedding)
+		if err != nil {
+			return nil, fmt.Errorf("kb chunk %d: %w", c.ID, err)
+		}
+		entries = append(entries, &Entry{
+			Source:  c.Source,
+			Hash:    c.SourceHash,
+			Index:   c.Index,
+			Title:   c.Title,
+			Content: c.Content,
+			Model:   c.Model,
+			Vector:  vector,
+		})
+	}
+	return entries, nil
+}
+
+func (s *DBStorage) Stats(ctx context.Context) ([]*Stat, error) {
+	stats, err := s.store.KBStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Stat, 0, len(stats))
+	for _, st := range stats {
+		result = append(result, &Stat{KB: st.KB, Sources: st.Sources, Chunks: st.Chunks})
+	}
+	return result, nil
+}
+
+// FileStorage 将每个知识库保存为 dir 下的 <kb>.json 文件，适合不方便使用数据库的单机部署
+type FileStorage struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// fileEntry 文件中的片段，向量使用 EncodeVector 编码以减小文件体积
+type fileEntry struct {
+	*Entry
+	Embedding string `json:"embedding"`
+}
+
+func NewFileStorage(dir string) *FileStorage {
+	return &FileStorage{dir: dir}
+}
+
+func (s *FileStorage) path(kb string) string {
+	return filepath.Join(s.dir, kb+".json")
+}
+
+func (s *FileStorage) read(kb string) ([]*Entry, error) {
+	data, err := os.ReadFile(s.path(kb))
+	if errors.Is(err, os.ErrNotExist) {
+		return []*Entry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read kb index failed: %w", err)
+	}
+	var items []*fileEntry
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("parse kb index %s failed: %w", s.path(kb), err)
+	}
+	entries := make([]*Entry, 0, len(items))
+	for _, item := range items {
+		if item.Entry == nil {
+			continue
+		}
+		if item.Vector, err = DecodeVector(item.Embedding); err != nil {
+			return nil, fmt.Errorf("parse kb index %s failed: %w", s.path(kb), err)
+		}
+		entries = append(entries, item.Entry)
+	}
+	return entries, nil
+}
+
+// write 先写入临时文件再重命名，避免服务读取到写了一半的文件
+func (s *FileStorage) write(kb string, entries []*Entry) error {
+	if len(entries) == 0 {
+		if err := os.Remove(s.path(kb)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove kb index failed: %w", err)
+		}
+		return nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Source != entries[j].Source {
+			return entries[i].Source < entries[j].Source
+		}
+		return entries[i].Index < entries[j].Index
+	})
+	items := make([]*fileEntry, 0, len(entries))
+	for _, e := range entries {
+		items = append(items, &fileEntry{Entry: e, Embedding: EncodeVector(e.Vector)})
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		return fmt.Errorf("encode kb index failed: %w", err)
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("create kb index dir failed: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, kb+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create kb index failed: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write kb index failed: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write kb index failed: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(kb)); err != nil {
+		return fmt.Errorf("write kb index failed: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStorage) Sources(ctx context.Context, kb string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.read(kb)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string)
+	for _, e := range entries {
+		result[e.Source] = e.Hash
+	}
+	return result, nil
+}
+
+func (s *FileStorage) Replace(ctx context.Context, kb, source string, entries []*Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, err := s.read(kb)
+	if err != nil {
+		return err
+	}
+	kept := make([]*Entry, 0, len(current)+len(entries))
+	for _, e := range current {
+		if e.Source != source {
+			kept = append(kept, e)
+		}
+	}
+	for _, e := range entries {
+		e.Source = source
+		kept = append(kept, e)
+	}
+	return s.write(kb, kept)
+}
+
+func (s *FileStorage) DeleteSource(ctx context.Context, kb, source string) error {
+	return s.Replace(ctx, kb, source, nil)
+}
+
+func (s *FileStorage) Delete(ctx context.Context, kb string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(kb, nil)
+}
+
+func (s *FileStorage) Load(ctx context.Context, kb string) ([]*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(kb)
+}
+
+func (s *FileStorage) Stats(ctx context.Context) ([]*Stat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("list kb index failed: %w", err)
+	}
+	result := make([]*Stat, 0, len(files))
+	for _, f := range files {
+		kb := strings.TrimSuffix(filepath.Base(f), ".json")
+		entries, err := s.read(kb)
+		if err != nil {
+			return nil, err
+		}
+		sources := make(map[string]bool)
+		for _, e := range entries {
+			sources[e.Source] = true
+		}
+		result = append(result, &Stat{KB: kb, Sources: len(sources), Chunks: len(entries)})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].KB < result[j].KB })
+	return result, nil
+}
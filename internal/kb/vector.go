@@ -0,0 +1,62 @@
+package kb
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// EncodeVector 将向量编码为 base64 的小端 float32 序列，便于存入文本字段
+func EncodeVector(v []float32) string {
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// DecodeVector 解码 EncodeVector 编码的向量
+func DecodeVector(s string) ([]float32, error) {
+	buf, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("decode vector failed: %w", err)
+	}
+	if len(buf)%4 != 0 {
+		return nil, fmt.Errorf("decode vector failed: invalid length %d", len(buf))
+	}
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v, nil
+}
+
+// normalize 将向量归一化，归一化后的余弦相似度即为点积
+func normalize(v []float64) []float32 {
+	var sum float64
+	for _, f := range v {
+		sum += f * f
+	}
+	norm := math.Sqrt(sum)
+	result := make([]float32, len(v))
+	if norm == 0 {
+		return result
+	}
+	for i, f := range v {
+		result[i] = float32(f / norm)
+	}
+	return result
+}
+
+// similarity 计算两个归一化向量的余弦相似度，维度不同时返回 0
+func similarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
@@ -0,0 +1,167 @@
+package larktest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	larkevent "github.com/larksuite/oapi-sdk-go/v3/event"
+)
+
+// MessageEvent 用户发送给机器人的消息，对应 im.message.receive_v1 事件
+type MessageEvent struct {
+	EventID   string
+	MessageID string
+	ChatID    string
+	// ChatType p2p 或 group，默认 p2p
+	ChatType string
+	OpenID   string
+	// MessageType 默认 text
+	MessageType string
+	// Content 消息内容的 JSON，如 {"text":"hello"}
+	Content string
+}
+
+// TextEvent 构造用户发送的文本消息事件
+func TextEvent(openID, text string) *MessageEvent {
+	content, _ := json.Marshal(map[string]string{"text": text})
+	return &MessageEvent{OpenID: openID, MessageType: "text", Content: string(content)}
+}
+
+// EventBody 构造事件的请求内容，应用配置了 EventEncryptKey 时加密
+func (s *Server) EventBody(ev *MessageEvent) ([]byte, error) {
+	s.mu.Lock()
+	if ev.EventID == "" {
+		ev.EventID = s.nextID("ev")
+	}
+	if ev.MessageID == "" {
+		ev.MessageID = s.nextID("om")
+	}
+	if ev.ChatID == "" {
+		ev.ChatID = s.nextID("oc")
+	}
+	s.mu.Unlock()
+	if ev.ChatType == "" {
+		ev.ChatType = "p2p"
+	}
+	if ev.MessageType == "" {
+		ev.MessageType = "text"
+	}
+
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	body, err := json.Marshal(map[string]interface{}{
+		"schema": "2.0",
+		"header": map[string]interface{}{
+			"event_id":    ev.EventID,
+			"event_type":  "im.message.receive_v1",
+			"app_id":      s.app.AppID,
+			"tenant_key":  "tenant",
+			"create_time": now,
+			"token":       s.app.VerificationToken,
+		},
+		"event": map[string]interface{}{
+			"sender": map[string]interface{}{
+				"sender_id":   map[string]interface{}{"open_id": ev.OpenID},
+				"sender_type": "user",
+				"tenant_key":  "tenant",
+			},
+			"message": map[string]interface{}{
+				"message_id":   ev.MessageID,
+				"create_time":  now,
+				"chat_id":      ev.ChatID,
+				"chat_type":    ev.ChatType,
+				"message_type": ev.MessageType,
+				"content":      ev.Content,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal event failed: %w", err)
+	}
+	return s.encrypt(body)
+}
+
+// ChallengeBody 构造配置回调地址时的 url_verification 请求内容
+func (s *Server) ChallengeBody(challenge string) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{
+		"challenge": challenge,
+		"token":     s.app.VerificationToken,
+		"type":      string(larkevent.ReqTypeChallenge),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal challenge failed: %w", err)
+	}
+	return s.encrypt(body)
+}
+
+// NewEventRequest 构造发送到回调地址 url 的事件请求，配置了 EventEncryptKey 时带上签名
+func (s *Server) NewEventRequest(url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create event request failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if s.app.EventEncryptKey != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		nonce := strconv.FormatInt(time.Now().UnixNano(), 36)
+		req.Header.Set(larkevent.EventRequestTimestamp, timestamp)
+		req.Header.Set(larkevent.EventRequestNonce, nonce)
+		req.Header.Set(larkevent.EventSignature, larkevent.Signature(timestamp, nonce, s.app.EventEncryptKey, string(body)))
+	}
+	return req, nil
+}
+
+// SendEvent 将消息事件发送到回调地址 url
+func (s *Server) SendEvent(url string, ev *MessageEvent) (*http.Response, error) {
+	body, err := s.EventBody(ev)
+	if err != nil {
+		return nil, err
+	}
+	req, err := s.NewEventRequest(url, body)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// SendRawEvent 将未加密的事件 JSON 发送到回调地址 url，配置了 EventEncryptKey 时加密并签名，用于重放记录的事件
+func (s *Server) SendRawEvent(url string, event []byte) (*http.Response, error) {
+	body, err := s.encrypt(event)
+	if err != nil {
+		return nil, err
+	}
+	req, err := s.NewEventRequest(url, body)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// encrypt 使用 EventEncryptKey 加密事件，与 larkevent.EventDecrypt 对应
+func (s *Server) encrypt(body []byte) ([]byte, error) {
+	if s.app.EventEncryptKey == "" {
+		return body, nil
+	}
+	key := sha256.Sum256([]byte(s.app.EventEncryptKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("create cipher failed: %w", err)
+	}
+	padding := aes.BlockSize - len(body)%aes.BlockSize
+	plain := append(body, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	buf := make([]byte, aes.BlockSize+len(plain))
+	iv := buf[:aes.BlockSize]
+	if _, err := rand.Read(iv); err != nil {
+		return nil, fmt.Errorf("generate iv failed: %w", err)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(buf[aes.BlockSize:], plain)
+	return json.Marshal(map[string]string{"encrypt": base64.StdEncoding.EncodeToString(buf)})
+}
@@ -0,0 +1,474 @@
+// Package larktest 提供一个基于 httptest 的飞书开放平台模拟服务，用于在离线环境中端到端测试飞书回调和消息发送。
+//
+// 飞书 client 通过 lark.WithOpenBaseUrl(server.URL) 指向模拟服务，模拟服务颁发 tenant access token，
+// 记录 im/v1/messages 的发送、回复和更新请求，提供消息中的资源文件，并可以向回调地址发送签名和加密的事件。
+// 模拟服务还提供知识空间节点、云空间文件夹和新版文档的只读接口，用于测试知识库同步；
+// 以及通讯录、日程忙闲、任务、群成员和会话历史消息的接口，用于测试工具调用。
+package larktest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 消息操作
+const (
+	OpCreate = "create"
+	OpReply  = "reply"
+	OpPatch  = "patch"
+)
+
+// App 模拟服务中的飞书应用
+type App struct {
+	AppID             string
+	AppSecret         string
+	VerificationToken string
+	// EventEncryptKey 不为空时事件会被加密和签名
+	EventEncryptKey string
+}
+
+// Message 应用调用 im/v1/messages 接口发送、回复或更新的消息
+type Message struct {
+	Op            string
+	MessageID     string
+	ReceiveIDType string
+	ReceiveID     string
+	// ParentID 回复和更新的消息 Id
+	ParentID string
+	MsgType  string
+	Content  string
+	UUID     string
+}
+
+// Text 解析文本消息的内容
+func (m Message) Text() string {
+	var content struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal([]byte(m.Content), &content); err != nil {
+		return ""
+	}
+	return content.Text
+}
+
+// File 应用上传的文件
+type File struct {
+	FileKey  string
+	FileType string
+	FileName string
+	Data     []byte
+}
+
+// WikiNode 知识空间中的节点
+type WikiNode struct {
+	SpaceID         string
+	NodeToken       string
+	ParentNodeToken string
+	ObjToken        string
+	// ObjType 文档类型，如 docx、sheet
+	ObjType string
+	Title   string
+}
+
+// DriveFile 云空间文件夹中的文件
+type DriveFile struct {
+	FolderToken string
+	Token       string
+	Name        string
+	// Type 文件类型，如 docx、file
+	Type string
+	URL  string
+}
+
+// Document 新版文档
+type Document struct {
+	DocumentID string
+	RevisionID int
+	Title      string
+	// Content 文档的纯文本内容
+	Content string
+}
+
+type resource struct {
+	name string
+	data []byte
+}
+
+// Server 飞书开放平台模拟服务
+type Server struct {
+	*httptest.Server
+	app   App
+	token string
+
+	mu         sync.Mutex
+	seq        int
+	messages   []Message
+	files      []File
+	resources  map[string]resource
+	changed    chan struct{}
+	nodes      []WikiNode
+	driveFiles []DriveFile
+	documents  map[string]Document
+	users      []User
+	busy       map[string][]Busy
+	tasks      []Task
+	members    map[string][]string
+	history    []ChatMessage
+}
+
+// NewServer 启动模拟服务，使用完毕后需要调用 Close
+func NewServer(app App) *Server {
+	s := &Server{
+		app:       app,
+		token:     "t-" + app.AppID,
+		resources: make(map[string]resource),
+		changed:   make(chan struct{}),
+		documents: make(map[string]Document),
+		busy:      make(map[string][]Busy),
+		members:   make(map[string][]string),
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	e := gin.New()
+	e.POST("/open-apis/auth/v3/tenant_access_token/internal", s.accessToken("tenant_access_token"))
+	e.POST("/open-apis/auth/v3/app_access_token/internal", s.accessToken("app_access_token"))
+	api := e.Group("/open-apis/im/v1", s.auth)
+	api.POST("/messages", s.createMessage)
+	api.POST("/messages/:message_id/reply", s.replyMessage)
+	api.PATCH("/messages/:message_id", s.patchMessage)
+	api.GET("/messages/:message_id/resources/:file_key", s.getResource)
+	api.POST("/files", s.createFile)
+	api.GET("/messages", s.listMessages)
+	api.GET("/chats/:chat_id/members", s.listChatMembers)
+	e.GET("/open-apis/contact/v3/users", s.auth, s.listUsers)
+	e.POST("/open-apis/calendar/v4/freebusy/list", s.auth, s.listFreebusy)
+	e.POST("/open-apis/task/v1/tasks", s.auth, s.createTask)
+	e.GET("/open-apis/wiki/v2/spaces/:space_id/nodes", s.auth, s.listWikiNodes)
+	e.GET("/open-apis/drive/v1/files", s.auth, s.listDriveFiles)
+	e.GET("/open-apis/docx/v1/documents/:document_id", s.auth, s.getDocument)
+	e.GET("/open-apis/docx/v1/documents/:document_id/raw_content", s.auth, s.getDocumentRawContent)
+	s.Server = httptest.NewServer(e)
+	return s
+}
+
+// Messages 返回记录的全部消息
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Files 返回上传的全部文件
+func (s *Server) Files() []File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]File(nil), s.files...)
+}
+
+// WaitMessages 等待记录的消息数达到 n，超时返回错误。飞书回调中的消息是异步处理的，发送事件后需要等待回复。
+func (s *Server) WaitMessages(n int, timeout time.Duration) ([]Message, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		messages, changed := append([]Message(nil), s.messages...), s.changed
+		s.mu.Unlock()
+		if len(messages) >= n {
+			return messages, nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return messages, fmt.Errorf("wait for %d messages timeout, got %d", n, len(messages))
+		}
+	}
+}
+
+// Reset 清空记录的消息和文件
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages, s.files = nil, nil
+}
+
+// AddResource 添加消息中的资源文件，如用户发送的图片和文件
+func (s *Server) AddResource(messageID, fileKey, fileName string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resources[messageID+"/"+fileKey] = resource{name: fileName, data: data}
+}
+
+// AddWikiNode 添加知识空间中的节点
+func (s *Server) AddWikiNode(n WikiNode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes = append(s.nodes, n)
+}
+
+// AddDriveFile 添加云空间文件夹中的文件
+func (s *Server) AddDriveFile(f DriveFile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.driveFiles = append(s.driveFiles, f)
+}
+
+// SetDocument 添加或更新新版文档
+func (s *Server) SetDocument(d Document) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.documents[d.DocumentID] = d
+}
+
+// RemoveDocument 删除新版文档以及引用该文档的节点和文件
+func (s *Server) RemoveDocument(documentID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.documents, documentID)
+	nodes := s.nodes[:0]
+	for _, n := range s.nodes {
+		if n.ObjToken != documentID {
+			nodes = append(nodes, n)
+		}
+	}
+	s.nodes = nodes
+	files := s.driveFiles[:0]
+	for _, f := range s.driveFiles {
+		if f.Token != documentID {
+			files = append(files, f)
+		}
+	}
+	s.driveFiles = files
+}
+
+func (s *Server) nextID(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s_%d", prefix, s.seq)
+}
+
+// record 记录消息并通知等待的调用方，返回消息 Id
+func (s *Server) record(m Message) Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m.MessageID == "" {
+		m.MessageID = s.nextID("om")
+	}
+	s.messages = append(s.messages, m)
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return m
+}
+
+func (s *Server) accessToken(field string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			AppID     string `json:"app_id"`
+			AppSecret string `json:"app_secret"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.AppID != s.app.AppID || req.AppSecret != s.app.AppSecret {
+			c.JSON(http.StatusOK, gin.H{"code": 10014, "msg": "app secret invalid"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "ok", field: s.token, "expire": 7200})
+	}
+}
+
+// auth 校验请求头中的 tenant access token
+func (s *Server) auth(c *gin.Context) {
+	if c.GetHeader("Authorization") != "Bearer "+s.token {
+		c.AbortWithStatusJSON(http.StatusOK, gin.H{"code": 99991663, "msg": "Invalid access token for authorization"})
+		return
+	}
+	c.Next()
+}
+
+type messageBody struct {
+	ReceiveID string `json:"receive_id"`
+	MsgType   string `json:"msg_type"`
+	Content   string `json:"content"`
+	UUID      string `json:"uuid"`
+}
+
+func (s *Server) createMessage(c *gin.Context) {
+	var body messageBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": err.Error()})
+		return
+	}
+	m := s.record(Message{
+		Op:            OpCreate,
+		ReceiveIDType: c.Query("receive_id_type"),
+		ReceiveID:     body.ReceiveID,
+		MsgType:       body.MsgType,
+		Content:       body.Content,
+		UUID:          body.UUID,
+	})
+	c.JSON(http.StatusOK, messageResp(m))
+}
+
+func (s *Server) replyMessage(c *gin.Context) {
+	var body messageBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": err.Error()})
+		return
+	}
+	m := s.record(Message{
+		Op:       OpReply,
+		ParentID: c.Param("message_id"),
+		MsgType:  body.MsgType,
+		Content:  body.Content,
+		UUID:     body.UUID,
+	})
+	c.JSON(http.StatusOK, messageResp(m))
+}
+
+func (s *Server) patchMessage(c *gin.Context) {
+	var body messageBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": err.Error()})
+		return
+	}
+	s.record(Message{
+		Op:        OpPatch,
+		MessageID: c.Param("message_id"),
+		ParentID:  c.Param("message_id"),
+		MsgType:   "interactive",
+		Content:   body.Content,
+	})
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": gin.H{}})
+}
+
+func messageResp(m Message) gin.H {
+	return gin.H{"code": 0, "msg": "success", "data": gin.H{
+		"message_id":  m.MessageID,
+		"parent_id":   m.ParentID,
+		"msg_type":    m.MsgType,
+		"create_time": strconv.FormatInt(time.Now().UnixMilli(), 10),
+		"body":        gin.H{"content": m.Content},
+	}}
+}
+
+func (s *Server) getResource(c *gin.Context) {
+	s.mu.Lock()
+	r, ok := s.resources[c.Param("message_id")+"/"+c.Param("file_key")]
+	s.mu.Unlock()
+	// 下载接口出错时返回非 200 的状态码，SDK 根据状态码区分文件内容和错误信息
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 234003, "msg": "File not in msg."})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", r.name))
+	c.Data(http.StatusOK, "application/octet-stream", r.data)
+}
+
+func (s *Server) createFile(c *gin.Context) {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": err.Error()})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": err.Error()})
+		return
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, f); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	file := File{
+		FileKey:  s.nextID("file"),
+		FileType: c.PostForm("file_type"),
+		FileName: c.PostForm("file_name"),
+		Data:     buf.Bytes(),
+	}
+	s.files = append(s.files, file)
+	s.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": gin.H{"file_key": file.FileKey}})
+}
+
+// listWikiNodes 返回 parent_node_token 下的全部节点，不分页
+func (s *Server) listWikiNodes(c *gin.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	spaceID, parent := c.Param("space_id"), c.Query("parent_node_token")
+	items := make([]gin.H, 0)
+	for _, n := range s.nodes {
+		if n.SpaceID != spaceID || n.ParentNodeToken != parent {
+			continue
+		}
+		hasChild := false
+		for _, child := range s.nodes {
+			if child.SpaceID == spaceID && child.ParentNodeToken == n.NodeToken {
+				hasChild = true
+				break
+			}
+		}
+		items = append(items, gin.H{
+			"space_id":          n.SpaceID,
+			"node_token":        n.NodeToken,
+			"parent_node_token": n.ParentNodeToken,
+			"obj_token":         n.ObjToken,
+			"obj_type":          n.ObjType,
+			"title":             n.Title,
+			"has_child":         hasChild,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": gin.H{"items": items, "has_more": false}})
+}
+
+// listDriveFiles 返回文件夹中的全部文件，不分页
+func (s *Server) listDriveFiles(c *gin.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files := make([]gin.H, 0)
+	for _, f := range s.driveFiles {
+		if f.FolderToken != c.Query("folder_token") {
+			continue
+		}
+		files = append(files, gin.H{"token": f.Token, "name": f.Name, "type": f.Type, "parent_token": f.FolderToken, "url": f.URL})
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": gin.H{"files": files, "has_more": false}})
+}
+
+func (s *Server) document(c *gin.Context) (Document, bool) {
+	s.mu.Lock()
+	d, ok := s.documents[c.Param("document_id")]
+	s.mu.Unlock()
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"code": 1770002, "msg": "not found"})
+	}
+	return d, ok
+}
+
+func (s *Server) getDocument(c *gin.Context) {
+	d, ok := s.document(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": gin.H{"document": gin.H{
+		"document_id": d.DocumentID,
+		"revision_id": d.RevisionID,
+		"title":       d.Title,
+	}}})
+}
+
+func (s *Server) getDocumentRawContent(c *gin.Context) {
+	d, ok := s.document(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": gin.H{"content": d.Content}})
+}
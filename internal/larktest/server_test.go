@@ -0,0 +1,236 @@
+package larktest
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	lark "github.com/larksuite/oapi-sdk-go/v3"
+	larkdocx "github.com/larksuite/oapi-sdk-go/v3/service/docx/v1"
+	larkdrive "github.com/larksuite/oapi-sdk-go/v3/service/drive/v1"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	larkwiki "github.com/larksuite/oapi-sdk-go/v3/service/wiki/v2"
+)
+
+var testApp = App{AppID: "cli_test", AppSecret: "secret", VerificationToken: "token", EventEncryptKey: "encrypt-key"}
+
+func TestServerMessages(t *testing.T) {
+	s := NewServer(testApp)
+	defer s.Close()
+	ctx := context.Background()
+	client := lark.NewClient(testApp.AppID, testApp.AppSecret, lark.WithOpenBaseUrl(s.URL))
+
+	created, err := client.Im.Message.Create(ctx, larkim.NewCreateMessageReqBuilder().
+		ReceiveIdType(larkim.ReceiveIdTypeOpenId).
+		Body(larkim.NewCreateMessageReqBodyBuilder().
+			ReceiveId("ou_user").
+			MsgType(larkim.MsgTypeText).
+			Content(`{"text":"hello"}`).
+			Build()).
+		Build())
+	if err != nil || !created.Success() {
+		t.Fatalf("create message: %v, %+v", err, created)
+	}
+	messageID := *created.Data.MessageId
+
+	replied, err := client.Im.Message.Reply(ctx, larkim.NewReplyMessageReqBuilder().
+		MessageId(messageID).
+		Body(larkim.NewReplyMessageReqBodyBuilder().
+			MsgType(larkim.MsgTypeText).
+			Content(`{"text":"reply"}`).
+			Build()).
+		Build())
+	if err != nil || !replied.Success() {
+		t.Fatalf("reply message: %v, %+v", err, replied)
+	}
+
+	patched, err := client.Im.Message.Patch(ctx, larkim.NewPatchMessageReqBuilder().
+		MessageId(messageID).
+		Body(larkim.NewPatchMessageReqBodyBuilder().
+			Content(`{"elements":[]}`).
+			Build()).
+		Build())
+	if err != nil || !patched.Success() {
+		t.Fatalf("patch message: %v, %+v", err, patched)
+	}
+
+	messages, err := s.WaitMessages(3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Message{
+		{Op: OpCreate, MessageID: messageID, ReceiveIDType: "open_id", ReceiveID: "ou_user", MsgType: "text", Content: `{"text":"hello"}`},
+		{Op: OpReply, MessageID: *replied.Data.MessageId, ParentID: messageID, MsgType: "text", Content: `{"text":"reply"}`},
+		{Op: OpPatch, MessageID: messageID, ParentID: messageID, MsgType: "interactive", Content: `{"elements":[]}`},
+	}
+	for i, m := range messages {
+		if m != want[i] {
+			t.Errorf("messages[%d] = %+v, want %+v", i, m, want[i])
+		}
+	}
+	if got := messages[1].Text(); got != "reply" {
+		t.Errorf("reply text = %q, want reply", got)
+	}
+}
+
+func TestServerTenantToken(t *testing.T) {
+	cases := []struct {
+		name    string
+		secret  string
+		success bool
+		// 记录的消息数，获取 token 失败时不会发送消息
+		messages int
+	}{
+		{name: "valid secret", secret: testApp.AppSecret, success: true, messages: 1},
+		{name: "invalid secret", secret: "other"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// SDK 按 app id 缓存 token，每个用例使用不同的应用
+			app := testApp
+			app.AppID = "cli_" + strings.ReplaceAll(tc.name, " ", "_")
+			s := NewServer(app)
+			defer s.Close()
+			client := lark.NewClient(app.AppID, tc.secret, lark.WithOpenBaseUrl(s.URL))
+			resp, err := client.Im.Message.Create(context.Background(), larkim.NewCreateMessageReqBuilder().
+				ReceiveIdType(larkim.ReceiveIdTypeOpenId).
+				Body(larkim.NewCreateMessageReqBodyBuilder().
+					ReceiveId("ou_user").
+					MsgType(larkim.MsgTypeText).
+					Content(`{"text":"hello"}`).
+					Build()).
+				Build())
+			success := err == nil && resp.Success()
+			if success != tc.success {
+				t.Errorf("success = %v, want %v (err: %v)", success, tc.success, err)
+			}
+			if got := len(s.Messages()); got != tc.messages {
+				t.Errorf("recorded %d messages, want %d", got, tc.messages)
+			}
+		})
+	}
+}
+
+func TestServerResource(t *testing.T) {
+	s := NewServer(testApp)
+	defer s.Close()
+	ctx := context.Background()
+	client := lark.NewClient(testApp.AppID, testApp.AppSecret, lark.WithOpenBaseUrl(s.URL))
+	s.AddResource("om_1", "file_1", "report.txt", []byte("content"))
+
+	cases := []struct {
+		name      string
+		messageID string
+		fileKey   string
+		success   bool
+	}{
+		{name: "found", messageID: "om_1", fileKey: "file_1", success: true},
+		{name: "other message", messageID: "om_2", fileKey: "file_1"},
+		{name: "not found", messageID: "om_1", fileKey: "file_2"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := client.Im.MessageResource.Get(ctx, larkim.NewGetMessageResourceReqBuilder().
+				MessageId(tc.messageID).
+				FileKey(tc.fileKey).
+				Type("file").
+				Build())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Success() != tc.success {
+				t.Fatalf("success = %v, want %v: %+v", resp.Success(), tc.success, resp.CodeError)
+			}
+			if !tc.success {
+				return
+			}
+			data, err := io.ReadAll(resp.File)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.FileName != "report.txt" || string(data) != "content" {
+				t.Errorf("resource = %s %q, want report.txt %q", resp.FileName, data, "content")
+			}
+		})
+	}
+}
+
+func TestServerDocuments(t *testing.T) {
+	s := NewServer(testApp)
+	defer s.Close()
+	ctx := context.Background()
+	client := lark.NewClient(testApp.AppID, testApp.AppSecret, lark.WithOpenBaseUrl(s.URL))
+	s.AddWikiNode(WikiNode{SpaceID: "space", NodeToken: "wik_1", ObjToken: "doc_1", ObjType: "docx", Title: "手册"})
+	s.AddWikiNode(WikiNode{SpaceID: "space", NodeToken: "wik_2", ParentNodeToken: "wik_1", ObjToken: "sht_1", ObjType: "sheet", Title: "表格"})
+	s.AddWikiNode(WikiNode{SpaceID: "other", NodeToken: "wik_3", ObjToken: "doc_3", ObjType: "docx"})
+	s.AddDriveFile(DriveFile{FolderToken: "fld_1", Token: "doc_2", Name: "制度", Type: "docx", URL: "https://example.feishu.cn/docx/doc_2"})
+	s.AddDriveFile(DriveFile{FolderToken: "fld_2", Token: "doc_4", Name: "其他", Type: "docx"})
+	s.SetDocument(Document{DocumentID: "doc_1", RevisionID: 3, Title: "手册", Content: "第一行\n第二行"})
+
+	// 知识空间按父节点列出，has_child 标记是否有子节点
+	for _, tc := range []struct {
+		parent   string
+		nodes    string
+		hasChild bool
+	}{
+		{parent: "", nodes: "wik_1", hasChild: true},
+		{parent: "wik_1", nodes: "wik_2"},
+	} {
+		builder := larkwiki.NewListSpaceNodeReqBuilder().SpaceId("space")
+		if tc.parent != "" {
+			builder.ParentNodeToken(tc.parent)
+		}
+		resp, err := client.Wiki.SpaceNode.List(ctx, builder.Build())
+		if err != nil || !resp.Success() {
+			t.Fatalf("list wiki nodes of %q: %v, %+v", tc.parent, err, resp)
+		}
+		tokens := make([]string, 0)
+		for _, n := range resp.Data.Items {
+			tokens = append(tokens, *n.NodeToken)
+		}
+		if got := strings.Join(tokens, ","); got != tc.nodes {
+			t.Errorf("nodes of %q = %s, want %s", tc.parent, got, tc.nodes)
+		}
+		if len(resp.Data.Items) == 1 && *resp.Data.Items[0].HasChild != tc.hasChild {
+			t.Errorf("node %s has_child = %v, want %v", tokens[0], *resp.Data.Items[0].HasChild, tc.hasChild)
+		}
+	}
+
+	files, err := client.Drive.File.List(ctx, larkdrive.NewListFileReqBuilder().FolderToken("fld_1").Build())
+	if err != nil || !files.Success() {
+		t.Fatalf("list drive files: %v, %+v", err, files)
+	}
+	if len(files.Data.Files) != 1 || *files.Data.Files[0].Token != "doc_2" || *files.Data.Files[0].Url != "https://example.feishu.cn/docx/doc_2" {
+		t.Errorf("files = %+v, want doc_2", files.Data.Files)
+	}
+
+	doc, err := client.Docx.Document.Get(ctx, larkdocx.NewGetDocumentReqBuilder().DocumentId("doc_1").Build())
+	if err != nil || !doc.Success() {
+		t.Fatalf("get document: %v, %+v", err, doc)
+	}
+	if *doc.Data.Document.RevisionId != 3 || *doc.Data.Document.Title != "手册" {
+		t.Errorf("document = %+v, want revision 3", doc.Data.Document)
+	}
+	content, err := client.Docx.Document.RawContent(ctx, larkdocx.NewRawContentDocumentReqBuilder().DocumentId("doc_1").Build())
+	if err != nil || !content.Success() {
+		t.Fatalf("get raw content: %v, %+v", err, content)
+	}
+	if *content.Data.Content != "第一行\n第二行" {
+		t.Errorf("content = %q", *content.Data.Content)
+	}
+
+	// 删除后文档和所在的节点都不再返回
+	s.RemoveDocument("doc_1")
+	if doc, err := client.Docx.Document.Get(ctx, larkdocx.NewGetDocumentReqBuilder().DocumentId("doc_1").Build()); err != nil || doc.Success() {
+		t.Errorf("get removed document: %v, success %v, want failure", err, doc.Success())
+	}
+	nodes, err := client.Wiki.SpaceNode.List(ctx, larkwiki.NewListSpaceNodeReqBuilder().SpaceId("space").Build())
+	if err != nil || !nodes.Success() {
+		t.Fatalf("list wiki nodes: %v, %+v", err, nodes)
+	}
+	if len(nodes.Data.Items) != 0 {
+		t.Errorf("nodes after remove = %d, want 0", len(nodes.Data.Items))
+	}
+}
@@ -0,0 +1,212 @@
+package larktest
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// User 通讯录中的用户
+type User struct {
+	OpenID   string
+	Name     string
+	EnName   string
+	Email    string
+	JobTitle string
+	City     string
+	// Resigned 是否已离职
+	Resigned bool
+}
+
+// Busy 日程中的忙碌时间段
+type Busy struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Task 应用调用 task/v1/tasks 接口创建的任务
+type Task struct {
+	ID              string
+	Summary         string
+	Description     string
+	Due             string
+	CollaboratorIDs []string
+	FollowerIDs     []string
+}
+
+// ChatMessage 会话中的历史消息
+type ChatMessage struct {
+	ChatID string
+	// SenderID 发送者的 open_id，SenderType 为 user 或 app，默认 user
+	SenderID   string
+	SenderType string
+	// MsgType 默认 text
+	MsgType string
+	Content string
+	// CreateTime 为零值时使用当前时间
+	CreateTime time.Time
+}
+
+// AddUser 添加通讯录中的用户
+func (s *Server) AddUser(u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = append(s.users, u)
+}
+
+// SetFreebusy 设置用户的忙碌时间段
+func (s *Server) SetFreebusy(openID string, busy ...Busy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.busy[openID] = busy
+}
+
+// Tasks 返回创建的全部任务
+func (s *Server) Tasks() []Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Task(nil), s.tasks...)
+}
+
+// AddChatMember 将用户添加为群成员
+func (s *Server) AddChatMember(chatID, openID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.members[chatID] = append(s.members[chatID], openID)
+}
+
+// AddChatMessage 添加会话中的历史消息
+func (s *Server) AddChatMessage(m ChatMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m.SenderType == "" {
+		m.SenderType = "user"
+	}
+	if m.MsgType == "" {
+		m.MsgType = "text"
+	}
+	if m.CreateTime.IsZero() {
+		m.CreateTime = time.Now()
+	}
+	s.history = append(s.history, m)
+}
+
+// listUsers 返回全部用户，不分页
+func (s *Server) listUsers(c *gin.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]gin.H, 0, len(s.users))
+	for _, u := range s.users {
+		items = append(items, gin.H{
+			"open_id":   u.OpenID,
+			"name":      u.Name,
+			"en_name":   u.EnName,
+			"email":     u.Email,
+			"job_title": u.JobTitle,
+			"city":      u.City,
+			"status":    gin.H{"is_resigned": u.Resigned, "is_activated": !u.Resigned},
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": gin.H{"items": items, "has_more": false}})
+}
+
+// listFreebusy 返回与请求时间区间重叠的忙碌时间段
+func (s *Server) listFreebusy(c *gin.Context) {
+	var body struct {
+		TimeMin string `json:"time_min"`
+		TimeMax string `json:"time_max"`
+		UserID  string `json:"user_id"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": err.Error()})
+		return
+	}
+	min, err1 := time.Parse(time.RFC3339, body.TimeMin)
+	max, err2 := time.Parse(time.RFC3339, body.TimeMax)
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusOK, gin.H{"code": 190002, "msg": "invalid time"})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]gin.H, 0)
+	for _, b := range s.busy[body.UserID] {
+		if b.End.After(min) && b.Start.Before(max) {
+			list = append(list, gin.H{"start_time": b.Start.Format(time.RFC3339), "end_time": b.End.Format(time.RFC3339)})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": gin.H{"freebusy_list": list}})
+}
+
+func (s *Server) createTask(c *gin.Context) {
+	var body struct {
+		Summary     string `json:"summary"`
+		Description string `json:"description"`
+		Due         struct {
+			Time string `json:"time"`
+		} `json:"due"`
+		Origin struct {
+			PlatformI18nName string `json:"platform_i18n_name"`
+		} `json:"origin"`
+		CollaboratorIDs []string `json:"collaborator_ids"`
+		FollowerIDs     []string `json:"follower_ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": err.Error()})
+		return
+	}
+	if body.Summary == "" || body.Origin.PlatformI18nName == "" {
+		c.JSON(http.StatusOK, gin.H{"code": 1470400, "msg": "summary and origin are required"})
+		return
+	}
+	s.mu.Lock()
+	task := Task{
+		ID:              s.nextID("task"),
+		Summary:         body.Summary,
+		Description:     body.Description,
+		Due:             body.Due.Time,
+		CollaboratorIDs: body.CollaboratorIDs,
+		FollowerIDs:     body.FollowerIDs,
+	}
+	s.tasks = append(s.tasks, task)
+	s.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": gin.H{"task": gin.H{"id": task.ID, "summary": task.Summary}}})
+}
+
+// listChatMembers 返回群的全部成员，不分页
+func (s *Server) listChatMembers(c *gin.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]gin.H, 0)
+	for _, id := range s.members[c.Param("chat_id")] {
+		items = append(items, gin.H{"member_id_type": "open_id", "member_id": id})
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": gin.H{"items": items, "has_more": false, "member_total": len(items)}})
+}
+
+// listMessages 按发送时间升序返回会话中 start_time 之后的历史消息，不分页
+func (s *Server) listMessages(c *gin.Context) {
+	var start int64
+	if v := c.Query("start_time"); v != "" {
+		start, _ = strconv.ParseInt(v, 10, 64)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]gin.H, 0)
+	for i, m := range s.history {
+		if m.ChatID != c.Query("container_id") || m.CreateTime.Unix() < start {
+			continue
+		}
+		items = append(items, gin.H{
+			"message_id":  "om_history_" + strconv.Itoa(i),
+			"msg_type":    m.MsgType,
+			"create_time": strconv.FormatInt(m.CreateTime.UnixMilli(), 10),
+			"chat_id":     m.ChatID,
+			"sender":      gin.H{"id": m.SenderID, "id_type": "open_id", "sender_type": m.SenderType},
+			"body":        gin.H{"content": m.Content},
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": gin.H{"items": items, "has_more": false}})
+}
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const namespace = "chatgpt_lark"
+
+var (
+	// HTTPRequests HTTP 请求数
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Total number of HTTP requests by method, path and status.",
+	}, []string{"method", "path", "status"})
+
+	// HTTPDuration HTTP 请求耗时
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and path.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "path"})
+
+	// EventsReceived 收到的飞书事件数
+	EventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_received_total",
+		Help:      "Total number of Lark events received by route and event type.",
+	}, []string{"route", "type"})
+
+	// EventsRejected 未通过解密或签名校验的飞书事件数
+	EventsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_rejected_total",
+		Help:      "Total number of Lark events rejected by verification, by route and reason.",
+	}, []string{"route", "reason"})
+
+	// GPTDuration GPT 接口耗时
+	GPTDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "gpt_request_duration_seconds",
+		Help:      "GPT request latency by model, route and result.",
+		Buckets:   []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120},
+	}, []string{"model", "route", "result"})
+
+	// GPTTokens GPT 接口消耗的 token 数
+	GPTTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gpt_tokens_total",
+		Help:      "Total number of GPT tokens by model, route and kind (prompt or completion).",
+	}, []string{"model", "route", "kind"})
+
+	// LarkSendFailures 飞书消息发送失败数
+	LarkSendFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lark_send_failures_total",
+		Help:      "Total number of failed Lark message sends by message type.",
+	}, []string{"msg_type"})
+
+	// InFlight 正在处理的消息数，即处理消息的 goroutine 数量
+	InFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "messages_in_flight",
+		Help:      "Number of messages currently being processed.",
+	})
+
+	// QueueDepth 等待 GPT 并发额度的消息数
+	QueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "messages_queued",
+		Help:      "Number of messages waiting for a GPT concurrency slot.",
+	})
+
+	// Commands 命令使用次数
+	Commands = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_total",
+		Help:      "Total number of bot commands by command name.",
+	}, []string{"command"})
+
+	// ModerationFlagged 未通过内容审核的消息数
+	ModerationFlagged = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "moderation_flagged_total",
+		Help:      "Total number of prompts and replies flagged by moderation by stage and action.",
+	}, []string{"stage", "action"})
+
+	// ToolCalls GPT 调用工具的次数
+	ToolCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tool_calls_total",
+		Help:      "Total number of tool calls requested by GPT by tool name and result.",
+	}, []string{"tool", "result"})
+)
+
+// GaugeValue 返回 gauge 的当前值
+func GaugeValue(g prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	if err := g.Write(m); err != nil {
+		return 0
+	}
+	return m.GetGauge().GetValue()
+}
@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// TimestampHeader carries the unix timestamp (seconds) of a signed request.
+	TimestampHeader = "X-Admin-Timestamp"
+	// SignatureHeader carries the hex encoded HMAC-SHA256 signature of a signed request.
+	SignatureHeader = "X-Admin-Signature"
+	// NonceHeader carries a random one-time value of a signed request, so that
+	// a captured request cannot be replayed within MaxSkew.
+	NonceHeader = "X-Admin-Nonce"
+	// SessionCookie is the name of the cookie holding a signed login session.
+	SessionCookie = "admin_session"
+	// SessionHeader must be present on non-GET requests authenticated by the
+	// session cookie, so that cross-site forms cannot reuse the cookie.
+	SessionHeader = "X-Admin-UI"
+
+	defaultMaxSkew = 5 * time.Minute
+	maxNonceLength = 64
+)
+
+// NonceStore records the nonces of signed requests.
+type NonceStore interface {
+	// UseNonce records nonce until expiresAt and reports whether it has not
+	// been used before.
+	UseNonce(ctx context.Context, nonce string, expiresAt time.Time) (bool, error)
+}
+
+// AuthOptions configures AuthHandler. Empty credentials are never accepted.
+type AuthOptions struct {
+	// Token is compared with the "Authorization: Bearer <token>" header.
+	Token string
+	// HMACSecret verifies requests signed with Sign.
+	HMACSecret string
+	// MaxSkew bounds the difference between the signed timestamp and the
+	// server clock, defaulting to 5 minutes.
+	MaxSkew time.Duration
+	// Nonces rejects signed requests whose nonce has been used. It defaults
+	// to an in-memory store, which does not detect a request replayed to
+	// another instance.
+	Nonces NonceStore
+	// SessionSecret verifies session cookies created by NewSession.
+	SessionSecret string
+}
+
+// TokenAuthHandler returns a handler rejecting requests whose
+// "Authorization: Bearer <token>" header does not match the given token.
+func TokenAuthHandler(token string) gin.HandlerFunc {
+	return AuthHandler(AuthOptions{Token: token})
+}
+
+// AuthHandler returns a handler accepting requests that carry the bearer
+// token, are signed with the HMAC secret, or carry a valid session cookie.
+func AuthHandler(opts AuthOptions) gin.HandlerFunc {
+	if opts.MaxSkew <= 0 {
+		opts.MaxSkew = defaultMaxSkew
+	}
+	if opts.Nonces == nil {
+		opts.Nonces = newMemoryNonces()
+	}
+	return func(ctx *gin.Context) {
+		if validToken(ctx, opts.Token) || validSignature(ctx, opts) || validSession(ctx, opts.SessionSecret) {
+			ctx.Next()
+			return
+		}
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "unauthorized"})
+	}
+}
+
+// NewSession returns a session cookie value signed with secret that expires
+// after ttl.
+func NewSession(secret string, ttl time.Duration) string {
+	exp := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return exp + "." + signSession(secret, exp)
+}
+
+// ValidSession reports whether value is an unexpired session signed with secret.
+func ValidSession(secret, value string) bool {
+	exp, signature, ok := strings.Cut(value, ".")
+	if secret == "" || !ok {
+		return false
+	}
+	ts, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() >= ts {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(signSession(secret, exp)))
+}
+
+func signSession(secret, exp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("session\n" + exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validSession(ctx *gin.Context, secret string) bool {
+	value, err := ctx.Cookie(SessionCookie)
+	if err != nil || !ValidSession(secret, value) {
+		return false
+	}
+	switch ctx.Request.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	}
+	return ctx.GetHeader(SessionHeader) != ""
+}
+
+// Sign computes the signature of a request:
+// hex(HMAC-SHA256(secret, timestamp + "\n" + nonce + "\n" + method + "\n" + requestURI + "\n" + body)).
+func Sign(secret, timestamp, nonce, method, requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + nonce + "\n" + method + "\n" + requestURI + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validToken(ctx *gin.Context, token string) bool {
+	header := ctx.GetHeader("Authorization")
+	if token == "" || !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	got := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func validSignature(ctx *gin.Context, opts AuthOptions) bool {
+	timestamp, nonce, signature := ctx.GetHeader(TimestampHeader), ctx.GetHeader(NonceHeader), ctx.GetHeader(SignatureHeader)
+	if opts.HMACSecret == "" || timestamp == "" || nonce == "" || len(nonce) > maxNonceLength || signature == "" {
+		return false
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	signedAt := time.Unix(ts, 0)
+	if skew := time.Since(signedAt); skew > opts.MaxSkew || skew < -opts.MaxSkew {
+		return false
+	}
+
+	var body []byte
+	if ctx.Request.Body != nil {
+		body, err = io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			return false
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	want := Sign(opts.HMACSecret, timestamp, nonce, ctx.Request.Method, ctx.Request.URL.RequestURI(), body)
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(want)) {
+		return false
+	}
+	// the timestamp is rejected after MaxSkew, so the nonce only has to be
+	// remembered until then
+	first, err := opts.Nonces.UseNonce(ctx.Request.Context(), nonce, signedAt.Add(opts.MaxSkew))
+	return err == nil && first
+}
+
+// memoryNonces is a NonceStore local to the process.
+type memoryNonces struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func newMemoryNonces() *memoryNonces {
+	return &memoryNonces{nonces: make(map[string]time.Time)}
+}
+
+func (m *memoryNonces) UseNonce(_ context.Context, nonce string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for n, exp := range m.nonces {
+		if now.After(exp) {
+			delete(m.nonces, n)
+		}
+	}
+	if _, ok := m.nonces[nonce]; ok {
+		return false, nil
+	}
+	m.nonces[nonce] = expiresAt
+	return true, nil
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSignedRequest(t *testing.T) {
+	const secret = "hmac-secret"
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.POST("/admin/reload", AuthHandler(AuthOptions{HMACSecret: secret}), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	cases := []struct {
+		name      string
+		timestamp string
+		nonce     string
+		// signedNonce 签名时使用的 nonce，为空时与 nonce 相同
+		signedNonce string
+		status      int
+	}{
+		{name: "signed", timestamp: now, nonce: "n1", status: http.StatusOK},
+		{name: "replayed", timestamp: now, nonce: "n1", status: http.StatusUnauthorized},
+		{name: "new nonce", timestamp: now, nonce: "n2", status: http.StatusOK},
+		{name: "missing nonce", timestamp: now, status: http.StatusUnauthorized},
+		{name: "nonce not signed", timestamp: now, nonce: "n3", signedNonce: "n4", status: http.StatusUnauthorized},
+		{name: "stale timestamp", timestamp: stale, nonce: "n5", status: http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			signedNonce := tc.signedNonce
+			if signedNonce == "" {
+				signedNonce = tc.nonce
+			}
+			body := `{"a":1}`
+			req := httptest.NewRequest(http.MethodPost, "/admin/reload", strings.NewReader(body))
+			req.Header.Set(TimestampHeader, tc.timestamp)
+			req.Header.Set(NonceHeader, tc.nonce)
+			req.Header.Set(SignatureHeader, Sign(secret, tc.timestamp, signedNonce, http.MethodPost, "/admin/reload", []byte(body)))
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, req)
+			if w.Code != tc.status {
+				t.Errorf("status = %d, want %d", w.Code, tc.status)
+			}
+		})
+	}
+}
@@ -3,8 +3,10 @@ package middleware
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"time"
 
@@ -95,6 +97,7 @@ func RequestIDHandler(fieldKey, headerName string) gin.HandlerFunc {
 		if !ok {
 			id = xid.New()
 			r = r.WithContext(CtxWithID(r.Context(), id))
+			ctx.Request = r
 		}
 		if fieldKey != "" {
 			log := zerolog.Ctx(r.Context())
@@ -125,19 +128,53 @@ func CustomHeaderHandler(fieldKey, header string) func(next http.Handler) http.H
 	}
 }
 
-// AccessHandler returns a handler that call f after each request.
-func AccessHandler() gin.HandlerFunc {
+// BodyLimitHandler 限制请求内容的大小，超出 maxBytes 时返回 413，maxBytes 为 0 时不限制。
+// 声明了 Content-Length 的请求直接检查长度；其他请求在读取内容时限制，读取超出时返回 *http.MaxBytesError，
+// 由处理器通过 IsBodyTooLarge 判断并返回 413。内容不会被提前缓存。
+func BodyLimitHandler(maxBytes int64) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if maxBytes <= 0 || ctx.Request.Body == nil {
+			ctx.Next()
+			return
+		}
+		if ctx.Request.ContentLength > maxBytes {
+			ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"msg": "request body too large"})
+			return
+		}
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxBytes)
+		ctx.Next()
+	}
+}
+
+// IsBodyTooLarge 读取请求内容的错误是否因为超出 BodyLimitHandler 的限制
+func IsBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// AccessHandler 在 debug 级别记录请求和响应内容。内容按 opts 隐藏敏感字段、截断并采样，
+// 未开启 debug 日志或未被采样的请求不会缓存内容。
+func AccessHandler(opts AccessLogOptions) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		c := ctx.Request.Context()
+		if zerolog.GlobalLevel() > zerolog.DebugLevel || log.Ctx(c).GetLevel() > zerolog.DebugLevel ||
+			(opts.SampleRate > 0 && opts.SampleRate < 1 && rand.Float64() >= opts.SampleRate) {
+			ctx.Next()
+			return
+		}
 
 		// Request
 		start := time.Now()
 		var buf bytes.Buffer
 		tee := io.TeeReader(ctx.Request.Body, &buf)
-		body, _ := ioutil.ReadAll(tee)
+		body, err := ioutil.ReadAll(tee)
+		if err != nil && IsBodyTooLarge(err) {
+			ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"msg": "request body too large"})
+			return
+		}
 		ctx.Request.Body = ioutil.NopCloser(&buf)
 
-		log.Ctx(c).Debug().Str("request", string(body)).Msg("收到请求")
+		log.Ctx(c).Debug().Str("request", opts.redactBody(body)).Msg("收到请求")
 		ww := &bodyLogWriter{
 			ResponseWriter: ctx.Writer,
 			body:           bytes.NewBufferString(""),
@@ -148,7 +185,7 @@ func AccessHandler() gin.HandlerFunc {
 
 		// Response
 		log.Ctx(c).Debug().
-			Str("response", ww.body.String()).
+			Str("response", opts.redactBody(ww.body.Bytes())).
 			Int("status", ww.Status()).
 			Dur("duration", time.Since(start)).
 			Msg("返回响应")
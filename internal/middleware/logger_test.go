@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBodyLimitHandler(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	e := gin.New()
+	e.Use(BodyLimitHandler(8))
+	e.POST("/", func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if IsBodyTooLarge(err) {
+			c.Status(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.String(http.StatusOK, string(body))
+	})
+
+	cases := []struct {
+		name          string
+		body          string
+		contentLength int64
+		status        int
+	}{
+		{name: "within limit", body: "12345678", contentLength: 8, status: http.StatusOK},
+		{name: "content length too large", body: "123456789", contentLength: 9, status: http.StatusRequestEntityTooLarge},
+		{name: "chunked too large", body: "123456789", contentLength: -1, status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+			req.ContentLength = tc.contentLength
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, req)
+			if w.Code != tc.status {
+				t.Errorf("status = %d, want %d", w.Code, tc.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/fanchunke/chatgpt-lark/internal/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsHandler returns a handler recording the request count and latency.
+// The matched route pattern is used as path label to keep cardinality low.
+func MetricsHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+
+		path := ctx.FullPath()
+		if path == "" {
+			path = "unmatched"
+		}
+		method := ctx.Request.Method
+		metrics.HTTPRequests.WithLabelValues(method, path, strconv.Itoa(ctx.Writer.Status())).Inc()
+		metrics.HTTPDuration.WithLabelValues(method, path).Observe(time.Since(start).Seconds())
+	}
+}
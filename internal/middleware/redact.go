@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const redactedValue = "******"
+
+// AccessLogOptions 访问日志中请求和响应内容的记录方式
+type AccessLogOptions struct {
+	// 记录的请求和响应内容的最大长度，超出部分被截断，为 0 时不截断
+	MaxBodyLength int
+	// JSON 内容中需要隐藏的字段名，不区分大小写，对任意层级的字段生效
+	RedactFields []string
+	// 记录请求和响应内容的请求比例，取值 (0, 1]，为 0 时全部记录
+	SampleRate float64
+}
+
+// redactBody 隐藏 JSON 内容中的敏感字段并截断过长的内容，非 JSON 内容只截断
+func (o AccessLogOptions) redactBody(body []byte) string {
+	content := string(body)
+	if len(o.RedactFields) > 0 {
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err == nil {
+			fields := make(map[string]bool, len(o.RedactFields))
+			for _, f := range o.RedactFields {
+				fields[strings.ToLower(f)] = true
+			}
+			if b, err := json.Marshal(redactJSON(v, fields)); err == nil {
+				content = string(b)
+			}
+		}
+	}
+	if o.MaxBodyLength > 0 && len(content) > o.MaxBodyLength {
+		// 回退到字符边界，避免截断多字节字符
+		n := o.MaxBodyLength
+		for n > 0 && content[n]&0xC0 == 0x80 {
+			n--
+		}
+		content = fmt.Sprintf("%s...(truncated, %d bytes)", content[:n], len(content))
+	}
+	return content
+}
+
+func redactJSON(v interface{}, fields map[string]bool) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		for k, child := range vv {
+			if fields[strings.ToLower(k)] {
+				vv[k] = redactedValue
+				continue
+			}
+			vv[k] = redactJSON(child, fields)
+		}
+	case []interface{}:
+		for i, child := range vv {
+			vv[i] = redactJSON(child, fields)
+		}
+	}
+	return v
+}
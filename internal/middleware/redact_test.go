@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRedactBody(t *testing.T) {
+	cases := []struct {
+		name string
+		opts AccessLogOptions
+		body string
+		want string
+	}{
+		{
+			name: "redact fields",
+			opts: AccessLogOptions{RedactFields: []string{"Token"}},
+			body: `{"header":{"token":"secret"},"text":"hello"}`,
+			want: `{"header":{"token":"******"},"text":"hello"}`,
+		},
+		{
+			name: "truncate ascii",
+			opts: AccessLogOptions{MaxBodyLength: 5},
+			body: "hello world",
+			want: "hello...(truncated, 11 bytes)",
+		},
+		{
+			// “你好世界”每个字 3 个字节，第 5 个字节在“好”的中间
+			name: "truncate at rune boundary",
+			opts: AccessLogOptions{MaxBodyLength: 5},
+			body: "你好世界",
+			want: "你...(truncated, 12 bytes)",
+		},
+		{
+			name: "not truncated",
+			opts: AccessLogOptions{MaxBodyLength: 12},
+			body: "你好世界",
+			want: "你好世界",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.opts.redactBody([]byte(tc.body))
+			if got != tc.want {
+				t.Errorf("redactBody() = %q, want %q", got, tc.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("redactBody() = %q is not valid UTF-8", got)
+			}
+		})
+	}
+}
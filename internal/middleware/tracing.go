@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"github.com/fanchunke/chatgpt-lark/internal/tracing"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingHandler 为每个请求创建 span，并从请求头中恢复上游的 trace context
+func TracingHandler(service string) gin.HandlerFunc {
+	return otelgin.Middleware(service)
+}
+
+// TraceIDHandler adds the trace id as a field to the context's logger using
+// fieldKey as field key, and records the request id on the current span.
+// It must be used after TracingHandler and RequestIDHandler.
+func TraceIDHandler(fieldKey string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		c := ctx.Request.Context()
+		if id, ok := IDFromCtx(c); ok {
+			trace.SpanFromContext(c).SetAttributes(attribute.String("request.id", id.String()))
+		}
+		if traceId := tracing.TraceID(c); traceId != "" {
+			log := zerolog.Ctx(c)
+			log.UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str(fieldKey, traceId)
+			})
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,375 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"entgo.io/ent/dialect"
+	entsql "entgo.io/ent/dialect/sql"
+)
+
+//go:embed migrations
+var migrationsFS embed.FS
+
+const (
+	versionTable = "schema_migrations"
+	// lockName 迁移锁的名称，同时启动的多个副本只有一个能执行迁移
+	lockName = "chatgpt_lark_schema_migrations"
+	// lockTimeout MySQL 等待迁移锁的秒数
+	lockTimeout = 300
+)
+
+var (
+	// ErrSchemaTooNew 数据库的版本高于程序支持的版本
+	ErrSchemaTooNew = errors.New("migrate: database schema is newer than expected")
+	// ErrPendingMigrations 数据库存在未执行的迁移
+	ErrPendingMigrations = errors.New("migrate: database schema has pending migrations")
+)
+
+var fileRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration 一个版本的迁移，包含升级和回滚的 SQL
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status 迁移的执行状态
+type Status struct {
+	*Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator 执行 migrations/<dialect> 目录下的版本化迁移，已执行的版本记录在 schema_migrations 表中
+type Migrator struct {
+	db         *sql.DB
+	dialect    string
+	migrations []*Migration
+}
+
+func New(db *sql.DB, dialect string) (*Migrator, error) {
+	migrations, err := load(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+func load(dialect string) ([]*Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationsFS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: unsupported dialect %s: %w", dialect, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		matches := fileRegexp.FindStringSubmatch(e.Name())
+		if matches == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(matches[1])
+		content, err := fs.ReadFile(migrationsFS, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("migrate: read %s failed: %w", e.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		}
+		if matches[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migrate: version %d has no up migration", m.Version)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Latest 程序支持的最新版本
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) ensureVersionTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+versionTable+
+		" (version bigint NOT NULL PRIMARY KEY, name varchar(255) NOT NULL, applied_at timestamp NOT NULL)")
+	if err != nil {
+		return fmt.Errorf("migrate: create %s failed: %w", versionTable, err)
+	}
+	return nil
+}
+
+// applied 获取已执行的版本及其执行时间
+func (m *Migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := m.db.QueryContext(ctx, "SELECT version, applied_at FROM "+versionTable)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: query %s failed: %w", versionTable, err)
+	}
+	defer rows.Close()
+
+	result := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("migrate: scan %s failed: %w", versionTable, err)
+		}
+		result[version] = appliedAt
+	}
+	return result, rows.Err()
+}
+
+// Version 数据库当前的版本，即已执行的最大版本
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// Status 获取全部迁移的执行状态
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Status, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		at, ok := applied[mg.Version]
+		result = append(result, &Status{Migration: mg, Applied: ok, AppliedAt: at})
+	}
+	return result, nil
+}
+
+// Check 检查数据库版本。版本高于程序支持的版本时返回 ErrSchemaTooNew，存在未执行的迁移时返回 ErrPendingMigrations。
+func (m *Migrator) Check(ctx context.Context) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version > m.Latest() {
+		return fmt.Errorf("%w: database version %d, expected %d", ErrSchemaTooNew, version, m.Latest())
+	}
+	for _, s := range status {
+		if !s.Applied {
+			return fmt.Errorf("%w: version %d (%s) is not applied", ErrPendingMigrations, s.Version, s.Name)
+		}
+	}
+	return nil
+}
+
+// lock 获取迁移锁，返回释放锁的函数。PostgreSQL 使用 advisory lock，MySQL 使用 GET_LOCK，
+// 锁与连接绑定，连接断开时自动释放。sqlite3 只能被单机访问，依赖数据库自身的写锁。
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	if m.dialect != dialect.Postgres && m.dialect != dialect.MySQL {
+		return func() {}, nil
+	}
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: get connection failed: %w", err)
+	}
+
+	var release func()
+	if m.dialect == dialect.Postgres {
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey())
+		release = func() {
+			_, _ = conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey())
+		}
+	} else {
+		var acquired sql.NullInt64
+		err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, lockTimeout).Scan(&acquired)
+		if err == nil && acquired.Int64 != 1 {
+			err = fmt.Errorf("timeout after %ds", lockTimeout)
+		}
+		release = func() {
+			_, _ = conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", lockName)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("migrate: acquire lock failed: %w", err)
+	}
+	return func() {
+		release()
+		conn.Close()
+	}, nil
+}
+
+// lockKey PostgreSQL advisory lock 的 key
+func lockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(lockName))
+	return int64(h.Sum64())
+}
+
+// Up 按顺序执行未执行的迁移，steps 为 0 时执行全部。多个副本同时执行时通过迁移锁串行执行。
+func (m *Migrator) Up(ctx context.Context, steps int) ([]*Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	version, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if version > m.Latest() {
+		return nil, fmt.Errorf("%w: database version %d, expected %d", ErrSchemaTooNew, version, m.Latest())
+	}
+	status, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make([]*Migration, 0)
+	for _, s := range status {
+		if s.Applied {
+			continue
+		}
+		if steps > 0 && len(done) >= steps {
+			break
+		}
+		err := m.run(ctx, s.Migration, s.Up, func(tx *sql.Tx) error {
+			q, args := entsql.Dialect(m.dialect).Insert(versionTable).
+				Columns("version", "name", "applied_at").
+				Values(s.Version, s.Name, time.Now()).
+				Query()
+			_, err := tx.ExecContext(ctx, q, args...)
+			return err
+		})
+		if err != nil {
+			return done, err
+		}
+		done = append(done, s.Migration)
+	}
+	return done, nil
+}
+
+// Down 按倒序回滚已执行的迁移，steps 为 0 时回滚一个版本
+func (m *Migrator) Down(ctx context.Context, steps int) ([]*Migration, error) {
+	if steps <= 0 {
+		steps = 1
+	}
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make([]*Migration, 0)
+	for i := len(status) - 1; i >= 0 && len(done) < steps; i-- {
+		s := status[i]
+		if !s.Applied {
+			continue
+		}
+		if s.Down == "" {
+			return done, fmt.Errorf("migrate: version %d has no down migration", s.Version)
+		}
+		err := m.run(ctx, s.Migration, s.Down, func(tx *sql.Tx) error {
+			q, args := entsql.Dialect(m.dialect).Delete(versionTable).
+				Where(entsql.EQ("version", s.Version)).
+				Query()
+			_, err := tx.ExecContext(ctx, q, args...)
+			return err
+		})
+		if err != nil {
+			return done, err
+		}
+		done = append(done, s.Migration)
+	}
+	return done, nil
+}
+
+// run 在事务中执行迁移脚本并更新版本记录。MySQL 的 DDL 不支持事务，失败时需要人工处理。
+func (m *Migrator) run(ctx context.Context, mg *Migration, script string, record func(tx *sql.Tx) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("migrate: start transaction failed: %w", err)
+	}
+	for _, stmt := range splitStatements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migrate: version %d (%s) failed: %w", mg.Version, mg.Name, err)
+		}
+	}
+	if err := record(tx); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("migrate: record version %d failed: %w", mg.Version, err)
+	}
+	return tx.Commit()
+}
+
+// splitStatements 按行尾的分号拆分 SQL 脚本
+func splitStatements(script string) []string {
+	stmts := make([]string, 0)
+	var b strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSpace(b.String()))
+			b.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(b.String()); rest != "" {
+		stmts = append(stmts, rest)
+	}
+	return stmts
+}
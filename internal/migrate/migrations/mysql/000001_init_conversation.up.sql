@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS `sessions` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `user_id` varchar(50) NOT NULL,
+  `status` bool NOT NULL DEFAULT 0,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `deleted_at` bigint NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`),
+  INDEX `session_status_user_id` (`status`, `user_id`)
+) CHARSET utf8mb4 COLLATE utf8mb4_bin;
+CREATE TABLE IF NOT EXISTS `messages` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `from_user_id` varchar(50) NOT NULL,
+  `to_user_id` varchar(50) NOT NULL,
+  `content` longtext NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `spouse_id` bigint NULL,
+  `session_id` bigint NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `spouse_id` (`spouse_id`),
+  INDEX `message_session_id_from_user_id_created_at` (`session_id`, `from_user_id`, `created_at`),
+  INDEX `message_session_id_to_user_id_created_at` (`session_id`, `to_user_id`, `created_at`),
+  CONSTRAINT `messages_messages_spouse` FOREIGN KEY (`spouse_id`) REFERENCES `messages` (`id`) ON DELETE SET NULL,
+  CONSTRAINT `messages_sessions_messages` FOREIGN KEY (`session_id`) REFERENCES `sessions` (`id`) ON DELETE SET NULL
+) CHARSET utf8mb4 COLLATE utf8mb4_bin;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `user_sessions` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `user_id` varchar(50) NOT NULL,
+  `name` varchar(32) NOT NULL,
+  `conversation_key` varchar(50) NOT NULL,
+  `system_prompt` longtext NOT NULL,
+  `model` varchar(64) NOT NULL DEFAULT '',
+  `active` bool NOT NULL DEFAULT 0,
+  `created_at` timestamp NOT NULL,
+  `updated_at` timestamp NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `usersession_user_id_name` (`user_id`, `name`),
+  INDEX `usersession_conversation_key` (`conversation_key`)
+) CHARSET utf8mb4 COLLATE utf8mb4_bin;
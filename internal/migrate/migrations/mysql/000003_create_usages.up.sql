@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `usages` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `conversation_key` varchar(50) NOT NULL,
+  `message_id` bigint NOT NULL DEFAULT 0,
+  `model` varchar(64) NOT NULL,
+  `prompt_tokens` bigint NOT NULL DEFAULT 0,
+  `completion_tokens` bigint NOT NULL DEFAULT 0,
+  `total_tokens` bigint NOT NULL DEFAULT 0,
+  `created_at` timestamp NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `usage_conversation_key_created_at` (`conversation_key`, `created_at`),
+  INDEX `usage_message_id` (`message_id`)
+) CHARSET utf8mb4 COLLATE utf8mb4_bin;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `app_settings` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `app` varchar(64) NOT NULL,
+  `name` varchar(32) NOT NULL,
+  `value` longtext NOT NULL,
+  `updated_at` timestamp NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `appsetting_app_name` (`app`, `name`)
+) CHARSET utf8mb4 COLLATE utf8mb4_bin;
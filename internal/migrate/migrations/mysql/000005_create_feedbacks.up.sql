@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `feedbacks` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `user_id` varchar(50) NOT NULL,
+  `conversation_key` varchar(50) NOT NULL,
+  `message_id` bigint NOT NULL DEFAULT 0,
+  `rating` bigint NOT NULL,
+  `comment` longtext NOT NULL,
+  `created_at` timestamp NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `feedback_rating_created_at` (`rating`, `created_at`)
+) CHARSET utf8mb4 COLLATE utf8mb4_bin;
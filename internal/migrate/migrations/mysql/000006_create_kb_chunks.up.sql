@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `kb_chunks` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `kb` varchar(64) NOT NULL,
+  `source` varchar(255) NOT NULL,
+  `source_hash` varchar(64) NOT NULL,
+  `chunk_index` bigint NOT NULL DEFAULT 0,
+  `title` longtext NOT NULL,
+  `content` longtext NOT NULL,
+  `model` varchar(64) NOT NULL,
+  `embedding` longtext NOT NULL,
+  `created_at` timestamp NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `kbchunk_kb_source` (`kb`, `source`)
+) CHARSET utf8mb4 COLLATE utf8mb4_bin;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `received_messages` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `app` varchar(64) NOT NULL,
+  `message_id` varchar(64) NOT NULL,
+  `created_at` timestamp NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `receivedmessage_app_message_id` (`app`, `message_id`),
+  INDEX `receivedmessage_created_at` (`created_at`)
+) CHARSET utf8mb4 COLLATE utf8mb4_bin;
@@ -0,0 +1,3 @@
+ALTER TABLE `usages` DROP COLUMN `app`;
+DELETE FROM `user_sessions` WHERE `app` <> '';
+ALTER TABLE `user_sessions` DROP INDEX `usersession_app_user_id_name`, ADD UNIQUE INDEX `usersession_user_id_name` (`user_id`, `name`), DROP COLUMN `app`;
@@ -0,0 +1,2 @@
+ALTER TABLE `user_sessions` ADD COLUMN `app` varchar(64) NOT NULL DEFAULT '', DROP INDEX `usersession_user_id_name`, ADD UNIQUE INDEX `usersession_app_user_id_name` (`app`, `user_id`, `name`);
+ALTER TABLE `usages` ADD COLUMN `app` varchar(64) NOT NULL DEFAULT '';
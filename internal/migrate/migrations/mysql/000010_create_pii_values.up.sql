@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `pii_values` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `app` varchar(64) NOT NULL,
+  `user_id` varchar(64) NOT NULL,
+  `placeholder` varchar(64) NOT NULL,
+  `value` longtext NOT NULL,
+  `expires_at` timestamp NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `piivalue_app_user_id_placeholder` (`app`, `user_id`, `placeholder`),
+  INDEX `piivalue_expires_at` (`expires_at`)
+) CHARSET utf8mb4 COLLATE utf8mb4_bin;
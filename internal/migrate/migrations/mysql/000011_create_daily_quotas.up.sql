@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `daily_quotas` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `app` varchar(64) NOT NULL,
+  `user_id` varchar(64) NOT NULL,
+  `day` varchar(10) NOT NULL,
+  `messages` bigint NOT NULL DEFAULT 0,
+  `tokens` bigint NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `dailyquota_app_user_id_day` (`app`, `user_id`, `day`),
+  INDEX `dailyquota_day` (`day`)
+) CHARSET utf8mb4 COLLATE utf8mb4_bin;
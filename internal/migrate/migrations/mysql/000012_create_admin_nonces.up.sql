@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS `admin_nonces` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `nonce` varchar(64) NOT NULL,
+  `expires_at` timestamp NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `adminnonce_nonce` (`nonce`),
+  INDEX `adminnonce_expires_at` (`expires_at`)
+) CHARSET utf8mb4 COLLATE utf8mb4_bin;
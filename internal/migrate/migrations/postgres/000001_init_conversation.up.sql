@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS "sessions" (
+  "id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY,
+  "user_id" character varying(50) NOT NULL,
+  "status" boolean NOT NULL DEFAULT false,
+  "created_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "updated_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "deleted_at" bigint NOT NULL DEFAULT 0,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "session_status_user_id" ON "sessions" ("status", "user_id");
+CREATE TABLE IF NOT EXISTS "messages" (
+  "id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY,
+  "from_user_id" character varying(50) NOT NULL,
+  "to_user_id" character varying(50) NOT NULL,
+  "content" text NOT NULL,
+  "created_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "spouse_id" bigint NULL,
+  "session_id" bigint NULL,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "messages_messages_spouse" FOREIGN KEY ("spouse_id") REFERENCES "messages" ("id") ON DELETE SET NULL,
+  CONSTRAINT "messages_sessions_messages" FOREIGN KEY ("session_id") REFERENCES "sessions" ("id") ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "messages_spouse_id_key" ON "messages" ("spouse_id");
+CREATE INDEX IF NOT EXISTS "message_session_id_from_user_id_created_at" ON "messages" ("session_id", "from_user_id", "created_at");
+CREATE INDEX IF NOT EXISTS "message_session_id_to_user_id_created_at" ON "messages" ("session_id", "to_user_id", "created_at");
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS "user_sessions" (
+  "id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY,
+  "user_id" character varying(50) NOT NULL,
+  "name" character varying(32) NOT NULL,
+  "conversation_key" character varying(50) NOT NULL,
+  "system_prompt" text NOT NULL DEFAULT '',
+  "model" character varying(64) NOT NULL DEFAULT '',
+  "active" boolean NOT NULL DEFAULT false,
+  "created_at" timestamptz NOT NULL,
+  "updated_at" timestamptz NOT NULL,
+  PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "usersession_user_id_name" ON "user_sessions" ("user_id", "name");
+CREATE INDEX IF NOT EXISTS "usersession_conversation_key" ON "user_sessions" ("conversation_key");
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS "usages" (
+  "id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY,
+  "conversation_key" character varying(50) NOT NULL,
+  "message_id" bigint NOT NULL DEFAULT 0,
+  "model" character varying(64) NOT NULL,
+  "prompt_tokens" bigint NOT NULL DEFAULT 0,
+  "completion_tokens" bigint NOT NULL DEFAULT 0,
+  "total_tokens" bigint NOT NULL DEFAULT 0,
+  "created_at" timestamptz NOT NULL,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "usage_conversation_key_created_at" ON "usages" ("conversation_key", "created_at");
+CREATE INDEX IF NOT EXISTS "usage_message_id" ON "usages" ("message_id");
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS "app_settings" (
+  "id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY,
+  "app" character varying(64) NOT NULL,
+  "name" character varying(32) NOT NULL,
+  "value" text NOT NULL,
+  "updated_at" timestamptz NOT NULL,
+  PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "appsetting_app_name" ON "app_settings" ("app", "name");
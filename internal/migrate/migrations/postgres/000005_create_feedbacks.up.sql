@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS "feedbacks" (
+  "id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY,
+  "user_id" character varying(50) NOT NULL,
+  "conversation_key" character varying(50) NOT NULL,
+  "message_id" bigint NOT NULL DEFAULT 0,
+  "rating" bigint NOT NULL,
+  "comment" text NOT NULL,
+  "created_at" timestamptz NOT NULL,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "feedback_rating_created_at" ON "feedbacks" ("rating", "created_at");
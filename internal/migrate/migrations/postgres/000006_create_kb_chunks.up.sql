@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS "kb_chunks" (
+  "id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY,
+  "kb" character varying(64) NOT NULL,
+  "source" character varying(255) NOT NULL,
+  "source_hash" character varying(64) NOT NULL,
+  "chunk_index" bigint NOT NULL DEFAULT 0,
+  "title" text NOT NULL,
+  "content" text NOT NULL,
+  "model" character varying(64) NOT NULL,
+  "embedding" text NOT NULL,
+  "created_at" timestamptz NOT NULL,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "kbchunk_kb_source" ON "kb_chunks" ("kb", "source");
@@ -0,0 +1 @@
+ALTER TABLE "user_sessions" ADD COLUMN "idle_expired_at" timestamptz NULL;
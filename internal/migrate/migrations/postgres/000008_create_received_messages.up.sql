@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS "received_messages" (
+  "id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY,
+  "app" character varying(64) NOT NULL,
+  "message_id" character varying(64) NOT NULL,
+  "created_at" timestamptz NOT NULL,
+  PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "receivedmessage_app_message_id" ON "received_messages" ("app", "message_id");
+CREATE INDEX IF NOT EXISTS "receivedmessage_created_at" ON "received_messages" ("created_at");
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS "pii_values" (
+  "id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY,
+  "app" character varying(64) NOT NULL,
+  "user_id" character varying(64) NOT NULL,
+  "placeholder" character varying(64) NOT NULL,
+  "value" text NOT NULL,
+  "expires_at" timestamptz NOT NULL,
+  PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "piivalue_app_user_id_placeholder" ON "pii_values" ("app", "user_id", "placeholder");
+CREATE INDEX IF NOT EXISTS "piivalue_expires_at" ON "pii_values" ("expires_at");
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS "daily_quotas" (
+  "id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY,
+  "app" character varying(64) NOT NULL,
+  "user_id" character varying(64) NOT NULL,
+  "day" character varying(10) NOT NULL,
+  "messages" bigint NOT NULL DEFAULT 0,
+  "tokens" bigint NOT NULL DEFAULT 0,
+  PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "dailyquota_app_user_id_day" ON "daily_quotas" ("app", "user_id", "day");
+CREATE INDEX IF NOT EXISTS "dailyquota_day" ON "daily_quotas" ("day");
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS "admin_nonces" (
+  "id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY,
+  "nonce" character varying(64) NOT NULL,
+  "expires_at" timestamptz NOT NULL,
+  PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "adminnonce_nonce" ON "admin_nonces" ("nonce");
+CREATE INDEX IF NOT EXISTS "adminnonce_expires_at" ON "admin_nonces" ("expires_at");
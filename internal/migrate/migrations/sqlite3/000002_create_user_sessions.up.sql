@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS `user_sessions` (`id` integer NOT NULL PRIMARY KEY AUTOINCREMENT, `user_id` text NOT NULL, `name` text NOT NULL, `conversation_key` text NOT NULL, `system_prompt` text NOT NULL DEFAULT '', `model` text NOT NULL DEFAULT '', `active` bool NOT NULL DEFAULT false, `created_at` datetime NOT NULL, `updated_at` datetime NOT NULL);
+CREATE UNIQUE INDEX IF NOT EXISTS `usersession_user_id_name` ON `user_sessions` (`user_id`, `name`);
+CREATE INDEX IF NOT EXISTS `usersession_conversation_key` ON `user_sessions` (`conversation_key`);
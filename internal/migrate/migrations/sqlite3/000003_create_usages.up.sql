@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS `usages` (`id` integer NOT NULL PRIMARY KEY AUTOINCREMENT, `conversation_key` text NOT NULL, `message_id` integer NOT NULL DEFAULT 0, `model` text NOT NULL, `prompt_tokens` integer NOT NULL DEFAULT 0, `completion_tokens` integer NOT NULL DEFAULT 0, `total_tokens` integer NOT NULL DEFAULT 0, `created_at` datetime NOT NULL);
+CREATE INDEX IF NOT EXISTS `usage_conversation_key_created_at` ON `usages` (`conversation_key`, `created_at`);
+CREATE INDEX IF NOT EXISTS `usage_message_id` ON `usages` (`message_id`);
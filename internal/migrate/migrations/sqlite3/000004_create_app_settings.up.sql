@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS `app_settings` (`id` integer NOT NULL PRIMARY KEY AUTOINCREMENT, `app` text NOT NULL, `name` text NOT NULL, `value` text NOT NULL, `updated_at` datetime NOT NULL);
+CREATE UNIQUE INDEX IF NOT EXISTS `appsetting_app_name` ON `app_settings` (`app`, `name`);
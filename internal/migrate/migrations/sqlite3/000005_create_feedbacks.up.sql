@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS `feedbacks` (`id` integer NOT NULL PRIMARY KEY AUTOINCREMENT, `user_id` text NOT NULL, `conversation_key` text NOT NULL, `message_id` integer NOT NULL DEFAULT 0, `rating` integer NOT NULL, `comment` text NOT NULL, `created_at` datetime NOT NULL);
+CREATE INDEX IF NOT EXISTS `feedback_rating_created_at` ON `feedbacks` (`rating`, `created_at`);
@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS `kb_chunks` (`id` integer NOT NULL PRIMARY KEY AUTOINCREMENT, `kb` text NOT NULL, `source` text NOT NULL, `source_hash` text NOT NULL, `chunk_index` integer NOT NULL DEFAULT 0, `title` text NOT NULL, `content` text NOT NULL, `model` text NOT NULL, `embedding` text NOT NULL, `created_at` datetime NOT NULL);
+CREATE INDEX IF NOT EXISTS `kbchunk_kb_source` ON `kb_chunks` (`kb`, `source`);
@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS `received_messages` (`id` integer NOT NULL PRIMARY KEY AUTOINCREMENT, `app` text NOT NULL, `message_id` text NOT NULL, `created_at` datetime NOT NULL);
+CREATE UNIQUE INDEX IF NOT EXISTS `receivedmessage_app_message_id` ON `received_messages` (`app`, `message_id`);
+CREATE INDEX IF NOT EXISTS `receivedmessage_created_at` ON `received_messages` (`created_at`);
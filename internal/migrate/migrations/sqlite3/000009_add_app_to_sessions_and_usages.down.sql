@@ -0,0 +1,5 @@
+ALTER TABLE `usages` DROP COLUMN `app`;
+DELETE FROM `user_sessions` WHERE `app` <> '';
+DROP INDEX IF EXISTS `usersession_app_user_id_name`;
+CREATE UNIQUE INDEX IF NOT EXISTS `usersession_user_id_name` ON `user_sessions` (`user_id`, `name`);
+ALTER TABLE `user_sessions` DROP COLUMN `app`;
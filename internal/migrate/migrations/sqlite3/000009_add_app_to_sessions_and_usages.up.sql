@@ -0,0 +1,4 @@
+ALTER TABLE `user_sessions` ADD COLUMN `app` text NOT NULL DEFAULT '';
+DROP INDEX IF EXISTS `usersession_user_id_name`;
+CREATE UNIQUE INDEX IF NOT EXISTS `usersession_app_user_id_name` ON `user_sessions` (`app`, `user_id`, `name`);
+ALTER TABLE `usages` ADD COLUMN `app` text NOT NULL DEFAULT '';
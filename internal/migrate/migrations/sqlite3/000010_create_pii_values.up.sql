@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS `pii_values` (`id` integer NOT NULL PRIMARY KEY AUTOINCREMENT, `app` text NOT NULL, `user_id` text NOT NULL, `placeholder` text NOT NULL, `value` text NOT NULL, `expires_at` datetime NOT NULL);
+CREATE UNIQUE INDEX IF NOT EXISTS `piivalue_app_user_id_placeholder` ON `pii_values` (`app`, `user_id`, `placeholder`);
+CREATE INDEX IF NOT EXISTS `piivalue_expires_at` ON `pii_values` (`expires_at`);
@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS `daily_quotas` (`id` integer NOT NULL PRIMARY KEY AUTOINCREMENT, `app` text NOT NULL, `user_id` text NOT NULL, `day` text NOT NULL, `messages` integer NOT NULL DEFAULT 0, `tokens` integer NOT NULL DEFAULT 0);
+CREATE UNIQUE INDEX IF NOT EXISTS `dailyquota_app_user_id_day` ON `daily_quotas` (`app`, `user_id`, `day`);
+CREATE INDEX IF NOT EXISTS `dailyquota_day` ON `daily_quotas` (`day`);
@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS `admin_nonces` (`id` integer NOT NULL PRIMARY KEY AUTOINCREMENT, `nonce` text NOT NULL, `expires_at` datetime NOT NULL);
+CREATE UNIQUE INDEX IF NOT EXISTS `adminnonce_nonce` ON `admin_nonces` (`nonce`);
+CREATE INDEX IF NOT EXISTS `adminnonce_expires_at` ON `admin_nonces` (`expires_at`);
@@ -0,0 +1,149 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Action 内容未通过审核时的处理方式
+type Action string
+
+const (
+	// ActionBlock 拦截内容，回复用户内容未通过审核
+	ActionBlock Action = "block"
+	// ActionWarn 继续处理，并提醒用户内容可能不合规
+	ActionWarn Action = "warn"
+	// ActionRedact 隐藏命中关键词的内容后继续处理，OpenAI 审核命中时按 block 处理
+	ActionRedact Action = "redact"
+	// ActionLog 只记录日志
+	ActionLog Action = "log"
+)
+
+// ParseAction 解析处理方式，为空时默认 block
+func ParseAction(s string) (Action, error) {
+	switch a := Action(s); a {
+	case "":
+		return ActionBlock, nil
+	case ActionBlock, ActionWarn, ActionRedact, ActionLog:
+		return a, nil
+	}
+	return "", fmt.Errorf("unsupported moderation action %q, supported actions: block, warn, redact, log", s)
+}
+
+const (
+	// CategoryKeyword 命中本地关键词或正则
+	CategoryKeyword = "keyword"
+	redactedText    = "***"
+)
+
+// Verdict 审核结果
+type Verdict struct {
+	Flagged    bool
+	Categories []string
+	// 是否命中本地关键词或正则，命中时可以通过 Redact 隐藏
+	KeywordMatched bool
+	// 是否被 OpenAI moderation 接口判定为违规，这部分内容无法通过 Redact 隐藏
+	OpenAIFlagged bool
+}
+
+// Redactable 是否只命中了本地关键词或正则，可以通过 Redact 隐藏全部违规内容
+func (v *Verdict) Redactable() bool {
+	return v.KeywordMatched && !v.OpenAIFlagged
+}
+
+func (v *Verdict) String() string {
+	if !v.Flagged {
+		return "pass"
+	}
+	return fmt.Sprintf("flagged(%s)", strings.Join(v.Categories, ","))
+}
+
+// Moderator 使用 OpenAI moderation 接口和本地关键词、正则审核内容
+type Moderator struct {
+	client   *openai.Client
+	patterns []*regexp.Regexp
+}
+
+// New client 为 nil 时不调用 OpenAI moderation 接口。keywords 不区分大小写，patterns 为正则表达式。
+func New(client *openai.Client, keywords, patterns []string) (*Moderator, error) {
+	m := &Moderator{client: client}
+	for _, k := range keywords {
+		if k == "" {
+			continue
+		}
+		m.patterns = append(m.patterns, regexp.MustCompile("(?i)"+regexp.QuoteMeta(k)))
+	}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid moderation pattern %q: %w", p, err)
+		}
+		m.patterns = append(m.patterns, re)
+	}
+	return m, nil
+}
+
+// Enabled 是否配置了任一审核方式
+func (m *Moderator) Enabled() bool {
+	return m.client != nil || len(m.patterns) > 0
+}
+
+// Check 审核内容。OpenAI 接口请求失败时返回错误，调用方决定是否放行。
+func (m *Moderator) Check(ctx context.Context, text string) (*Verdict, error) {
+	v := &Verdict{}
+	for _, re := range m.patterns {
+		if re.MatchString(text) {
+			v.Flagged, v.KeywordMatched = true, true
+			v.Categories = append(v.Categories, CategoryKeyword)
+			break
+		}
+	}
+	if m.client == nil {
+		return v, nil
+	}
+
+	resp, err := m.client.Moderations(ctx, openai.ModerationRequest{Input: text})
+	if err != nil {
+		return v, fmt.Errorf("openai moderation failed: %w", err)
+	}
+	for _, r := range resp.Results {
+		if !r.Flagged {
+			continue
+		}
+		v.Flagged, v.OpenAIFlagged = true, true
+		v.Categories = append(v.Categories, categories(r.Categories)...)
+	}
+	return v, nil
+}
+
+// Redact 将命中本地关键词和正则的内容替换为 ***
+func (m *Moderator) Redact(text string) string {
+	for _, re := range m.patterns {
+		text = re.ReplaceAllString(text, redactedText)
+	}
+	return text
+}
+
+func categories(c openai.ResultCategories) []string {
+	result := make([]string, 0)
+	for name, flagged := range map[string]bool{
+		"hate":             c.Hate,
+		"hate/threatening": c.HateThreatening,
+		"self-harm":        c.SelfHarm,
+		"sexual":           c.Sexual,
+		"sexual/minors":    c.SexualMinors,
+		"violence":         c.Violence,
+		"violence/graphic": c.ViolenceGraphic,
+	} {
+		if flagged {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,61 @@
+package moderation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/fanchunke/chatgpt-lark/internal/openaitest"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestCheck(t *testing.T) {
+	srv := openaitest.NewServer()
+	defer srv.Close()
+	srv.SetModeration(func(input string) openai.Result {
+		if strings.Contains(input, "violent") {
+			return openai.Result{Flagged: true, Categories: openai.ResultCategories{Violence: true}}
+		}
+		return openai.Result{}
+	})
+	m, err := New(openai.NewClientWithConfig(srv.ClientConfig()), []string{"Secret"}, []string{`\d{4}-\d{4}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		text       string
+		verdict    string
+		redactable bool
+		redacted   string
+	}{
+		{text: "hello", verdict: "pass", redacted: "hello"},
+		{text: "my secret is 1234-5678", verdict: "flagged(keyword)", redactable: true, redacted: "my *** is ***"},
+		{text: "violent", verdict: "flagged(violence)", redacted: "violent"},
+		// 同时命中关键词和 OpenAI 接口时，隐藏关键词后仍包含违规内容
+		{text: "violent secret", verdict: "flagged(keyword,violence)", redacted: "violent ***"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.text, func(t *testing.T) {
+			v, err := m.Check(context.Background(), tc.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := v.String(); got != tc.verdict {
+				t.Errorf("verdict = %s, want %s", got, tc.verdict)
+			}
+			if got := v.Redactable(); got != tc.redactable {
+				t.Errorf("redactable = %v, want %v", got, tc.redactable)
+			}
+			if got := m.Redact(tc.text); got != tc.redacted {
+				t.Errorf("redacted = %q, want %q", got, tc.redacted)
+			}
+		})
+	}
+}
+
+func TestNewInvalidPattern(t *testing.T) {
+	if _, err := New(nil, nil, []string{"("}); err == nil {
+		t.Fatal("want error for invalid pattern")
+	}
+}
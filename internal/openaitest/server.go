@@ -0,0 +1,440 @@
+// Package openaitest 提供一个基于 httptest 的 OpenAI 兼容模拟服务，用于离线测试和不使用 API Key 的本地开发。
+//
+// 模拟服务按顺序返回预设的 completion 和 chat completion 响应，支持 SSE 流式响应、token 用量、
+// 注入 429/500 等错误以及响应延迟。未预设响应时使用默认的响应函数，默认回显用户的最后一条消息。
+// embeddings 接口返回根据文本内容生成的确定性向量，内容相近的文本向量也相近。
+// chat completion 的预设响应可以包含工具调用，用于测试 tools 参数的请求。
+package openaitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Response 预设的响应
+type Response struct {
+	// Content 回复内容
+	Content string
+	// Chunks 流式响应的分段，为空时按 Content 的字符分段
+	Chunks []string
+	// Usage 为 nil 时根据请求和回复的长度估算
+	Usage *openai.Usage
+	// Status 不为 0 时返回错误，如 429、500
+	Status int
+	// Error 错误信息，为空时使用状态码对应的默认信息
+	Error string
+	// Delay 返回响应前的延迟，请求取消时提前返回
+	Delay time.Duration
+	// ToolCalls 不为空时返回工具调用，不支持流式响应
+	ToolCalls []ToolCall
+}
+
+// ToolCall 预设响应中的工具调用
+type ToolCall struct {
+	Name string
+	// Arguments JSON 格式的参数
+	Arguments string
+}
+
+// Request 服务收到的请求
+type Request struct {
+	// Path 请求路径，如 /v1/chat/completions
+	Path     string
+	Model    string
+	Prompt   string
+	Messages []openai.ChatCompletionMessage
+	Stream   bool
+	User     string
+	// Tools 请求中 tools 参数的工具名称
+	Tools []string
+}
+
+// LastUserMessage 请求中用户的最后一条消息，completion 请求返回 prompt
+func (r *Request) LastUserMessage() string {
+	for i := len(r.Messages) - 1; i >= 0; i-- {
+		if r.Messages[i].Role == openai.ChatMessageRoleUser {
+			return r.Messages[i].Content
+		}
+	}
+	return r.Prompt
+}
+
+// Responder 未预设响应时根据请求生成响应
+type Responder func(req *Request) Response
+
+// Echo 默认的响应函数，回显用户的最后一条消息
+func Echo(req *Request) Response {
+	return Response{Content: "[mock] " + req.LastUserMessage()}
+}
+
+// Models 模型列表接口返回的模型
+var Models = []string{openai.GPT3Dot5Turbo, openai.GPT3TextDavinci003}
+
+// Server OpenAI 兼容的模拟服务
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	seq       int
+	scripted  []Response
+	responder Responder
+	moderate  func(input string) openai.Result
+	requests  []Request
+}
+
+// NewServer 启动模拟服务，使用完毕后需要调用 Close
+func NewServer() *Server {
+	s := &Server{
+		responder: Echo,
+		moderate:  func(string) openai.Result { return openai.Result{} },
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	e := gin.New()
+	v1 := e.Group("/v1")
+	v1.POST("/completions", s.createCompletion)
+	v1.POST("/chat/completions", s.createChatCompletion)
+	v1.POST("/moderations", s.createModeration)
+	v1.POST("/embeddings", s.createEmbeddings)
+	v1.GET("/models", s.listModels)
+	s.Server = httptest.NewServer(e)
+	return s
+}
+
+// ClientConfig 返回指向模拟服务的 go-openai client 配置
+func (s *Server) ClientConfig() openai.ClientConfig {
+	config := openai.DefaultConfig("mock")
+	config.BaseURL = s.URL + "/v1"
+	return config
+}
+
+// Enqueue 追加预设的响应，completion 和 chat completion 请求按顺序使用
+func (s *Server) Enqueue(responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripted = append(s.scripted, responses...)
+}
+
+// SetResponder 设置未预设响应时使用的响应函数
+func (s *Server) SetResponder(r Responder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responder = r
+}
+
+// SetModeration 设置 moderation 接口的审核结果
+func (s *Server) SetModeration(fn func(input string) openai.Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.moderate = fn
+}
+
+// Requests 返回收到的全部 completion 和 chat completion 请求
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Reset 清空预设的响应和收到的请求
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripted, s.requests = nil, nil
+}
+
+// next 记录请求，返回预设的下一个响应
+func (s *Server) next(req *Request) (Response, string) {
+	s.mu.Lock()
+	s.seq++
+	id := fmt.Sprintf("mock-%d", s.seq)
+	s.requests = append(s.requests, *req)
+	if len(s.scripted) > 0 {
+		resp := s.scripted[0]
+		s.scripted = s.scripted[1:]
+		s.mu.Unlock()
+		return resp, id
+	}
+	responder := s.responder
+	s.mu.Unlock()
+	return responder(req), id
+}
+
+// wait 等待预设的延迟，请求取消时返回 false
+func wait(c *gin.Context, resp Response) bool {
+	if resp.Delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(resp.Delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.Request.Context().Done():
+		return false
+	}
+}
+
+// writeError 返回预设的错误，格式与 OpenAI 一致
+func writeError(c *gin.Context, resp Response) {
+	msg, errType := resp.Error, "server_error"
+	if resp.Status == http.StatusTooManyRequests {
+		errType = "rate_limit_error"
+	}
+	if msg == "" {
+		msg = http.StatusText(resp.Status)
+	}
+	c.JSON(resp.Status, openai.ErrorResponse{Error: &openai.APIError{Message: msg, Type: errType}})
+}
+
+// usage 返回预设的用量，未预设时按字符数估算
+func usage(resp Response, prompt string) openai.Usage {
+	if resp.Usage != nil {
+		return *resp.Usage
+	}
+	u := openai.Usage{PromptTokens: countTokens(prompt), CompletionTokens: countTokens(resp.Content)}
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	return u
+}
+
+// countTokens 粗略估算 token 数，结果是确定的，便于测试断言
+func countTokens(s string) int {
+	return (utf8.RuneCountInString(s) + 3) / 4
+}
+
+func chunks(resp Response) []string {
+	if len(resp.Chunks) > 0 {
+		return resp.Chunks
+	}
+	result := make([]string, 0, utf8.RuneCountInString(resp.Content))
+	for _, r := range resp.Content {
+		result = append(result, string(r))
+	}
+	return result
+}
+
+func (s *Server) createCompletion(c *gin.Context) {
+	var body openai.CompletionRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		writeError(c, Response{Status: http.StatusBadRequest, Error: err.Error()})
+		return
+	}
+	req := &Request{Path: c.Request.URL.Path, Model: body.Model, Prompt: body.Prompt, Stream: body.Stream, User: body.User}
+	resp, id := s.next(req)
+	if !wait(c, resp) {
+		return
+	}
+	if resp.Status != 0 {
+		writeError(c, resp)
+		return
+	}
+
+	created := time.Now().Unix()
+	if body.Stream {
+		events := make([]interface{}, 0)
+		for _, chunk := range chunks(resp) {
+			events = append(events, openai.CompletionResponse{
+				ID: id, Object: "text_completion", Created: created, Model: body.Model,
+				Choices: []openai.CompletionChoice{{Text: chunk}},
+			})
+		}
+		writeStream(c, events)
+		return
+	}
+	c.JSON(http.StatusOK, openai.CompletionResponse{
+		ID: id, Object: "text_completion", Created: created, Model: body.Model,
+		Choices: []openai.CompletionChoice{{Text: resp.Content, FinishReason: "stop"}},
+		Usage:   usage(resp, body.Prompt),
+	})
+}
+
+// chatCompletionBody go-openai 的请求不包含 tools 参数
+type chatCompletionBody struct {
+	openai.ChatCompletionRequest
+	Tools []struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	} `json:"tools"`
+}
+
+func (s *Server) createChatCompletion(c *gin.Context) {
+	var body chatCompletionBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		writeError(c, Response{Status: http.StatusBadRequest, Error: err.Error()})
+		return
+	}
+	req := &Request{Path: c.Request.URL.Path, Model: body.Model, Messages: body.Messages, Stream: body.Stream, User: body.User}
+	for _, t := range body.Tools {
+		req.Tools = append(req.Tools, t.Function.Name)
+	}
+	resp, id := s.next(req)
+	if !wait(c, resp) {
+		return
+	}
+	if resp.Status != 0 {
+		writeError(c, resp)
+		return
+	}
+
+	created := time.Now().Unix()
+	if body.Stream {
+		events := make([]interface{}, 0)
+		for _, chunk := range chunks(resp) {
+			events = append(events, openai.ChatCompletionStreamResponse{
+				ID: id, Object: "chat.completion.chunk", Created: created, Model: body.Model,
+				Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Content: chunk}}},
+			})
+		}
+		writeStream(c, events)
+		return
+	}
+	prompt := make([]string, 0, len(body.Messages))
+	for _, m := range body.Messages {
+		prompt = append(prompt, m.Content)
+	}
+	if len(resp.ToolCalls) > 0 {
+		calls := make([]gin.H, 0, len(resp.ToolCalls))
+		for i, call := range resp.ToolCalls {
+			calls = append(calls, gin.H{
+				"id":       fmt.Sprintf("call_%s_%d", id, i),
+				"type":     "function",
+				"function": gin.H{"name": call.Name, "arguments": call.Arguments},
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"id": id, "object": "chat.completion", "created": created, "model": body.Model,
+			"choices": []gin.H{{
+				"index":         0,
+				"message":       gin.H{"role": openai.ChatMessageRoleAssistant, "content": resp.Content, "tool_calls": calls},
+				"finish_reason": "tool_calls",
+			}},
+			"usage": usage(resp, strings.Join(prompt, "\n")),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, openai.ChatCompletionResponse{
+		ID: id, Object: "chat.completion", Created: created, Model: body.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: resp.Content},
+			FinishReason: "stop",
+		}},
+		Usage: usage(resp, strings.Join(prompt, "\n")),
+	})
+}
+
+// writeStream 以 SSE 格式逐条返回流式响应，以 data: [DONE] 结束
+func writeStream(c *gin.Context, events []interface{}) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+	for _, ev := range events {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", data); err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+	fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+	c.Writer.Flush()
+}
+
+func (s *Server) createModeration(c *gin.Context) {
+	var body openai.ModerationRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		writeError(c, Response{Status: http.StatusBadRequest, Error: err.Error()})
+		return
+	}
+	s.mu.Lock()
+	moderate := s.moderate
+	s.mu.Unlock()
+	c.JSON(http.StatusOK, openai.ModerationResponse{
+		ID:      "modr-mock",
+		Model:   "text-moderation-latest",
+		Results: []openai.Result{moderate(body.Input)},
+	})
+}
+
+// EmbeddingDimensions Embed 生成的向量维度
+const EmbeddingDimensions = 256
+
+// Embed 将文本中的英文单词和中文的单字、双字哈希到固定维度的向量中，用于在没有 API Key 时模拟语义检索
+func Embed(text string) []float64 {
+	v := make([]float64, EmbeddingDimensions)
+	add := func(feature string) {
+		h := fnv.New32a()
+		h.Write([]byte(feature))
+		v[h.Sum32()%EmbeddingDimensions]++
+	}
+	var (
+		word []rune
+		prev rune
+	)
+	flush := func() {
+		if len(word) > 0 {
+			add(strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flush()
+			add(string(r))
+			if prev != 0 {
+				add(string([]rune{prev, r}))
+			}
+			prev = r
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word = append(word, r)
+		default:
+			flush()
+		}
+		prev = 0
+	}
+	flush()
+	return v
+}
+
+func (s *Server) createEmbeddings(c *gin.Context) {
+	var body openai.EmbeddingRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		writeError(c, Response{Status: http.StatusBadRequest, Error: err.Error()})
+		return
+	}
+	data := make([]openai.Embedding, 0, len(body.Input))
+	tokens := 0
+	for i, input := range body.Input {
+		data = append(data, openai.Embedding{Object: "embedding", Embedding: Embed(input), Index: i})
+		tokens += countTokens(input)
+	}
+	c.JSON(http.StatusOK, openai.EmbeddingResponse{
+		Object: "list",
+		Data:   data,
+		Model:  body.Model,
+		Usage:  openai.Usage{PromptTokens: tokens, TotalTokens: tokens},
+	})
+}
+
+func (s *Server) listModels(c *gin.Context) {
+	models := make([]openai.Model, 0, len(Models))
+	for _, id := range Models {
+		models = append(models, openai.Model{ID: id, Object: "model", OwnedBy: "openai"})
+	}
+	c.JSON(http.StatusOK, gin.H{"object": "list", "data": models})
+}
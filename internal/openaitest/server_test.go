@@ -0,0 +1,132 @@
+package openaitest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestServerStream(t *testing.T) {
+	cases := []struct {
+		name     string
+		response Response
+		want     []string
+	}{
+		{name: "chunks", response: Response{Chunks: []string{"hel", "lo"}}, want: []string{"hel", "lo"}},
+		{name: "content", response: Response{Content: "你好"}, want: []string{"你", "好"}},
+	}
+	for _, tc := range cases {
+		t.Run("chat/"+tc.name, func(t *testing.T) {
+			s := NewServer()
+			defer s.Close()
+			s.Enqueue(tc.response)
+			client := openai.NewClientWithConfig(s.ClientConfig())
+			stream, err := client.CreateChatCompletionStream(context.Background(), openai.ChatCompletionRequest{
+				Model:    openai.GPT3Dot5Turbo,
+				Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hello"}},
+				Stream:   true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stream.Close()
+			var got []string
+			for {
+				resp, err := stream.Recv()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, resp.Choices[0].Delta.Content)
+			}
+			if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+				t.Errorf("chunks = %q, want %q", got, tc.want)
+			}
+			if reqs := s.Requests(); len(reqs) != 1 || !reqs[0].Stream {
+				t.Errorf("requests = %+v, want one stream request", reqs)
+			}
+		})
+		t.Run("completion/"+tc.name, func(t *testing.T) {
+			s := NewServer()
+			defer s.Close()
+			s.Enqueue(tc.response)
+			client := openai.NewClientWithConfig(s.ClientConfig())
+			stream, err := client.CreateCompletionStream(context.Background(), openai.CompletionRequest{
+				Model:  openai.GPT3TextDavinci003,
+				Prompt: "hello",
+				Stream: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stream.Close()
+			var got []string
+			for {
+				resp, err := stream.Recv()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, resp.Choices[0].Text)
+			}
+			if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+				t.Errorf("chunks = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServerResponse(t *testing.T) {
+	cases := []struct {
+		name     string
+		response Response
+		// usage 期望的用量，Usage 未预设时按字符数估算
+		usage  openai.Usage
+		status int
+	}{
+		{name: "scripted usage", response: Response{Content: "hi", Usage: &openai.Usage{PromptTokens: 7, CompletionTokens: 3, TotalTokens: 10}}, usage: openai.Usage{PromptTokens: 7, CompletionTokens: 3, TotalTokens: 10}},
+		{name: "estimated usage", response: Response{Content: "hi"}, usage: openai.Usage{PromptTokens: 2, CompletionTokens: 1, TotalTokens: 3}},
+		{name: "rate limited", response: Response{Status: http.StatusTooManyRequests}, status: http.StatusTooManyRequests},
+		{name: "server error", response: Response{Status: http.StatusInternalServerError, Error: "boom"}, status: http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewServer()
+			defer s.Close()
+			s.Enqueue(tc.response)
+			client := openai.NewClientWithConfig(s.ClientConfig())
+			resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+				Model:    openai.GPT3Dot5Turbo,
+				Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hello"}},
+			})
+			if tc.status != 0 {
+				var apiErr *openai.APIError
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != tc.status {
+					t.Fatalf("err = %v, want status %d", err, tc.status)
+				}
+				if tc.response.Error != "" && apiErr.Message != tc.response.Error {
+					t.Errorf("message = %q, want %q", apiErr.Message, tc.response.Error)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resp.Choices[0].Message.Content; got != tc.response.Content {
+				t.Errorf("content = %q, want %q", got, tc.response.Content)
+			}
+			if resp.Usage != tc.usage {
+				t.Errorf("usage = %+v, want %+v", resp.Usage, tc.usage)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package pii
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// 内置的检测器
+const (
+	DetectorIDCard    = "idcard"
+	DetectorMobile    = "mobile"
+	DetectorEmail     = "email"
+	DetectorAccessKey = "accesskey"
+	DetectorIP        = "ip"
+)
+
+// Detectors 全部内置检测器，按检测顺序排列，与配置中的顺序无关。身份证号需要在手机号之前检测，避免其中的数字被识别为手机号。
+var Detectors = []string{DetectorIDCard, DetectorAccessKey, DetectorEmail, DetectorMobile, DetectorIP}
+
+// Rule 自定义的检测规则，name 用于生成占位符
+type Rule struct {
+	Name    string
+	Pattern string
+}
+
+type detector struct {
+	kind    string
+	pattern *regexp.Regexp
+	// valid 进一步校验匹配的内容，为 nil 时不校验
+	valid func(string) bool
+}
+
+var builtin = map[string][]*detector{
+	DetectorIDCard: {{
+		kind:    "ID_CARD",
+		pattern: regexp.MustCompile(`\b[1-9]\d{5}(?:18|19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]\b`),
+	}},
+	DetectorMobile: {{
+		kind:    "MOBILE",
+		pattern: regexp.MustCompile(`(?:\+?86[- ]?|\b)1[3-9]\d{9}\b`),
+	}},
+	DetectorEmail: {{
+		kind:    "EMAIL",
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	}},
+	DetectorAccessKey: {{
+		kind:    "ACCESS_KEY",
+		pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	}, {
+		kind:    "ACCESS_KEY",
+		pattern: regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`),
+	}},
+	DetectorIP: {{
+		kind:    "IP",
+		pattern: regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}\b`),
+		valid:   isIP,
+	}, {
+		kind:    "IP",
+		pattern: regexp.MustCompile(`(?i)(?:[0-9a-f]{0,4}:){2,7}[0-9a-f]{0,4}`),
+		valid:   isIPv6,
+	}},
+}
+
+func isIP(s string) bool {
+	return net.ParseIP(s) != nil
+}
+
+// isIPv6 至少包含三组非空的地址段，避免将 ::1 或代码中的 a::b 识别为 IP
+func isIPv6(s string) bool {
+	groups := 0
+	for _, g := range strings.Split(s, ":") {
+		if g != "" {
+			groups++
+		}
+	}
+	return groups >= 3 && isIP(s)
+}
+
+var (
+	ruleNameRegexp    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+	placeholderRegexp = regexp.MustCompile(`\[[A-Z][A-Z0-9_]*_[0-9a-f]{8}\]`)
+)
+
+// Redactor 将敏感信息替换为占位符，并在回复中还原
+type Redactor struct {
+	key       []byte
+	detectors []*detector
+}
+
+// New 创建 Redactor。key 用于生成占位符，相同的 key 和内容生成相同的占位符，因此多轮对话中同一个值的占位符保持不变。
+// detectors 为启用的内置检测器，按 Detectors 的顺序检测；rules 为自定义的检测规则，在内置检测器之后按配置顺序检测。
+func New(key []byte, detectors []string, rules []Rule) (*Redactor, error) {
+	r := &Redactor{key: key}
+	enabled := make(map[string]bool)
+	for _, name := range detectors {
+		if _, ok := builtin[name]; !ok {
+			return nil, fmt.Errorf("unsupported pii detector %q, supported detectors: %s", name, strings.Join(Detectors, ", "))
+		}
+		enabled[name] = true
+	}
+	for _, name := range Detectors {
+		if enabled[name] {
+			r.detectors = append(r.detectors, builtin[name]...)
+		}
+	}
+	for _, rule := range rules {
+		if !ruleNameRegexp.MatchString(rule.Name) {
+			return nil, fmt.Errorf("invalid pii rule name %q, only letters, digits and _ are allowed", rule.Name)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pii rule %s pattern %q: %w", rule.Name, rule.Pattern, err)
+		}
+		r.detectors = append(r.detectors, &detector{kind: strings.ToUpper(rule.Name), pattern: re})
+	}
+	return r, nil
+}
+
+// Enabled 是否配置了任一检测器
+func (r *Redactor) Enabled() bool {
+	return len(r.detectors) > 0
+}
+
+// Redact 将敏感信息替换为占位符，返回替换后的内容和占位符对应的原始值
+func (r *Redactor) Redact(text string) (string, map[string]string) {
+	values := make(map[string]string)
+	for _, d := range r.detectors {
+		text = d.pattern.ReplaceAllStringFunc(text, func(s string) string {
+			if d.valid != nil && !d.valid(s) {
+				return s
+			}
+			placeholder := r.placeholder(d.kind, s)
+			values[placeholder] = s
+			return placeholder
+		})
+	}
+	return text, values
+}
+
+func (r *Redactor) placeholder(kind, value string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(value))
+	return fmt.Sprintf("[%s_%s]", kind, hex.EncodeToString(mac.Sum(nil))[:8])
+}
+
+// Restore 将内容中的占位符还原为原始值，未知的占位符保持不变
+func Restore(text string, values map[string]string) string {
+	if len(values) == 0 {
+		return text
+	}
+	return placeholderRegexp.ReplaceAllStringFunc(text, func(s string) string {
+		if v, ok := values[s]; ok {
+			return v
+		}
+		return s
+	})
+}
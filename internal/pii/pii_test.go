@@ -0,0 +1,136 @@
+package pii
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRedactDetectorOrder(t *testing.T) {
+	// 按 Detectors 的顺序检测，配置中手机号在邮箱之前时，以手机号开头的邮箱同样整体替换为邮箱占位符
+	r, err := New([]byte("key"), []string{DetectorMobile, DetectorEmail}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redacted, values := r.Redact("邮箱 13800138000@qq.com")
+	if len(values) != 1 || !strings.HasPrefix(redacted, "邮箱 [EMAIL_") {
+		t.Errorf("redacted = %s, values = %v, want one EMAIL placeholder", redacted, values)
+	}
+}
+
+func TestRedactMobile(t *testing.T) {
+	r, err := New([]byte("key"), []string{DetectorMobile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		text  string
+		value string
+	}{
+		{text: "电话 13812345678", value: "13812345678"},
+		// 带国家码的号码整体替换
+		{text: "电话 +8613812345678", value: "+8613812345678"},
+		{text: "电话 8613812345678", value: "8613812345678"},
+		{text: "电话 +86 13812345678", value: "+86 13812345678"},
+		{text: "电话 86-13812345678", value: "86-13812345678"},
+		// 更长的数字中的一部分不是手机号
+		{text: "订单 2013812345678"},
+		{text: "订单 138123456789"},
+	}
+	for _, tc := range cases {
+		redacted, values := r.Redact(tc.text)
+		if tc.value == "" {
+			if len(values) != 0 {
+				t.Errorf("%q: values = %v, want none", tc.text, values)
+			}
+			continue
+		}
+		if len(values) != 1 || !strings.HasPrefix(redacted, "电话 [MOBILE_") || !strings.HasSuffix(redacted, "]") {
+			t.Errorf("%q: redacted = %q, values = %v, want one MOBILE placeholder", tc.text, redacted, values)
+			continue
+		}
+		for _, v := range values {
+			if v != tc.value {
+				t.Errorf("%q: value = %q, want %q", tc.text, v, tc.value)
+			}
+		}
+	}
+}
+
+// memoryStorage 在内存中模拟数据库
+type memoryStorage struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (s *memoryStorage) PutPIIValues(ctx context.Context, app, userId string, values map[string]string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for placeholder, value := range values {
+		s.values[app+"/"+userId+"/"+placeholder] = value
+	}
+	return nil
+}
+
+func (s *memoryStorage) GetPIIValues(ctx context.Context, app, userId string, placeholders []string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := make(map[string]string)
+	for _, placeholder := range placeholders {
+		if v, ok := s.values[app+"/"+userId+"/"+placeholder]; ok {
+			values[placeholder] = v
+		}
+	}
+	return values, nil
+}
+
+func TestVault(t *testing.T) {
+	ctx := context.Background()
+	storage := &memoryStorage{values: make(map[string]string)}
+	v, err := NewVault("app", "secret", storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(v.Key(), []string{DetectorEmail}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redacted, values := r.Redact("邮箱 alice@example.com")
+	if err := v.Put(ctx, "ou_alice", values, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range storage.values {
+		if strings.Contains(s, "alice@example.com") {
+			t.Fatalf("value is stored in plaintext: %s", s)
+		}
+	}
+
+	cases := []struct {
+		name   string
+		secret string
+		userId string
+		want   string
+	}{
+		// 重启或其他实例使用相同的密钥时可以还原
+		{name: "same secret", secret: "secret", userId: "ou_alice", want: "邮箱 alice@example.com"},
+		{name: "other user", secret: "secret", userId: "ou_bob", want: redacted},
+		{name: "other secret", secret: "other", userId: "ou_alice", want: redacted},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := NewVault("app", tc.secret, storage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := v.Restore(ctx, tc.userId, redacted)
+			if got != tc.want {
+				t.Errorf("restored = %q, want %q (err: %v)", got, tc.want, err)
+			}
+			if tc.secret != "secret" && err == nil {
+				t.Error("want decrypt error with other secret")
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package pii
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Storage 持久化用户的占位符和加密后的原始值
+type Storage interface {
+	PutPIIValues(ctx context.Context, app, userId string, values map[string]string, expiresAt time.Time) error
+	GetPIIValues(ctx context.Context, app, userId string, placeholders []string) (map[string]string, error)
+}
+
+// Vault 保存每个用户的占位符和原始值，用于还原之后几轮对话中引用的占位符。
+// 生成占位符和加密原始值的 key 都由配置的 secret 派生，原始值加密后保存在 Storage 中，
+// 因此重启后和使用相同配置的其他实例上同样可以还原会话历史中的占位符。
+type Vault struct {
+	app     string
+	key     []byte
+	aead    cipher.AEAD
+	storage Storage
+}
+
+// NewVault 创建应用 app 的 Vault，secret 不能为空
+func NewVault(app, secret string, storage Storage) (*Vault, error) {
+	if secret == "" {
+		return nil, errors.New("pii secret is empty")
+	}
+	block, err := aes.NewCipher(deriveKey(secret, "value"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Vault{app: app, key: deriveKey(secret, "placeholder"), aead: aead, storage: storage}, nil
+}
+
+func deriveKey(secret, usage string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("pii-" + usage))
+	return mac.Sum(nil)
+}
+
+// Key 生成占位符使用的 key
+func (v *Vault) Key() []byte {
+	return v.key
+}
+
+// Put 加密保存用户的占位符和原始值，ttl 后过期
+func (v *Vault) Put(ctx context.Context, userId string, values map[string]string, ttl time.Duration) error {
+	if len(values) == 0 {
+		return nil
+	}
+	sealed := make(map[string]string, len(values))
+	for placeholder, value := range values {
+		nonce := make([]byte, v.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		data := v.aead.Seal(nonce, nonce, []byte(value), v.additionalData(userId, placeholder))
+		sealed[placeholder] = base64.StdEncoding.EncodeToString(data)
+	}
+	return v.storage.PutPIIValues(ctx, v.app, userId, sealed, time.Now().Add(ttl))
+}
+
+// Restore 将内容中用户的占位符还原为原始值，未知、过期或无法解密的占位符保持不变
+func (v *Vault) Restore(ctx context.Context, userId, text string) (string, error) {
+	placeholders := placeholderRegexp.FindAllString(text, -1)
+	if len(placeholders) == 0 {
+		return text, nil
+	}
+	sealed, err := v.storage.GetPIIValues(ctx, v.app, userId, placeholders)
+	if err != nil {
+		return text, err
+	}
+	values := make(map[string]string, len(sealed))
+	var openErr error
+	for placeholder, s := range sealed {
+		value, err := v.open(userId, placeholder, s)
+		if err != nil {
+			// secret 修改后无法解密之前保存的原始值，保留占位符并继续还原其他占位符
+			if openErr == nil {
+				openErr = fmt.Errorf("decrypt pii value %s failed: %w", placeholder, err)
+			}
+			continue
+		}
+		values[placeholder] = value
+	}
+	return Restore(text, values), openErr
+}
+
+func (v *Vault) open(userId, placeholder, s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	size := v.aead.NonceSize()
+	if len(data) < size {
+		return "", errors.New("ciphertext too short")
+	}
+	value, err := v.aead.Open(nil, data[:size], data[size:], v.additionalData(userId, placeholder))
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// additionalData 将密文绑定到应用、用户和占位符，避免被替换到其他用户的记录中
+func (v *Vault) additionalData(userId, placeholder string) []byte {
+	return []byte(v.app + "\x00" + userId + "\x00" + placeholder)
+}
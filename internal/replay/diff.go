@@ -0,0 +1,183 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var placeholderRegexp = regexp.MustCompile(`\[([A-Z][A-Z0-9_]*)_[0-9a-f]{8}\]`)
+
+// Exchange 一个事件以及处理过程中的接口调用
+type Exchange struct {
+	Event *Entry
+	Calls []*Entry
+	Done  *Entry
+}
+
+// Read 读取记录文件
+func Read(r io.Reader) ([]*Entry, error) {
+	entries := make([]*Entry, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		e := &Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Exchanges 按事件 Id 将记录分组，按事件的顺序返回。没有事件的记录会被忽略。
+// 飞书重试推送的事件消息 Id 相同，分别作为不同的事件返回。
+func Exchanges(entries []*Entry) []*Exchange {
+	result := make([]*Exchange, 0)
+	byId := make(map[string]*Exchange)
+	for _, e := range entries {
+		if e.Kind == KindEvent {
+			ex := &Exchange{Event: e}
+			byId[e.key()] = ex
+			result = append(result, ex)
+			continue
+		}
+		ex, ok := byId[e.key()]
+		if !ok {
+			continue
+		}
+		switch e.Kind {
+		case KindHTTP:
+			ex.Calls = append(ex.Calls, e)
+		case KindDone:
+			ex.Done = e
+		}
+	}
+	return result
+}
+
+// key 记录所属事件的 key，没有事件 Id 的记录使用消息 Id
+func (e *Entry) key() string {
+	if e.EventId != "" {
+		return e.EventId
+	}
+	return e.MessageId
+}
+
+// Diff 对比两次处理中发出的接口请求和处理结果，返回以 - 和 + 开头的差异行，没有差异时返回空。
+// 接口的响应不参与对比，ignore 中的 JSON 字段不参与对比，如每次请求都不同的 uuid。
+func Diff(old, new *Exchange, ignore []string) []string {
+	fields := make(map[string]bool, len(ignore))
+	for _, f := range ignore {
+		fields[strings.ToLower(f)] = true
+	}
+	a, b := old.lines(fields), new.lines(fields)
+	return diffLines(a, b)
+}
+
+// lines 将接口请求和处理结果格式化为便于对比的文本行
+func (ex *Exchange) lines(ignore map[string]bool) []string {
+	lines := make([]string, 0)
+	for _, c := range ex.Calls {
+		lines = append(lines, fmt.Sprintf("%s %s %s", c.Service, c.Method, c.Path))
+		lines = append(lines, strings.Split(normalize(c.Request, ignore), "\n")...)
+	}
+	if ex.Done == nil {
+		lines = append(lines, "<not finished>")
+	} else if ex.Done.Error != "" {
+		lines = append(lines, "error: "+ex.Done.Error)
+	}
+	return lines
+}
+
+// normalize 格式化 JSON 内容，展开 JSON 字符串并去掉忽略的字段
+func normalize(raw json.RawMessage, ignore map[string]bool) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return string(raw)
+	}
+	b, err := json.MarshalIndent(strip(v, ignore), "  ", "  ")
+	if err != nil {
+		return string(raw)
+	}
+	return "  " + string(b)
+}
+
+func strip(v interface{}, ignore map[string]bool) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		for k, child := range vv {
+			if ignore[strings.ToLower(k)] {
+				delete(vv, k)
+				continue
+			}
+			vv[k] = strip(child, ignore)
+		}
+	case []interface{}:
+		for i, child := range vv {
+			vv[i] = strip(child, ignore)
+		}
+	case string:
+		if t := strings.TrimSpace(vv); strings.HasPrefix(t, "{") {
+			var inner interface{}
+			if err := json.Unmarshal([]byte(vv), &inner); err == nil {
+				return strip(inner, ignore)
+			}
+		}
+		// 脱敏占位符的哈希与生成时的 key 有关，对比时只保留类型
+		return placeholderRegexp.ReplaceAllString(vv, "[$1]")
+	}
+	return v
+}
+
+// diffLines 基于最长公共子序列对比文本行
+func diffLines(a, b []string) []string {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	result := make([]string, 0)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			result = append(result, "- "+a[i])
+			i++
+		default:
+			result = append(result, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		result = append(result, "- "+a[i])
+	}
+	for ; j < m; j++ {
+		result = append(result, "+ "+b[j])
+	}
+	return result
+}
@@ -0,0 +1,242 @@
+// Package replay 记录飞书事件以及处理过程中的 GPT 和飞书接口调用，并在当前代码上重放，用于复现线上问题。
+package replay
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/fanchunke/chatgpt-lark/internal/pii"
+)
+
+// 记录的类型
+const (
+	// KindEvent 解密后的飞书事件
+	KindEvent = "event"
+	// KindHTTP 处理事件时调用的 GPT 和飞书接口
+	KindHTTP = "http"
+	// KindDone 事件处理完成
+	KindDone = "done"
+)
+
+// 接口调用的服务
+const (
+	ServiceGPT  = "gpt"
+	ServiceLark = "lark"
+)
+
+const redactedValue = "******"
+
+// MaxResourceSize 记录的消息资源的最大字节数，超出时只记录大小
+const MaxResourceSize = 8 << 20
+
+// Entry 一条记录，同一个事件的记录使用相同的 EventId。
+// 飞书重试推送的事件 EventId 不同、MessageId 相同，分别记录为不同的事件。
+type Entry struct {
+	Seq       int64  `json:"seq"`
+	Time      string `json:"time"`
+	Kind      string `json:"kind"`
+	EventId   string `json:"eventId,omitempty"`
+	MessageId string `json:"messageId"`
+	// 事件所属的应用和回调版本，用于重放时确定回调地址
+	App     string `json:"app,omitempty"`
+	Version string `json:"version,omitempty"`
+	// 接口调用的服务、方法、路径和状态码
+	Service string `json:"service,omitempty"`
+	Method  string `json:"method,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Status  int    `json:"status,omitempty"`
+	// 事件内容或接口的请求、响应内容，JSON 内容原样保存，其他内容保存为字符串
+	Request  json.RawMessage `json:"request,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	// 下载的消息资源的文件名和内容，仅开启 Options.Resources 时记录
+	FileName string `json:"fileName,omitempty"`
+	Data     []byte `json:"data,omitempty"`
+}
+
+// Options 记录内容的脱敏方式
+type Options struct {
+	// JSON 内容中需要隐藏的字段名，不区分大小写，对任意层级的字段生效
+	RedactFields []string
+	// 使用内置的检测器将 JSON 字符串中的敏感信息替换为占位符。占位符使用每个 Recorder 随机生成且不保存的 key 计算，
+	// 无法通过枚举原始值还原，相同的值只在同一个 Recorder 的记录中生成相同的占位符
+	RedactPII bool
+	// 记录用户消息中下载的图片和文件内容，重放时由模拟的飞书开放平台返回。
+	// 文件内容无法脱敏，超过 MaxResourceSize 的文件只记录大小
+	Resources bool
+}
+
+// Recorder 将记录以 JSON lines 格式写入 w。Recorder 为 nil 时不记录。
+type Recorder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	seq    int64
+	fields map[string]bool
+	pii    *pii.Redactor
+	// resources 是否记录消息资源的内容
+	resources bool
+}
+
+// New 创建 Recorder，w 实现 io.Closer 时由 Close 关闭
+func New(w io.Writer, opts Options) (*Recorder, error) {
+	r := &Recorder{w: w, fields: make(map[string]bool), resources: opts.Resources}
+	if c, ok := w.(io.Closer); ok {
+		r.closer = c
+	}
+	for _, f := range opts.RedactFields {
+		r.fields[strings.ToLower(f)] = true
+	}
+	if opts.RedactPII {
+		// 占位符只保留 32 位的 HMAC，固定的 key 可以通过枚举手机号还原，因此每个 Recorder 使用随机的 key
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("generate pii key failed: %w", err)
+		}
+		redactor, err := pii.New(key, pii.Detectors, nil)
+		if err != nil {
+			return nil, err
+		}
+		r.pii = redactor
+	}
+	return r, nil
+}
+
+// NewFile 创建写入文件的 Recorder，文件已存在时追加，按大小轮转。
+// maxSize 为单个文件的最大 MB 数，maxBackups、maxAge 为 0 时保留全部轮转的文件
+func NewFile(filename string, maxSize, maxBackups, maxAge int, opts Options) (*Recorder, error) {
+	return New(&lumberjack.Logger{
+		Filename:   filename,
+		LocalTime:  true,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+		MaxAge:     maxAge,
+	}, opts)
+}
+
+// Close 关闭记录文件
+func (r *Recorder) Close() error {
+	if r == nil || r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+type eventKey struct{}
+
+// eventInfo 记录中事件的 Id 和消息 Id
+type eventInfo struct {
+	id        string
+	messageId string
+}
+
+// WithEvent 将事件 Id 和消息 Id 保存到 context 中，之后使用该 context 的接口调用都会被记录
+func WithEvent(ctx context.Context, eventId, messageId string) context.Context {
+	return context.WithValue(ctx, eventKey{}, eventInfo{id: eventId, messageId: messageId})
+}
+
+// eventFrom 获取 context 中的事件
+func eventFrom(ctx context.Context) (eventInfo, bool) {
+	ev, ok := ctx.Value(eventKey{}).(eventInfo)
+	return ev, ok && ev.id != ""
+}
+
+// RecordEvent 记录解密后的飞书事件
+func (r *Recorder) RecordEvent(ctx context.Context, app, version string, event interface{}) error {
+	if r == nil {
+		return nil
+	}
+	ev, _ := eventFrom(ctx)
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal event failed: %w", err)
+	}
+	return r.write(&Entry{Kind: KindEvent, EventId: ev.id, MessageId: ev.messageId, App: app, Version: version, Request: r.redact(body)})
+}
+
+// RecordDone 记录事件处理完成和处理的错误
+func (r *Recorder) RecordDone(ctx context.Context, err error) error {
+	if r == nil {
+		return nil
+	}
+	ev, _ := eventFrom(ctx)
+	entry := &Entry{Kind: KindDone, EventId: ev.id, MessageId: ev.messageId}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	return r.write(entry)
+}
+
+func (r *Recorder) write(e *Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	e.Seq = r.seq
+	e.Time = time.Now().Format(time.RFC3339Nano)
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal record failed: %w", err)
+	}
+	if _, err := r.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write record failed: %w", err)
+	}
+	return nil
+}
+
+// redact 隐藏 JSON 内容中的敏感字段和敏感信息，非 JSON 内容保存为字符串
+func (r *Recorder) redact(body []byte) json.RawMessage {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		s, _ := json.Marshal(string(body))
+		return s
+	}
+	b, err := json.Marshal(r.redactJSON(v))
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (r *Recorder) redactJSON(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		for k, child := range vv {
+			if r.fields[strings.ToLower(k)] {
+				vv[k] = redactedValue
+				continue
+			}
+			vv[k] = r.redactJSON(child)
+		}
+	case []interface{}:
+		for i, child := range vv {
+			vv[i] = r.redactJSON(child)
+		}
+	case string:
+		// 飞书消息的 content 是 JSON 字符串，同样需要脱敏
+		if t := strings.TrimSpace(vv); strings.HasPrefix(t, "{") || strings.HasPrefix(t, "[") {
+			var inner interface{}
+			if err := json.Unmarshal([]byte(vv), &inner); err == nil {
+				if b, err := json.Marshal(r.redactJSON(inner)); err == nil {
+					return string(b)
+				}
+			}
+		}
+		if r.pii != nil {
+			s, _ := r.pii.Redact(vv)
+			return s
+		}
+	}
+	return v
+}
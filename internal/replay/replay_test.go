@@ -0,0 +1,136 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExchanges(t *testing.T) {
+	// 飞书重试推送时事件 Id 不同，消息 Id 相同，重试的事件被去重
+	entries := []*Entry{
+		{Kind: KindEvent, EventId: "ev_1", MessageId: "om_1"},
+		{Kind: KindEvent, EventId: "ev_2", MessageId: "om_1"},
+		{Kind: KindDone, EventId: "ev_2", MessageId: "om_1"},
+		{Kind: KindHTTP, EventId: "ev_1", MessageId: "om_1", Service: ServiceGPT},
+		{Kind: KindDone, EventId: "ev_1", MessageId: "om_1"},
+		// 没有事件 Id 的旧记录按消息 Id 分组
+		{Kind: KindEvent, MessageId: "om_2"},
+		{Kind: KindHTTP, MessageId: "om_2", Service: ServiceLark},
+		{Kind: KindDone, MessageId: "om_2"},
+	}
+	exchanges := Exchanges(entries)
+	want := []struct {
+		eventId string
+		calls   int
+	}{{"ev_1", 1}, {"ev_2", 0}, {"", 1}}
+	if len(exchanges) != len(want) {
+		t.Fatalf("got %d exchanges, want %d", len(exchanges), len(want))
+	}
+	for i, ex := range exchanges {
+		if ex.Event.EventId != want[i].eventId || len(ex.Calls) != want[i].calls || ex.Done == nil {
+			t.Errorf("exchanges[%d] = event %q, %d calls, done %v, want event %q, %d calls, done", i, ex.Event.EventId, len(ex.Calls), ex.Done != nil, want[i].eventId, want[i].calls)
+		}
+	}
+}
+
+func TestTransportResource(t *testing.T) {
+	image := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="a.png"`)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(image)
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name      string
+		resources bool
+		path      string
+		// data 期望记录的资源内容
+		data []byte
+	}{
+		{name: "resource", resources: true, path: "/open-apis/im/v1/messages/om_1/resources/img_1", data: image},
+		{name: "resources disabled", path: "/open-apis/im/v1/messages/om_1/resources/img_1"},
+		{name: "other path", resources: true, path: "/open-apis/im/v1/images/img_1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			r, err := New(&buf, Options{Resources: tc.resources})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: r.Transport(ServiceLark, nil)}
+			req, err := http.NewRequestWithContext(WithEvent(context.Background(), "ev_1", "om_1"), http.MethodGet, srv.URL+tc.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if !bytes.Equal(body, image) {
+				t.Errorf("body = %q, want %q", body, image)
+			}
+
+			entries, err := Read(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+			e := entries[0]
+			var response string
+			json.Unmarshal(e.Response, &response)
+			if e.EventId != "ev_1" || response != "<image/png, 6 bytes>" {
+				t.Errorf("entry = %s %q, want ev_1 with binary response size", e.EventId, response)
+			}
+			if !bytes.Equal(e.Data, tc.data) {
+				t.Errorf("data = %q, want %q", e.Data, tc.data)
+			}
+			if tc.data != nil {
+				messageId, fileKey, ok := e.Resource()
+				if !ok || messageId != "om_1" || fileKey != "img_1" || e.FileName != "a.png" {
+					t.Errorf("resource = %s/%s %s, want om_1/img_1 a.png", messageId, fileKey, e.FileName)
+				}
+			}
+		})
+	}
+}
+
+func TestRecorderRedactPII(t *testing.T) {
+	body := []byte(`{"text":"手机号 13812345678"}`)
+	redact := func(r *Recorder) string {
+		t.Helper()
+		var v struct{ Text string }
+		if err := json.Unmarshal(r.redact(body), &v); err != nil {
+			t.Fatal(err)
+		}
+		return v.Text
+	}
+	r1, err := New(io.Discard, Options{RedactPII: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := New(io.Discard, Options{RedactPII: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一个 Recorder 中相同的值生成相同的占位符，不同的 Recorder 使用不同的 key
+	first := redact(r1)
+	if first == string(body) || first != redact(r1) {
+		t.Errorf("redacted = %q, want the same placeholder", first)
+	}
+	if other := redact(r2); other == first {
+		t.Errorf("placeholders of two recorders are both %q, want different keys", first)
+	}
+}
@@ -0,0 +1,109 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// resourcePath 飞书下载消息资源的接口路径
+var resourcePath = regexp.MustCompile(`^/open-apis/im/v1/messages/([^/]+)/resources/([^/]+)$`)
+
+// Resource 返回消息资源下载记录中的消息 Id 和文件 key
+func (e *Entry) Resource() (messageId, fileKey string, ok bool) {
+	if e.Service != ServiceLark {
+		return "", "", false
+	}
+	m := resourcePath.FindStringSubmatch(e.Path)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// Transport 返回记录接口调用的 http.RoundTripper。只记录 context 中带有事件的请求，
+// 飞书的 access token 接口不记录。base 为 nil 时使用 http.DefaultTransport。
+func (r *Recorder) Transport(service string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if r == nil {
+		return base
+	}
+	return &transport{recorder: r, service: service, base: base}
+}
+
+type transport struct {
+	recorder *Recorder
+	service  string
+	base     http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ev, ok := eventFrom(req.Context())
+	if !ok || strings.Contains(req.URL.Path, "/open-apis/auth/") {
+		return t.base.RoundTrip(req)
+	}
+
+	entry := &Entry{Kind: KindHTTP, EventId: ev.id, MessageId: ev.messageId, Service: t.service, Method: req.Method, Path: req.URL.Path}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		entry.Request = t.recorder.body(req.Header.Get("Content-Type"), body)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		entry.Error = err.Error()
+		t.recorder.write(entry)
+		return nil, err
+	}
+	entry.Status = resp.StatusCode
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		entry.Error = err.Error()
+		t.recorder.write(entry)
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	entry.Response = t.recorder.body(resp.Header.Get("Content-Type"), body)
+	if _, _, ok := entry.Resource(); ok && t.recorder.resources && resp.StatusCode == http.StatusOK && len(body) <= MaxResourceSize {
+		entry.Data = body
+		if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+			entry.FileName = params["filename"]
+		}
+	}
+	t.recorder.write(entry)
+	return resp, nil
+}
+
+// body 记录 JSON 和文本内容，文件、图片等二进制内容只记录类型和大小
+func (r *Recorder) body(contentType string, body []byte) json.RawMessage {
+	if !isText(contentType) {
+		s, _ := json.Marshal(fmt.Sprintf("<%s, %d bytes>", contentType, len(body)))
+		return s
+	}
+	return r.redact(body)
+}
+
+// isText 判断内容是否为 JSON 或文本，没有类型时按文本处理
+func isText(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json")
+}
@@ -0,0 +1,258 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	entsql "entgo.io/ent/dialect/sql"
+	"github.com/fanchunke/xgpt3/conversation/ent/chatent"
+	"github.com/fanchunke/xgpt3/conversation/ent/chatent/message"
+	"github.com/fanchunke/xgpt3/conversation/ent/chatent/session"
+)
+
+// LastActivity 获取 xgpt3 对话最近一次活跃的时间。没有开启的对话时返回 false。
+func (s *Store) LastActivity(ctx context.Context, conversationKey string) (time.Time, bool, error) {
+	sess, err := s.chatent.Session.
+		Query().
+		Where(session.UserIDEQ(conversationKey), session.StatusEQ(true)).
+		Order(chatent.Desc(session.FieldCreatedAt)).
+		First(ctx)
+	if chatent.IsNotFound(err) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("query active conversation failed: %w", err)
+	}
+
+	msg, err := s.chatent.Message.
+		Query().
+		Where(message.SessionIDEQ(sess.ID)).
+		Order(chatent.Desc(message.FieldCreatedAt)).
+		First(ctx)
+	if chatent.IsNotFound(err) {
+		return sess.CreatedAt, true, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("query latest message failed: %w", err)
+	}
+	return msg.CreatedAt, true, nil
+}
+
+// ListIdleConversations 获取在 before 之后没有任何消息的开启中的 xgpt3 对话，返回按顺序排列且大于 after 的前 limit 个不重复的用户 Id，
+// 调用方使用上一批的最后一个用户 Id 作为 after 获取下一批
+func (s *Store) ListIdleConversations(ctx context.Context, before time.Time, after string, limit int) ([]string, error) {
+	keys, err := s.chatent.Session.
+		Query().
+		Where(
+			session.StatusEQ(true),
+			session.UserIDGT(after),
+			session.CreatedAtLT(before),
+			session.Not(session.HasMessagesWith(message.CreatedAtGTE(before))),
+		).
+		Unique(true).
+		Order(chatent.Asc(session.FieldUserID)).
+		Limit(limit).
+		Select(session.FieldUserID).
+		Strings(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query idle conversations failed: %w", err)
+	}
+	return keys, nil
+}
+
+// LatestReplyID 获取 xgpt3 对话中最近一条回复消息的 Id
+func (s *Store) LatestReplyID(ctx context.Context, conversationKey string) (int, error) {
+	msg, err := s.chatent.Message.
+		Query().
+		Where(message.ToUserIDEQ(conversationKey), message.HasSpouse()).
+		Order(chatent.Desc(message.FieldCreatedAt), chatent.Desc(message.FieldID)).
+		First(ctx)
+	if chatent.IsNotFound(err) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("query latest reply failed: %w", err)
+	}
+	return msg.ID, nil
+}
+
+// RecentMessages 获取 xgpt3 开启中的对话最近的 limit 条消息，按创建时间排序。没有开启的对话时返回空。
+func (s *Store) RecentMessages(ctx context.Context, conversationKey string, limit int) ([]*chatent.Message, error) {
+	sess, err := s.chatent.Session.
+		Query().
+		Where(session.UserIDEQ(conversationKey), session.StatusEQ(true)).
+		Order(chatent.Desc(session.FieldCreatedAt)).
+		First(ctx)
+	if chatent.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query active conversation failed: %w", err)
+	}
+
+	msgs, err := s.chatent.Message.
+		Query().
+		Where(message.SessionIDEQ(sess.ID)).
+		Order(chatent.Desc(message.FieldCreatedAt), chatent.Desc(message.FieldID)).
+		Limit(limit).
+		All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query recent messages failed: %w", err)
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, nil
+}
+
+// LatestConversation 获取 xgpt3 中最近一次的对话，包含已关闭的对话
+func (s *Store) LatestConversation(ctx context.Context, conversationKey string) (*chatent.Session, error) {
+	sess, err := s.chatent.Session.
+		Query().
+		Where(session.UserIDEQ(conversationKey)).
+		Order(chatent.Desc(session.FieldCreatedAt), chatent.Desc(session.FieldID)).
+		First(ctx)
+	if chatent.IsNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query latest conversation failed: %w", err)
+	}
+	return sess, nil
+}
+
+// GetConversation 获取 xgpt3 对话及其全部消息，消息按创建时间排序
+func (s *Store) GetConversation(ctx context.Context, id int) (*chatent.Session, []*chatent.Message, error) {
+	sess, err := s.chatent.Session.Get(ctx, id)
+	if chatent.IsNotFound(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("query conversation failed: %w", err)
+	}
+
+	msgs, err := s.chatent.Message.
+		Query().
+		Where(message.SessionIDEQ(id)).
+		Order(chatent.Asc(message.FieldCreatedAt), chatent.Asc(message.FieldID)).
+		All(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query conversation messages failed: %w", err)
+	}
+	return sess, msgs, nil
+}
+
+// ConversationFilter 查询 xgpt3 对话的条件，为空的条件不生效
+type ConversationFilter struct {
+	// 用户 Id，包含用户全部命名会话的对话
+	UserID string
+	// 消息内容包含的文本
+	Query string
+	// 对话是否开启
+	Open   *bool
+	Limit  int
+	Offset int
+}
+
+// ConversationSummary xgpt3 对话的概要信息
+type ConversationSummary struct {
+	ID int
+	// xgpt3 对话的用户 Id
+	ConversationKey string
+	// 对话所属的用户和命名会话，会话已删除时为空
+	UserID      string
+	SessionName string
+	Open        bool
+	Messages    int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// ListConversations 按创建时间倒序查询 xgpt3 对话，同时返回满足条件的对话总数
+func (s *Store) ListConversations(ctx context.Context, filter ConversationFilter) ([]*ConversationSummary, int, error) {
+	q := s.chatent.Session.Query()
+	if filter.UserID != "" {
+		sessions, err := s.ListUserSessions(ctx, filter.UserID)
+		if err != nil {
+			return nil, 0, err
+		}
+		keys := []string{filter.UserID}
+		for _, sess := range sessions {
+			if sess.ConversationKey != filter.UserID {
+				keys = append(keys, sess.ConversationKey)
+			}
+		}
+		q = q.Where(session.UserIDIn(keys...))
+	}
+	if filter.Query != "" {
+		q = q.Where(session.HasMessagesWith(message.ContentContains(filter.Query)))
+	}
+	if filter.Open != nil {
+		q = q.Where(session.StatusEQ(*filter.Open))
+	}
+
+	total, err := q.Clone().Count(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("count conversations failed: %w", err)
+	}
+	convs, err := q.
+		Order(chatent.Desc(session.FieldCreatedAt), chatent.Desc(session.FieldID)).
+		Limit(filter.Limit).
+		Offset(filter.Offset).
+		All(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query conversations failed: %w", err)
+	}
+	if len(convs) == 0 {
+		return []*ConversationSummary{}, total, nil
+	}
+
+	ids := make([]int, 0, len(convs))
+	keys := make([]interface{}, 0, len(convs))
+	for _, conv := range convs {
+		ids = append(ids, conv.ID)
+		keys = append(keys, conv.UserID)
+	}
+	var counts []struct {
+		SessionID int `json:"session_id"`
+		Count     int `json:"count"`
+	}
+	err = s.chatent.Message.Query().
+		Where(message.SessionIDIn(ids...)).
+		GroupBy(message.FieldSessionID).
+		Aggregate(chatent.Count()).
+		Scan(ctx, &counts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("count conversation messages failed: %w", err)
+	}
+	messages := make(map[int]int, len(counts))
+	for _, c := range counts {
+		messages[c.SessionID] = c.Count
+	}
+	sessions, err := s.selectSessions(ctx, s.drv, entsql.In("conversation_key", keys...))
+	if err != nil {
+		return nil, 0, err
+	}
+	owners := make(map[string]*Session, len(sessions))
+	for _, sess := range sessions {
+		owners[sess.ConversationKey] = sess
+	}
+
+	result := make([]*ConversationSummary, 0, len(convs))
+	for _, conv := range convs {
+		summary := &ConversationSummary{
+			ID:              conv.ID,
+			ConversationKey: conv.UserID,
+			Open:            conv.Status,
+			Messages:        messages[conv.ID],
+			CreatedAt:       conv.CreatedAt,
+			UpdatedAt:       conv.UpdatedAt,
+		}
+		if sess, ok := owners[conv.UserID]; ok {
+			summary.UserID, summary.SessionName = sess.UserID, sess.Name
+		}
+		result = append(result, summary)
+	}
+	return result, total, nil
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	entsql "entgo.io/ent/dialect/sql"
+	"github.com/fanchunke/xgpt3/conversation/ent/chatent/message"
+)
+
+const (
+	// RatingGood 用户对回答满意
+	RatingGood = 1
+	// RatingBad 用户对回答不满意
+	RatingBad = -1
+)
+
+// Feedback 用户对回答的评价
+type Feedback struct {
+	ID     int
+	UserID string
+	// xgpt3 对话的用户 Id
+	ConversationKey string
+	// 评价的 xgpt3 回复消息 Id，未开启会话功能时为 0
+	MessageID int
+	Rating    int
+	Comment   string
+	CreatedAt time.Time
+	// 评价的消息所属的 xgpt3 对话 Id，只在查询时填充，消息已删除时为 0
+	ConversationID int
+}
+
+var feedbackColumns = []string{"id", "user_id", "conversation_key", "message_id", "rating", "comment", "created_at"}
+
+// CreateFeedback 记录用户的评价
+func (s *Store) CreateFeedback(ctx context.Context, f *Feedback) error {
+	if f.CreatedAt.IsZero() {
+		f.CreatedAt = time.Now()
+	}
+	_, err := exec(ctx, s.drv, s.builder().Insert(FeedbacksTable.Name).
+		Columns(feedbackColumns[1:]...).
+		Values(f.UserID, f.ConversationKey, f.MessageID, f.Rating, f.Comment, f.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("insert feedback failed: %w", err)
+	}
+	return nil
+}
+
+// ListFeedbacks 按时间倒序获取评价，rating 为 0 时返回全部评价
+func (s *Store) ListFeedbacks(ctx context.Context, rating, limit int) ([]*Feedback, error) {
+	q := s.builder().Select(feedbackColumns...).From(entsql.Table(FeedbacksTable.Name))
+	if rating != 0 {
+		q = q.Where(entsql.EQ("rating", rating))
+	}
+	q = q.OrderBy(entsql.Desc("created_at"), entsql.Desc("id")).Limit(limit)
+
+	result := make([]*Feedback, 0)
+	err := query(ctx, s.drv, q, func(rows *entsql.Rows) error {
+		f := &Feedback{}
+		if err := rows.Scan(&f.ID, &f.UserID, &f.ConversationKey, &f.MessageID, &f.Rating, &f.Comment, &f.CreatedAt); err != nil {
+			return fmt.Errorf("scan feedback failed: %w", err)
+		}
+		result = append(result, f)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query feedbacks failed: %w", err)
+	}
+
+	ids := make([]int, 0, len(result))
+	for _, f := range result {
+		if f.MessageID != 0 {
+			ids = append(ids, f.MessageID)
+		}
+	}
+	if len(ids) == 0 {
+		return result, nil
+	}
+	msgs, err := s.chatent.Message.Query().Where(message.IDIn(ids...)).All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query feedback messages failed: %w", err)
+	}
+	conversations := make(map[int]int, len(msgs))
+	for _, msg := range msgs {
+		conversations[msg.ID] = msg.SessionID
+	}
+	for _, f := range result {
+		f.ConversationID = conversations[f.MessageID]
+	}
+	return result, nil
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+
+	"github.com/fanchunke/xgpt3/conversation"
+	"github.com/fanchunke/xgpt3/conversation/ent"
+)
+
+type replyHookKey struct{}
+
+// ReplyHook 绑定到单次 GPT 请求的 ctx 上，在 xgpt3 保存回复时回调，并记录保存的回复消息
+type ReplyHook struct {
+	// Rewrite 保存回复前调用，返回实际保存的内容，为 nil 时保存原始回复
+	Rewrite func(ctx context.Context, content string) (string, error)
+	// ReplyID xgpt3 保存的回复消息 Id，未保存时为 0
+	ReplyID int
+}
+
+// WithReplyHook 将 hook 绑定到 ctx，使用该 ctx 调用 xgpt3 时生效
+func WithReplyHook(ctx context.Context, hook *ReplyHook) context.Context {
+	return context.WithValue(ctx, replyHookKey{}, hook)
+}
+
+func replyHookFrom(ctx context.Context) *ReplyHook {
+	hook, _ := ctx.Value(replyHookKey{}).(*ReplyHook)
+	return hook
+}
+
+// conversationHandler 在 xgpt3 的会话存储外执行 ctx 中的回调
+type conversationHandler struct {
+	*ent.ConversationHandler
+}
+
+// ConversationHandler 返回 xgpt3 使用的会话存储。
+// 通过 WithReplyHook 绑定的回调可以在保存回复前改写内容，并获取保存的回复消息 Id。
+func (s *Store) ConversationHandler() conversation.Handler {
+	return &conversationHandler{ConversationHandler: ent.New(s.chatent)}
+}
+
+func (h *conversationHandler) CreateSpouseMessage(ctx context.Context, session *conversation.Session, fromUserId, toUserId, content string, spouse *conversation.Message) (*conversation.Message, error) {
+	hook := replyHookFrom(ctx)
+	if hook != nil && hook.Rewrite != nil {
+		rewritten, err := hook.Rewrite(ctx, content)
+		if err != nil {
+			return nil, err
+		}
+		content = rewritten
+	}
+	m, err := h.ConversationHandler.CreateSpouseMessage(ctx, session, fromUserId, toUserId, content, spouse)
+	if err == nil && hook != nil {
+		hook.ReplyID = m.ID
+	}
+	return m, err
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"entgo.io/ent/dialect"
+	entsql "entgo.io/ent/dialect/sql"
+)
+
+// KBChunk 知识库中一个文档片段及其向量
+type KBChunk struct {
+	ID int
+	// 知识库名称
+	KB string
+	// 文档的相对路径
+	Source string
+	// 文档内容和切分参数的哈希，未变化的文档重复导入时跳过
+	SourceHash string
+	Index      int
+	Title      string
+	Content    string
+	// 生成向量使用的模型
+	Model string
+	// 编码后的向量
+	Embedding string
+	CreatedAt time.Time
+}
+
+// KBStat 知识库的文档数和片段数
+type KBStat struct {
+	KB      string
+	Sources int
+	Chunks  int
+}
+
+var kbChunkColumns = []string{"id", "kb", "source", "source_hash", "chunk_index", "title", "content", "model", "embedding", "created_at"}
+
+// ListKBSources 获取知识库中的全部文档，返回以文档路径为 key、文档哈希为值的 map
+func (s *Store) ListKBSources(ctx context.Context, kb string) (map[string]string, error) {
+	result := make(map[string]string)
+	q := s.builder().Select("source", "source_hash").From(entsql.Table(KbChunksTable.Name)).
+		Where(entsql.EQ("kb", kb)).
+		GroupBy("source", "source_hash")
+	err := query(ctx, s.drv, q, func(rows *entsql.Rows) error {
+		var source, hash string
+		if err := rows.Scan(&source, &hash); err != nil {
+			return fmt.Errorf("scan kb source failed: %w", err)
+		}
+		result[source] = hash
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query kb sources failed: %w", err)
+	}
+	return result, nil
+}
+
+// ReplaceKBSource 使用 chunks 替换知识库中文档的全部片段
+func (s *Store) ReplaceKBSource(ctx context.Context, kb, source string, chunks []*KBChunk) error {
+	return s.withTx(ctx, func(conn dialect.ExecQuerier) error {
+		if err := s.deleteKBChunks(ctx, conn, entsql.And(entsql.EQ("kb", kb), entsql.EQ("source", source))); err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, c := range chunks {
+			_, err := exec(ctx, conn, s.builder().Insert(KbChunksTable.Name).
+				Columns(kbChunkColumns[1:]...).
+				Values(kb, source, c.SourceHash, c.Index, c.Title, c.Content, c.Model, c.Embedding, now))
+			if err != nil {
+				return fmt.Errorf("insert kb chunk failed: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteKBSource 删除知识库中的文档
+func (s *Store) DeleteKBSource(ctx context.Context, kb, source string) error {
+	return s.deleteKBChunks(ctx, s.drv, entsql.And(entsql.EQ("kb", kb), entsql.EQ("source", source)))
+}
+
+// DeleteKB 删除知识库的全部文档
+func (s *Store) DeleteKB(ctx context.Context, kb string) error {
+	return s.deleteKBChunks(ctx, s.drv, entsql.EQ("kb", kb))
+}
+
+func (s *Store) deleteKBChunks(ctx context.Context, conn dialect.ExecQuerier, where *entsql.Predicate) error {
+	if _, err := exec(ctx, conn, s.builder().Delete(KbChunksTable.Name).Where(where)); err != nil {
+		return fmt.Errorf("delete kb chunks failed: %w", err)
+	}
+	return nil
+}
+
+// ListKBChunks 获取知识库的全部片段
+func (s *Store) ListKBChunks(ctx context.Context, kb string) ([]*KBChunk, error) {
+	q := s.builder().Select(kbChunkColumns...).From(entsql.Table(KbChunksTable.Name)).
+		Where(entsql.EQ("kb", kb)).
+		OrderBy(entsql.Asc("source"), entsql.Asc("chunk_index"))
+	result := make([]*KBChunk, 0)
+	err := query(ctx, s.drv, q, func(rows *entsql.Rows) error {
+		c := &KBChunk{}
+		if err := rows.Scan(&c.ID, &c.KB, &c.Source, &c.SourceHash, &c.Index, &c.Title, &c.Content, &c.Model, &c.Embedding, &c.CreatedAt); err != nil {
+			return fmt.Errorf("scan kb chunk failed: %w", err)
+		}
+		result = append(result, c)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query kb chunks failed: %w", err)
+	}
+	return result, nil
+}
+
+// KBStats 获取全部知识库的文档数和片段数，按名称排序
+func (s *Store) KBStats(ctx context.Context) ([]*KBStat, error) {
+	q := s.builder().Select("kb", "source", entsql.Count("*")).From(entsql.Table(KbChunksTable.Name)).
+		GroupBy("kb", "source")
+	stats := make(map[string]*KBStat)
+	err := query(ctx, s.drv, q, func(rows *entsql.Rows) error {
+		var (
+			kb, source string
+			chunks     int
+		)
+		if err := rows.Scan(&kb, &source, &chunks); err != nil {
+			return fmt.Errorf("scan kb stat failed: %w", err)
+		}
+		if _, ok := stats[kb]; !ok {
+			stats[kb] = &KBStat{KB: kb}
+		}
+		stats[kb].Sources++
+		stats[kb].Chunks += chunks
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query kb stats failed: %w", err)
+	}
+	result := make([]*KBStat, 0, len(stats))
+	for _, stat := range stats {
+		result = append(result, stat)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].KB < result[j].KB })
+	return result, nil
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	entsql "entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/sqlgraph"
+)
+
+// MarkReceived 记录应用收到的飞书消息，用于识别飞书重试推送的同一条消息。消息已经记录过时返回 false。
+// 记录保存在数据库中，多个实例之间共享。
+func (s *Store) MarkReceived(ctx context.Context, app, messageId string) (bool, error) {
+	_, err := exec(ctx, s.drv, s.builder().Insert(ReceivedMessagesTable.Name).
+		Columns("app", "message_id", "created_at").
+		Values(app, messageId, time.Now()))
+	if err != nil && sqlgraph.IsUniqueConstraintError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("insert received message failed: %w", err)
+	}
+	return true, nil
+}
+
+// PurgeReceived 删除 before 之前收到的消息记录，返回删除的数量
+func (s *Store) PurgeReceived(ctx context.Context, before time.Time) (int, error) {
+	n, err := exec(ctx, s.drv, s.builder().Delete(ReceivedMessagesTable.Name).Where(entsql.LT("created_at", before)))
+	if err != nil {
+		return 0, fmt.Errorf("delete received messages failed: %w", err)
+	}
+	return int(n), nil
+}
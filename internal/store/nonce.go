@@ -0,0 +1,34 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	entsql "entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/sqlgraph"
+)
+
+// UseNonce 记录管理接口签名请求的 nonce，nonce 已经使用过时返回 false。
+// 记录保存在数据库中，多个实例之间共享，expiresAt 之后由 PurgeNonces 删除。
+func (s *Store) UseNonce(ctx context.Context, nonce string, expiresAt time.Time) (bool, error) {
+	_, err := exec(ctx, s.drv, s.builder().Insert(AdminNoncesTable.Name).
+		Columns("nonce", "expires_at").
+		Values(nonce, expiresAt))
+	if err != nil && sqlgraph.IsUniqueConstraintError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("insert admin nonce failed: %w", err)
+	}
+	return true, nil
+}
+
+// PurgeNonces 删除 before 之前过期的 nonce，返回删除的数量
+func (s *Store) PurgeNonces(ctx context.Context, before time.Time) (int, error) {
+	n, err := exec(ctx, s.drv, s.builder().Delete(AdminNoncesTable.Name).Where(entsql.LT("expires_at", before)))
+	if err != nil {
+		return 0, fmt.Errorf("delete admin nonces failed: %w", err)
+	}
+	return int(n), nil
+}
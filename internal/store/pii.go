@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	entsql "entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/sqlgraph"
+)
+
+// PutPIIValues 保存用户的占位符和加密后的原始值，已保存的占位符更新原始值和过期时间。
+// 记录保存在数据库中，重启后和多个实例之间都可以还原占位符。
+func (s *Store) PutPIIValues(ctx context.Context, app, userId string, values map[string]string, expiresAt time.Time) error {
+	for placeholder, value := range values {
+		n, err := exec(ctx, s.drv, s.builder().Update(PiiValuesTable.Name).
+			Set("value", value).
+			Set("expires_at", expiresAt).
+			Where(entsql.And(
+				entsql.EQ("app", app),
+				entsql.EQ("user_id", userId),
+				entsql.EQ("placeholder", placeholder),
+			)))
+		if err != nil {
+			return fmt.Errorf("update pii value failed: %w", err)
+		}
+		if n > 0 {
+			continue
+		}
+		_, err = exec(ctx, s.drv, s.builder().Insert(PiiValuesTable.Name).
+			Columns("app", "user_id", "placeholder", "value", "expires_at").
+			Values(app, userId, placeholder, value, expiresAt))
+		// 并发的消息已经保存了同一个占位符，原始值相同
+		if err != nil && !sqlgraph.IsUniqueConstraintError(err) {
+			return fmt.Errorf("insert pii value failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetPIIValues 获取用户未过期的占位符对应的原始值，不存在或已过期的占位符不返回
+func (s *Store) GetPIIValues(ctx context.Context, app, userId string, placeholders []string) (map[string]string, error) {
+	values := make(map[string]string)
+	if len(placeholders) == 0 {
+		return values, nil
+	}
+	args := make([]interface{}, 0, len(placeholders))
+	for _, p := range placeholders {
+		args = append(args, p)
+	}
+	err := query(ctx, s.drv, s.builder().Select("placeholder", "value").
+		From(entsql.Table(PiiValuesTable.Name)).
+		Where(entsql.And(
+			entsql.EQ("app", app),
+			entsql.EQ("user_id", userId),
+			entsql.In("placeholder", args...),
+			entsql.GT("expires_at", time.Now()),
+		)), func(rows *entsql.Rows) error {
+		var placeholder, value string
+		if err := rows.Scan(&placeholder, &value); err != nil {
+			return err
+		}
+		values[placeholder] = value
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query pii values failed: %w", err)
+	}
+	return values, nil
+}
+
+// PurgePIIValues 删除 before 之前过期的占位符，返回删除的数量
+func (s *Store) PurgePIIValues(ctx context.Context, before time.Time) (int, error) {
+	n, err := exec(ctx, s.drv, s.builder().Delete(PiiValuesTable.Name).Where(entsql.LT("expires_at", before)))
+	if err != nil {
+		return 0, fmt.Errorf("delete pii values failed: %w", err)
+	}
+	return int(n), nil
+}
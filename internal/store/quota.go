@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	entsql "entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/sqlgraph"
+)
+
+// QuotaUsage 用户一天内已使用的额度
+type QuotaUsage struct {
+	UserID   string `json:"userId"`
+	Messages int    `json:"messages"`
+	Tokens   int    `json:"tokens"`
+}
+
+// TakeQuota 检查用户在 day 当天是否超出额度，未超出时计入一条消息并返回 true。maxMessages、maxTokens 为 0 时不限制。
+// 计数保存在数据库中，多个实例之间共享，检查和计数在同一条语句中完成。
+func (s *Store) TakeQuota(ctx context.Context, app, userId, day string, maxMessages, maxTokens int) (bool, error) {
+	where := []*entsql.Predicate{entsql.EQ("app", app), entsql.EQ("user_id", userId), entsql.EQ("day", day)}
+	if maxMessages > 0 {
+		where = append(where, entsql.LT("messages", maxMessages))
+	}
+	if maxTokens > 0 {
+		where = append(where, entsql.LT("tokens", maxTokens))
+	}
+	// 用户当天的第一条消息插入记录，并发插入失败时重新更新
+	for i := 0; i < 2; i++ {
+		n, err := exec(ctx, s.drv, s.builder().Update(DailyQuotasTable.Name).Add("messages", 1).Where(entsql.And(where...)))
+		if err != nil {
+			return false, fmt.Errorf("update daily quota failed: %w", err)
+		}
+		if n > 0 {
+			return true, nil
+		}
+		inserted, err := s.insertQuota(ctx, app, userId, day, 1, 0)
+		if err != nil || inserted {
+			return inserted, err
+		}
+		// 记录已存在但超出额度时，再次更新同样失败
+	}
+	return false, nil
+}
+
+// AddQuotaTokens 计入用户在 day 当天消耗的 token 数
+func (s *Store) AddQuotaTokens(ctx context.Context, app, userId, day string, tokens int) error {
+	for i := 0; i < 2; i++ {
+		n, err := exec(ctx, s.drv, s.builder().Update(DailyQuotasTable.Name).Add("tokens", tokens).Where(entsql.And(
+			entsql.EQ("app", app),
+			entsql.EQ("user_id", userId),
+			entsql.EQ("day", day),
+		)))
+		if err != nil {
+			return fmt.Errorf("update daily quota failed: %w", err)
+		}
+		if n > 0 {
+			return nil
+		}
+		if inserted, err := s.insertQuota(ctx, app, userId, day, 0, tokens); err != nil || inserted {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertQuota 插入用户当天的计数，记录已存在时返回 false
+func (s *Store) insertQuota(ctx context.Context, app, userId, day string, messages, tokens int) (bool, error) {
+	_, err := exec(ctx, s.drv, s.builder().Insert(DailyQuotasTable.Name).
+		Columns("app", "user_id", "day", "messages", "tokens").
+		Values(app, userId, day, messages, tokens))
+	if err != nil && sqlgraph.IsUniqueConstraintError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("insert daily quota failed: %w", err)
+	}
+	return true, nil
+}
+
+// ListQuotaUsages 获取应用在 day 当天全部用户已使用的额度，按用户 Id 排序
+func (s *Store) ListQuotaUsages(ctx context.Context, app, day string) ([]QuotaUsage, error) {
+	result := make([]QuotaUsage, 0)
+	err := query(ctx, s.drv, s.builder().Select("user_id", "messages", "tokens").
+		From(entsql.Table(DailyQuotasTable.Name)).
+		Where(entsql.And(entsql.EQ("app", app), entsql.EQ("day", day))).
+		OrderBy("user_id"), func(rows *entsql.Rows) error {
+		var u QuotaUsage
+		if err := rows.Scan(&u.UserID, &u.Messages, &u.Tokens); err != nil {
+			return err
+		}
+		result = append(result, u)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query daily quotas failed: %w", err)
+	}
+	return result, nil
+}
+
+// ResetQuota 清空用户在 day 当天的计数
+func (s *Store) ResetQuota(ctx context.Context, app, userId, day string) error {
+	_, err := exec(ctx, s.drv, s.builder().Delete(DailyQuotasTable.Name).Where(entsql.And(
+		entsql.EQ("app", app),
+		entsql.EQ("user_id", userId),
+		entsql.EQ("day", day),
+	)))
+	if err != nil {
+		return fmt.Errorf("delete daily quota failed: %w", err)
+	}
+	return nil
+}
+
+// PurgeQuotas 删除 before 之前各天的计数，返回删除的数量
+func (s *Store) PurgeQuotas(ctx context.Context, before string) (int, error) {
+	n, err := exec(ctx, s.drv, s.builder().Delete(DailyQuotasTable.Name).Where(entsql.LT("day", before)))
+	if err != nil {
+		return 0, fmt.Errorf("delete daily quotas failed: %w", err)
+	}
+	return int(n), nil
+}
@@ -0,0 +1,319 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	entsql "entgo.io/ent/dialect/sql"
+	"github.com/fanchunke/xgpt3/conversation/ent/chatent"
+	"github.com/fanchunke/xgpt3/conversation/ent/chatent/message"
+	"github.com/fanchunke/xgpt3/conversation/ent/chatent/session"
+)
+
+// RetentionMode 过期数据的处理方式
+type RetentionMode string
+
+const (
+	// RetentionDelete 删除过期的消息、已关闭的对话、用量记录和评价
+	RetentionDelete RetentionMode = "delete"
+	// RetentionMetadata 清空过期消息和评价说明的内容，只保留元数据
+	RetentionMetadata RetentionMode = "metadata"
+	// RetentionAnonymize 将已关闭的过期对话中的用户 Id 替换为匿名 Id，
+	// 对话全部被匿名化且过期的命名会话同样替换用户 Id 和对话 Id，避免通过会话表关联回用户；过期的评价替换用户 Id 和对话 Id
+	RetentionAnonymize RetentionMode = "anonymize"
+)
+
+const anonymousPrefix = "anon_"
+
+// PurgeResult 清理结果
+type PurgeResult struct {
+	Messages      int
+	Conversations int
+	Usages        int
+	Sessions      int
+	Feedbacks     int
+	PIIValues     int
+}
+
+func (r PurgeResult) String() string {
+	return fmt.Sprintf("messages: %d, conversations: %d, usages: %d, sessions: %d, feedbacks: %d, pii values: %d", r.Messages, r.Conversations, r.Usages, r.Sessions, r.Feedbacks, r.PIIValues)
+}
+
+// Purge 按 mode 处理 before 之前的数据
+func (s *Store) Purge(ctx context.Context, before time.Time, mode RetentionMode, salt string) (PurgeResult, error) {
+	switch mode {
+	case RetentionDelete:
+		return s.purgeDelete(ctx, before)
+	case RetentionMetadata:
+		return s.purgeContent(ctx, before)
+	case RetentionAnonymize:
+		return s.purgeAnonymize(ctx, before, salt)
+	}
+	return PurgeResult{}, fmt.Errorf("unsupported retention mode: %s", mode)
+}
+
+func (s *Store) purgeDelete(ctx context.Context, before time.Time) (PurgeResult, error) {
+	var result PurgeResult
+	n, err := s.chatent.Message.Delete().Where(message.CreatedAtLT(before)).Exec(ctx)
+	if err != nil {
+		return result, fmt.Errorf("delete messages failed: %w", err)
+	}
+	result.Messages = n
+
+	n, err = s.chatent.Session.Delete().Where(
+		session.StatusEQ(false),
+		session.CreatedAtLT(before),
+		session.Not(session.HasMessages()),
+	).Exec(ctx)
+	if err != nil {
+		return result, fmt.Errorf("delete conversations failed: %w", err)
+	}
+	result.Conversations = n
+
+	affected, err := exec(ctx, s.drv, s.builder().Delete(UsagesTable.Name).Where(entsql.LT("created_at", before)))
+	if err != nil {
+		return result, fmt.Errorf("delete usages failed: %w", err)
+	}
+	result.Usages = int(affected)
+
+	affected, err = exec(ctx, s.drv, s.builder().Delete(FeedbacksTable.Name).Where(entsql.LT("created_at", before)))
+	if err != nil {
+		return result, fmt.Errorf("delete feedbacks failed: %w", err)
+	}
+	result.Feedbacks = int(affected)
+	return result, nil
+}
+
+func (s *Store) purgeContent(ctx context.Context, before time.Time) (PurgeResult, error) {
+	var result PurgeResult
+	n, err := s.chatent.Message.Update().
+		Where(message.CreatedAtLT(before), message.ContentNEQ("")).
+		SetContent("").
+		Save(ctx)
+	if err != nil {
+		return result, fmt.Errorf("clear message content failed: %w", err)
+	}
+	result.Messages = n
+
+	affected, err := exec(ctx, s.drv, s.builder().Update(FeedbacksTable.Name).
+		Set("comment", "").
+		Where(entsql.And(entsql.LT("created_at", before), entsql.NEQ("comment", ""))))
+	if err != nil {
+		return result, fmt.Errorf("clear feedback comments failed: %w", err)
+	}
+	result.Feedbacks = int(affected)
+	return result, nil
+}
+
+func (s *Store) purgeAnonymize(ctx context.Context, before time.Time, salt string) (PurgeResult, error) {
+	var result PurgeResult
+	sessions, err := s.chatent.Session.Query().
+		Where(
+			session.StatusEQ(false),
+			session.CreatedAtLT(before),
+			session.Not(session.UserIDHasPrefix(anonymousPrefix)),
+		).
+		All(ctx)
+	if err != nil {
+		return result, fmt.Errorf("query conversations failed: %w", err)
+	}
+
+	for _, sess := range sessions {
+		r, err := s.anonymizeConversation(ctx, sess, salt)
+		if err != nil {
+			return result, err
+		}
+		result.Conversations++
+		result.Messages += r.Messages
+		result.Usages += r.Usages
+	}
+
+	n, err := s.anonymizeSessions(ctx, before, salt)
+	if err != nil {
+		return result, err
+	}
+	result.Sessions = n
+
+	n, err = s.anonymizeFeedbacks(ctx, before, salt)
+	if err != nil {
+		return result, err
+	}
+	result.Feedbacks = n
+	return result, nil
+}
+
+// anonymizeFeedbacks 将 before 之前的评价中的用户 Id 和对话 Id 替换为匿名 Id，与匿名化的对话使用相同的匿名 Id
+func (s *Store) anonymizeFeedbacks(ctx context.Context, before time.Time, salt string) (int, error) {
+	type feedbackKey struct {
+		id              int
+		userId, convKey string
+	}
+	feedbacks := make([]feedbackKey, 0)
+	q := s.builder().Select("id", "user_id", "conversation_key").
+		From(entsql.Table(FeedbacksTable.Name)).
+		Where(entsql.And(entsql.LT("created_at", before), entsql.Not(entsql.HasPrefix("user_id", anonymousPrefix))))
+	err := query(ctx, s.drv, q, func(rows *entsql.Rows) error {
+		var f feedbackKey
+		if err := rows.Scan(&f.id, &f.userId, &f.convKey); err != nil {
+			return fmt.Errorf("scan feedback failed: %w", err)
+		}
+		feedbacks = append(feedbacks, f)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("query feedbacks failed: %w", err)
+	}
+
+	for i, f := range feedbacks {
+		_, err := exec(ctx, s.drv, s.builder().Update(FeedbacksTable.Name).
+			Set("user_id", AnonymousID(f.userId, salt)).
+			Set("conversation_key", AnonymousID(f.convKey, salt)).
+			Where(entsql.EQ("id", f.id)))
+		if err != nil {
+			return i, fmt.Errorf("anonymize feedback failed: %w", err)
+		}
+	}
+	return len(feedbacks), nil
+}
+
+// anonymizeSessions 匿名化 before 之前未更新、且不再有未匿名化对话的命名会话
+func (s *Store) anonymizeSessions(ctx context.Context, before time.Time, salt string) (int, error) {
+	sessions, err := s.selectSessions(ctx, s.drv, entsql.And(
+		entsql.LT("updated_at", before),
+		entsql.Not(entsql.HasPrefix("user_id", anonymousPrefix)),
+	))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, sess := range sessions {
+		// 会话还有开启中或未过期的对话时保留
+		exists, err := s.chatent.Session.Query().Where(session.UserIDEQ(sess.ConversationKey)).Exist(ctx)
+		if err != nil {
+			return n, fmt.Errorf("query conversations failed: %w", err)
+		}
+		if exists {
+			continue
+		}
+		_, err = exec(ctx, s.drv, s.builder().Update(UserSessionsTable.Name).
+			Set("user_id", AnonymousID(sess.UserID, salt)).
+			Set("conversation_key", AnonymousID(sess.ConversationKey, salt)).
+			// 同一用户可能有多个同名的过期会话，使用会话 Id 作为名称避免冲突
+			Set("name", fmt.Sprintf("%s%d", anonymousPrefix, sess.ID)).
+			Set("active", false).
+			Where(entsql.EQ("id", sess.ID)))
+		if err != nil {
+			return n, fmt.Errorf("anonymize session failed: %w", err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (s *Store) anonymizeConversation(ctx context.Context, sess *chatent.Session, salt string) (PurgeResult, error) {
+	var result PurgeResult
+	anon := AnonymousID(sess.UserID, salt)
+	n, err := s.chatent.Message.Update().
+		Where(message.SessionIDEQ(sess.ID), message.FromUserIDEQ(sess.UserID)).
+		SetFromUserID(anon).
+		Save(ctx)
+	if err != nil {
+		return result, fmt.Errorf("anonymize messages failed: %w", err)
+	}
+	result.Messages += n
+
+	n, err = s.chatent.Message.Update().
+		Where(message.SessionIDEQ(sess.ID), message.ToUserIDEQ(sess.UserID)).
+		SetToUserID(anon).
+		Save(ctx)
+	if err != nil {
+		return result, fmt.Errorf("anonymize messages failed: %w", err)
+	}
+	result.Messages += n
+
+	if err := s.chatent.Session.UpdateOneID(sess.ID).SetUserID(anon).Exec(ctx); err != nil {
+		return result, fmt.Errorf("anonymize conversation failed: %w", err)
+	}
+
+	affected, err := exec(ctx, s.drv, s.builder().Update(UsagesTable.Name).
+		Set("conversation_key", anon).
+		Where(entsql.And(entsql.EQ("conversation_key", sess.UserID), entsql.LTE("created_at", sess.UpdatedAt))))
+	if err != nil {
+		return result, fmt.Errorf("anonymize usages failed: %w", err)
+	}
+	result.Usages = int(affected)
+	return result, nil
+}
+
+// ForgetUser 删除用户的全部会话、对话、消息、用量记录、评价、脱敏原始值和额度计数
+func (s *Store) ForgetUser(ctx context.Context, userId string) (PurgeResult, error) {
+	var result PurgeResult
+	sessions, err := s.ListUserSessions(ctx, userId)
+	if err != nil {
+		return result, err
+	}
+	keys := []string{userId}
+	for _, sess := range sessions {
+		if sess.ConversationKey != userId {
+			keys = append(keys, sess.ConversationKey)
+		}
+	}
+
+	n, err := s.chatent.Message.Delete().
+		Where(message.HasSessionWith(session.UserIDIn(keys...))).
+		Exec(ctx)
+	if err != nil {
+		return result, fmt.Errorf("delete messages failed: %w", err)
+	}
+	result.Messages = n
+
+	n, err = s.chatent.Session.Delete().Where(session.UserIDIn(keys...)).Exec(ctx)
+	if err != nil {
+		return result, fmt.Errorf("delete conversations failed: %w", err)
+	}
+	result.Conversations = n
+
+	values := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, key)
+	}
+	affected, err := exec(ctx, s.drv, s.builder().Delete(UsagesTable.Name).Where(entsql.In("conversation_key", values...)))
+	if err != nil {
+		return result, fmt.Errorf("delete usages failed: %w", err)
+	}
+	result.Usages = int(affected)
+
+	affected, err = exec(ctx, s.drv, s.builder().Delete(UserSessionsTable.Name).Where(entsql.EQ("user_id", userId)))
+	if err != nil {
+		return result, fmt.Errorf("delete sessions failed: %w", err)
+	}
+	result.Sessions = int(affected)
+
+	affected, err = exec(ctx, s.drv, s.builder().Delete(FeedbacksTable.Name).Where(entsql.EQ("user_id", userId)))
+	if err != nil {
+		return result, fmt.Errorf("delete feedbacks failed: %w", err)
+	}
+	result.Feedbacks = int(affected)
+
+	affected, err = exec(ctx, s.drv, s.builder().Delete(PiiValuesTable.Name).Where(entsql.EQ("user_id", userId)))
+	if err != nil {
+		return result, fmt.Errorf("delete pii values failed: %w", err)
+	}
+	result.PIIValues = int(affected)
+
+	// 每日额度的计数只保留前一天和当天，不计入删除结果
+	if _, err := exec(ctx, s.drv, s.builder().Delete(DailyQuotasTable.Name).Where(entsql.EQ("user_id", userId))); err != nil {
+		return result, fmt.Errorf("delete daily quotas failed: %w", err)
+	}
+	return result, nil
+}
+
+// AnonymousID 使用加盐的 sha256 生成匿名 Id
+func AnonymousID(id, salt string) string {
+	sum := sha256.Sum256([]byte(salt + id))
+	return anonymousPrefix + hex.EncodeToString(sum[:])[:32]
+}
@@ -0,0 +1,256 @@
+package store
+
+import (
+	"entgo.io/ent/dialect/sql/schema"
+	"entgo.io/ent/schema/field"
+)
+
+var (
+	// UserSessionsColumns holds the columns for the "user_sessions" table.
+	UserSessionsColumns = []*schema.Column{
+		{Name: "id", Type: field.TypeInt, Increment: true},
+		{Name: "user_id", Type: field.TypeString, Size: 50},
+		{Name: "name", Type: field.TypeString, Size: 32},
+		{Name: "conversation_key", Type: field.TypeString, Size: 50},
+		{Name: "system_prompt", Type: field.TypeString, Size: 2147483647, Default: ""},
+		{Name: "model", Type: field.TypeString, Size: 64, Default: ""},
+		{Name: "active", Type: field.TypeBool, Default: false},
+		{Name: "created_at", Type: field.TypeTime},
+		{Name: "updated_at", Type: field.TypeTime},
+		{Name: "idle_expired_at", Type: field.TypeTime, Nullable: true},
+		{Name: "app", Type: field.TypeString, Size: 64, Default: ""},
+	}
+	// UserSessionsTable holds the schema information for the "user_sessions" table.
+	UserSessionsTable = &schema.Table{
+		Name:       "user_sessions",
+		Columns:    UserSessionsColumns,
+		PrimaryKey: []*schema.Column{UserSessionsColumns[0]},
+		Indexes: []*schema.Index{
+			{
+				Name:    "usersession_app_user_id_name",
+				Unique:  true,
+				Columns: []*schema.Column{UserSessionsColumns[10], UserSessionsColumns[1], UserSessionsColumns[2]},
+			},
+			{
+				Name:    "usersession_conversation_key",
+				Unique:  false,
+				Columns: []*schema.Column{UserSessionsColumns[3]},
+			},
+		},
+	}
+	// UsagesColumns holds the columns for the "usages" table.
+	UsagesColumns = []*schema.Column{
+		{Name: "id", Type: field.TypeInt, Increment: true},
+		{Name: "conversation_key", Type: field.TypeString, Size: 50},
+		{Name: "message_id", Type: field.TypeInt, Default: 0},
+		{Name: "model", Type: field.TypeString, Size: 64},
+		{Name: "prompt_tokens", Type: field.TypeInt, Default: 0},
+		{Name: "completion_tokens", Type: field.TypeInt, Default: 0},
+		{Name: "total_tokens", Type: field.TypeInt, Default: 0},
+		{Name: "created_at", Type: field.TypeTime},
+		{Name: "app", Type: field.TypeString, Size: 64, Default: ""},
+	}
+	// UsagesTable holds the schema information for the "usages" table.
+	UsagesTable = &schema.Table{
+		Name:       "usages",
+		Columns:    UsagesColumns,
+		PrimaryKey: []*schema.Column{UsagesColumns[0]},
+		Indexes: []*schema.Index{
+			{
+				Name:    "usage_conversation_key_created_at",
+				Unique:  false,
+				Columns: []*schema.Column{UsagesColumns[1], UsagesColumns[7]},
+			},
+			{
+				Name:    "usage_message_id",
+				Unique:  false,
+				Columns: []*schema.Column{UsagesColumns[2]},
+			},
+		},
+	}
+	// AppSettingsColumns holds the columns for the "app_settings" table.
+	AppSettingsColumns = []*schema.Column{
+		{Name: "id", Type: field.TypeInt, Increment: true},
+		{Name: "app", Type: field.TypeString, Size: 64},
+		{Name: "name", Type: field.TypeString, Size: 32},
+		{Name: "value", Type: field.TypeString, Size: 2147483647},
+		{Name: "updated_at", Type: field.TypeTime},
+	}
+	// AppSettingsTable holds the schema information for the "app_settings" table.
+	AppSettingsTable = &schema.Table{
+		Name:       "app_settings",
+		Columns:    AppSettingsColumns,
+		PrimaryKey: []*schema.Column{AppSettingsColumns[0]},
+		Indexes: []*schema.Index{
+			{
+				Name:    "appsetting_app_name",
+				Unique:  true,
+				Columns: []*schema.Column{AppSettingsColumns[1], AppSettingsColumns[2]},
+			},
+		},
+	}
+	// FeedbacksColumns holds the columns for the "feedbacks" table.
+	FeedbacksColumns = []*schema.Column{
+		{Name: "id", Type: field.TypeInt, Increment: true},
+		{Name: "user_id", Type: field.TypeString, Size: 50},
+		{Name: "conversation_key", Type: field.TypeString, Size: 50},
+		{Name: "message_id", Type: field.TypeInt, Default: 0},
+		{Name: "rating", Type: field.TypeInt},
+		{Name: "comment", Type: field.TypeString, Size: 2147483647},
+		{Name: "created_at", Type: field.TypeTime},
+	}
+	// FeedbacksTable holds the schema information for the "feedbacks" table.
+	FeedbacksTable = &schema.Table{
+		Name:       "feedbacks",
+		Columns:    FeedbacksColumns,
+		PrimaryKey: []*schema.Column{FeedbacksColumns[0]},
+		Indexes: []*schema.Index{
+			{
+				Name:    "feedback_rating_created_at",
+				Unique:  false,
+				Columns: []*schema.Column{FeedbacksColumns[4], FeedbacksColumns[6]},
+			},
+		},
+	}
+	// KbChunksColumns holds the columns for the "kb_chunks" table.
+	KbChunksColumns = []*schema.Column{
+		{Name: "id", Type: field.TypeInt, Increment: true},
+		{Name: "kb", Type: field.TypeString, Size: 64},
+		{Name: "source", Type: field.TypeString, Size: 255},
+		{Name: "source_hash", Type: field.TypeString, Size: 64},
+		{Name: "chunk_index", Type: field.TypeInt, Default: 0},
+		{Name: "title", Type: field.TypeString, Size: 2147483647},
+		{Name: "content", Type: field.TypeString, Size: 2147483647},
+		{Name: "model", Type: field.TypeString, Size: 64},
+		{Name: "embedding", Type: field.TypeString, Size: 2147483647},
+		{Name: "created_at", Type: field.TypeTime},
+	}
+	// KbChunksTable holds the schema information for the "kb_chunks" table.
+	KbChunksTable = &schema.Table{
+		Name:       "kb_chunks",
+		Columns:    KbChunksColumns,
+		PrimaryKey: []*schema.Column{KbChunksColumns[0]},
+		Indexes: []*schema.Index{
+			{
+				Name:    "kbchunk_kb_source",
+				Unique:  false,
+				Columns: []*schema.Column{KbChunksColumns[1], KbChunksColumns[2]},
+			},
+		},
+	}
+	// ReceivedMessagesColumns holds the columns for the "received_messages" table.
+	ReceivedMessagesColumns = []*schema.Column{
+		{Name: "id", Type: field.TypeInt, Increment: true},
+		{Name: "app", Type: field.TypeString, Size: 64},
+		{Name: "message_id", Type: field.TypeString, Size: 64},
+		{Name: "created_at", Type: field.TypeTime},
+	}
+	// ReceivedMessagesTable holds the schema information for the "received_messages" table.
+	ReceivedMessagesTable = &schema.Table{
+		Name:       "received_messages",
+		Columns:    ReceivedMessagesColumns,
+		PrimaryKey: []*schema.Column{ReceivedMessagesColumns[0]},
+		Indexes: []*schema.Index{
+			{
+				Name:    "receivedmessage_app_message_id",
+				Unique:  true,
+				Columns: []*schema.Column{ReceivedMessagesColumns[1], ReceivedMessagesColumns[2]},
+			},
+			{
+				Name:    "receivedmessage_created_at",
+				Unique:  false,
+				Columns: []*schema.Column{ReceivedMessagesColumns[3]},
+			},
+		},
+	}
+	// PiiValuesColumns holds the columns for the "pii_values" table.
+	PiiValuesColumns = []*schema.Column{
+		{Name: "id", Type: field.TypeInt, Increment: true},
+		{Name: "app", Type: field.TypeString, Size: 64},
+		{Name: "user_id", Type: field.TypeString, Size: 64},
+		{Name: "placeholder", Type: field.TypeString, Size: 64},
+		{Name: "value", Type: field.TypeString, Size: 2147483647},
+		{Name: "expires_at", Type: field.TypeTime},
+	}
+	// PiiValuesTable holds the schema information for the "pii_values" table.
+	PiiValuesTable = &schema.Table{
+		Name:       "pii_values",
+		Columns:    PiiValuesColumns,
+		PrimaryKey: []*schema.Column{PiiValuesColumns[0]},
+		Indexes: []*schema.Index{
+			{
+				Name:    "piivalue_app_user_id_placeholder",
+				Unique:  true,
+				Columns: []*schema.Column{PiiValuesColumns[1], PiiValuesColumns[2], PiiValuesColumns[3]},
+			},
+			{
+				Name:    "piivalue_expires_at",
+				Unique:  false,
+				Columns: []*schema.Column{PiiValuesColumns[5]},
+			},
+		},
+	}
+	// DailyQuotasColumns holds the columns for the "daily_quotas" table.
+	DailyQuotasColumns = []*schema.Column{
+		{Name: "id", Type: field.TypeInt, Increment: true},
+		{Name: "app", Type: field.TypeString, Size: 64},
+		{Name: "user_id", Type: field.TypeString, Size: 64},
+		{Name: "day", Type: field.TypeString, Size: 10},
+		{Name: "messages", Type: field.TypeInt, Default: 0},
+		{Name: "tokens", Type: field.TypeInt, Default: 0},
+	}
+	// DailyQuotasTable holds the schema information for the "daily_quotas" table.
+	DailyQuotasTable = &schema.Table{
+		Name:       "daily_quotas",
+		Columns:    DailyQuotasColumns,
+		PrimaryKey: []*schema.Column{DailyQuotasColumns[0]},
+		Indexes: []*schema.Index{
+			{
+				Name:    "dailyquota_app_user_id_day",
+				Unique:  true,
+				Columns: []*schema.Column{DailyQuotasColumns[1], DailyQuotasColumns[2], DailyQuotasColumns[3]},
+			},
+			{
+				Name:    "dailyquota_day",
+				Unique:  false,
+				Columns: []*schema.Column{DailyQuotasColumns[3]},
+			},
+		},
+	}
+	// AdminNoncesColumns holds the columns for the "admin_nonces" table.
+	AdminNoncesColumns = []*schema.Column{
+		{Name: "id", Type: field.TypeInt, Increment: true},
+		{Name: "nonce", Type: field.TypeString, Size: 64},
+		{Name: "expires_at", Type: field.TypeTime},
+	}
+	// AdminNoncesTable holds the schema information for the "admin_nonces" table.
+	AdminNoncesTable = &schema.Table{
+		Name:       "admin_nonces",
+		Columns:    AdminNoncesColumns,
+		PrimaryKey: []*schema.Column{AdminNoncesColumns[0]},
+		Indexes: []*schema.Index{
+			{
+				Name:    "adminnonce_nonce",
+				Unique:  true,
+				Columns: []*schema.Column{AdminNoncesColumns[1]},
+			},
+			{
+				Name:    "adminnonce_expires_at",
+				Unique:  false,
+				Columns: []*schema.Column{AdminNoncesColumns[2]},
+			},
+		},
+	}
+	// Tables holds all the tables in the schema.
+	Tables = []*schema.Table{
+		UserSessionsTable,
+		UsagesTable,
+		AppSettingsTable,
+		FeedbacksTable,
+		KbChunksTable,
+		ReceivedMessagesTable,
+		PiiValuesTable,
+		DailyQuotasTable,
+		AdminNoncesTable,
+	}
+)
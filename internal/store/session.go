@@ -0,0 +1,304 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"entgo.io/ent/dialect"
+	entsql "entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/sqlgraph"
+)
+
+// DefaultSessionName 用户默认会话的名称
+const DefaultSessionName = "default"
+
+// ErrSessionExists 用户已有同名会话
+var ErrSessionExists = errors.New("store: session already exists")
+
+// Session 用户的命名会话。每个命名会话对应 xgpt3 中的一个独立对话，
+// 通过 ConversationKey 作为 xgpt3 的用户 Id 进行隔离。
+type Session struct {
+	ID int
+	// 飞书应用名称，默认应用为空
+	App string
+	// 用户 Id
+	UserID string
+	// 会话名称
+	Name string
+	// xgpt3 对话使用的用户 Id
+	ConversationKey string
+	// 会话的系统提示词
+	SystemPrompt string
+	// 会话使用的模型，为空时使用默认模型
+	Model string
+	// 是否为当前会话
+	Active    bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+var sessionColumns = []string{"id", "app", "user_id", "name", "conversation_key", "system_prompt", "model", "active", "created_at", "updated_at"}
+
+func scanSession(rows *entsql.Rows) (*Session, error) {
+	s := &Session{}
+	if err := rows.Scan(&s.ID, &s.App, &s.UserID, &s.Name, &s.ConversationKey, &s.SystemPrompt, &s.Model, &s.Active, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("scan session failed: %w", err)
+	}
+	return s, nil
+}
+
+func (s *Store) selectSessions(ctx context.Context, conn dialect.ExecQuerier, where *entsql.Predicate) ([]*Session, error) {
+	t := entsql.Table(UserSessionsTable.Name)
+	q := s.builder().Select(sessionColumns...).From(t).Where(where).OrderBy(entsql.Asc("created_at"), entsql.Asc("id"))
+	result := make([]*Session, 0)
+	err := query(ctx, conn, q, func(rows *entsql.Rows) error {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return err
+		}
+		result = append(result, sess)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query sessions failed: %w", err)
+	}
+	return result, nil
+}
+
+func (s *Store) getSession(ctx context.Context, conn dialect.ExecQuerier, where *entsql.Predicate) (*Session, error) {
+	sessions, err := s.selectSessions(ctx, conn, where)
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, ErrNotFound
+	}
+	return sessions[0], nil
+}
+
+// userSessions 应用中用户的会话
+func userSessions(app, userId string) *entsql.Predicate {
+	return entsql.And(entsql.EQ("app", app), entsql.EQ("user_id", userId))
+}
+
+// userSession 应用中用户的命名会话
+func userSession(app, userId, name string) *entsql.Predicate {
+	return entsql.And(entsql.EQ("app", app), entsql.EQ("user_id", userId), entsql.EQ("name", name))
+}
+
+// ListUserSessions 获取用户在全部应用中的会话
+func (s *Store) ListUserSessions(ctx context.Context, userId string) ([]*Session, error) {
+	return s.selectSessions(ctx, s.drv, entsql.EQ("user_id", userId))
+}
+
+// ListSessions 获取用户在应用中的全部会话
+func (s *Store) ListSessions(ctx context.Context, app, userId string) ([]*Session, error) {
+	return s.selectSessions(ctx, s.drv, userSessions(app, userId))
+}
+
+// GetSession 根据名称获取用户在应用中的会话
+func (s *Store) GetSession(ctx context.Context, app, userId, name string) (*Session, error) {
+	return s.getSession(ctx, s.drv, userSession(app, userId, name))
+}
+
+// SessionByConversationKey 根据 xgpt3 对话的用户 Id 获取会话
+func (s *Store) SessionByConversationKey(ctx context.Context, key string) (*Session, error) {
+	return s.getSession(ctx, s.drv, entsql.EQ("conversation_key", key))
+}
+
+// ActiveSession 获取用户在应用中当前的会话。用户没有任何会话时，创建默认会话。
+// 默认应用的默认会话直接使用用户 Id 作为 xgpt3 的用户 Id，以兼容已有的对话；其他应用与命名会话一样生成新的对话用户 Id。
+func (s *Store) ActiveSession(ctx context.Context, app, userId string) (*Session, error) {
+	sess, err := s.getSession(ctx, s.drv, entsql.And(userSessions(app, userId), entsql.EQ("active", true)))
+	if err == nil {
+		return sess, nil
+	}
+	if err != ErrNotFound {
+		return nil, err
+	}
+
+	sessions, err := s.ListSessions(ctx, app, userId)
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) > 0 {
+		return s.SwitchSession(ctx, app, userId, sessions[0].Name)
+	}
+	key := userId
+	if app != "" {
+		if key, err = newConversationKey(userId); err != nil {
+			return nil, err
+		}
+	}
+	// 同一用户的并发消息可能同时创建默认会话，唯一索引保证只有一个成功
+	if err := s.insertSession(ctx, s.drv, app, userId, DefaultSessionName, key, true); err != nil && err != ErrSessionExists {
+		return nil, err
+	}
+	return s.GetSession(ctx, app, userId, DefaultSessionName)
+}
+
+// CreateSession 在应用中创建命名会话并切换到该会话，同名会话已存在时返回 ErrSessionExists
+func (s *Store) CreateSession(ctx context.Context, app, userId, name string) (*Session, error) {
+	key, err := newConversationKey(userId)
+	if err != nil {
+		return nil, err
+	}
+	err = s.withTx(ctx, func(conn dialect.ExecQuerier) error {
+		if _, err := s.getSession(ctx, conn, userSession(app, userId, name)); err == nil {
+			return ErrSessionExists
+		} else if err != ErrNotFound {
+			return err
+		}
+		if err := s.deactivateSessions(ctx, conn, app, userId); err != nil {
+			return err
+		}
+		return s.insertSession(ctx, conn, app, userId, name, key, true)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetSession(ctx, app, userId, name)
+}
+
+// SwitchSession 切换用户在应用中的当前会话
+func (s *Store) SwitchSession(ctx context.Context, app, userId, name string) (*Session, error) {
+	err := s.withTx(ctx, func(conn dialect.ExecQuerier) error {
+		if err := s.deactivateSessions(ctx, conn, app, userId); err != nil {
+			return err
+		}
+		n, err := exec(ctx, conn, s.builder().Update(UserSessionsTable.Name).
+			Set("active", true).
+			Set("updated_at", time.Now()).
+			Where(userSession(app, userId, name)))
+		if err != nil {
+			return fmt.Errorf("activate session failed: %w", err)
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetSession(ctx, app, userId, name)
+}
+
+// RenameSession 重命名会话，新名称已存在时返回 ErrSessionExists。会话对应的 xgpt3 对话不受影响。
+func (s *Store) RenameSession(ctx context.Context, app, userId, name, newName string) error {
+	n, err := exec(ctx, s.drv, s.builder().Update(UserSessionsTable.Name).
+		Set("name", newName).
+		Set("updated_at", time.Now()).
+		Where(userSession(app, userId, name)))
+	if err != nil && sqlgraph.IsUniqueConstraintError(err) {
+		return ErrSessionExists
+	}
+	if err != nil {
+		return fmt.Errorf("rename session failed: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteSession 删除会话，返回被删除的会话
+func (s *Store) DeleteSession(ctx context.Context, app, userId, name string) (*Session, error) {
+	sess, err := s.GetSession(ctx, app, userId, name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = exec(ctx, s.drv, s.builder().Delete(UserSessionsTable.Name).Where(entsql.EQ("id", sess.ID)))
+	if err != nil {
+		return nil, fmt.Errorf("delete session failed: %w", err)
+	}
+	return sess, nil
+}
+
+// UpdateSession 更新会话的系统提示词和模型
+func (s *Store) UpdateSession(ctx context.Context, sess *Session) error {
+	_, err := exec(ctx, s.drv, s.builder().Update(UserSessionsTable.Name).
+		Set("system_prompt", sess.SystemPrompt).
+		Set("model", sess.Model).
+		Set("updated_at", time.Now()).
+		Where(entsql.EQ("id", sess.ID)))
+	if err != nil {
+		return fmt.Errorf("update session failed: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) deactivateSessions(ctx context.Context, conn dialect.ExecQuerier, app, userId string) error {
+	_, err := exec(ctx, conn, s.builder().Update(UserSessionsTable.Name).
+		Set("active", false).
+		Where(entsql.And(userSessions(app, userId), entsql.EQ("active", true))))
+	if err != nil {
+		return fmt.Errorf("deactivate sessions failed: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) insertSession(ctx context.Context, conn dialect.ExecQuerier, app, userId, name, key string, active bool) error {
+	now := time.Now()
+	_, err := exec(ctx, conn, s.builder().Insert(UserSessionsTable.Name).
+		Columns("app", "user_id", "name", "conversation_key", "system_prompt", "model", "active", "created_at", "updated_at").
+		Values(app, userId, name, key, "", "", active, now, now))
+	if err != nil && sqlgraph.IsUniqueConstraintError(err) {
+		return ErrSessionExists
+	}
+	if err != nil {
+		return fmt.Errorf("insert session failed: %w", err)
+	}
+	return nil
+}
+
+// MarkIdleExpired 记录会话因长时间不活跃被后台任务关闭，lastActivity 为会话最后活跃的时间。
+// 用户下次发送消息时通过 TakeIdleExpired 获取该记录并提示用户已开启新的会话。
+func (s *Store) MarkIdleExpired(ctx context.Context, conversationKey string, lastActivity time.Time) error {
+	_, err := exec(ctx, s.drv, s.builder().Update(UserSessionsTable.Name).
+		Set("idle_expired_at", lastActivity).
+		Where(entsql.EQ("conversation_key", conversationKey)))
+	if err != nil {
+		return fmt.Errorf("mark session idle expired failed: %w", err)
+	}
+	return nil
+}
+
+// TakeIdleExpired 获取并清除会话的过期记录，返回会话最后活跃的时间。没有记录时返回 false。
+func (s *Store) TakeIdleExpired(ctx context.Context, sessionId int) (time.Time, bool, error) {
+	var last sql.NullTime
+	q := s.builder().Select("idle_expired_at").From(entsql.Table(UserSessionsTable.Name)).Where(entsql.EQ("id", sessionId))
+	err := query(ctx, s.drv, q, func(rows *entsql.Rows) error {
+		return rows.Scan(&last)
+	})
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("query session idle expired failed: %w", err)
+	}
+	if !last.Valid {
+		return time.Time{}, false, nil
+	}
+
+	// 并发的消息只有一条能清除记录，避免重复提示
+	n, err := exec(ctx, s.drv, s.builder().Update(UserSessionsTable.Name).
+		SetNull("idle_expired_at").
+		Where(entsql.And(entsql.EQ("id", sessionId), entsql.NotNull("idle_expired_at"))))
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("clear session idle expired failed: %w", err)
+	}
+	return last.Time, n > 0, nil
+}
+
+// newConversationKey 生成命名会话的 xgpt3 用户 Id。
+// xgpt3 的 user_id 字段长度为 50，open_id 为 35 位，因此只追加 8 位随机后缀。
+func newConversationKey(userId string) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate conversation key failed: %w", err)
+	}
+	return userId + "#" + hex.EncodeToString(b), nil
+}
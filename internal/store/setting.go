@@ -0,0 +1,66 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"entgo.io/ent/dialect"
+	entsql "entgo.io/ent/dialect/sql"
+)
+
+// ListAppSettings 获取应用在运行时修改的设置，返回以设置名称为 key 的 map
+func (s *Store) ListAppSettings(ctx context.Context, app string) (map[string]string, error) {
+	result := make(map[string]string)
+	q := s.builder().Select("name", "value").From(entsql.Table(AppSettingsTable.Name)).Where(entsql.EQ("app", app))
+	err := query(ctx, s.drv, q, func(rows *entsql.Rows) error {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return fmt.Errorf("scan app setting failed: %w", err)
+		}
+		result[name] = value
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query app settings failed: %w", err)
+	}
+	return result, nil
+}
+
+// SetAppSettings 保存应用的设置，values 中的设置会覆盖已有的值
+func (s *Store) SetAppSettings(ctx context.Context, app string, values map[string]string) error {
+	return s.withTx(ctx, func(conn dialect.ExecQuerier) error {
+		now := time.Now()
+		for name, value := range values {
+			_, err := exec(ctx, conn, s.builder().Delete(AppSettingsTable.Name).
+				Where(entsql.And(entsql.EQ("app", app), entsql.EQ("name", name))))
+			if err != nil {
+				return fmt.Errorf("delete app setting failed: %w", err)
+			}
+			_, err = exec(ctx, conn, s.builder().Insert(AppSettingsTable.Name).
+				Columns("app", "name", "value", "updated_at").
+				Values(app, name, value, now))
+			if err != nil {
+				return fmt.Errorf("insert app setting failed: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteAppSettings 删除应用的设置，恢复使用配置文件中的值
+func (s *Store) DeleteAppSettings(ctx context.Context, app string, names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	values := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		values = append(values, name)
+	}
+	_, err := exec(ctx, s.drv, s.builder().Delete(AppSettingsTable.Name).
+		Where(entsql.And(entsql.EQ("app", app), entsql.In("name", values...))))
+	if err != nil {
+		return fmt.Errorf("delete app settings failed: %w", err)
+	}
+	return nil
+}
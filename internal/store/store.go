@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"entgo.io/ent/dialect"
+	entsql "entgo.io/ent/dialect/sql"
+	"github.com/fanchunke/xgpt3/conversation/ent/chatent"
+)
+
+// ErrNotFound 记录不存在
+var ErrNotFound = errors.New("store: record not found")
+
+// Store 保存 chatgpt-lark 自身的业务数据，与 xgpt3 的会话表共用同一个数据库。
+// xgpt3 的会话表通过 chatent 访问。
+type Store struct {
+	drv     dialect.Driver
+	chatent *chatent.Client
+}
+
+func New(drv dialect.Driver) *Store {
+	return &Store{drv: drv, chatent: chatent.NewClient(chatent.Driver(drv))}
+}
+
+// Chatent 返回访问 xgpt3 会话表的 client
+func (s *Store) Chatent() *chatent.Client {
+	return s.chatent
+}
+
+func (s *Store) builder() *entsql.DialectBuilder {
+	return entsql.Dialect(s.drv.Dialect())
+}
+
+// Ping 检查数据库连接是否可用
+func (s *Store) Ping(ctx context.Context) error {
+	rows := &entsql.Rows{}
+	if err := s.drv.Query(ctx, "SELECT 1", []interface{}{}, rows); err != nil {
+		return fmt.Errorf("store - ping failed: %w", err)
+	}
+	return rows.Close()
+}
+
+// withTx 在事务中执行 fn
+func (s *Store) withTx(ctx context.Context, fn func(conn dialect.ExecQuerier) error) error {
+	tx, err := s.drv.Tx(ctx)
+	if err != nil {
+		return fmt.Errorf("store - start transaction failed: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			err = fmt.Errorf("%w: rolling back transaction: %v", err, rerr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func exec(ctx context.Context, conn dialect.ExecQuerier, q entsql.Querier) (int64, error) {
+	stmt, args := q.Query()
+	var res entsql.Result
+	if err := conn.Exec(ctx, stmt, args, &res); err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func query(ctx context.Context, conn dialect.ExecQuerier, q entsql.Querier, scan func(rows *entsql.Rows) error) error {
+	stmt, args := q.Query()
+	rows := &entsql.Rows{}
+	if err := conn.Query(ctx, stmt, args, rows); err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,229 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	entsql "entgo.io/ent/dialect/sql"
+	"github.com/fanchunke/chatgpt-lark/internal/migrate"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestStore 创建执行了全部迁移的内存数据库
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	drv, err := entsql.Open("sqlite3", fmt.Sprintf("file:store-%d?mode=memory&cache=shared&_fk=1", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { drv.Close() })
+	m, err := migrate.New(drv.DB(), drv.Dialect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background(), 0); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	return New(drv)
+}
+
+func TestTakeQuota(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	const day = "2026-01-02"
+
+	cases := []struct {
+		name        string
+		userId      string
+		maxMessages int
+		maxTokens   int
+		// tokens 每条消息之后计入的 token 数
+		tokens int
+		// allowed 连续发送时允许的消息数
+		allowed int
+	}{
+		{name: "messages", userId: "ou_1", maxMessages: 2, allowed: 2},
+		{name: "tokens", userId: "ou_2", maxTokens: 10, tokens: 4, allowed: 3},
+		{name: "unlimited", userId: "ou_3", tokens: 100, allowed: 5},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			allowed := 0
+			for i := 0; i < 5; i++ {
+				ok, err := s.TakeQuota(ctx, "app", tc.userId, day, tc.maxMessages, tc.maxTokens)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					break
+				}
+				allowed++
+				if err := s.AddQuotaTokens(ctx, "app", tc.userId, day, tc.tokens); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if allowed != tc.allowed {
+				t.Errorf("allowed %d messages, want %d", allowed, tc.allowed)
+			}
+		})
+	}
+
+	// 计数按应用和日期区分，清空后重新计数
+	usages, err := s.ListQuotaUsages(ctx, "app", day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []QuotaUsage{{UserID: "ou_1", Messages: 2}, {UserID: "ou_2", Messages: 3, Tokens: 12}, {UserID: "ou_3", Messages: 5, Tokens: 500}}
+	if fmt.Sprint(usages) != fmt.Sprint(want) {
+		t.Errorf("usages = %+v, want %+v", usages, want)
+	}
+	if ok, err := s.TakeQuota(ctx, "other", "ou_1", day, 2, 0); err != nil || !ok {
+		t.Errorf("other app = %v, %v, want allowed", ok, err)
+	}
+	if err := s.ResetQuota(ctx, "app", "ou_1", day); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.TakeQuota(ctx, "app", "ou_1", day, 2, 0); err != nil || !ok {
+		t.Errorf("after reset = %v, %v, want allowed", ok, err)
+	}
+	if n, err := s.PurgeQuotas(ctx, "2026-01-03"); err != nil || n != 4 {
+		t.Errorf("purge = %d, %v, want 4", n, err)
+	}
+}
+
+func TestUseNonce(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	expiresAt := time.Now().Add(time.Minute)
+	for i, want := range []bool{true, false} {
+		first, err := s.UseNonce(ctx, "nonce", expiresAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first != want {
+			t.Errorf("use %d = %v, want %v", i, first, want)
+		}
+	}
+	if n, err := s.PurgeNonces(ctx, expiresAt.Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("purge = %d, %v, want 1", n, err)
+	}
+}
+
+func TestUsageStatsByDay(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+8", 8*3600)
+	defer func() { time.Local = local }()
+
+	ctx := context.Background()
+	s := newTestStore(t)
+	// 按 UTC 保存的时间在本地时区中跨天
+	for _, createdAt := range []time.Time{
+		time.Date(2026, 1, 1, 15, 30, 0, 0, time.UTC),
+		time.Date(2026, 1, 1, 16, 30, 0, 0, time.UTC),
+		time.Date(2026, 1, 2, 2, 0, 0, 0, time.UTC),
+	} {
+		if err := s.CreateUsage(ctx, &Usage{ConversationKey: "ou_1", Model: "gpt", TotalTokens: 10, CreatedAt: createdAt}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := s.UsageStats(ctx, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC), UsageGroupDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []UsageStat{{Key: "2026-01-01", Requests: 1, TotalTokens: 10}, {Key: "2026-01-02", Requests: 2, TotalTokens: 20}}
+	if len(stats) != len(want) {
+		t.Fatalf("got %d stats, want %d: %+v", len(stats), len(want), stats)
+	}
+	for i, stat := range stats {
+		if *stat != want[i] {
+			t.Errorf("stats[%d] = %+v, want %+v", i, *stat, want[i])
+		}
+	}
+}
+
+func TestPurgeFeedbacks(t *testing.T) {
+	ctx := context.Background()
+	before := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		mode RetentionMode
+		// want 清理后的评价，按 Id 倒序
+		want []string
+	}{
+		{mode: RetentionDelete, want: []string{"ou_1 sess_1 新的"}},
+		{mode: RetentionMetadata, want: []string{"ou_1 sess_1 新的", "ou_1 sess_1 "}},
+		{mode: RetentionAnonymize, want: []string{"ou_1 sess_1 新的", AnonymousID("ou_1", "salt") + " " + AnonymousID("sess_1", "salt") + " 旧的"}},
+	}
+	for _, tc := range cases {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			s := newTestStore(t)
+			for _, f := range []*Feedback{
+				{UserID: "ou_1", ConversationKey: "sess_1", Rating: RatingBad, Comment: "旧的", CreatedAt: before.Add(-time.Hour)},
+				{UserID: "ou_1", ConversationKey: "sess_1", Rating: RatingBad, Comment: "新的", CreatedAt: before.Add(time.Hour)},
+			} {
+				if err := s.CreateFeedback(ctx, f); err != nil {
+					t.Fatal(err)
+				}
+			}
+			result, err := s.Purge(ctx, before, tc.mode, "salt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Feedbacks != 1 {
+				t.Errorf("purged %d feedbacks, want 1", result.Feedbacks)
+			}
+			feedbacks, err := s.ListFeedbacks(ctx, RatingBad, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(feedbacks))
+			for _, f := range feedbacks {
+				got = append(got, f.UserID+" "+f.ConversationKey+" "+f.Comment)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("feedbacks = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestListIdleConversations(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	before := time.Now().Add(-time.Hour)
+	// ou_1 有两个开启中的对话，只返回一次；ou_3 在 before 之后创建
+	for _, c := range []struct {
+		key       string
+		createdAt time.Time
+	}{
+		{"ou_1", before.Add(-2 * time.Hour)},
+		{"ou_1", before.Add(-time.Hour)},
+		{"ou_2", before.Add(-time.Hour)},
+		{"ou_3", before.Add(time.Minute)},
+		{"ou_4", before.Add(-time.Hour)},
+	} {
+		if _, err := s.chatent.Session.Create().SetUserID(c.key).SetStatus(true).SetCreatedAt(c.createdAt).Save(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 按批获取，每批的数量为不重复的用户数
+	var got []string
+	after := ""
+	for {
+		keys, err := s.ListIdleConversations(ctx, before, after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, keys...)
+		if len(keys) < 2 {
+			break
+		}
+		after = keys[len(keys)-1]
+	}
+	if want := []string{"ou_1", "ou_2", "ou_4"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+}
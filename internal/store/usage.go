@@ -0,0 +1,202 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"entgo.io/ent/dialect"
+	entsql "entgo.io/ent/dialect/sql"
+)
+
+// Usage 一次 GPT 调用的模型和 token 用量
+type Usage struct {
+	ID int
+	// 飞书应用名称，默认应用为空
+	App string
+	// xgpt3 对话的用户 Id
+	ConversationKey string
+	// 对应的 xgpt3 回复消息 Id，未开启会话功能时为 0
+	MessageID        int
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	CreatedAt        time.Time
+}
+
+var usageColumns = []string{"id", "app", "conversation_key", "message_id", "model", "prompt_tokens", "completion_tokens", "total_tokens", "created_at"}
+
+// CreateUsage 记录一次 GPT 调用的用量
+func (s *Store) CreateUsage(ctx context.Context, u *Usage) error {
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now()
+	}
+	_, err := exec(ctx, s.drv, s.builder().Insert(UsagesTable.Name).
+		Columns(usageColumns[1:]...).
+		Values(u.App, u.ConversationKey, u.MessageID, u.Model, u.PromptTokens, u.CompletionTokens, u.TotalTokens, u.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("insert usage failed: %w", err)
+	}
+	return nil
+}
+
+// ListUsagesByMessage 获取回复消息对应的用量，返回以消息 Id 为 key 的 map
+func (s *Store) ListUsagesByMessage(ctx context.Context, messageIds ...int) (map[int]*Usage, error) {
+	result := make(map[int]*Usage, len(messageIds))
+	if len(messageIds) == 0 {
+		return result, nil
+	}
+
+	ids := make([]interface{}, 0, len(messageIds))
+	for _, id := range messageIds {
+		ids = append(ids, id)
+	}
+	q := s.builder().Select(usageColumns...).From(entsql.Table(UsagesTable.Name)).Where(entsql.In("message_id", ids...))
+	err := query(ctx, s.drv, q, func(rows *entsql.Rows) error {
+		u := &Usage{}
+		if err := rows.Scan(&u.ID, &u.App, &u.ConversationKey, &u.MessageID, &u.Model, &u.PromptTokens, &u.CompletionTokens, &u.TotalTokens, &u.CreatedAt); err != nil {
+			return fmt.Errorf("scan usage failed: %w", err)
+		}
+		result[u.MessageID] = u
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query usages failed: %w", err)
+	}
+	return result, nil
+}
+
+// UsageGroup 用量统计的分组方式
+type UsageGroup string
+
+const (
+	UsageGroupModel UsageGroup = "model"
+	UsageGroupUser  UsageGroup = "user"
+	UsageGroupDay   UsageGroup = "day"
+	UsageGroupApp   UsageGroup = "app"
+)
+
+// ParseUsageGroup 解析分组方式，为空时按模型分组
+func ParseUsageGroup(s string) (UsageGroup, error) {
+	switch g := UsageGroup(s); g {
+	case "":
+		return UsageGroupModel, nil
+	case UsageGroupModel, UsageGroupUser, UsageGroupDay, UsageGroupApp:
+		return g, nil
+	}
+	return "", fmt.Errorf("unsupported usage group %q, supported groups: model, user, day, app", s)
+}
+
+// UsageStat 一个分组的用量合计
+type UsageStat struct {
+	// 分组的值：模型名称、用户 Id、2006-01-02 格式的日期或应用名称
+	Key              string
+	Requests         int
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+}
+
+func (u *UsageStat) add(o *UsageStat) {
+	u.Requests += o.Requests
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.TotalTokens += o.TotalTokens
+}
+
+// UsageStats 统计 [from, to) 时间范围内的用量，按 total tokens 倒序返回，按日期分组时按日期排序。
+// 按用户分组时，对话的用户 Id 通过会话表映射为用户 Id，会话已删除时使用对话的用户 Id。
+// 按日期分组时在数据库中按服务器当前的时区偏移汇总，时间范围跨越夏令时切换时日期的边界可能偏移一小时。
+func (s *Store) UsageStats(ctx context.Context, from, to time.Time, group UsageGroup) ([]*UsageStat, error) {
+	column := "model"
+	switch group {
+	case UsageGroupUser:
+		column = "conversation_key"
+	case UsageGroupApp:
+		column = "app"
+	case UsageGroupDay:
+		column = s.localDate("created_at")
+	}
+	q := s.builder().
+		Select(column, entsql.Count("*"), entsql.Sum("prompt_tokens"), entsql.Sum("completion_tokens"), entsql.Sum("total_tokens")).
+		From(entsql.Table(UsagesTable.Name)).
+		Where(entsql.And(entsql.GTE("created_at", from), entsql.LT("created_at", to))).
+		GroupBy(column)
+
+	stats := make(map[string]*UsageStat)
+	keys := make([]interface{}, 0)
+	err := query(ctx, s.drv, q, func(rows *entsql.Rows) error {
+		stat := &UsageStat{}
+		if err := rows.Scan(&stat.Key, &stat.Requests, &stat.PromptTokens, &stat.CompletionTokens, &stat.TotalTokens); err != nil {
+			return fmt.Errorf("scan usage stat failed: %w", err)
+		}
+		stats[stat.Key] = stat
+		keys = append(keys, stat.Key)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query usage stats failed: %w", err)
+	}
+
+	if group == UsageGroupUser && len(keys) > 0 {
+		sessions, err := s.selectSessions(ctx, s.drv, entsql.In("conversation_key", keys...))
+		if err != nil {
+			return nil, err
+		}
+		for _, sess := range sessions {
+			stat, ok := stats[sess.ConversationKey]
+			if !ok || sess.ConversationKey == sess.UserID {
+				continue
+			}
+			delete(stats, sess.ConversationKey)
+			if owner, ok := stats[sess.UserID]; ok {
+				owner.add(stat)
+			} else {
+				stat.Key = sess.UserID
+				stats[sess.UserID] = stat
+			}
+		}
+	}
+
+	result := make([]*UsageStat, 0, len(stats))
+	for _, stat := range stats {
+		result = append(result, stat)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if group == UsageGroupDay {
+			return result[i].Key < result[j].Key
+		}
+		if result[i].TotalTokens != result[j].TotalTokens {
+			return result[i].TotalTokens > result[j].TotalTokens
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result, nil
+}
+
+// localDate 返回将时间列转换为本地时区 2006-01-02 格式日期的 SQL 表达式，使用服务器当前的时区偏移
+func (s *Store) localDate(column string) string {
+	_, offset := time.Now().Zone()
+	minutes := offset / 60
+	switch s.drv.Dialect() {
+	case dialect.MySQL:
+		// timestamp 列按会话时区返回
+		return fmt.Sprintf("DATE_FORMAT(CONVERT_TZ(%s, @@session.time_zone, '%s'), '%%Y-%%m-%%d')", column, utcOffset(minutes))
+	case dialect.Postgres:
+		return fmt.Sprintf("TO_CHAR((%s AT TIME ZONE 'UTC') + INTERVAL '%d minutes', 'YYYY-MM-DD')", column, minutes)
+	default:
+		// sqlite 先将带时区的时间转换为 UTC
+		return fmt.Sprintf("DATE(%s, '%+d minutes')", column, minutes)
+	}
+}
+
+// utcOffset 将分钟数格式化为 +08:00 格式的时区偏移
+func utcOffset(minutes int) string {
+	sign := '+'
+	if minutes < 0 {
+		sign, minutes = '-', -minutes
+	}
+	return fmt.Sprintf("%c%02d:%02d", sign, minutes/60, minutes%60)
+}
@@ -0,0 +1,69 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// 内置工具的名称
+const (
+	CurrentTime    = "get_current_time"
+	LookupUser     = "lookup_user"
+	CheckFreebusy  = "check_freebusy"
+	CreateTask     = "create_task"
+	SearchMessages = "search_messages"
+)
+
+var weekdays = []string{"星期日", "星期一", "星期二", "星期三", "星期四", "星期五", "星期六"}
+
+// Builtin 返回不依赖飞书接口的内置工具
+func Builtin() []*Tool {
+	return []*Tool{currentTime(time.Now)}
+}
+
+func currentTime(now func() time.Time) *Tool {
+	return &Tool{
+		Name:        CurrentTime,
+		Description: "获取当前的日期、时间和星期，回答与今天、明天、本周等相对时间有关的问题前先调用",
+		Parameters: object(map[string]interface{}{
+			"timezone": property("string", "IANA 时区，如 Asia/Shanghai，默认为服务所在时区"),
+		}),
+		Run: func(ctx context.Context, inv *Invocation) (string, error) {
+			var args struct {
+				Timezone string `json:"timezone"`
+			}
+			if err := inv.Bind(&args); err != nil {
+				return "", err
+			}
+			loc := time.Local
+			if args.Timezone != "" {
+				l, err := time.LoadLocation(args.Timezone)
+				if err != nil {
+					return "", fmt.Errorf("unknown timezone %q", args.Timezone)
+				}
+				loc = l
+			}
+			t := now().In(loc)
+			zone, _ := t.Zone()
+			return fmt.Sprintf("%s %s（%s，UTC%s）", t.Format("2006-01-02 15:04:05"), weekdays[t.Weekday()], zone, t.Format("-07:00")), nil
+		},
+	}
+}
+
+// parseTime 解析 RFC3339 或 2006-01-02 15:04 格式的时间，没有时区时使用服务所在时区
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use RFC3339 or 2006-01-02 15:04", s)
+}
+
+func formatTime(t time.Time) string {
+	return t.In(time.Local).Format("2006-01-02 15:04")
+}
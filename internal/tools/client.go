@@ -0,0 +1,127 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// RoleTool 工具调用结果消息的角色
+const RoleTool = "tool"
+
+// Definition 请求中 tools 参数的一项
+type Definition struct {
+	Type     string   `json:"type"`
+	Function Function `json:"function"`
+}
+
+type Function struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// Call GPT 返回的工具调用
+type Call struct {
+	Id       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name string `json:"name"`
+	// Arguments JSON 格式的参数
+	Arguments string `json:"arguments"`
+}
+
+// Message chat completion 的消息，在 go-openai 的消息之外支持工具调用和调用结果
+type Message struct {
+	Role       string `json:"role"`
+	Content    string `json:"content"`
+	ToolCalls  []Call `json:"tool_calls,omitempty"`
+	ToolCallId string `json:"tool_call_id,omitempty"`
+}
+
+// Request 带有 tools 参数的 chat completion 请求
+type Request struct {
+	Model      string       `json:"model"`
+	Messages   []Message    `json:"messages"`
+	Tools      []Definition `json:"tools,omitempty"`
+	ToolChoice string       `json:"tool_choice,omitempty"`
+	MaxTokens  int          `json:"max_tokens,omitempty"`
+	User       string       `json:"user,omitempty"`
+}
+
+type Choice struct {
+	Index        int     `json:"index"`
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+type Response struct {
+	Id      string       `json:"id"`
+	Model   string       `json:"model"`
+	Choices []Choice     `json:"choices"`
+	Usage   openai.Usage `json:"usage"`
+}
+
+// Client 调用 OpenAI chat completion 接口的工具调用请求。go-openai 的请求不支持 tools 参数，因此直接发送 HTTP 请求。
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewClient 创建 client，baseURL 与 go-openai 的 BaseURL 一致，如 https://api.openai.com/v1
+func NewClient(baseURL, apiKey string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey, httpClient: httpClient}
+}
+
+// CreateChatCompletion 发送 chat completion 请求，返回的消息中可能包含工具调用
+func (c *Client) CreateChatCompletion(ctx context.Context, req *Request) (*Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request failed: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	httpResp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response failed: %w", err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		var errResp openai.ErrorResponse
+		if err := json.Unmarshal(data, &errResp); err == nil && errResp.Error != nil {
+			return nil, fmt.Errorf("status code %d: %s", httpResp.StatusCode, errResp.Error.Message)
+		}
+		return nil, fmt.Errorf("status code %d", httpResp.StatusCode)
+	}
+
+	resp := &Response{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, fmt.Errorf("unmarshal response failed: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("Empty GPT Choices")
+	}
+	return resp, nil
+}
@@ -0,0 +1,555 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lark "github.com/larksuite/oapi-sdk-go/v3"
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkcalendar "github.com/larksuite/oapi-sdk-go/v3/service/calendar/v4"
+	larkcontact "github.com/larksuite/oapi-sdk-go/v3/service/contact/v3"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	larktask "github.com/larksuite/oapi-sdk-go/v3/service/task/v1"
+)
+
+const (
+	// directoryTTL 通讯录缓存的有效期
+	directoryTTL      = 10 * time.Minute
+	maxDirectoryUsers = 5000
+	maxLookupResults  = 5
+	maxFreebusyRange  = 7 * 24 * time.Hour
+	defaultSearchDays = 7
+	maxSearchDays     = 30
+	// maxScannedMessages 搜索消息时最多读取的消息数
+	maxScannedMessages = 1000
+	defaultSearchLimit = 10
+	maxSearchLimit     = 20
+	taskOrigin         = `{"zh_cn":"ChatGPT 助手","en_us":"ChatGPT Assistant"}`
+)
+
+// Lark 返回使用飞书应用凭证调用开放平台接口的内置工具。
+// 应用需要开通通讯录、日历、任务和消息的读取权限以及创建任务的权限，工具只能访问应用可见范围内的用户和应用所在的群。
+func Lark(client *lark.Client) []*Tool {
+	l := &larkTools{client: client, directory: &directory{client: client}}
+	return []*Tool{l.lookupUser(), l.checkFreebusy(), l.createTask(), l.searchMessages()}
+}
+
+type larkTools struct {
+	client    *lark.Client
+	directory *directory
+}
+
+// directory 缓存应用可见范围内的用户，用于按姓名查找同事和检查用户是否为企业成员
+type directory struct {
+	client *lark.Client
+
+	mu     sync.Mutex
+	users  []*larkcontact.User
+	byId   map[string]*larkcontact.User
+	loaded time.Time
+}
+
+func (d *directory) load(ctx context.Context) ([]*larkcontact.User, map[string]*larkcontact.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.byId != nil && time.Since(d.loaded) < directoryTTL {
+		return d.users, d.byId, nil
+	}
+
+	iter, err := d.client.Contact.User.ListByIterator(ctx, larkcontact.NewListUserReqBuilder().
+		UserIdType(larkcontact.UserIdTypeOpenId).
+		PageSize(100).
+		Limit(maxDirectoryUsers).
+		Build())
+	if err != nil {
+		return nil, nil, fmt.Errorf("list users failed: %w", err)
+	}
+	users := make([]*larkcontact.User, 0)
+	byId := make(map[string]*larkcontact.User)
+	for {
+		ok, u, err := iter.Next()
+		if err != nil {
+			return nil, nil, fmt.Errorf("list users failed: %w", err)
+		}
+		if !ok {
+			break
+		}
+		if u.Status != nil && (larkcore.BoolValue(u.Status.IsResigned) || larkcore.BoolValue(u.Status.IsExited)) {
+			continue
+		}
+		users = append(users, u)
+		byId[larkcore.StringValue(u.OpenId)] = u
+	}
+	d.users, d.byId, d.loaded = users, byId, time.Now()
+	return users, byId, nil
+}
+
+// user 按 open_id 查找用户，不在应用可见范围内时返回 nil
+func (d *directory) user(ctx context.Context, openId string) (*larkcontact.User, error) {
+	_, byId, err := d.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return byId[openId], nil
+}
+
+// requireMember 检查发起请求的用户是企业通讯录中的成员，外部联系人不能使用通讯录、日历和任务工具
+func (d *directory) requireMember(ctx context.Context, inv *Invocation) error {
+	u, err := d.user(ctx, inv.UserId)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return Forbidden("requesting user is not in the app's contact scope")
+	}
+	return nil
+}
+
+// requireUsers 检查 open_id 对应的用户都在通讯录中
+func (d *directory) requireUsers(ctx context.Context, openIds []string) error {
+	for _, id := range openIds {
+		u, err := d.user(ctx, id)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return Forbidden(fmt.Sprintf("user %s is not in the app's contact scope, use lookup_user to find the open_id", id))
+		}
+	}
+	return nil
+}
+
+func (d *directory) name(ctx context.Context, openId string) string {
+	if u, err := d.user(ctx, openId); err == nil && u != nil {
+		return larkcore.StringValue(u.Name)
+	}
+	return openId
+}
+
+func (l *larkTools) lookupUser() *Tool {
+	return &Tool{
+		Name:        LookupUser,
+		Description: "按姓名、英文名或邮箱在企业通讯录中查找同事，返回同事的 open_id、邮箱、职务和城市。查询日历或分配任务前先调用以获取 open_id",
+		Parameters: object(map[string]interface{}{
+			"name": property("string", "同事的姓名、英文名、别名或邮箱，可以只填写一部分"),
+		}, "name"),
+		Authorize: l.directory.requireMember,
+		Run: func(ctx context.Context, inv *Invocation) (string, error) {
+			var args struct {
+				Name string `json:"name"`
+			}
+			if err := inv.Bind(&args); err != nil {
+				return "", err
+			}
+			query := strings.ToLower(strings.TrimSpace(args.Name))
+			if query == "" {
+				return "", fmt.Errorf("name is required")
+			}
+			users, _, err := l.directory.load(ctx)
+			if err != nil {
+				return "", err
+			}
+
+			lines := make([]string, 0)
+			for _, u := range users {
+				if !matchUser(u, query) {
+					continue
+				}
+				if len(lines) == maxLookupResults {
+					lines = append(lines, "匹配的同事较多，只列出前 5 位，请使用更完整的姓名查找")
+					break
+				}
+				lines = append(lines, describeUser(u))
+			}
+			if len(lines) == 0 {
+				return fmt.Sprintf("通讯录中没有找到与「%s」匹配的同事", args.Name), nil
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+	}
+}
+
+func matchUser(u *larkcontact.User, query string) bool {
+	for _, field := range []*string{u.Name, u.EnName, u.Nickname, u.Email, u.EnterpriseEmail} {
+		if v := strings.ToLower(larkcore.StringValue(field)); v != "" && strings.Contains(v, query) {
+			return true
+		}
+	}
+	return false
+}
+
+// describeUser 用户的基本信息，不包含手机号等敏感信息
+func describeUser(u *larkcontact.User) string {
+	parts := []string{larkcore.StringValue(u.Name), "open_id: " + larkcore.StringValue(u.OpenId)}
+	if v := larkcore.StringValue(u.EnName); v != "" {
+		parts = append(parts, "英文名: "+v)
+	}
+	email := larkcore.StringValue(u.EnterpriseEmail)
+	if email == "" {
+		email = larkcore.StringValue(u.Email)
+	}
+	if email != "" {
+		parts = append(parts, "邮箱: "+email)
+	}
+	if v := larkcore.StringValue(u.JobTitle); v != "" {
+		parts = append(parts, "职务: "+v)
+	}
+	if v := larkcore.StringValue(u.City); v != "" {
+		parts = append(parts, "城市: "+v)
+	}
+	return strings.Join(parts, "，")
+}
+
+type freebusyArgs struct {
+	UserId string `json:"user_id"`
+	Start  string `json:"start"`
+	End    string `json:"end"`
+}
+
+// checkFreebusy 使用应用的 tenant_access_token 查询，只检查发起人和同事在应用的可见范围内，
+// 不检查同事的日历共享设置，因此 tools.permissions 未配置该工具时所有用户都不能调用
+func (l *larkTools) checkFreebusy() *Tool {
+	return &Tool{
+		Name:        CheckFreebusy,
+		Description: "查询同事在一段时间内的日程忙闲，返回忙碌的时间段，时间范围不超过 7 天",
+		Parameters: object(map[string]interface{}{
+			"user_id": property("string", "同事的 open_id，通过 lookup_user 获取"),
+			"start":   property("string", "开始时间，RFC3339 或 2006-01-02 15:04 格式"),
+			"end":     property("string", "结束时间，RFC3339 或 2006-01-02 15:04 格式"),
+		}, "user_id", "start", "end"),
+		Authorize: func(ctx context.Context, inv *Invocation) error {
+			var args freebusyArgs
+			if err := inv.Bind(&args); err != nil {
+				return err
+			}
+			if err := l.directory.requireMember(ctx, inv); err != nil {
+				return err
+			}
+			return l.directory.requireUsers(ctx, []string{args.UserId})
+		},
+		Run: func(ctx context.Context, inv *Invocation) (string, error) {
+			var args freebusyArgs
+			if err := inv.Bind(&args); err != nil {
+				return "", err
+			}
+			start, err := parseTime(args.Start)
+			if err != nil {
+				return "", err
+			}
+			end, err := parseTime(args.End)
+			if err != nil {
+				return "", err
+			}
+			if !end.After(start) || end.Sub(start) > maxFreebusyRange {
+				return "", fmt.Errorf("end must be after start and within 7 days")
+			}
+
+			resp, err := l.client.Calendar.Freebusy.List(ctx, larkcalendar.NewListFreebusyReqBuilder().
+				UserIdType(larkcalendar.UserIdTypeOpenId).
+				Body(larkcalendar.NewListFreebusyReqBodyBuilder().
+					TimeMin(start.Format(time.RFC3339)).
+					TimeMax(end.Format(time.RFC3339)).
+					UserId(args.UserId).
+					Build()).
+				Build())
+			if err != nil {
+				return "", fmt.Errorf("list freebusy failed: %w", err)
+			}
+			if !resp.Success() {
+				return "", fmt.Errorf("list freebusy failed: %d %s", resp.Code, resp.Msg)
+			}
+
+			name := l.directory.name(ctx, args.UserId)
+			if resp.Data == nil || len(resp.Data.FreebusyList) == 0 {
+				return fmt.Sprintf("%s 在 %s 至 %s 之间没有日程，全部空闲", name, formatTime(start), formatTime(end)), nil
+			}
+			lines := []string{fmt.Sprintf("%s 在 %s 至 %s 之间的忙碌时间段：", name, formatTime(start), formatTime(end))}
+			for _, fb := range resp.Data.FreebusyList {
+				lines = append(lines, fmt.Sprintf("- %s 至 %s", rfc3339Local(fb.StartTime), rfc3339Local(fb.EndTime)))
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+	}
+}
+
+func rfc3339Local(s *string) string {
+	t, err := time.Parse(time.RFC3339, larkcore.StringValue(s))
+	if err != nil {
+		return larkcore.StringValue(s)
+	}
+	return formatTime(t)
+}
+
+type taskArgs struct {
+	Summary     string   `json:"summary"`
+	Description string   `json:"description"`
+	Due         string   `json:"due"`
+	AssigneeIds []string `json:"assignee_ids"`
+}
+
+// bindTask 解析任务参数，未指定负责人时由发起请求的用户负责
+func bindTask(inv *Invocation) (*taskArgs, error) {
+	args := &taskArgs{}
+	if err := inv.Bind(args); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(args.Summary) == "" {
+		return nil, fmt.Errorf("summary is required")
+	}
+	if len(args.AssigneeIds) == 0 {
+		args.AssigneeIds = []string{inv.UserId}
+	}
+	return args, nil
+}
+
+// createTask 以应用身份创建任务，与 checkFreebusy 一样默认所有用户都不能调用
+func (l *larkTools) createTask() *Tool {
+	return &Tool{
+		Name:        CreateTask,
+		Description: "创建飞书任务。这是写操作，调用后会先请用户在卡片中确认，确认后才会创建",
+		Parameters: object(map[string]interface{}{
+			"summary":     property("string", "任务标题"),
+			"description": property("string", "任务描述"),
+			"due":         property("string", "截止时间，RFC3339 或 2006-01-02 15:04 格式"),
+			"assignee_ids": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "负责人的 open_id，通过 lookup_user 获取，默认为用户自己",
+			},
+		}, "summary"),
+		Write: true,
+		Authorize: func(ctx context.Context, inv *Invocation) error {
+			args, err := bindTask(inv)
+			if err != nil {
+				return err
+			}
+			if err := l.directory.requireMember(ctx, inv); err != nil {
+				return err
+			}
+			return l.directory.requireUsers(ctx, args.AssigneeIds)
+		},
+		Describe: func(ctx context.Context, inv *Invocation) (string, error) {
+			args, err := bindTask(inv)
+			if err != nil {
+				return "", err
+			}
+			lines := []string{"创建任务：" + args.Summary}
+			if args.Description != "" {
+				lines = append(lines, "描述："+args.Description)
+			}
+			if args.Due != "" {
+				due, err := parseTime(args.Due)
+				if err != nil {
+					return "", err
+				}
+				lines = append(lines, "截止时间："+formatTime(due))
+			}
+			names := make([]string, 0, len(args.AssigneeIds))
+			for _, id := range args.AssigneeIds {
+				names = append(names, l.directory.name(ctx, id))
+			}
+			lines = append(lines, "负责人："+strings.Join(names, "、"))
+			return strings.Join(lines, "\n"), nil
+		},
+		Run: func(ctx context.Context, inv *Invocation) (string, error) {
+			args, err := bindTask(inv)
+			if err != nil {
+				return "", err
+			}
+			task := larktask.NewTaskBuilder().
+				Summary(args.Summary).
+				Description(args.Description).
+				Origin(larktask.NewOriginBuilder().PlatformI18nName(taskOrigin).Build()).
+				CollaboratorIds(args.AssigneeIds).
+				FollowerIds([]string{inv.UserId})
+			if args.Due != "" {
+				due, err := parseTime(args.Due)
+				if err != nil {
+					return "", err
+				}
+				task.Due(larktask.NewDueBuilder().Time(strconv.FormatInt(due.Unix(), 10)).Build())
+			}
+
+			resp, err := l.client.Task.Task.Create(ctx, larktask.NewCreateTaskReqBuilder().
+				UserIdType(larktask.UserIdTypeOpenId).
+				Task(task.Build()).
+				Build())
+			if err != nil {
+				return "", fmt.Errorf("create task failed: %w", err)
+			}
+			if !resp.Success() {
+				return "", fmt.Errorf("create task failed: %d %s", resp.Code, resp.Msg)
+			}
+			var id string
+			if resp.Data != nil && resp.Data.Task != nil {
+				id = larkcore.StringValue(resp.Data.Task.Id)
+			}
+			return fmt.Sprintf("已创建任务「%s」，任务 Id：%s", args.Summary, id), nil
+		},
+	}
+}
+
+// requireChatMember 检查发起请求的用户是当前群的成员。单聊是用户与机器人之间的会话，不需要检查。
+func (l *larkTools) requireChatMember(ctx context.Context, inv *Invocation) error {
+	if inv.ChatId == "" {
+		return Forbidden("no current chat")
+	}
+	if inv.ChatType == "p2p" {
+		return nil
+	}
+	iter, err := l.client.Im.ChatMembers.GetByIterator(ctx, larkim.NewGetChatMembersReqBuilder().
+		ChatId(inv.ChatId).
+		MemberIdType(larkim.MemberIdTypeOpenId).
+		PageSize(100).
+		Build())
+	if err != nil {
+		return fmt.Errorf("list chat members failed: %w", err)
+	}
+	for {
+		ok, m, err := iter.Next()
+		if err != nil {
+			return fmt.Errorf("list chat members failed: %w", err)
+		}
+		if !ok {
+			return Forbidden("requesting user is not a member of the current chat")
+		}
+		if larkcore.StringValue(m.MemberId) == inv.UserId {
+			return nil
+		}
+	}
+}
+
+type searchArgs struct {
+	Keyword string `json:"keyword"`
+	Days    int    `json:"days"`
+	Limit   int    `json:"limit"`
+}
+
+func (l *larkTools) searchMessages() *Tool {
+	return &Tool{
+		Name:        SearchMessages,
+		Description: "在当前会话的历史消息中搜索包含关键词的文本消息，返回最近的匹配消息、发送时间和发送人",
+		Parameters: object(map[string]interface{}{
+			"keyword": property("string", "关键词，不区分大小写"),
+			"days":    property("integer", "搜索最近几天的消息，默认 7，最多 30"),
+			"limit":   property("integer", "最多返回的消息数，默认 10，最多 20"),
+		}, "keyword"),
+		Authorize: l.requireChatMember,
+		Run: func(ctx context.Context, inv *Invocation) (string, error) {
+			var args searchArgs
+			if err := inv.Bind(&args); err != nil {
+				return "", err
+			}
+			keyword := strings.ToLower(strings.TrimSpace(args.Keyword))
+			if keyword == "" {
+				return "", fmt.Errorf("keyword is required")
+			}
+			if args.Days <= 0 || args.Days > maxSearchDays {
+				args.Days = defaultSearchDays
+			}
+			if args.Limit <= 0 || args.Limit > maxSearchLimit {
+				args.Limit = defaultSearchLimit
+			}
+
+			start := time.Now().AddDate(0, 0, -args.Days)
+			iter, err := l.client.Im.Message.ListByIterator(ctx, larkim.NewListMessageReqBuilder().
+				ContainerIdType("chat").
+				ContainerId(inv.ChatId).
+				StartTime(strconv.FormatInt(start.Unix(), 10)).
+				PageSize(50).
+				Limit(maxScannedMessages).
+				Build())
+			if err != nil {
+				return "", fmt.Errorf("list messages failed: %w", err)
+			}
+			// 消息按发送时间升序返回，保留最近的 limit 条
+			matches := make([]string, 0)
+			for {
+				ok, m, err := iter.Next()
+				if err != nil {
+					return "", fmt.Errorf("list messages failed: %w", err)
+				}
+				if !ok {
+					break
+				}
+				if larkcore.BoolValue(m.Deleted) || m.Body == nil {
+					continue
+				}
+				text := messageText(larkcore.StringValue(m.MsgType), larkcore.StringValue(m.Body.Content))
+				if text == "" || !strings.Contains(strings.ToLower(text), keyword) {
+					continue
+				}
+				matches = append(matches, fmt.Sprintf("[%s] %s：%s", messageTime(m.CreateTime), l.sender(ctx, m.Sender), text))
+				if len(matches) > args.Limit {
+					matches = matches[1:]
+				}
+			}
+			if len(matches) == 0 {
+				return fmt.Sprintf("最近 %d 天的消息中没有找到包含「%s」的消息", args.Days, args.Keyword), nil
+			}
+			return strings.Join(matches, "\n"), nil
+		},
+	}
+}
+
+func (l *larkTools) sender(ctx context.Context, sender *larkim.Sender) string {
+	if sender == nil {
+		return "未知"
+	}
+	if larkcore.StringValue(sender.SenderType) == "app" {
+		return "机器人"
+	}
+	return l.directory.name(ctx, larkcore.StringValue(sender.Id))
+}
+
+func messageTime(ms *string) string {
+	v, err := strconv.ParseInt(larkcore.StringValue(ms), 10, 64)
+	if err != nil {
+		return larkcore.StringValue(ms)
+	}
+	return formatTime(time.UnixMilli(v))
+}
+
+// messageText 提取文本和富文本消息中的文字，其他类型的消息返回空字符串
+func messageText(msgType, content string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(content), &v); err != nil {
+		return ""
+	}
+	switch msgType {
+	case larkim.MsgTypeText:
+		if m, ok := v.(map[string]interface{}); ok {
+			text, _ := m["text"].(string)
+			return text
+		}
+	case larkim.MsgTypePost:
+		texts := make([]string, 0)
+		collectTexts(v, &texts)
+		return strings.Join(texts, " ")
+	}
+	return ""
+}
+
+// collectTexts 收集富文本中 title 和 text 字段的文字
+func collectTexts(v interface{}, texts *[]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, key := range []string{"title", "text"} {
+			if s, ok := v[key].(string); ok && s != "" {
+				*texts = append(*texts, s)
+			}
+		}
+		if content, ok := v["content"]; ok {
+			collectTexts(content, texts)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectTexts(item, texts)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Package tools 实现 GPT 的工具调用：工具注册表、支持 tools 参数的 chat completion client，以及内置的时间和飞书工具。
+//
+// 工具以发起请求的用户身份执行权限检查，写操作需要用户确认后才执行，确认流程由调用方实现。
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrForbidden 用户没有权限以给定参数调用工具
+var ErrForbidden = errors.New("permission denied")
+
+// Invocation 一次工具调用，权限检查使用发起请求的用户身份
+type Invocation struct {
+	AppId string
+	// UserId 发起请求的用户的 open_id
+	UserId string
+	// ChatId 用户发送消息的会话，ChatType 为 p2p 或 group
+	ChatId   string
+	ChatType string
+	// Arguments GPT 生成的 JSON 参数
+	Arguments json.RawMessage
+}
+
+// Bind 将参数解析到 v 中
+func (inv *Invocation) Bind(v interface{}) error {
+	if len(inv.Arguments) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(inv.Arguments, v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+// Tool 可以由 GPT 调用的工具
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters 参数的 JSON Schema
+	Parameters map[string]interface{}
+	// Write 是否为写操作，写操作需要用户确认后才执行
+	Write bool
+	// Authorize 检查发起请求的用户能否以给定参数调用工具，为 nil 时不检查
+	Authorize func(ctx context.Context, inv *Invocation) error
+	// Describe 生成写操作确认时展示给用户的操作内容
+	Describe func(ctx context.Context, inv *Invocation) (string, error)
+	// Run 执行工具，返回提供给 GPT 的结果
+	Run func(ctx context.Context, inv *Invocation) (string, error)
+}
+
+// Definition 返回请求中 tools 参数使用的定义
+func (t *Tool) Definition() Definition {
+	return Definition{
+		Type:     "function",
+		Function: Function{Name: t.Name, Description: t.Description, Parameters: t.Parameters},
+	}
+}
+
+// Registry 工具注册表
+type Registry struct {
+	tools map[string]*Tool
+}
+
+func NewRegistry(tools ...*Tool) *Registry {
+	r := &Registry{tools: make(map[string]*Tool)}
+	for _, t := range tools {
+		r.Register(t)
+	}
+	return r
+}
+
+// Register 注册工具，同名的工具会被覆盖
+func (r *Registry) Register(t *Tool) {
+	r.tools[t.Name] = t
+}
+
+// Get 按名称获取工具
+func (r *Registry) Get(name string) (*Tool, bool) {
+	t, ok := r.tools[name]
+	return t, ok
+}
+
+// Names 返回全部工具的名称，按名称排序
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.tools))
+	for name := range r.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Forbidden 返回 ErrForbidden 包装的错误，reason 会作为调用结果提供给 GPT
+func Forbidden(reason string) error {
+	return fmt.Errorf("%w: %s", ErrForbidden, reason)
+}
+
+// object 生成 object 类型的 JSON Schema
+func object(properties map[string]interface{}, required ...string) map[string]interface{} {
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func property(typ, description string) map[string]interface{} {
+	return map[string]interface{}{"type": typ, "description": description}
+}
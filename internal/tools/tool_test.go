@@ -0,0 +1,80 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(&Tool{Name: "b", Description: "first"}, &Tool{Name: "a"})
+	// 同名的工具覆盖之前注册的工具
+	r.Register(&Tool{Name: "b", Description: "second"})
+
+	if got := strings.Join(r.Names(), ","); got != "a,b" {
+		t.Errorf("names = %s, want a,b", got)
+	}
+	b, ok := r.Get("b")
+	if !ok || b.Description != "second" {
+		t.Errorf("get b = %+v, %v, want the second tool", b, ok)
+	}
+	if _, ok := r.Get("c"); ok {
+		t.Error("get c = true, want unknown tool")
+	}
+	def := b.Definition()
+	if def.Type != "function" || def.Function.Name != "b" || def.Function.Description != "second" {
+		t.Errorf("definition = %+v", def)
+	}
+}
+
+func TestInvocationBind(t *testing.T) {
+	cases := []struct {
+		name      string
+		arguments string
+		want      string
+		err       bool
+	}{
+		{name: "empty", want: ""},
+		{name: "valid", arguments: `{"timezone":"UTC"}`, want: "UTC"},
+		{name: "invalid", arguments: `{"timezone":`, err: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var args struct {
+				Timezone string `json:"timezone"`
+			}
+			inv := &Invocation{Arguments: json.RawMessage(tc.arguments)}
+			err := inv.Bind(&args)
+			if (err != nil) != tc.err {
+				t.Fatalf("err = %v, want error %v", err, tc.err)
+			}
+			if args.Timezone != tc.want {
+				t.Errorf("timezone = %q, want %q", args.Timezone, tc.want)
+			}
+		})
+	}
+}
+
+func TestForbidden(t *testing.T) {
+	err := Forbidden("not a member")
+	if !errors.Is(err, ErrForbidden) || !strings.Contains(err.Error(), "not a member") {
+		t.Errorf("err = %v, want ErrForbidden with reason", err)
+	}
+}
+
+func TestCurrentTime(t *testing.T) {
+	tool := currentTime(func() time.Time { return time.Date(2026, 1, 1, 16, 30, 0, 0, time.UTC) })
+	out, err := tool.Run(context.Background(), &Invocation{Arguments: json.RawMessage(`{"timezone":"Asia/Shanghai"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2026-01-02 00:30:00 星期五"; !strings.HasPrefix(out, want) {
+		t.Errorf("output = %q, want prefix %q", out, want)
+	}
+	if _, err := tool.Run(context.Background(), &Invocation{Arguments: json.RawMessage(`{"timezone":"Mars/Base"}`)}); err == nil {
+		t.Error("unknown timezone err = nil, want error")
+	}
+}
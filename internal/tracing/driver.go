@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"context"
+
+	"entgo.io/ent/dialect"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// Driver 为数据库操作创建 span
+func Driver(drv dialect.Driver) dialect.Driver {
+	return &driver{Driver: drv}
+}
+
+type driver struct {
+	dialect.Driver
+}
+
+func (d *driver) Exec(ctx context.Context, query string, args, v interface{}) error {
+	return traceQuery(ctx, d.Dialect(), "db.exec", query, func(ctx context.Context) error {
+		return d.Driver.Exec(ctx, query, args, v)
+	})
+}
+
+func (d *driver) Query(ctx context.Context, query string, args, v interface{}) error {
+	return traceQuery(ctx, d.Dialect(), "db.query", query, func(ctx context.Context) error {
+		return d.Driver.Query(ctx, query, args, v)
+	})
+}
+
+func (d *driver) Tx(ctx context.Context) (dialect.Tx, error) {
+	tx, err := d.Driver.Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &txDriver{Tx: tx, dialect: d.Dialect()}, nil
+}
+
+type txDriver struct {
+	dialect.Tx
+	dialect string
+}
+
+func (t *txDriver) Exec(ctx context.Context, query string, args, v interface{}) error {
+	return traceQuery(ctx, t.dialect, "db.exec", query, func(ctx context.Context) error {
+		return t.Tx.Exec(ctx, query, args, v)
+	})
+}
+
+func (t *txDriver) Query(ctx context.Context, query string, args, v interface{}) error {
+	return traceQuery(ctx, t.dialect, "db.query", query, func(ctx context.Context) error {
+		return t.Tx.Query(ctx, query, args, v)
+	})
+}
+
+func traceQuery(ctx context.Context, system, name, query string, fn func(ctx context.Context) error) error {
+	ctx, span := Start(ctx, name,
+		semconv.DBSystemKey.String(system),
+		attribute.String("db.statement", query),
+	)
+	err := fn(ctx)
+	End(span, err)
+	return err
+}